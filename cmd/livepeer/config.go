@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	yaml "gx/ipfs/QmNNARAR2ncSEDKGVAjsE77VYAtNE6qMMPEC7hfWMwMdF9/yaml.v2"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/p2p"
+	"github.com/livepeer/go-livepeer/server"
+)
+
+var ErrConfig = errors.New("ErrConfig")
+
+//EnvPrefix is prepended to the env variable name of every flag (ethPassword -> LP_ETH_PASSWORD)
+const EnvPrefix = "LP_"
+
+const redacted = "<redacted>"
+
+//configSections maps the sections of the config file to the flags they contain.  The keys in each section are the flag names.
+var configSections = map[string][]string{
+	"node":        {"datadir", "testnet", "offchain", "shutdownTimeout"},
+	"network":     {"p", "bootID", "bootAddr", "bootNodes", "bootDNS", "bootnode", "publicAddr", "natPortMap", "circuitRelay", "circuitRelayHop", "targetPeers", "maxPeers", "pingInterval", "retransmitTimeout", "uploadCapacity", "segmentFormat"},
+	"eth":         {"ethAcctAddr", "ethKeyPath", "ethPassword", "ethSigner", "ethSignerToken", "ethIpcPath", "ethWsUrl", "controllerAddr", "gasPrice"},
+	"media":       {"http", "rtmp", "hlsEncryption", "hlsKeyRotation", "hlsKeyDir", "playbackPolicy", "playbackKey", "playbackAllowedIPs", "playbackAllowedReferrers"},
+	"transcoder":  {"transcoder", "ipfsPath", "checkOutput", "region", "transcoderCapacity", "segmentAddr", "segmentURL", "segmentCert", "segmentKey"},
+	"storage":     {"storage", "ipfsApiUrl", "s3Endpoint", "s3Bucket", "s3Region", "s3AccessKey", "s3SecretKey", "storagePath"},
+	"broadcaster": {"maxPricePerSegment", "transcodingOptions", "segmentTransport", "transcodeResponseTimeout", "renditionStallTimeout", "excludeFailedTranscoders", "probeSource", "adaptiveLadder", "ladderMinBitrateStep", "ladderPricePerRendition", "announceStreams"},
+	"monitoring":  {"monitor", "monitorhost"},
+}
+
+//secretFlags are never shown in the effective config.
+var secretFlags = map[string]bool{"ethPassword": true, "ethSignerToken": true, "s3AccessKey": true, "s3SecretKey": true, "playbackKey": true}
+
+//testnetDefaults are applied when -testnet is set, unless the setting is configured explicitly.
+var testnetDefaults = map[string]string{
+	"bootNodes": strings.Join(testnetBootNodes, ","),
+}
+
+//testnetBootNodes are the bootstrap nodes of the testnet.
+var testnetBootNodes = []string{
+	"/ip4/52.15.174.204/tcp/15000/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC",
+}
+
+//testnetEthDefaults are applied when -testnet is set and the node is not offchain.
+var testnetEthDefaults = map[string]string{
+	"ethWsUrl":       "ws://ethws-testnet.livepeer.org:8546",
+	"controllerAddr": "0x0875085bc9a970055ddb213330c027cd9d26e20e",
+}
+
+//envName returns the env variable for a flag, e.g. ethIpcPath -> LP_ETH_IPC_PATH
+func envName(flagName string) string {
+	var b bytes.Buffer
+	runes := []rune(flagName)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return EnvPrefix + b.String()
+}
+
+//readConfigFile parses a YAML config file into flag name -> value.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections map[string]map[string]interface{}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("cannot parse %v: %v", path, err)
+	}
+
+	vals := make(map[string]string)
+	for section, settings := range sections {
+		names, ok := configSections[section]
+		if !ok {
+			return nil, fmt.Errorf("unknown section %q in %v", section, path)
+		}
+		for key, v := range settings {
+			if !contains(names, key) {
+				return nil, fmt.Errorf("unknown setting %q in section %q", key, section)
+			}
+			if v == nil {
+				continue
+			}
+			vals[key] = fmt.Sprint(v)
+		}
+	}
+	return vals, nil
+}
+
+//applyConfig fills in the flags that were not set on the command line, first from the config file and then from env variables.  The
+//precedence is flags > env > config file > defaults.  Returns the names of all the explicitly configured settings.
+func applyConfig(fs *flag.FlagSet, configPath string) (map[string]bool, error) {
+	fromFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { fromFlags[f.Name] = true })
+
+	configured := make(map[string]bool)
+	for name := range fromFlags {
+		configured[name] = true
+	}
+
+	if configPath != "" {
+		vals, err := readConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for name, v := range vals {
+			if fromFlags[name] {
+				continue
+			}
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %v in %v: %v", v, name, configPath, err)
+			}
+			configured[name] = true
+		}
+	}
+
+	for _, names := range configSections {
+		for _, name := range names {
+			v, ok := os.LookupEnv(envName(name))
+			if !ok || fromFlags[name] {
+				continue
+			}
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %v: %v", v, envName(name), err)
+			}
+			configured[name] = true
+		}
+	}
+
+	return configured, nil
+}
+
+//applyTestnetDefaults sets the testnet bootnode and contract settings that haven't been configured explicitly.
+func applyTestnetDefaults(fs *flag.FlagSet, configured map[string]bool, offchain bool) {
+	defaults := make(map[string]string)
+	for k, v := range testnetDefaults {
+		defaults[k] = v
+	}
+	if !offchain {
+		for k, v := range testnetEthDefaults {
+			defaults[k] = v
+		}
+	}
+	//Settings configured as empty (like in the example config file) still get the testnet defaults
+	isSet := func(name string) bool { return configured[name] && fs.Lookup(name).Value.String() != "" }
+	//Bootstrap nodes configured any other way replace the testnet ones
+	if isSet("bootID") || isSet("bootDNS") {
+		delete(defaults, "bootNodes")
+	}
+	for name, v := range defaults {
+		if !isSet(name) {
+			fs.Set(name, v)
+		}
+	}
+}
+
+//validateConfig checks the settings that can't be checked by the flag parser.
+func validateConfig(fs *flag.FlagSet) error {
+	errs := []string{}
+	get := func(name string) string { return fs.Lookup(name).Value.String() }
+
+	for _, name := range []string{"http", "rtmp"} {
+		if p, err := strconv.Atoi(get(name)); err != nil || p <= 0 || p > 65535 {
+			errs = append(errs, fmt.Sprintf("%v: invalid port %q", name, get(name)))
+		}
+	}
+	for _, opt := range strings.Split(get("transcodingOptions"), ",") {
+		if _, ok := core.ProfileLookup(strings.TrimSpace(opt)); !ok {
+			errs = append(errs, fmt.Sprintf("transcodingOptions: unknown profile %q", opt))
+		}
+	}
+	if !core.ValidSegmentTransport(core.SegmentTransport(get("segmentTransport"))) {
+		errs = append(errs, fmt.Sprintf("segmentTransport: needs to be p2p or http, got %q", get("segmentTransport")))
+	}
+	if (get("segmentAddr") == "") != (get("segmentURL") == "") {
+		errs = append(errs, "segmentAddr and segmentURL need to be set together")
+	}
+	if get("segmentURL") != "" {
+		if u, err := url.Parse(get("segmentURL")); err != nil || u.Scheme != "https" || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("segmentURL: needs to be an https URL, got %q", get("segmentURL")))
+		}
+	}
+	if get("segmentAddr") != "" && get("segmentCert") == "" {
+		errs = append(errs, "segmentAddr needs segmentCert and segmentKey, segments are only taken over https")
+	}
+	if (get("segmentCert") == "") != (get("segmentKey") == "") {
+		errs = append(errs, "segmentCert and segmentKey need to be set together")
+	}
+	for _, name := range []string{"ethAcctAddr", "controllerAddr"} {
+		if v := get(name); v != "" && !common.IsHexAddress(v) {
+			errs = append(errs, fmt.Sprintf("%v: invalid address %q", name, v))
+		}
+	}
+	if get("ethIpcPath") != "" && get("ethWsUrl") != "" {
+		errs = append(errs, "only one of ethIpcPath and ethWsUrl can be set")
+	}
+	if step, err := strconv.ParseFloat(get("ladderMinBitrateStep"), 64); err != nil || step < 0 || step >= 1 {
+		errs = append(errs, fmt.Sprintf("ladderMinBitrateStep: needs to be at least 0 and less than 1, got %q", get("ladderMinBitrateStep")))
+	}
+	if (get("bootID") == "") != (get("bootAddr") == "") {
+		errs = append(errs, "bootID and bootAddr need to be set together")
+	}
+	if _, err := p2p.ParseBootstrapAddrs(get("bootNodes")); err != nil {
+		errs = append(errs, fmt.Sprintf("bootNodes: %v", err))
+	}
+	if port, err := strconv.Atoi(get("p")); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, fmt.Sprintf("p: invalid port %q", get("p")))
+	} else if _, err := p2p.ParsePublicAddrs(get("publicAddr"), port); err != nil {
+		errs = append(errs, fmt.Sprintf("publicAddr: %v", err))
+	}
+	if get("circuitRelayHop") == "true" && get("circuitRelay") != "true" {
+		errs = append(errs, "circuitRelayHop needs circuitRelay")
+	}
+	target, err := strconv.Atoi(get("targetPeers"))
+	if err != nil || target < 0 {
+		errs = append(errs, fmt.Sprintf("targetPeers: needs to be at least 0, got %q", get("targetPeers")))
+	}
+	if max, err := strconv.Atoi(get("maxPeers")); err != nil || max < 0 || (max > 0 && max < target) {
+		errs = append(errs, fmt.Sprintf("maxPeers: needs to be 0 or at least targetPeers, got %q", get("maxPeers")))
+	}
+	if d, err := time.ParseDuration(get("retransmitTimeout")); err != nil || d < 0 || (d > 0 && d <= p2p.RetransmitDelay) {
+		errs = append(errs, fmt.Sprintf("retransmitTimeout: needs to be 0 or more than %v, got %q", p2p.RetransmitDelay, get("retransmitTimeout")))
+	}
+	if capacity, err := strconv.Atoi(get("transcoderCapacity")); err != nil || capacity < 0 {
+		errs = append(errs, fmt.Sprintf("transcoderCapacity: needs to be at least 0, got %q", get("transcoderCapacity")))
+	}
+	if capacity, err := strconv.Atoi(get("uploadCapacity")); err != nil || capacity < 0 {
+		errs = append(errs, fmt.Sprintf("uploadCapacity: needs to be at least 0, got %q", get("uploadCapacity")))
+	}
+	switch get("storage") {
+	case "ipfs", "ipfsapi", "fs":
+	case "s3":
+		if get("s3Bucket") == "" {
+			errs = append(errs, "s3Bucket needs to be set for s3 storage")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("storage: unknown storage %q", get("storage")))
+	}
+
+	if !core.SegmentFormat(get("segmentFormat")).IsKnown() {
+		errs = append(errs, fmt.Sprintf("segmentFormat: unknown format %q", get("segmentFormat")))
+	}
+	switch get("playbackPolicy") {
+	case "public":
+	case "signed":
+		if get("playbackKey") == "" {
+			errs = append(errs, "playbackKey needs to be set for the signed playback policy")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("playbackPolicy: unknown policy %q", get("playbackPolicy")))
+	}
+	if _, err := server.NewPlaybackPolicy(false, get("playbackAllowedIPs"), ""); err != nil {
+		errs = append(errs, fmt.Sprintf("playbackAllowedIPs: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+//effectiveConfig returns the current value of every setting, by section, with secrets redacted.
+func effectiveConfig(fs *flag.FlagSet) map[string]map[string]string {
+	config := make(map[string]map[string]string)
+	for section, names := range configSections {
+		config[section] = make(map[string]string)
+		for _, name := range names {
+			v := fs.Lookup(name).Value.String()
+			if secretFlags[name] && v != "" {
+				v = redacted
+			}
+			config[section][name] = v
+		}
+	}
+	return config
+}
+
+//configCmd implements `livepeer config validate`.  Flags (including -config) can be passed after the subcommand.
+func configCmd(fs *flag.FlagSet, configPath *string, args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Println("Usage: livepeer config validate -config <file> [flags]")
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 && *configPath == "" {
+		*configPath = fs.Arg(0)
+	}
+	if *configPath == "" {
+		*configPath = os.Getenv(envName("config"))
+	}
+
+	configured, err := applyConfig(fs, *configPath)
+	if err != nil {
+		fmt.Printf("Invalid config: %v\n", err)
+		return 1
+	}
+	if fs.Lookup("testnet").Value.String() == "true" {
+		applyTestnetDefaults(fs, configured, fs.Lookup("offchain").Value.String() == "true")
+	}
+	if err := validateConfig(fs); err != nil {
+		fmt.Printf("Invalid config: %v\n", err)
+		return 1
+	}
+
+	sections := make([]string, 0, len(configSections))
+	for section := range configSections {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	config := effectiveConfig(fs)
+	fmt.Println("Effective config:")
+	for _, section := range sections {
+		fmt.Printf("%v:\n", section)
+		for _, name := range configSections[section] {
+			fmt.Printf("  %v: %v\n", name, config[section][name])
+		}
+	}
+	fmt.Println("Config is valid")
+	return 0
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestEffectiveConfigSecrets(t *testing.T) {
+	fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+	for _, names := range configSections {
+		for _, name := range names {
+			fs.String(name, "", "")
+		}
+	}
+	if err := fs.Parse([]string{"-ethSignerToken", "token", "-s3AccessKey", "access", "-s3SecretKey", "secret", "-ethPassword", "pass", "-playbackKey", "key", "-s3Bucket", "bucket"}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := effectiveConfig(fs)
+	for section, name := range map[string]string{"eth": "ethSignerToken", "storage": "s3AccessKey"} {
+		if v := config[section][name]; v != redacted {
+			t.Errorf("Expecting %v to be redacted, got %q", name, v)
+		}
+	}
+	for name := range secretFlags {
+		for section, names := range configSections {
+			for _, n := range names {
+				if n == name && config[section][name] != redacted {
+					t.Errorf("Expecting %v to be redacted, got %q", name, config[section][name])
+				}
+			}
+		}
+	}
+	if v := config["storage"]["s3Bucket"]; v != "bucket" {
+		t.Errorf("Expecting s3Bucket to be shown, got %q", v)
+	}
+}
@@ -20,10 +20,10 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/livepeer/lpms/transcoder"
-
 	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
 
 	"github.com/ethereum/go-ethereum/accounts"
@@ -32,15 +32,20 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/console"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/golang/glog"
-	bnet "github.com/livepeer/go-livepeer-basicnet"
 	lpcommon "github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/directnet"
 	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/eth/signer"
 	"github.com/livepeer/go-livepeer/ipfs"
 	lpmon "github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/go-livepeer/p2p"
+	bnet "github.com/livepeer/go-livepeer/p2p/basicnet"
 	"github.com/livepeer/go-livepeer/server"
+	"github.com/livepeer/go-livepeer/storage"
 	lpmscore "github.com/livepeer/lpms/core"
 )
 
@@ -48,6 +53,7 @@ var ErrKeygen = errors.New("ErrKeygen")
 var EthRpcTimeout = 10 * time.Second
 var EthEventTimeout = 120 * time.Second
 var ErrIpfs = errors.New("ErrIpfs")
+var ErrKeystore = errors.New("ErrKeystore")
 
 func main() {
 	flag.Set("logtostderr", "true")
@@ -83,16 +89,53 @@ func main() {
 	port := flag.Int("p", 15000, "port")
 	httpPort := flag.String("http", "8935", "http port")
 	rtmpPort := flag.String("rtmp", "1935", "rtmp port")
+	hlsEncryption := flag.Bool("hlsEncryption", false, "Set to true to encrypt the HLS streams served to players with AES-128")
+	hlsKeyRotation := flag.Uint64("hlsKeyRotation", 10, "Number of segments encrypted with the same key before rotating to a new one, 0 for one key per stream")
+	hlsKeyDir := flag.String("hlsKeyDir", "", "Directory for the HLS encryption keys (default <datadir>/keys)")
+	playbackPolicy := flag.String("playbackPolicy", "public", "Default playback policy of the streams served by the node: public, or signed to require playback URLs signed with -playbackKey")
+	playbackKey := flag.String("playbackKey", "", "Secret key for signing playback URLs (HMAC-SHA256)")
+	playbackAllowedIPs := flag.String("playbackAllowedIPs", "", "Comma separated IPs or CIDR networks viewers can watch from by default, empty for anywhere")
+	playbackAllowedReferrers := flag.String("playbackAllowedReferrers", "", "Comma separated hosts of the pages streams can be played from by default, empty for any")
 	datadir := flag.String("datadir", fmt.Sprintf("%v/.lpData", usr.HomeDir), "data directory")
 	bootID := flag.String("bootID", "", "Bootstrap node ID")
 	bootAddr := flag.String("bootAddr", "", "Bootstrap node addr")
+	bootNodes := flag.String("bootNodes", "", "Comma separated multiaddrs of more bootstrap nodes, ending with their peer ID, e.g. /ip4/1.2.3.4/tcp/15000/ipfs/Qm...")
+	bootDNS := flag.String("bootDNS", "", "Domain with TXT records of bootstrap nodes, one dnsaddr=<multiaddr> per record")
 	bootnode := flag.Bool("bootnode", false, "Set to true if starting bootstrap node")
+	targetPeers := flag.Int("targetPeers", 8, "Number of peers to stay connected to. The node reconnects to known peers when it has fewer")
+	publicAddr := flag.String("publicAddr", "", "Comma separated addresses other nodes reach the node on, as IP, IP:port or multiaddr. Without it, peers check which addresses they can reach")
+	natPortMap := flag.Bool("natPortMap", true, "Map the port on the router with UPnP or NAT-PMP")
+	circuitRelay := flag.Bool("circuitRelay", false, "Connect to peers through circuit relays, and advertise addresses through relays when the node can't be reached")
+	circuitRelayHop := flag.Bool("circuitRelayHop", false, "Relay connections for other nodes. Needs -circuitRelay")
+	maxPeers := flag.Int("maxPeers", 50, "Number of peers the node can be connected to before it drops the slowest ones, 0 for no limit")
+	pingInterval := flag.Duration("pingInterval", p2p.PingInterval, "How often to ping the peers. Peers that miss 3 pings in a row are evicted")
+	retransmitTimeout := flag.Duration("retransmitTimeout", p2p.RetransmitTimeout, "How long to wait for segments lost on the way, which are asked for again, before skipping them with a discontinuity. 0 to pass segments on as they come")
+	uploadCapacity := flag.Int("uploadCapacity", 0, "Upload capacity in kbps. Over it, the node refuses to relay streams to more peers, 0 for no limit")
 	transcoder := flag.Bool("transcoder", false, "Set to true to be a transcoder")
+	checkOutput := flag.Bool("checkOutput", true, "Set to true to check transcoded segments with ffprobe before claiming them. Segments that keep failing the check are left out of the claim")
+	region := flag.String("region", "", "Region of the transcoder, announced to broadcasters (e.g. us-east)")
+	transcoderCapacity := flag.Int("transcoderCapacity", 0, "Number of streams the transcoder can transcode at once, announced to broadcasters. 0 to leave it out")
+	segmentAddr := flag.String("segmentAddr", "", "Address the transcoder takes segments on over HTTP, straight from the broadcaster (e.g. 0.0.0.0:8936). Empty to only take segments from the network")
+	segmentURL := flag.String("segmentURL", "", "Public URL of -segmentAddr given to broadcasters (e.g. https://transcoder.example.com:8936). Needs -segmentAddr")
+	segmentCert := flag.String("segmentCert", "", "TLS certificate for -segmentAddr (required with it). Segments go over HTTP/2")
+	segmentKey := flag.String("segmentKey", "", "TLS key for -segmentCert")
 	maxPricePerSegment := flag.Int("maxPricePerSegment", 1, "Max price per segment for a broadcast job")
 	transcodingOptions := flag.String("transcodingOptions", "P240p30fps16x9,P360p30fps16x9", "Transcoding options for broadcast job")
+	segmentTransport := flag.String("segmentTransport", string(core.SegmentTransportP2P), "How broadcast segments get to the transcoder: p2p, or http to send them straight to transcoders that take segments over HTTP as well")
+	transcodeResponseTimeout := flag.Duration("transcodeResponseTimeout", server.TranscodeResponseTimeout, "How long to wait for the transcoder of a broadcast job to answer before creating a new job")
+	renditionStallTimeout := flag.Duration("renditionStallTimeout", server.RenditionStallTimeout, "How long a transcoded stream can go without new segments before creating a new job")
+	adaptiveLadder := flag.Bool("adaptiveLadder", server.AdaptiveLadder, "Set to true to build the profiles of broadcast jobs from the probed source instead of using transcodingOptions as they are. Needs -probeSource")
+	ladderMinBitrateStep := flag.Float64("ladderMinBitrateStep", server.LadderRules.MinBitrateStep, "Fraction a rendition's bitrate has to be below the next higher rendition (or the source) to be in the adaptive ladder")
+	ladderPricePerRendition := flag.Uint64("ladderPricePerRendition", server.LadderRules.PricePerRendition, "Expected price per segment of one rendition. The adaptive ladder gets at most maxPricePerSegment / ladderPricePerRendition renditions, 0 for no cap")
+	announceStreams := flag.Bool("announceStreams", server.AnnounceStreams, "Set to true to announce broadcasts to the live stream directory of the network. Each broadcast can opt in or out with ?announce=. Needs an Eth account")
+	probeSource := flag.Bool("probeSource", true, "Set to true to probe broadcast streams with ffprobe, so the master playlist has their real bitrate, resolution and codecs")
+	segmentFormat := flag.String("segmentFormat", string(core.SegmentFormatGob), "Format segments are broadcast in: gob (understood by all nodes) or lpss1")
+	excludeFailedTranscoders := flag.Bool("excludeFailedTranscoders", server.ExcludeFailedTranscoders, "Set to true to create another job if a transcoder that already failed the broadcast gets the new job")
 	ethAcctAddr := flag.String("ethAcctAddr", "", "Existing Eth account address")
 	ethKeyPath := flag.String("ethKeyPath", "", "Path for the Eth Key")
 	ethPassword := flag.String("ethPassword", "", "Eth account password")
+	ethSignerAddr := flag.String("ethSigner", "", "Address of a remote signer holding the Eth key (unix:///path/to/socket or http://host:port). The keystore isn't used if this is set")
+	ethSignerToken := flag.String("ethSignerToken", "", "Auth token of the remote signer (its -authToken). Required for http://")
 	ethIpcPath := flag.String("ethIpcPath", "", "Path for eth IPC file")
 	ethWsUrl := flag.String("ethWsUrl", "", "geth websocket url")
 	testnet := flag.Bool("testnet", false, "Set to true to connect to testnet")
@@ -101,24 +144,47 @@ func main() {
 	monitor := flag.Bool("monitor", true, "Set to true to send performance metrics")
 	monhost := flag.String("monitorhost", "http://viz.livepeer.org:8081/metrics", "host name for the metrics data collector")
 	ipfsPath := flag.String("ipfsPath", fmt.Sprintf("%v/.ipfs", usr.HomeDir), "IPFS path")
+	storageType := flag.String("storage", "ipfs", "Where transcoders store segment data for verification: ipfs (embedded IPFS node), ipfsapi (external IPFS node), s3 or fs")
+	ipfsApiUrl := flag.String("ipfsApiUrl", "http://127.0.0.1:5001", "HTTP API of the external IPFS node, for -storage ipfsapi")
+	s3Endpoint := flag.String("s3Endpoint", "https://s3.amazonaws.com", "Endpoint of the S3-compatible object store, for -storage s3")
+	s3Bucket := flag.String("s3Bucket", "", "Bucket for -storage s3")
+	s3Region := flag.String("s3Region", "us-east-1", "Region for -storage s3")
+	s3AccessKey := flag.String("s3AccessKey", "", "Access key for -storage s3. Requests are anonymous if not set")
+	s3SecretKey := flag.String("s3SecretKey", "", "Secret key for -storage s3")
+	storagePath := flag.String("storagePath", "", "Directory for -storage fs (default <datadir>/storage)")
 	offchain := flag.Bool("offchain", false, "Set to true to start the node in offchain mode")
 	version := flag.Bool("version", false, "Print out the version")
+	configPath := flag.String("config", "", "Path to a YAML config file. Settings can also be set with LP_ env variables (e.g. LP_ETH_PASSWORD)")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 60*time.Second, "Max time to wait for transcoding and claims to finish when shutting down")
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(configCmd(flag.CommandLine, configPath, os.Args[2:]))
+	}
 
 	flag.Parse()
 
 	if *version {
-		fmt.Println("Livepeer Node Version: 0.1.7-unstable")
+		fmt.Printf("Livepeer Node Version: %v\n", core.LivepeerVersion)
+		return
+	}
+
+	//Fill in the settings that are not on the command line from the config file and env variables
+	if *configPath == "" {
+		*configPath = os.Getenv(envName("config"))
+	}
+	configured, err := applyConfig(flag.CommandLine, *configPath)
+	if err != nil {
+		glog.Errorf("Error loading config: %v", err)
 		return
 	}
 
 	if *testnet {
-		*bootID = "12208a4eb428aa57a74ef0593612adb88077c75c71ad07c3c26e4e7a8d4860083b01"
-		*bootAddr = "/ip4/52.15.174.204/tcp/15000"
+		applyTestnetDefaults(flag.CommandLine, configured, *offchain)
+	}
 
-		if !*offchain {
-			*ethWsUrl = "ws://ethws-testnet.livepeer.org:8546"
-			*controllerAddr = "0x0875085bc9a970055ddb213330c027cd9d26e20e"
-		}
+	if err := validateConfig(flag.CommandLine); err != nil {
+		glog.Errorf("Invalid config: %v", err)
+		return
 	}
 
 	//Make sure datadir is present
@@ -142,11 +208,19 @@ func main() {
 		lpmon.Endpoint = *monhost
 	}
 	notifiee := bnet.NewBasicNotifiee(lpmon.Instance())
-	node, err := bnet.NewNode(*port, priv, pub, notifiee)
+	publicAddrs, err := p2p.ParsePublicAddrs(*publicAddr, *port)
+	if err != nil {
+		glog.Errorf("Error parsing public addresses: %v", err)
+		return
+	}
+	nodeOpts := bnet.NodeOptions{PublicAddrs: publicAddrs, NATPortMap: *natPortMap, CircuitRelay: *circuitRelay, CircuitRelayHop: *circuitRelayHop}
+	node, err := bnet.NewNodeWithOptions(*port, priv, pub, notifiee, nodeOpts)
 	if err != nil {
 		glog.Errorf("Error creating a new node: %v", err)
 		return
 	}
+	//Peers tell the node which of its addresses they can reach
+	p2p.NewAddrsChecker(node.PeerHost, node).Start()
 	addrs := make([]string, 0)
 	for _, addr := range node.PeerHost.Addrs() {
 		addrs = append(addrs, addr.String())
@@ -156,12 +230,59 @@ func main() {
 		glog.Errorf("Cannot create network node: %v", err)
 		return
 	}
+	uploadLimiter := p2p.NewUploadLimiter(int64(*uploadCapacity) * 1000 / 8)
+	nw.SetRelayLimiter(uploadLimiter)
+	nw.SetRelayRefuser(p2p.NewRelayRefusals(node.PeerHost, nw))
+	nw.SetAnnouncer(p2p.NewAnnouncer(node.PeerHost))
+	//Segments of the streams the node broadcasts are sent again to the subscribers that lost them
+	retransmitter := p2p.NewRetransmitter(node.PeerHost, nw)
+	retransmitter.Limiter = uploadLimiter
+	if *retransmitTimeout > 0 {
+		p2p.RetransmitTimeout = *retransmitTimeout
+		nw.SetSequencerFunc(func(strmID string) net.SegmentSequencer { return p2p.NewSegmentSequencer(strmID) })
+		nw.SetRetransmitter(retransmitter)
+	}
 
-	n, err := core.NewLivepeerNode(nil, nw, core.NodeID(nw.GetNodeID()), addrs, *datadir)
+	//Segments go over the network, and over HTTP to the transcoders that take them
+	vn := directnet.NewDirectVideoNetwork(nw)
+
+	n, err := core.NewLivepeerNode(nil, vn, core.NodeID(nw.GetNodeID()), addrs, *datadir)
 	if err != nil {
 		glog.Errorf("Error creating livepeer node: %v", err)
+		return
+	}
+	n.SegmentFormat = core.SegmentFormat(*segmentFormat)
+	n.SegmentFormats = p2p.NewSegmentFormats(node.PeerHost)
+	p2p.PingInterval = *pingInterval
+	n.Peers = p2p.NewPeerManager(node.PeerHost, filepath.Join(*datadir, "conn"), *targetPeers, *maxPeers)
+
+	//The broadcast settings are persisted in the datadir - only override them if they are configured explicitly
+	if configured["maxPricePerSegment"] || configured["transcodingOptions"] || configured["segmentTransport"] {
+		if err := n.Settings.Update(func(settings *core.Settings) error {
+			if configured["maxPricePerSegment"] {
+				settings.BroadcastPrice = uint64(*maxPricePerSegment)
+			}
+			if configured["transcodingOptions"] {
+				settings.BroadcastJobVideoProfiles = make([]lpmscore.VideoProfile, 0)
+				for _, opt := range strings.Split(*transcodingOptions, ",") {
+					p, _ := core.ProfileLookup(strings.TrimSpace(opt))
+					settings.BroadcastJobVideoProfiles = append(settings.BroadcastJobVideoProfiles, p)
+				}
+			}
+			if configured["segmentTransport"] {
+				settings.BroadcastSegmentTransport = core.SegmentTransport(*segmentTransport)
+			}
+			return nil
+		}); err != nil {
+			glog.Errorf("Error updating broadcast settings: %v", err)
+			return
+		}
 	}
 
+	//nodeCtx is cancelled when the node shuts down
+	nodeCtx, nodeCancel := context.WithCancel(context.Background())
+	defer nodeCancel()
+
 	if *bootnode {
 		glog.Infof("\n\nSetting up bootnode")
 		//Setup boostrap node
@@ -171,72 +292,65 @@ func main() {
 		}
 		lpmon.Instance().SetBootNode()
 	} else {
-		if err := n.Start(context.Background(), *bootID, *bootAddr); err != nil {
+		//Checked by validateConfig
+		bootstrap, _ := p2p.ParseBootstrapAddrs(*bootNodes)
+		if *bootID != "" {
+			bootstrap = append([]core.PeerConn{{NodeID: *bootID, NodeAddr: *bootAddr}}, bootstrap...)
+		}
+		if *bootDNS != "" {
+			if dnsBootstrap, err := p2p.LookupBootstrapAddrs(*bootDNS); err != nil {
+				glog.Errorf("Cannot look up the bootstrap nodes of %v: %v", *bootDNS, err)
+			} else {
+				glog.Infof("Found %v bootstrap nodes at %v", len(dnsBootstrap), *bootDNS)
+				bootstrap = append(bootstrap, dnsBootstrap...)
+			}
+		}
+		if err := n.Start(nodeCtx, bootstrap); err != nil {
 			glog.Errorf("Cannot connect to bootstrap node: %v", err)
 			return
 		}
 	}
 
 	var gethCmd *exec.Cmd
+	var ethRpc *rpc.Client
 	if *offchain {
 		glog.Infof("***Livepeer is in off-chain mode***")
 	} else {
-		var acct accounts.Account
-		var keystoreDir string
-		if _, err := os.Stat(*ethKeyPath); !os.IsNotExist(err) {
-			//Try loading eth key from ethKeyPath
-			data, err := ioutil.ReadFile(*ethKeyPath)
+		var ethSigner signer.Signer
+		if *ethSignerAddr != "" {
+			//The keys are held by a separate signer process
+			rs, err := signer.NewRemoteSigner(*ethSignerAddr, *ethSignerToken)
 			if err != nil {
-				glog.Errorf("Cannot read key from %v", *ethKeyPath)
+				glog.Errorf("Cannot connect to signer at %v: %v", *ethSignerAddr, err)
 				return
 			}
-
-			var objmap map[string]*json.RawMessage
-			if err := json.Unmarshal(data, &objmap); err != nil {
-				glog.Errorf("Cannot parse key from %v", *ethKeyPath)
-				return
-			}
-			var addr string
-			if err := json.Unmarshal(*objmap["address"], &addr); err != nil {
-				glog.Errorf("Cannot find address in %v", *ethKeyPath)
-				return
-			}
-			keystoreDir, _ = filepath.Split(*ethKeyPath)
-			acct, err = getEthAccount(keystoreDir, addr)
+			ethSigner = rs
+		} else {
+			acct, keystoreDir, err := getKeystoreAccount(*datadir, *ethKeyPath, *ethAcctAddr, ethPassword)
 			if err != nil {
-				glog.Errorf("Cannot get account %v in %v", addr, keystoreDir)
 				return
 			}
-		} else {
-			keystoreDir = filepath.Join(*datadir, "keystore")
-			//Try loading eth key from datadir
-			if _, err := os.Stat(keystoreDir); !os.IsNotExist(err) {
-				acct, err = getEthAccount(keystoreDir, *ethAcctAddr)
+			for firstTime := true; ; {
+				ks, err := signer.NewKeystoreSigner(acct, *ethPassword, keystoreDir)
 				if err != nil {
-					glog.Errorf("Cannot get account %v from %v", *ethAcctAddr, *datadir)
-					if acct, *ethPassword, err = createEthAccount(keystoreDir); err != nil {
-						glog.Errorf("Cannot create Eth account.")
-						return
+					if err == keystore.ErrDecrypt {
+						if !firstTime {
+							glog.Infof("Error decrypting using passphrase. Please provide the passphrase again.")
+						} else {
+							glog.Infof("Please provide the passphrase.")
+							firstTime = false
+						}
+						*ethPassword = getPassphrase(false)
+						continue
 					}
-				}
-			} else {
-				//Try to create a new Eth key
-				if acct, *ethPassword, err = createEthAccount(keystoreDir); err != nil {
-					glog.Errorf("Cannot create Eth account.")
+					glog.Errorf("Error unlocking Eth account: %v", err)
 					return
 				}
+				ethSigner = ks
+				break
 			}
 		}
 
-		if acct.Address.Hex() == "0x0000000000000000000000000000000000000000" {
-			glog.Errorf("Cannot find eth account")
-			return
-		}
-		if keystoreDir == "" {
-			glog.Errorf("Cannot find keystore directory")
-			return
-		}
-
 		//Get the Eth client connection information
 		gethUrl := ""
 		if *ethIpcPath != "" {
@@ -283,37 +397,24 @@ func main() {
 				return
 			}
 		}
-		glog.Infof("Using Eth account: %v", acct.Address.Hex())
+		glog.Infof("Using Eth account: %v", ethSigner.Account().Address.Hex())
 
 		//Set up eth client
-		backend, err := ethclient.Dial(gethUrl)
+		ethRpc, err = rpc.Dial(gethUrl)
 		if err != nil {
 			glog.Errorf("Failed to connect to Ethereum client: %v", err)
 			return
 		}
+		defer ethRpc.Close()
+		backend := ethclient.NewClient(ethRpc)
 
-		var client *eth.Client
-		for firstTime := true; ; {
-			client, err = eth.NewClient(acct, *ethPassword, keystoreDir, backend, big.NewInt(int64(*gasPrice)), common.HexToAddress(*controllerAddr), EthRpcTimeout, EthEventTimeout)
-			if err != nil {
-				if err == keystore.ErrDecrypt {
-					if !firstTime {
-						glog.Infof("Error decrypting using passphrase. Please provide the passphrase again.")
-					} else {
-						glog.Infof("Please provide the passphrase.")
-						firstTime = false
-					}
-					*ethPassword = getPassphrase(false)
-					continue
-				}
-				glog.Errorf("Error creating Eth client: %v", err)
-				return
-			}
-			break
+		client, err := eth.NewClient(ethSigner, backend, big.NewInt(int64(*gasPrice)), common.HexToAddress(*controllerAddr), EthRpcTimeout, EthEventTimeout)
+		if err != nil {
+			glog.Errorf("Error creating Eth client: %v", err)
+			return
 		}
 		n.Eth = client
-		n.EthAccount = acct.Address.String()
-		n.EthPassword = *ethPassword
+		n.EthAccount = client.Account().Address.String()
 
 		//Create LogMonitor, the addresses act as filters.
 		var logMonitor *eth.LogMonitor
@@ -324,39 +425,131 @@ func main() {
 		}
 
 		if *transcoder {
-			if err := setupTranscoder(n, logMonitor); err != nil {
+			if err := setupTranscoder(nodeCtx, n, logMonitor); err != nil {
 				glog.Errorf("Error setting up transcoder: %v", err)
 				return
 			}
 		}
 	}
 
+	var ipfsApi *ipfs.IpfsCoreApi
 	if *transcoder {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		switch *storageType {
+		case "ipfs":
+			ipfsApi, err = ipfs.StartIpfs(nodeCtx, *ipfsPath)
+			if err != nil {
+				glog.Errorf("Error starting ipfs: %v", err)
+				return
+			}
+			n.Storage = ipfsApi
+		case "ipfsapi":
+			n.Storage = storage.NewIpfsHTTPStorage(*ipfsApiUrl)
+		case "s3":
+			n.Storage = storage.NewS3Storage(*s3Endpoint, *s3Bucket, *s3Region, *s3AccessKey, *s3SecretKey)
+		case "fs":
+			if n.Eth != nil {
+				glog.Errorf("-storage fs is only for testing without an Eth account, the verifier cannot read the data on this machine.  Use ipfs, ipfsapi or s3")
+				return
+			}
+			if *storagePath == "" {
+				*storagePath = filepath.Join(*datadir, "storage")
+			}
+			fsStorage, err := storage.NewFSStorage(*storagePath)
+			if err != nil {
+				return
+			}
+			n.Storage = fsStorage
+		}
+		glog.Infof("Storing verification data with %v", *storageType)
+		//Claims that weren't submitted before the last shutdown are saved in the work dir
+		n.RecoverClaims()
 
-		ipfsApi, err := ipfs.StartIpfs(ctx, *ipfsPath)
-		if err != nil {
-			glog.Errorf("Error starting ipfs: %v", err)
-			return
+		if *checkOutput {
+			if _, err := exec.LookPath("ffprobe"); err != nil {
+				glog.Errorf("Cannot find ffprobe, transcoded segments will be claimed without checking them: %v", err)
+			} else {
+				n.OutputChecker = core.NewFFProbeChecker("", filepath.Join(*datadir, ".tmp"))
+			}
 		}
+	}
 
-		n.Ipfs = ipfsApi
+	if *probeSource {
+		if _, err := exec.LookPath("ffprobe"); err != nil {
+			glog.Errorf("Cannot find ffprobe, broadcast streams will be advertised with a profile guessed from the resolution: %v", err)
+		} else {
+			n.SourceProber = core.NewFFProbeChecker("", filepath.Join(*datadir, ".tmp"))
+		}
 	}
 
 	//Set up the media server
+	server.TranscodeResponseTimeout = *transcodeResponseTimeout
+	server.RenditionStallTimeout = *renditionStallTimeout
+	server.ExcludeFailedTranscoders = *excludeFailedTranscoders
+	server.AdaptiveLadder = *adaptiveLadder
+	server.AnnounceStreams = *announceStreams
+	server.LadderRules = core.LadderRules{MinBitrateStep: *ladderMinBitrateStep, PricePerRendition: *ladderPricePerRendition}
+	if *adaptiveLadder && n.SourceProber == nil {
+		glog.Errorf("Cannot build the adaptive ladder without probing the source, using transcodingOptions as they are")
+	}
+	if *announceStreams && n.Eth == nil {
+		glog.Errorf("Cannot announce streams without an Eth account to sign the announcements")
+	}
 	s := server.NewLivepeerServer(*rtmpPort, *httpPort, "", n)
+	s.EffectiveConfig = effectiveConfig(flag.CommandLine)
+	if *hlsEncryption {
+		if *hlsKeyDir == "" {
+			*hlsKeyDir = filepath.Join(*datadir, "keys")
+		}
+		keys, err := core.NewFileKeyManager(*hlsKeyDir)
+		if err != nil {
+			glog.Errorf("Error setting up HLS encryption keys: %v", err)
+			return
+		}
+		s.HLSEncryption = &core.HLSEncryption{Keys: keys, RotationSegments: *hlsKeyRotation}
+		glog.Infof("Encrypting HLS streams, rotating keys every %v segments", *hlsKeyRotation)
+	}
+	//Reported in the node status
+	switch {
+	case *bootnode:
+		n.Capabilities = append(n.Capabilities, core.CapabilityBootnode)
+	case *transcoder:
+		n.Capabilities = append(n.Capabilities, core.CapabilityTranscoder)
+	default:
+		n.Capabilities = append(n.Capabilities, core.CapabilityBroadcaster)
+	}
+	for _, f := range core.SegmentFormats {
+		n.Capabilities = append(n.Capabilities, core.SegmentFormatCapability(f))
+	}
+	if s.HLSEncryption != nil {
+		n.Capabilities = append(n.Capabilities, core.CapabilityHLSEncryption)
+	}
+	if *transcoder && *segmentAddr != "" {
+		n.SegmentURL = *segmentURL
+		n.Capabilities = append(n.Capabilities, core.CapabilityHTTPSegments)
+		go serveSegments(vn, *segmentAddr, *segmentCert, *segmentKey)
+	}
+	//Broadcasters learn about the transcoder from its announcements, which are signed with its Eth account
+	if *transcoder && n.Eth != nil {
+		n.Region = *region
+		n.TranscoderCapacity = *transcoderCapacity
+		go n.AnnounceCapabilities(nodeCtx)
+	}
+	if *playbackKey != "" {
+		s.PlaybackSigner = server.NewPlaybackSigner([]byte(*playbackKey))
+	}
+	//Checked by validateConfig
+	s.DefaultPlaybackPolicy, _ = server.NewPlaybackPolicy(*playbackPolicy == "signed", *playbackAllowedIPs, *playbackAllowedReferrers)
 	ec := make(chan error)
 	msCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	go func() {
 		s.StartWebserver()
-		ec <- s.StartMediaServer(msCtx, *maxPricePerSegment, *transcodingOptions)
+		ec <- s.StartMediaServer(msCtx)
 	}()
 
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	select {
 	case err := <-ec:
 		glog.Infof("Error from media server: %v", err)
@@ -365,12 +558,52 @@ func main() {
 		glog.Infof("MediaServer Done()")
 		return
 	case sig := <-c:
-		glog.Infof("Exiting Livepeer: %v", sig)
-		time.Sleep(time.Millisecond * 500) //Give time for other processes to shut down completely
+		glog.Infof("Exiting Livepeer: %v. Shutting down gracefully (up to %v) - send the signal again to exit immediately.", sig, *shutdownTimeout)
+		go func() {
+			sig := <-c
+			glog.Infof("Got %v again, exiting immediately", sig)
+			os.Exit(1)
+		}()
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer shutdownCancel()
+
+		//Stop taking new broadcasts and send EOF to the active ones
+		s.Drain()
+		//Finish the queued segments, claim the work (or persist the claims) and unsubscribe from the network
+		if err := n.Shutdown(shutdownCtx); err != nil {
+			glog.Errorf("Error shutting down node: %v", err)
+		}
+		cancel()
+		nodeCancel()
+		if ipfsApi != nil {
+			if err := ipfsApi.Close(); err != nil {
+				glog.Errorf("Error closing IPFS: %v", err)
+			}
+		}
+		if ethRpc != nil {
+			ethRpc.Close()
+		}
+		glog.Infof("Livepeer shut down")
 		return
 	}
 }
 
+//serveSegments takes the segments broadcasters send over HTTPS, which net/http serves as HTTP/2.  Plain HTTP isn't served, since anyone
+//on the path could read the streams or send segments of their own.
+func serveSegments(vn *directnet.DirectVideoNetwork, addr, cert, key string) {
+	if cert == "" || key == "" {
+		glog.Errorf("Not taking segments on %v, need a TLS certificate", addr)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(directnet.SegmentPath, vn)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	glog.Infof("Taking segments over HTTPS on %v", addr)
+	err := srv.ListenAndServeTLS(cert, key)
+	glog.Errorf("Stopped taking segments over HTTPS: %v", err)
+}
+
 type LPKeyFile struct {
 	Pub  string
 	Priv string
@@ -457,6 +690,67 @@ func getLPKeys(datadir string) (crypto.PrivKey, crypto.PubKey, error) {
 	return priv, pub, nil
 }
 
+//getKeystoreAccount finds the Eth account in the keystore, either from ethKeyPath or from the datadir.  A new account is created if
+//there isn't one.  Returns the account and the keystore dir.
+func getKeystoreAccount(datadir, ethKeyPath, ethAcctAddr string, ethPassword *string) (accounts.Account, string, error) {
+	var acct accounts.Account
+	var keystoreDir string
+	if _, err := os.Stat(ethKeyPath); !os.IsNotExist(err) {
+		//Try loading eth key from ethKeyPath
+		data, err := ioutil.ReadFile(ethKeyPath)
+		if err != nil {
+			glog.Errorf("Cannot read key from %v", ethKeyPath)
+			return accounts.Account{}, "", ErrKeystore
+		}
+
+		var objmap map[string]*json.RawMessage
+		if err := json.Unmarshal(data, &objmap); err != nil {
+			glog.Errorf("Cannot parse key from %v", ethKeyPath)
+			return accounts.Account{}, "", ErrKeystore
+		}
+		var addr string
+		if err := json.Unmarshal(*objmap["address"], &addr); err != nil {
+			glog.Errorf("Cannot find address in %v", ethKeyPath)
+			return accounts.Account{}, "", ErrKeystore
+		}
+		keystoreDir, _ = filepath.Split(ethKeyPath)
+		acct, err = getEthAccount(keystoreDir, addr)
+		if err != nil {
+			glog.Errorf("Cannot get account %v in %v", addr, keystoreDir)
+			return accounts.Account{}, "", ErrKeystore
+		}
+	} else {
+		keystoreDir = filepath.Join(datadir, "keystore")
+		//Try loading eth key from datadir
+		if _, err := os.Stat(keystoreDir); !os.IsNotExist(err) {
+			acct, err = getEthAccount(keystoreDir, ethAcctAddr)
+			if err != nil {
+				glog.Errorf("Cannot get account %v from %v", ethAcctAddr, datadir)
+				if acct, *ethPassword, err = createEthAccount(keystoreDir); err != nil {
+					glog.Errorf("Cannot create Eth account.")
+					return accounts.Account{}, "", ErrKeystore
+				}
+			}
+		} else {
+			//Try to create a new Eth key
+			if acct, *ethPassword, err = createEthAccount(keystoreDir); err != nil {
+				glog.Errorf("Cannot create Eth account.")
+				return accounts.Account{}, "", ErrKeystore
+			}
+		}
+	}
+
+	if acct.Address.Hex() == "0x0000000000000000000000000000000000000000" {
+		glog.Errorf("Cannot find eth account")
+		return accounts.Account{}, "", ErrKeystore
+	}
+	if keystoreDir == "" {
+		glog.Errorf("Cannot find keystore directory")
+		return accounts.Account{}, "", ErrKeystore
+	}
+	return acct, keystoreDir, nil
+}
+
 func getEthAccount(keystoreDir string, addr string) (accounts.Account, error) {
 	keyStore := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
 	accts := keyStore.Accounts()
@@ -478,7 +772,7 @@ func getEthAccount(keystoreDir string, addr string) (accounts.Account, error) {
 	return accts[0], nil
 }
 
-func setupTranscoder(n *core.LivepeerNode, lm *eth.LogMonitor) error {
+func setupTranscoder(ctx context.Context, n *core.LivepeerNode, lm *eth.LogMonitor) error {
 	//Check if transcoder is active
 	active, err := n.Eth.IsActiveTranscoder()
 	if err != nil {
@@ -496,18 +790,24 @@ func setupTranscoder(n *core.LivepeerNode, lm *eth.LogMonitor) error {
 		glog.Infof("Transcoder Active. Total Stake: %v", s)
 	}
 
-	rm := core.NewRewardManager(time.Second*5, n.Eth)
-	go rm.Start(context.Background())
+	rm := core.NewRewardManager(time.Second*5, n.Eth, n.Settings)
+	go rm.Start(ctx)
 
 	//Set up callback for when a job is assigned to us (via monitoring the eth log)
 	lm.SubscribeToJobEvents(func(job *eth.Job) {
+		settings := n.Settings.Get()
+		if !settings.TranscoderAcceptJobs {
+			glog.Infof("Not accepting jobs. Skipping job %v", job.JobId)
+			return
+		}
+
 		//Check if broadcaster has enough funds
 		bDeposit, err := n.Eth.GetBroadcasterDeposit(job.BroadcasterAddress)
 		if err != nil {
 			glog.Errorf("Error getting broadcaster deposit: %v", err)
 			return
 		}
-		if bDeposit.Cmp(big.NewInt(0)) == 0 {
+		if bDeposit.Cmp(settings.TranscoderMinBroadcasterDeposit) < 0 {
 			glog.Errorf("Broadcaster does not have enough funds. Skipping job")
 			return
 		}
@@ -519,12 +819,12 @@ func setupTranscoder(n *core.LivepeerNode, lm *eth.LogMonitor) error {
 			return
 		}
 
-		config := net.TranscodeConfig{StrmID: job.StreamId, Profiles: tProfiles, JobID: job.JobId, PerformOnchainClaim: true}
+		config := net.TranscodeConfig{StrmID: job.StreamId, Profiles: tProfiles, JobID: job.JobId, PerformOnchainClaim: true, BroadcasterAddress: job.BroadcasterAddress}
 		glog.Infof("Transcoder got job %v - strmID: %v, tData: %v, config: %v", job.JobId, job.StreamId, job.TranscodingOptions, config)
 
 		//Do The Transcoding
-		cm := core.NewBasicClaimManager(job.StreamId, job.JobId, job.BroadcasterAddress, job.MaxPricePerSegment, tProfiles, n.Eth, n.Ipfs)
-		tr := transcoder.NewFFMpegSegmentTranscoder(tProfiles, "", n.WorkDir)
+		cm := core.NewBasicClaimManager(job.StreamId, job.JobId, job.BroadcasterAddress, job.MaxPricePerSegment, tProfiles, n.Eth, n.Storage)
+		tr := core.NewFFMpegTranscoder(tProfiles, "", n.WorkDir)
 		strmIDs, err := n.TranscodeAndBroadcast(config, cm, tr)
 		if err != nil {
 			glog.Errorf("Transcode Error: %v", err)
@@ -548,10 +848,10 @@ func setupTranscoder(n *core.LivepeerNode, lm *eth.LogMonitor) error {
 func txDataToVideoProfile(txData string) ([]lpmscore.VideoProfile, error) {
 	profiles := make([]lpmscore.VideoProfile, 0)
 
-	for i := 0; i+lpcommon.VideoProfileIDSize < len(txData); i += lpcommon.VideoProfileIDSize {
+	for i := 0; i+lpcommon.VideoProfileIDSize <= len(txData); i += lpcommon.VideoProfileIDSize {
 		txp := txData[i : i+lpcommon.VideoProfileIDSize]
 
-		p, ok := lpmscore.VideoProfileLookup[lpcommon.VideoProfileNameLookup[txp]]
+		p, ok := core.ProfileLookup(lpcommon.VideoProfileNameLookup[txp])
 		if !ok {
 			// glog.Errorf("Cannot find video profile for job: %v", txp)
 			// return nil, core.ErrTranscode
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/core"
+	eth "github.com/livepeer/go-livepeer/eth"
+	"gopkg.in/urfave/cli.v1"
+)
+
+//Exit codes of the non-interactive commands
+const (
+	ExitOK          = 0
+	ExitNodeError   = 1 //Couldn't reach the node, or couldn't read its response
+	ExitUsage       = 2
+	ExitClientError = 4 //The node returned a 4xx
+	ExitServerError = 5 //The node returned a 5xx
+)
+
+var jsonFlag = cli.BoolFlag{
+	Name:  "json",
+	Usage: "print the result as JSON",
+}
+
+//commands are the non-interactive subcommands.  Each one maps to a webserver endpoint, and exits with a code that reflects the HTTP result.
+func commands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:   "status",
+			Usage:  "print the node and account status",
+			Flags:  []cli.Flag{jsonFlag},
+			Action: statusCmd,
+		},
+		{
+			Name:  "deposit",
+			Usage: "deposit tokens for broadcasting",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "amount", Usage: "amount of tokens to deposit"},
+				jsonFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "amount"); err != nil {
+					return err
+				}
+				return postCmd(c, "deposit", url.Values{"amount": {c.String("amount")}})
+			},
+		},
+		{
+			Name:  "bond",
+			Usage: "bond tokens to a transcoder",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "to", Usage: "address of the transcoder to bond to"},
+				cli.StringFlag{Name: "amount", Usage: "amount of tokens to bond"},
+				jsonFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "to", "amount"); err != nil {
+					return err
+				}
+				if !common.IsHexAddress(c.String("to")) {
+					return cli.NewExitError(fmt.Sprintf("Invalid transcoder address: %v", c.String("to")), ExitUsage)
+				}
+				return postCmd(c, "bond", url.Values{
+					"amount": {c.String("amount")},
+					"toAddr": {common.HexToAddress(c.String("to")).Hex()},
+				})
+			},
+		},
+		{
+			Name:  "unbond",
+			Usage: "unbond all bonded tokens",
+			Flags: []cli.Flag{jsonFlag},
+			Action: func(c *cli.Context) error {
+				return postCmd(c, "unbond", nil)
+			},
+		},
+		{
+			Name:  "withdraw",
+			Usage: "withdraw unbonded tokens",
+			Flags: []cli.Flag{jsonFlag},
+			Action: func(c *cli.Context) error {
+				return postCmd(c, "withdrawBond", nil)
+			},
+		},
+		{
+			Name:  "set-broadcast-config",
+			Usage: "set the max price per segment and transcoding options for new broadcasts",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "price", Usage: "max price per segment"},
+				cli.StringFlag{Name: "options", Usage: "comma separated transcoding profiles, e.g. P240p30fps16x9,P360p30fps16x9"},
+				jsonFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "price", "options"); err != nil {
+					return err
+				}
+				return postCmd(c, "setBroadcastConfig", url.Values{
+					"maxPricePerSegment": {c.String("price")},
+					"transcodingOptions": {c.String("options")},
+				})
+			},
+		},
+		{
+			Name:  "activate-transcoder",
+			Usage: "register as a transcoder, optionally bonding to yourself",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "blockRewardCut", Value: 10, Usage: "block reward cut percentage"},
+				cli.IntFlag{Name: "feeShare", Value: 5, Usage: "fee share percentage"},
+				cli.IntFlag{Name: "price", Value: 1, Usage: "price per segment"},
+				cli.IntFlag{Name: "amount", Value: 0, Usage: "amount of tokens to bond to yourself"},
+				jsonFlag,
+			},
+			Action: func(c *cli.Context) error {
+				return postCmd(c, "activateTranscoder", url.Values{
+					"blockRewardCut":  {fmt.Sprintf("%v", c.Int("blockRewardCut"))},
+					"feeShare":        {fmt.Sprintf("%v", c.Int("feeShare"))},
+					"pricePerSegment": {fmt.Sprintf("%v", c.Int("price"))},
+					"amount":          {fmt.Sprintf("%v", c.Int("amount"))},
+				})
+			},
+		},
+		{
+			Name:   "list-transcoders",
+			Usage:  "list the registered candidate transcoders",
+			Flags:  []cli.Flag{jsonFlag},
+			Action: listTranscodersCmd,
+		},
+		{
+			Name:  "transcoders",
+			Usage: "list the transcoders that announced their capabilities over the network",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "profile", Usage: "only transcoders that transcode into this profile"},
+				cli.StringFlag{Name: "maxPrice", Usage: "only transcoders that ask at most this price per segment"},
+				jsonFlag,
+			},
+			Action: transcodersCmd,
+		},
+		{
+			Name:  "live-streams",
+			Usage: "list the live streams that broadcasters announced over the network",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "tag", Usage: "only streams with this tag"},
+				cli.StringFlag{Name: "q", Usage: "only streams with this text in their title or tags"},
+				jsonFlag,
+			},
+			Action: liveStreamsCmd,
+		},
+		{
+			Name:   "streams",
+			Usage:  "list the streams on the node",
+			Flags:  []cli.Flag{jsonFlag},
+			Action: streamsCmd,
+		},
+		{
+			Name:   "peers",
+			Usage:  "list the peers of the node with their round trip times",
+			Flags:  []cli.Flag{jsonFlag},
+			Action: peersCmd,
+		},
+		{
+			Name:  "evict-peer",
+			Usage: "disconnect from a peer and stay away from it for a while",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "node", Usage: "node ID of the peer"},
+				cli.StringFlag{Name: "reason", Usage: "reason to log"},
+				jsonFlag,
+			},
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "node"); err != nil {
+					return err
+				}
+				return postCmd(c, "evictPeer", url.Values{"nodeID": {c.String("node")}, "reason": {c.String("reason")}})
+			},
+		},
+	}
+}
+
+func nodeURL(c *cli.Context, path string) string {
+	return fmt.Sprintf("http://%v:%v/%v", c.GlobalString("host"), c.GlobalString("http"), path)
+}
+
+//request sends a request to the node and returns the response body and the exit code for the response.
+func request(method, u string, val url.Values) ([]byte, int, error) {
+	var resp *http.Response
+	var err error
+	if method == "POST" {
+		resp, err = http.Post(u, "application/x-www-form-urlencoded", bytes.NewBufferString(val.Encode()))
+	} else {
+		resp, err = http.Get(u)
+	}
+	if err != nil {
+		return nil, ExitNodeError, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ExitNodeError, err
+	}
+
+	switch {
+	case resp.StatusCode >= 500:
+		return body, ExitServerError, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	case resp.StatusCode >= 400:
+		return body, ExitClientError, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, ExitOK, nil
+}
+
+//result prints the outcome of a command and returns the error to exit with.
+func result(c *cli.Context, data interface{}, code int, err error) error {
+	if c.Bool("json") {
+		out := map[string]interface{}{"ok": err == nil}
+		if err != nil {
+			out["error"] = err.Error()
+		} else if data != nil {
+			out["result"] = data
+		}
+		js, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(js))
+		if err != nil {
+			return cli.NewExitError("", code)
+		}
+		return nil
+	}
+
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Error: %v", err), code)
+	}
+	return nil
+}
+
+func requireFlags(c *cli.Context, names ...string) error {
+	for _, name := range names {
+		if c.String(name) == "" {
+			return cli.NewExitError(fmt.Sprintf("Need to provide --%v", name), ExitUsage)
+		}
+	}
+	return nil
+}
+
+func postCmd(c *cli.Context, endpoint string, val url.Values) error {
+	_, code, err := request("POST", nodeURL(c, endpoint), val)
+	if err == nil && !c.Bool("json") {
+		fmt.Println("OK")
+	}
+	return result(c, nil, code, err)
+}
+
+func statusCmd(c *cli.Context) error {
+	endpoints := []struct{ name, path string }{
+		{"NodeID", "nodeID"},
+		{"NodeAddrs", "nodeAddrs"},
+		{"EthAddr", "ethAddr"},
+		{"TokenBalance", "tokenBalance"},
+		{"EthBalance", "ethBalance"},
+		{"BroadcasterDeposit", "broadcasterDeposit"},
+		{"TranscoderStatus", "transcoderStatus"},
+		{"TranscoderBond", "transcoderBond"},
+		{"TranscoderStake", "transcoderStake"},
+		{"DelegatorStatus", "delegatorStatus"},
+		{"DelegatorStake", "delegatorStake"},
+	}
+
+	status := make(map[string]string)
+	for _, e := range endpoints {
+		body, code, err := request("GET", nodeURL(c, e.path), nil)
+		if err != nil {
+			return result(c, nil, code, fmt.Errorf("%v: %v", e.path, err))
+		}
+		status[e.name] = string(body)
+	}
+
+	if c.Bool("json") {
+		return result(c, status, ExitOK, nil)
+	}
+	wtr := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, e := range endpoints {
+		fmt.Fprintf(wtr, "%v:\t%v\n", e.name, status[e.name])
+	}
+	wtr.Flush()
+	return nil
+}
+
+func listTranscodersCmd(c *cli.Context) error {
+	body, code, err := request("GET", nodeURL(c, "candidateTranscodersStats"), nil)
+	if err != nil {
+		return result(c, nil, code, err)
+	}
+
+	var stats []eth.TranscoderStats
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return result(c, nil, ExitNodeError, fmt.Errorf("Error unmarshalling transcoder stats: %v", err))
+	}
+
+	if c.Bool("json") {
+		return result(c, stats, ExitOK, nil)
+	}
+	wtr := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(wtr, "Address\tTotalStake\tBlockRewardCut\tFeeShare\tPricePerSegment\tPendingBlockRewardCut\tPendingFeeShare\tPendingPricePerSegment")
+	for _, s := range stats {
+		fmt.Fprintf(wtr, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", s.Address.Hex(), s.TotalStake, s.BlockRewardCut, s.FeeShare, s.PricePerSegment, s.PendingBlockRewardCut, s.PendingFeeShare, s.PendingPricePerSegment)
+	}
+	wtr.Flush()
+	return nil
+}
+
+func transcodersCmd(c *cli.Context) error {
+	q := url.Values{}
+	if c.String("profile") != "" {
+		q.Set("profile", c.String("profile"))
+	}
+	if c.String("maxPrice") != "" {
+		q.Set("maxPricePerSegment", c.String("maxPrice"))
+	}
+	body, code, err := request("GET", nodeURL(c, "transcoders?"+q.Encode()), nil)
+	if err != nil {
+		return result(c, nil, code, err)
+	}
+
+	var recs []core.CapabilityRecord
+	if err := json.Unmarshal(body, &recs); err != nil {
+		return result(c, nil, ExitNodeError, fmt.Errorf("Error unmarshalling transcoders: %v", err))
+	}
+
+	if c.Bool("json") {
+		return result(c, recs, ExitOK, nil)
+	}
+	wtr := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(wtr, "NodeID\tEthAddress\tRegion\tPricePerSegment\tJobs\tCapacity\tProfiles\tAnnounced")
+	for _, r := range recs {
+		fmt.Fprintf(wtr, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.NodeID, r.EthAddress.Hex(), r.Region, r.PricePerSegment, r.Jobs, r.Capacity, len(r.Profiles), r.Timestamp.Format(time.RFC3339))
+	}
+	wtr.Flush()
+	return nil
+}
+
+func liveStreamsCmd(c *cli.Context) error {
+	q := url.Values{}
+	if c.String("tag") != "" {
+		q.Set("tag", c.String("tag"))
+	}
+	if c.String("q") != "" {
+		q.Set("q", c.String("q"))
+	}
+	body, code, err := request("GET", nodeURL(c, "liveStreams?"+q.Encode()), nil)
+	if err != nil {
+		return result(c, nil, code, err)
+	}
+
+	var recs []core.StreamRecord
+	if err := json.Unmarshal(body, &recs); err != nil {
+		return result(c, nil, ExitNodeError, fmt.Errorf("Error unmarshalling live streams: %v", err))
+	}
+
+	if c.Bool("json") {
+		return result(c, recs, ExitOK, nil)
+	}
+	wtr := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(wtr, "ManifestID\tTitle\tTags\tRenditions\tStarted")
+	for _, r := range recs {
+		fmt.Fprintf(wtr, "%v\t%v\t%v\t%v\t%v\n", r.ManifestID, r.Title, strings.Join(r.Tags, ","), strings.Join(r.Renditions, ","), r.StartTime.Format(time.RFC3339))
+	}
+	wtr.Flush()
+	return nil
+}
+
+func streamsCmd(c *cli.Context) error {
+	body, code, err := request("GET", nodeURL(c, "localStreams"), nil)
+	if err != nil {
+		return result(c, nil, code, err)
+	}
+
+	var streams []map[string]string
+	if err := json.Unmarshal(body, &streams); err != nil {
+		return result(c, nil, ExitNodeError, fmt.Errorf("Error unmarshalling streams: %v", err))
+	}
+
+	if c.Bool("json") {
+		return result(c, streams, ExitOK, nil)
+	}
+	for _, s := range streams {
+		fmt.Printf("%v\t%v\n", s["format"], s["streamID"])
+	}
+	return nil
+}
+
+func peersCmd(c *cli.Context) error {
+	body, code, err := request("GET", nodeURL(c, "peers"), nil)
+	if err != nil {
+		return result(c, nil, code, err)
+	}
+
+	var peers []core.PeerStats
+	if err := json.Unmarshal(body, &peers); err != nil {
+		return result(c, nil, ExitNodeError, fmt.Errorf("Error unmarshalling peers: %v", err))
+	}
+
+	if c.Bool("json") {
+		return result(c, peers, ExitOK, nil)
+	}
+	wtr := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	fmt.Fprintln(wtr, "NodeID\tConnected\tRTT\tPingFailures\tMisbehavior\tEvicted")
+	for _, p := range peers {
+		evicted := ""
+		if !p.EvictedUntil.IsZero() {
+			evicted = fmt.Sprintf("until %v (%v)", p.EvictedUntil.Format(time.RFC3339), p.EvictReason)
+		}
+		fmt.Fprintf(wtr, "%v\t%v\t%v\t%v\t%v\t%v\n", p.NodeID, p.Connected, p.RTT, p.PingFailures, p.Misbehavior, evicted)
+	}
+	wtr.Flush()
+	return nil
+}
@@ -59,6 +59,7 @@ func main() {
 			Usage: "transcoder on off flag",
 		},
 	}
+	app.Commands = commands()
 	app.Action = func(c *cli.Context) error {
 		// Set up the logger to print everything and the random generator
 		log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(c.Int("loglevel")), log.StreamHandler(os.Stdout, log.TerminalFormat(true))))
@@ -94,7 +94,7 @@ func httpGet(url string) string {
 
 	defer resp.Body.Close()
 	result, err := ioutil.ReadAll(resp.Body)
-	if err != nil || string(result) == "" {
+	if err != nil || string(result) == "" || resp.StatusCode != http.StatusOK {
 		// log.Error(fmt.Sprintf("Error reading from: %v - %v", url, err))
 		return ""
 	}
@@ -0,0 +1,175 @@
+/*
+Livepeer signer holds the Ethereum key of a Livepeer node, and signs transactions and segment hashes for it over a local socket (or
+http).  Run the node with -ethSigner pointing at the signer, and the key never has to live in the media-facing node.  Only calls to
+the allowed contract methods of the Livepeer contracts (as registered in the Controller) are signed, within the spending limits.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/eth/signer"
+)
+
+func main() {
+	flag.Set("logtostderr", "true")
+
+	usr, err := user.Current()
+	if err != nil {
+		glog.Fatalf("Cannot find current user: %v", err)
+	}
+
+	datadir := flag.String("datadir", fmt.Sprintf("%v/.lpData", usr.HomeDir), "data directory")
+	keystoreDir := flag.String("keystore", "", "Keystore directory (default <datadir>/keystore)")
+	ethAcctAddr := flag.String("ethAcctAddr", "", "Eth account address. The first account in the keystore is used if not set")
+	ethPassword := flag.String("ethPassword", "", "Eth account password. Prompted for if not set")
+	listen := flag.String("listen", "", "Where to listen for the node - unix:///path/to/socket or http://host:port (default unix://<datadir>/signer.sock)")
+	authToken := flag.String("authToken", "", "Token the node has to send (-ethSignerToken). Required with http://")
+	methods := flag.String("methods", strings.Join(signer.DefaultMethods, ","), "Comma separated contract methods that can be called, e.g. BondingManager.bond,BondingManager.reward")
+	ethUrl := flag.String("ethUrl", "", "geth IPC path or websocket url, to look up the contract addresses")
+	controllerAddr := flag.String("controllerAddr", "", "Protocol smart contract address. Transactions can only be sent to the contracts it lists")
+	maxApprove := flag.String("maxApprove", "", "Max amount of tokens the BondingManager or JobsManager can be approved to spend in one transaction. Approvals are refused if not set")
+	maxTxCost := flag.String("maxTxCost", "", "Max cost (value + gas * gas price) of a transaction, in wei")
+	maxDailyCost := flag.String("maxDailyCost", "", "Max total cost of the transactions signed in 24 hours, in wei")
+	flag.Parse()
+
+	policy, err := signer.NewPolicy(strings.Split(*methods, ","))
+	if err != nil {
+		glog.Errorf("Invalid methods: %v", err)
+		return
+	}
+	if !common.IsHexAddress(*controllerAddr) {
+		glog.Errorf("Need the address of the Controller (-controllerAddr)")
+		return
+	}
+	backend, err := ethclient.Dial(*ethUrl)
+	if err != nil {
+		glog.Errorf("Cannot connect to the Eth client at %v: %v", *ethUrl, err)
+		return
+	}
+	if err := policy.ResolveContracts(backend, common.HexToAddress(*controllerAddr)); err != nil {
+		glog.Errorf("Cannot get the contract addresses: %v", err)
+		return
+	}
+	if policy.MaxApprove, err = parseWei(*maxApprove); err != nil {
+		glog.Errorf("Invalid maxApprove: %v", err)
+		return
+	}
+	if policy.MaxTxCost, err = parseWei(*maxTxCost); err != nil {
+		glog.Errorf("Invalid maxTxCost: %v", err)
+		return
+	}
+	if policy.MaxWindowCost, err = parseWei(*maxDailyCost); err != nil {
+		glog.Errorf("Invalid maxDailyCost: %v", err)
+		return
+	}
+
+	if *keystoreDir == "" {
+		*keystoreDir = filepath.Join(*datadir, "keystore")
+	}
+	acct, err := findAccount(*keystoreDir, *ethAcctAddr)
+	if err != nil {
+		glog.Errorf("Cannot find Eth account in %v: %v", *keystoreDir, err)
+		return
+	}
+	if *ethPassword == "" {
+		if *ethPassword, err = console.Stdin.PromptPassword("Passphrase: "); err != nil {
+			glog.Errorf("Failed to read passphrase: %v", err)
+			return
+		}
+	}
+	ks, err := signer.NewKeystoreSigner(acct, *ethPassword, *keystoreDir)
+	if err != nil {
+		glog.Errorf("Error unlocking Eth account %v: %v", acct.Address.Hex(), err)
+		return
+	}
+
+	if *listen == "" {
+		*listen = "unix://" + filepath.Join(*datadir, "signer.sock")
+	}
+	if !strings.HasPrefix(*listen, "unix://") && *authToken == "" {
+		glog.Errorf("Need an -authToken to listen on %v, otherwise anyone who can reach it can sign with the key", *listen)
+		return
+	}
+	l, err := listenOn(*listen)
+	if err != nil {
+		glog.Errorf("Cannot listen on %v: %v", *listen, err)
+		return
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		l.Close()
+	}()
+
+	glog.Infof("Signing for %v on %v", acct.Address.Hex(), *listen)
+	if err := http.Serve(l, signer.NewServer(ks, policy, *authToken)); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+		glog.Errorf("Error serving: %v", err)
+	}
+}
+
+func listenOn(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "unix://") {
+		path := strings.TrimPrefix(addr, "unix://")
+		//Remove the socket left behind by a previous run
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		//Only the user running the signer (and the node) can connect
+		if err := os.Chmod(path, 0600); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	return net.Listen("tcp", strings.TrimPrefix(addr, "http://"))
+}
+
+func findAccount(keystoreDir string, addr string) (accounts.Account, error) {
+	accts := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP).Accounts()
+	if len(accts) == 0 {
+		return accounts.Account{}, fmt.Errorf("no accounts")
+	}
+	if addr == "" {
+		return accts[0], nil
+	}
+	for _, acct := range accts {
+		if acct.Address == common.HexToAddress(addr) {
+			return acct, nil
+		}
+	}
+	return accounts.Account{}, fmt.Errorf("account %v not found", addr)
+}
+
+func parseWei(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok || v.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return v, nil
+}
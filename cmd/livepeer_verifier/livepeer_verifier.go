@@ -0,0 +1,123 @@
+/*
+Livepeer verifier simulates the on-chain verification of claimed segments.  It reads the claim data a transcoder saved (the json files
+in <datadir>/claims), transcodes the segments again with ffmpeg, and reports whether the receipt hash and Merkle proof would check out
+against the claim root.  It doesn't need a connection to Ethereum or the network.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/verifier"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+//run returns the exit code: 0 if all segments pass, 1 if any fail or can't be verified, 2 for bad arguments
+func run() int {
+	claimsPath := flag.String("claims", "", "Claim data saved by the transcoder (<datadir>/claims/<streamID>.json)")
+	seqNo := flag.Int64("seqNo", -1, "Segment to verify. All claimable segments are verified if not set")
+	segPath := flag.String("segment", "", "Segment file to use instead of the segment data in the claims, e.g. from verification storage")
+	claimRoot := flag.String("claimRoot", "", "Claim root submitted on-chain. Taken from the claims if not set, or recomputed if the segment isn't claimed yet")
+	ffmpegPath := flag.String("ffmpegPath", "", "Directory of the ffmpeg binary, empty to look it up in PATH")
+	workDir := flag.String("workDir", "", "Directory for transcoding (default a temp dir)")
+	jsonOutput := flag.Bool("json", false, "Print the results as JSON")
+	flag.Parse()
+
+	if *claimsPath == "" {
+		fmt.Fprintln(os.Stderr, "Need to provide -claims")
+		flag.Usage()
+		return 2
+	}
+	claims, err := verifier.LoadClaims(*claimsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot load claims: %v\n", err)
+		return 1
+	}
+	profiles, err := verifier.Profiles(claims)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find profiles %v: %v\n", claims.Profiles, err)
+		return 1
+	}
+
+	var root *common.Hash
+	if *claimRoot != "" {
+		h := common.HexToHash(*claimRoot)
+		root = &h
+	}
+	var segData []byte
+	if *segPath != "" {
+		if *seqNo < 0 {
+			fmt.Fprintln(os.Stderr, "Need to provide -seqNo with -segment")
+			return 2
+		}
+		if segData, err = ioutil.ReadFile(*segPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read segment: %v\n", err)
+			return 1
+		}
+	}
+
+	if *workDir == "" {
+		if *workDir, err = ioutil.TempDir("", "livepeer_verifier"); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create work dir: %v\n", err)
+			return 1
+		}
+		defer os.RemoveAll(*workDir)
+	}
+	tr := core.NewFFMpegTranscoder(profiles, *ffmpegPath, filepath.Join(*workDir, "transcode"))
+
+	seqNos := []int64{*seqNo}
+	if *seqNo < 0 {
+		seqNos = []int64{}
+		for _, r := range verifier.Ranges(claims) {
+			for i := r[0]; i <= r[1]; i++ {
+				seqNos = append(seqNos, i)
+			}
+		}
+	}
+
+	results := make([]*verifier.Result, 0, len(seqNos))
+	failed := false
+	for _, n := range seqNos {
+		res, err := verifier.Verify(claims, n, segData, tr, root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot verify segment %v: %v\n", n, err)
+			return 1
+		}
+		results = append(results, res)
+		failed = failed || !res.Pass
+		if !*jsonOutput {
+			printResult(res)
+		}
+	}
+
+	if *jsonOutput {
+		data, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(data))
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func printResult(res *verifier.Result) {
+	status := "PASS"
+	if !res.Pass {
+		status = "FAIL"
+	}
+	fmt.Printf("Segment %v (claim %v-%v, root %v): %v\n", res.SeqNo, res.ClaimStart, res.ClaimEnd, res.ClaimRoot.Hex(), status)
+	if !res.Pass {
+		fmt.Printf("  %v\n", strings.Join(res.Reasons, "\n  "))
+	}
+}
@@ -12,4 +12,9 @@ var VideoProfileNameLookup = map[string]string{
 	"c0a6517a": "P240p30fps16x9",
 	"d435c53a": "P240p30fps4x3",
 	"fca40bf9": "P144p30fps16x9",
+	//Audio profiles
+	"5285d9c2": "A128kStereo",
+	"1acdf339": "A64kStereo",
+	"1c1a1188": "A32kMono",
+	"54fcc6f4": "Passthrough",
 }
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/ericxtang/m3u8"
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+//AudioRenditionGroupID is the EXT-X-MEDIA group of the audio rendition the video renditions share.
+const AudioRenditionGroupID = "audio-rendition"
+
+//AudioProfile describes an audio-only rendition.  Jobs, claims and the network only know about lpms video profiles, so audio renditions
+//go through them as a VideoProfile with just the Name and Bitrate (see VideoProfile), and are told apart by name with IsAudioProfile.
+type AudioProfile struct {
+	Name       string
+	Codec      string
+	Bitrate    string
+	Channels   uint
+	SampleRate uint
+}
+
+//Some sample audio profiles
+var (
+	A128kStereo = AudioProfile{Name: "A128kStereo", Codec: "aac", Bitrate: "128k", Channels: 2, SampleRate: 48000}
+	A64kStereo  = AudioProfile{Name: "A64kStereo", Codec: "aac", Bitrate: "64k", Channels: 2, SampleRate: 44100}
+	A32kMono    = AudioProfile{Name: "A32kMono", Codec: "aac", Bitrate: "32k", Channels: 1, SampleRate: 44100}
+)
+
+var AudioProfileLookup = map[string]AudioProfile{
+	"A128kStereo": A128kStereo,
+	"A64kStereo":  A64kStereo,
+	"A32kMono":    A32kMono,
+}
+
+type audioCodec struct {
+	encoder string //ffmpeg encoder
+	codecs  string //RFC 6381 codec string for the playlist
+}
+
+var audioCodecs = map[string]audioCodec{
+	"aac": {encoder: "aac", codecs: "mp4a.40.2"},
+	"mp3": {encoder: "libmp3lame", codecs: "mp4a.40.34"},
+}
+
+func (p AudioProfile) VideoProfile() lpmscore.VideoProfile {
+	return lpmscore.VideoProfile{Name: p.Name, Bitrate: p.Bitrate}
+}
+
+//ProfileLookup looks up a video, audio or passthrough profile by name.
+func ProfileLookup(name string) (lpmscore.VideoProfile, bool) {
+	if p, ok := lpmscore.VideoProfileLookup[name]; ok {
+		return p, true
+	}
+	if p, ok := AudioProfileLookup[name]; ok {
+		return p.VideoProfile(), true
+	}
+	if IsPassthroughProfile(name) {
+		return PassthroughProfile, true
+	}
+	return lpmscore.VideoProfile{}, false
+}
+
+func IsAudioProfile(name string) bool {
+	_, ok := AudioProfileLookup[name]
+	return ok
+}
+
+//ProfileNames returns the names of all profiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(lpmscore.VideoProfileLookup)+len(AudioProfileLookup)+1)
+	for name := range lpmscore.VideoProfileLookup {
+		names = append(names, name)
+	}
+	for name := range AudioProfileLookup {
+		names = append(names, name)
+	}
+	names = append(names, PassthroughProfile.Name)
+	sort.Strings(names)
+	return names
+}
+
+//ProfileVariantParams returns the master playlist params of a rendition.  Audio renditions get CODECS, so players know there's no video.
+//The params of passthrough renditions are empty, they should be the params of the source.
+func ProfileVariantParams(p lpmscore.VideoProfile) m3u8.VariantParams {
+	if IsPassthroughProfile(p.Name) {
+		//Same as the source, which only the broadcaster knows
+		return m3u8.VariantParams{}
+	}
+	a, ok := AudioProfileLookup[p.Name]
+	if !ok {
+		return lpmscore.VideoProfileToVariantParams(p)
+	}
+	vParams := lpmscore.VideoProfileToVariantParams(lpmscore.VideoProfile{Bitrate: a.Bitrate})
+	vParams.Codecs = audioCodecs[a.Codec].codecs
+	return vParams
+}
+
+//SharedAudioRendition returns the EXT-X-MEDIA entry of the audio rendition the video renditions share, which is the one with the highest
+//bitrate.  renditions is profile name -> playlist URI.  It returns nil if there is no audio rendition.
+func SharedAudioRendition(renditions map[string]string) *m3u8.Alternative {
+	audio := make([]lpmscore.VideoProfile, 0)
+	for name := range renditions {
+		if a, ok := AudioProfileLookup[name]; ok {
+			audio = append(audio, a.VideoProfile())
+		}
+	}
+	if len(audio) == 0 {
+		return nil
+	}
+	sort.Sort(byBandwidth(audio))
+	return &m3u8.Alternative{Type: "AUDIO", GroupId: AudioRenditionGroupID, Name: audio[0].Name, URI: renditions[audio[0].Name], Default: true, Autoselect: "YES"}
+}
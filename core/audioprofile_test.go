@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+func TestProfileLookup(t *testing.T) {
+	if p, ok := ProfileLookup("P240p30fps16x9"); !ok || p != lpmscore.P240p30fps16x9 {
+		t.Errorf("Expecting video profile, got %v %v", p, ok)
+	}
+	if p, ok := ProfileLookup("A64kStereo"); !ok || p.Name != "A64kStereo" || p.Bitrate != "64k" || p.Resolution != "" {
+		t.Errorf("Expecting audio profile, got %v %v", p, ok)
+	}
+	if _, ok := ProfileLookup("P9000p"); ok {
+		t.Errorf("Not expecting unknown profile")
+	}
+	if IsAudioProfile("P240p30fps16x9") || !IsAudioProfile("A32kMono") {
+		t.Errorf("Unexpected IsAudioProfile")
+	}
+	if names := ProfileNames(); len(names) != len(lpmscore.VideoProfileLookup)+len(AudioProfileLookup)+1 || names[0] != "A128kStereo" {
+		t.Errorf("Unexpected profile names %v", names)
+	}
+
+	vParams := ProfileVariantParams(A128kStereo.VideoProfile())
+	if vParams.Bandwidth != 128000 || vParams.Codecs != "mp4a.40.2" || vParams.Resolution != "" {
+		t.Errorf("Unexpected audio variant params %v", vParams)
+	}
+	if vParams := ProfileVariantParams(lpmscore.P240p30fps16x9); vParams.Bandwidth != 600000 || vParams.Resolution != "426x240" {
+		t.Errorf("Unexpected video variant params %v", vParams)
+	}
+}
+
+func TestSharedAudioRendition(t *testing.T) {
+	if alt := SharedAudioRendition(map[string]string{"P240p30fps16x9": "v.m3u8"}); alt != nil {
+		t.Errorf("Not expecting shared audio without audio rendition, got %v", alt)
+	}
+	alt := SharedAudioRendition(map[string]string{"P240p30fps16x9": "v.m3u8", "A32kMono": "a32.m3u8", "A64kStereo": "a64.m3u8"})
+	if alt == nil || alt.URI != "a64.m3u8" || alt.Type != "AUDIO" || alt.GroupId != AudioRenditionGroupID || !alt.Default {
+		t.Errorf("Expecting highest bitrate audio rendition to be shared, got %v", alt)
+	}
+}
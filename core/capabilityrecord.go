@@ -0,0 +1,269 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/eth/signer"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+var ErrCapabilityRecord = errors.New("ErrCapabilityRecord")
+
+//CapabilityAnnounceInterval is how often transcoders announce their capabilities.
+var CapabilityAnnounceInterval = time.Minute
+
+//CapabilityRecordTTL is how long a record is kept without a newer one.  Transcoders that stop announcing are taken to be offline.
+var CapabilityRecordTTL = 3 * CapabilityAnnounceInterval
+
+//MaxCapabilityClockSkew is how far in the future a record can be, since node clocks are off a little.
+var MaxCapabilityClockSkew = time.Minute
+
+//TranscoderPoolCheckTTL is how long a lookup of the transcoder pool holds, so the pool isn't looked up for every record.
+var TranscoderPoolCheckTTL = 10 * time.Minute
+
+//CapabilityRecord is what a transcoder announces about itself to the network, so broadcasters can pick prices and profiles that fit.
+type CapabilityRecord struct {
+	NodeID     string
+	EthAddress ethcommon.Address
+	Version    string
+	//Capabilities are the capabilities of the node status
+	Capabilities []string
+	//Profiles are the names of the profiles the transcoder transcodes into
+	Profiles []string
+	//Capacity is how many streams the transcoder can transcode at once, 0 if it didn't say.  Jobs is how many it's transcoding.
+	Capacity int
+	Jobs     int
+	Region   string
+	//PricePerSegment is the price the transcoder asks, in wei
+	PricePerSegment *big.Int
+	//SegmentURL is where the transcoder takes segments over HTTP, empty if it doesn't
+	SegmentURL string `json:",omitempty"`
+	Timestamp  time.Time
+}
+
+//HasProfile tells if the transcoder transcodes into the profile called name.
+func (r *CapabilityRecord) HasProfile(name string) bool {
+	for _, p := range r.Profiles {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+//signedRecord is how records are announced.  The signature is over the exact bytes of the record, signed by the Eth account in it.
+type signedRecord struct {
+	Record []byte
+	Sig    []byte
+}
+
+//encodeSigned signs the JSON of v with sign, which signs a hash with the key of an Eth account.
+func encodeSigned(v interface{}, sign func(hash []byte) ([]byte, error)) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := sign(crypto.Keccak256(data))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(signedRecord{Record: data, Sig: sig})
+}
+
+//decodeSigned decodes a record from encodeSigned into v, and returns the address that signed it.
+func decodeSigned(data []byte, v interface{}) (ethcommon.Address, error) {
+	var signed signedRecord
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return ethcommon.Address{}, err
+	}
+	if err := json.Unmarshal(signed.Record, v); err != nil {
+		return ethcommon.Address{}, err
+	}
+	pub, err := crypto.SigToPub(signer.SegmentSignHash(crypto.Keccak256(signed.Record)), signed.Sig)
+	if err != nil {
+		return ethcommon.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+//EncodeCapabilityRecord signs the record with sign, which signs a hash with the key of the record's EthAddress.
+func EncodeCapabilityRecord(rec *CapabilityRecord, sign func(hash []byte) ([]byte, error)) ([]byte, error) {
+	return encodeSigned(rec, sign)
+}
+
+//DecodeCapabilityRecord decodes an announced record, and checks it was signed by its EthAddress.
+func DecodeCapabilityRecord(data []byte) (*CapabilityRecord, error) {
+	var rec CapabilityRecord
+	addr, err := decodeSigned(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+	if addr != rec.EthAddress {
+		glog.Errorf("Capability record of %v isn't signed by %v", rec.NodeID, rec.EthAddress.Hex())
+		return nil, ErrCapabilityRecord
+	}
+	return &rec, nil
+}
+
+//CapabilityCache keeps the latest record of each transcoder account, until it expires.
+type CapabilityCache struct {
+	lock    sync.Mutex
+	records map[ethcommon.Address]*CapabilityRecord
+	//poolChecks are the lookups of the transcoder pool, by address
+	poolChecks map[ethcommon.Address]poolCheck
+	now        func() time.Time
+}
+
+type poolCheck struct {
+	inPool bool
+	at     time.Time
+}
+
+func NewCapabilityCache() *CapabilityCache {
+	return &CapabilityCache{records: make(map[ethcommon.Address]*CapabilityRecord), poolChecks: make(map[ethcommon.Address]poolCheck), now: time.Now}
+}
+
+//InPool tells if addr is in the transcoder pool.  It is looked up with lookup, unless it was in the last TranscoderPoolCheckTTL.
+func (c *CapabilityCache) InPool(addr ethcommon.Address, lookup func(addr ethcommon.Address) (bool, error)) (bool, error) {
+	c.lock.Lock()
+	now := c.now()
+	for a, check := range c.poolChecks {
+		if now.Sub(check.at) > TranscoderPoolCheckTTL {
+			delete(c.poolChecks, a)
+		}
+	}
+	check, ok := c.poolChecks[addr]
+	c.lock.Unlock()
+	if ok {
+		return check.inPool, nil
+	}
+
+	inPool, err := lookup(addr)
+	if err != nil {
+		return false, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.poolChecks[addr] = poolCheck{inPool: inPool, at: now}
+	return inPool, nil
+}
+
+//Add keeps rec if it's newer than the record the cache has of its account.  Records that expired already, or that are too far in the future,
+//are dropped.
+func (c *CapabilityCache) Add(rec *CapabilityRecord) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	if now.Sub(rec.Timestamp) > CapabilityRecordTTL || rec.Timestamp.Sub(now) > MaxCapabilityClockSkew {
+		return false
+	}
+	if old, ok := c.records[rec.EthAddress]; ok && !rec.Timestamp.After(old.Timestamp) {
+		return false
+	}
+	c.records[rec.EthAddress] = rec
+	return true
+}
+
+//Records returns the records that haven't expired, by Eth address.
+func (c *CapabilityCache) Records() []*CapabilityRecord {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	addrs := make([]string, 0, len(c.records))
+	for addr, rec := range c.records {
+		if now.Sub(rec.Timestamp) > CapabilityRecordTTL {
+			delete(c.records, addr)
+			continue
+		}
+		addrs = append(addrs, addr.Hex())
+	}
+	sort.Strings(addrs)
+	recs := make([]*CapabilityRecord, 0, len(addrs))
+	for _, addr := range addrs {
+		recs = append(recs, c.records[ethcommon.HexToAddress(addr)])
+	}
+	return recs
+}
+
+//capabilityRecord is the record of the node as it is now.
+func (n *LivepeerNode) capabilityRecord() *CapabilityRecord {
+	n.shutdownLock.Lock()
+	jobs := len(n.transcodeJobs)
+	n.shutdownLock.Unlock()
+	return &CapabilityRecord{
+		NodeID:          string(n.Identity),
+		EthAddress:      n.Eth.Account().Address,
+		Version:         LivepeerVersion,
+		Capabilities:    append([]string{}, n.Capabilities...),
+		Profiles:        ProfileNames(),
+		Capacity:        n.TranscoderCapacity,
+		Jobs:            jobs,
+		Region:          n.Region,
+		PricePerSegment: n.Settings.Get().TranscoderSegmentPrice,
+		SegmentURL:      n.SegmentURL,
+		Timestamp:       time.Now(),
+	}
+}
+
+//AnnounceCapabilities announces the capabilities of the node every CapabilityAnnounceInterval, until ctx is done.  The records are signed
+//with the Eth account of the node, so it needs one.
+func (n *LivepeerNode) AnnounceCapabilities(ctx context.Context) error {
+	a, ok := n.VideoNetwork.(net.CapabilityAnnouncer)
+	if !ok || n.Eth == nil {
+		glog.Errorf("Cannot announce capabilities without a network that spreads them and an Eth account")
+		return ErrCapabilityRecord
+	}
+	ticker := time.NewTicker(CapabilityAnnounceInterval)
+	defer ticker.Stop()
+	for {
+		data, err := EncodeCapabilityRecord(n.capabilityRecord(), n.Eth.SignSegmentHash)
+		if err != nil {
+			glog.Errorf("Error signing capability record: %v", err)
+		} else if err := a.AnnounceCapabilities(data); err != nil {
+			glog.Errorf("Error announcing capabilities: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+//gotCapabilities caches the records that other nodes announce, and returns false for the ones that aren't passed on.  nodeID signed the
+//announcement, and the Eth account signed the node ID in the record, so the record ties the node to an account.  The account has to be in
+//the transcoder pool.
+func (n *LivepeerNode) gotCapabilities(nodeID string, data []byte) bool {
+	rec, err := DecodeCapabilityRecord(data)
+	if err != nil {
+		glog.Errorf("Dropping capability record announced by %v: %v", nodeID, err)
+		return false
+	}
+	if rec.NodeID != nodeID {
+		glog.Errorf("Dropping capability record of %v announced by %v", rec.NodeID, nodeID)
+		return false
+	}
+	if n.Eth == nil {
+		glog.Errorf("Cannot check the capability record of %v without an Eth client", nodeID)
+		return false
+	}
+	inPool, err := n.Transcoders.InPool(rec.EthAddress, n.Eth.IsTranscoderInPool)
+	if err != nil {
+		glog.Errorf("Error looking up %v in the transcoder pool: %v", rec.EthAddress.Hex(), err)
+		return false
+	}
+	if !inPool {
+		glog.Errorf("Dropping capability record of %v: %v isn't in the transcoder pool", nodeID, rec.EthAddress.Hex())
+		return false
+	}
+	return n.Transcoders.Add(rec)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/eth/signer"
+)
+
+func TestCapabilityRecordSignature(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	sign := func(hash []byte) ([]byte, error) { return crypto.Sign(signer.SegmentSignHash(hash), key) }
+	rec := &CapabilityRecord{
+		NodeID:          "node",
+		EthAddress:      crypto.PubkeyToAddress(key.PublicKey),
+		Profiles:        []string{"P240p30fps16x9"},
+		PricePerSegment: big.NewInt(5),
+		Timestamp:       time.Now(),
+	}
+	data, err := EncodeCapabilityRecord(rec, sign)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	got, err := DecodeCapabilityRecord(data)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got.NodeID != "node" || got.EthAddress != rec.EthAddress || got.PricePerSegment.Cmp(big.NewInt(5)) != 0 || !got.HasProfile("P240p30fps16x9") {
+		t.Errorf("Expecting %+v, got %+v", rec, got)
+	}
+	if got.HasProfile("P720p30fps16x9") {
+		t.Errorf("Expecting no P720p30fps16x9")
+	}
+
+	//A record for someone else's address doesn't decode
+	other, _ := crypto.GenerateKey()
+	rec.EthAddress = crypto.PubkeyToAddress(other.PublicKey)
+	data, err = EncodeCapabilityRecord(rec, sign)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err := DecodeCapabilityRecord(data); err != ErrCapabilityRecord {
+		t.Errorf("Expecting ErrCapabilityRecord, got %v", err)
+	}
+	if _, err := DecodeCapabilityRecord([]byte("junk")); err == nil {
+		t.Errorf("Expecting error for junk")
+	}
+}
+
+func TestCapabilityCache(t *testing.T) {
+	now := time.Now()
+	c := NewCapabilityCache()
+	c.now = func() time.Time { return now }
+	a, b, cc := ethcommon.BytesToAddress([]byte{1}), ethcommon.BytesToAddress([]byte{2}), ethcommon.BytesToAddress([]byte{3})
+
+	if !c.Add(&CapabilityRecord{EthAddress: b, Timestamp: now}) || !c.Add(&CapabilityRecord{EthAddress: a, Timestamp: now}) {
+		t.Errorf("Expecting the records to be added")
+	}
+	//Older and duplicate records are dropped, newer ones replace
+	if c.Add(&CapabilityRecord{EthAddress: a, Timestamp: now.Add(-time.Second), Region: "old"}) {
+		t.Errorf("Expecting the older record to be dropped")
+	}
+	//Records are by account, whatever node announces them
+	if !c.Add(&CapabilityRecord{NodeID: "other", EthAddress: a, Timestamp: now.Add(time.Second), Region: "new"}) {
+		t.Errorf("Expecting the newer record to be added")
+	}
+	//Expired records and records from too far in the future are dropped
+	if c.Add(&CapabilityRecord{EthAddress: cc, Timestamp: now.Add(-CapabilityRecordTTL - time.Second)}) {
+		t.Errorf("Expecting the expired record to be dropped")
+	}
+	if c.Add(&CapabilityRecord{EthAddress: cc, Timestamp: now.Add(MaxCapabilityClockSkew + time.Second)}) {
+		t.Errorf("Expecting the future record to be dropped")
+	}
+
+	recs := c.Records()
+	if len(recs) != 2 || recs[0].EthAddress != a || recs[0].Region != "new" || recs[1].EthAddress != b {
+		t.Errorf("Expecting a and b, got %+v", recs)
+	}
+
+	now = now.Add(CapabilityRecordTTL + time.Millisecond)
+	if recs := c.Records(); len(recs) != 1 || recs[0].EthAddress != a {
+		t.Errorf("Expecting b to expire, got %+v", recs)
+	}
+}
+
+func TestCapabilityCachePool(t *testing.T) {
+	now := time.Now()
+	c := NewCapabilityCache()
+	c.now = func() time.Time { return now }
+	a := ethcommon.BytesToAddress([]byte{1})
+	lookups := 0
+	inPool := true
+	lookup := func(addr ethcommon.Address) (bool, error) {
+		lookups++
+		return inPool, nil
+	}
+	if ok, err := c.InPool(a, lookup); !ok || err != nil {
+		t.Errorf("Expecting a in the pool, got %v %v", ok, err)
+	}
+	//The pool is looked up again once the last lookup expired
+	inPool = false
+	if ok, _ := c.InPool(a, lookup); !ok || lookups != 1 {
+		t.Errorf("Expecting the lookup to hold, got %v after %v lookups", ok, lookups)
+	}
+	now = now.Add(TranscoderPoolCheckTTL + time.Second)
+	if ok, _ := c.InPool(a, lookup); ok || lookups != 2 {
+		t.Errorf("Expecting a to leave the pool, got %v after %v lookups", ok, lookups)
+	}
+	if _, err := c.InPool(ethcommon.BytesToAddress([]byte{2}), func(addr ethcommon.Address) (bool, error) { return false, ErrCapabilityRecord }); err != ErrCapabilityRecord {
+		t.Errorf("Expecting ErrCapabilityRecord, got %v", err)
+	}
+}
+
+func TestGotCapabilities(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	sign := func(hash []byte) ([]byte, error) { return crypto.Sign(signer.SegmentSignHash(hash), key) }
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	rec := &CapabilityRecord{NodeID: "node", EthAddress: addr, Timestamp: time.Now()}
+	data, err := EncodeCapabilityRecord(rec, sign)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	seth := &eth.StubClient{TranscoderPool: map[ethcommon.Address]bool{}}
+	n := &LivepeerNode{Eth: seth, Transcoders: NewCapabilityCache()}
+	//Accounts that aren't in the transcoder pool aren't taken
+	if n.gotCapabilities("node", data) || len(n.Transcoders.Records()) != 0 {
+		t.Errorf("Expecting the record to be dropped")
+	}
+	seth.TranscoderPool[addr] = true
+	n.Transcoders = NewCapabilityCache()
+	//Nodes can only announce records with their own node ID in them
+	if n.gotCapabilities("other", data) {
+		t.Errorf("Expecting the record of another node to be dropped")
+	}
+	if !n.gotCapabilities("node", data) {
+		t.Errorf("Expecting the record to be taken")
+	}
+	if recs := n.Transcoders.Records(); len(recs) != 1 || recs[0].EthAddress != addr {
+		t.Errorf("Expecting the record of %v, got %+v", addr.Hex(), recs)
+	}
+	//Nodes without an Eth client can't check the pool
+	n = &LivepeerNode{Transcoders: NewCapabilityCache()}
+	if n.gotCapabilities("node", data) {
+		t.Errorf("Expecting the record to be dropped without an Eth client")
+	}
+}
@@ -3,9 +3,14 @@ package core
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -15,7 +20,7 @@ import (
 	lpCommon "github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/eth"
 	ethTypes "github.com/livepeer/go-livepeer/eth/types"
-	"github.com/livepeer/go-livepeer/ipfs"
+	"github.com/livepeer/go-livepeer/storage"
 	lpmscore "github.com/livepeer/lpms/core"
 )
 
@@ -32,6 +37,45 @@ type ClaimManager interface {
 	DistributeFees() error
 }
 
+//ClaimPersister is implemented by claim managers that can save outstanding claims to disk, so they are not lost when the node shuts down.
+type ClaimPersister interface {
+	PersistClaims(path string) error
+	//SetClaimsPath makes the claim manager persist its claims to path each time it submits a claim, so they can be verified later.
+	SetClaimsPath(path string)
+}
+
+//PersistedClaims is the on-disk format for the claims of a job whose fees have not been distributed yet.
+type PersistedClaims struct {
+	StreamID        string
+	JobID           string
+	BroadcasterAddr string
+	PricePerSegment string
+	Profiles        []string
+	Segments        []PersistedSegmentClaim
+	//Claims are the claims submitted on-chain for the segments
+	Claims []PersistedClaim `json:",omitempty"`
+}
+
+//PersistedSegmentClaim is a single segment.  TranscodedDataHashes is keyed by profile name.  ClaimProof is the Merkle proof of the
+//segment's receipt, if the segment is claimed.
+type PersistedSegmentClaim struct {
+	SeqNo                int64
+	SegData              []byte
+	DataHash             []byte
+	TranscodedDataHashes map[string][]byte
+	BroadcasterSig       []byte
+	ClaimProof           []byte `json:",omitempty"`
+}
+
+//PersistedClaim is a claim submitted on-chain for the segments Start to End.
+type PersistedClaim struct {
+	ID       int64
+	Start    int64
+	End      int64
+	Root     string
+	BlockNum string
+}
+
 type claimData struct {
 	seqNo                int64
 	segData              []byte
@@ -43,13 +87,17 @@ type claimData struct {
 	claimBlkNum          *big.Int
 	claimProof           []byte
 	claimId              *big.Int
+	claimRoot            common.Hash
 	claimConcatTDatahash []byte
 }
 
 //BasicClaimManager manages the claim process for a Livepeer transcoder.  Check the Livepeer protocol for more details.
 type BasicClaimManager struct {
-	client eth.LivepeerEthClient
-	ipfs   ipfs.IpfsApi
+	client  eth.LivepeerEthClient
+	storage storage.Storage
+
+	//lock guards segClaimMap and cost, receipts are added while earlier claims are submitted
+	lock sync.Mutex
 
 	strmID   string
 	jobID    *big.Int
@@ -63,10 +111,18 @@ type BasicClaimManager struct {
 	pricePerSegment *big.Int
 
 	r *ethTypes.TranscodeReceipt
+
+	//firstClaimID is the on-chain ID of the first claim of the manager.  Claim IDs are the index of the claim in the job, so it's only
+	//set for claims that are recovered after the job already had claims.
+	firstClaimID int64
+
+	//claimsPath is where the claims are persisted when they are submitted
+	claimsPath  string
+	persistLock sync.Mutex
 }
 
 //NewBasicClaimManager creates a new claim manager.
-func NewBasicClaimManager(sid string, jid *big.Int, broadcaster common.Address, pricePerSegment *big.Int, p []lpmscore.VideoProfile, c eth.LivepeerEthClient, ipfs ipfs.IpfsApi) *BasicClaimManager {
+func NewBasicClaimManager(sid string, jid *big.Int, broadcaster common.Address, pricePerSegment *big.Int, p []lpmscore.VideoProfile, c eth.LivepeerEthClient, storage storage.Storage) *BasicClaimManager {
 	seqNos := make([][]int64, len(p), len(p))
 	rHashes := make([][]common.Hash, len(p), len(p))
 	sd := make([][][]byte, len(p), len(p))
@@ -92,7 +148,103 @@ func NewBasicClaimManager(sid string, jid *big.Int, broadcaster common.Address,
 		pLookup[p[i]] = i
 	}
 	// return &BasicClaimManager{client: c, ipfs: ipfs, strmID: sid, jobID: jid, cost: big.NewInt(0), broadcasterAddr: broadcaster, pricePerSegment: pricePerSegment, seqNos: seqNos, receiptHashes: rHashes, segData: sd, dataHashes: dHashes, tDataHashes: tHashes, bSigs: sigs, profiles: p, pLookup: pLookup}
-	return &BasicClaimManager{client: c, ipfs: ipfs, strmID: sid, jobID: jid, cost: big.NewInt(0), broadcasterAddr: broadcaster, pricePerSegment: pricePerSegment, profiles: p, pLookup: pLookup, segClaimMap: make(map[int64]*claimData)}
+	return &BasicClaimManager{client: c, storage: storage, strmID: sid, jobID: jid, cost: big.NewInt(0), broadcasterAddr: broadcaster, pricePerSegment: pricePerSegment, profiles: p, pLookup: pLookup, segClaimMap: make(map[int64]*claimData)}
+}
+
+//LoadPersistedClaims reads claims saved by PersistClaims.
+func LoadPersistedClaims(path string) (*PersistedClaims, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pc PersistedClaims
+	if err := json.Unmarshal(data, &pc); err != nil {
+		return nil, err
+	}
+	return &pc, nil
+}
+
+//RecoverClaimManager creates a claim manager for persisted claims, so they can be submitted after the node restarts.  The recovered
+//claims get the IDs after the claims the job already has on-chain.
+func RecoverClaimManager(pc *PersistedClaims, c eth.LivepeerEthClient, storage storage.Storage) (*BasicClaimManager, error) {
+	jid, ok := new(big.Int).SetString(pc.JobID, 10)
+	if !ok {
+		glog.Errorf("Bad job ID in claims of %v: %v", pc.StreamID, pc.JobID)
+		return nil, ErrClaimManager
+	}
+	price, ok := new(big.Int).SetString(pc.PricePerSegment, 10)
+	if !ok {
+		glog.Errorf("Bad price in claims of %v: %v", pc.StreamID, pc.PricePerSegment)
+		return nil, ErrClaimManager
+	}
+	ps := make([]lpmscore.VideoProfile, 0, len(pc.Profiles))
+	for _, name := range pc.Profiles {
+		p, ok := ProfileLookup(name)
+		if !ok {
+			glog.Errorf("Unknown profile in claims of %v: %v", pc.StreamID, name)
+			return nil, ErrClaimManager
+		}
+		ps = append(ps, p)
+	}
+	firstClaimID, err := nextClaimID(c, jid)
+	if err != nil {
+		glog.Errorf("Error getting the claims of job %v: %v", jid, err)
+		return nil, err
+	}
+
+	cm := NewBasicClaimManager(pc.StreamID, jid, common.HexToAddress(pc.BroadcasterAddr), price, ps, c, storage)
+	cm.firstClaimID = firstClaimID
+	for _, seg := range pc.Segments {
+		cd := &claimData{
+			seqNo:       seg.SeqNo,
+			segData:     seg.SegData,
+			dataHash:    seg.DataHash,
+			tDataHashes: make(map[lpmscore.VideoProfile][]byte),
+			bSig:        seg.BroadcasterSig,
+		}
+		for name, h := range seg.TranscodedDataHashes {
+			p, ok := ProfileLookup(name)
+			if !ok {
+				glog.Errorf("Unknown profile in claims of %v: %v", pc.StreamID, name)
+				return nil, ErrClaimManager
+			}
+			cd.tDataHashes[p] = h
+			cm.cost = new(big.Int).Add(cm.cost, price)
+		}
+		//Segments that were claimed before keep their claim, so they are verified and paid but not claimed again
+		for _, claim := range pc.Claims {
+			if seg.SeqNo < claim.Start || seg.SeqNo > claim.End {
+				continue
+			}
+			blkNum, ok := new(big.Int).SetString(claim.BlockNum, 10)
+			if !ok {
+				glog.Errorf("Bad block number in claim %v of %v: %v", claim.ID, pc.StreamID, claim.BlockNum)
+				return nil, ErrClaimManager
+			}
+			cd.claimStart = claim.Start
+			cd.claimEnd = claim.End
+			cd.claimBlkNum = blkNum
+			cd.claimProof = seg.ClaimProof
+			cd.claimId = big.NewInt(claim.ID)
+			cd.claimRoot = common.HexToHash(claim.Root)
+			cd.claimConcatTDatahash = cm.concatTDataHash(cd)
+		}
+		cm.segClaimMap[seg.SeqNo] = cd
+	}
+	return cm, nil
+}
+
+//nextClaimID finds the ID of the next claim of the job.  Claims that don't exist have no claim block.
+func nextClaimID(c eth.LivepeerEthClient, jid *big.Int) (int64, error) {
+	for id := int64(0); ; id++ {
+		claim, err := c.GetClaim(jid, big.NewInt(id))
+		if err != nil {
+			return 0, err
+		}
+		if claim == nil || claim.ClaimBlock == nil || claim.ClaimBlock.Sign() == 0 {
+			return id, nil
+		}
+	}
 }
 
 //AddReceipt adds a claim for a given video segment.
@@ -105,6 +257,9 @@ func (c *BasicClaimManager) AddReceipt(seqNo int64, data []byte, tDataHash []byt
 		return ErrClaimManager
 	}
 
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	cd, ok := c.segClaimMap[seqNo]
 	if !ok {
 		cd = &claimData{
@@ -125,6 +280,65 @@ func (c *BasicClaimManager) AddReceipt(seqNo int64, data []byte, tDataHash []byt
 	return nil
 }
 
+//SetClaimsPath makes Claim persist the claims to path when they are submitted.
+func (c *BasicClaimManager) SetClaimsPath(path string) {
+	c.persistLock.Lock()
+	defer c.persistLock.Unlock()
+	c.claimsPath = path
+}
+
+//PersistClaims writes the segments, and the claims submitted for them, to path as JSON.  Nothing is written if there are no segments.
+func (c *BasicClaimManager) PersistClaims(path string) error {
+	c.persistLock.Lock()
+	defer c.persistLock.Unlock()
+	pc := PersistedClaims{
+		StreamID:        c.strmID,
+		BroadcasterAddr: c.broadcasterAddr.Hex(),
+		PricePerSegment: c.pricePerSegment.String(),
+		Profiles:        make([]string, 0, len(c.profiles)),
+		Segments:        make([]PersistedSegmentClaim, 0),
+	}
+	if c.jobID != nil {
+		pc.JobID = c.jobID.String()
+	}
+	for _, p := range c.profiles {
+		pc.Profiles = append(pc.Profiles, p.Name)
+	}
+	c.lock.Lock()
+	keys := []int64{}
+	for key := range c.segClaimMap {
+		keys = append(keys, key)
+	}
+	sort.Sort(SortUint64(keys))
+	for _, seqNo := range keys {
+		cd := c.segClaimMap[seqNo]
+		tHashes := make(map[string][]byte)
+		for p, h := range cd.tDataHashes {
+			tHashes[p.Name] = h
+		}
+		pc.Segments = append(pc.Segments, PersistedSegmentClaim{SeqNo: seqNo, SegData: cd.segData, DataHash: cd.dataHash, TranscodedDataHashes: tHashes, BroadcasterSig: cd.bSig, ClaimProof: cd.claimProof})
+		//Segments are sorted, so the first segment of a claim adds it
+		if cd.claimBlkNum != nil && seqNo == cd.claimStart {
+			pc.Claims = append(pc.Claims, PersistedClaim{ID: cd.claimId.Int64(), Start: cd.claimStart, End: cd.claimEnd, Root: cd.claimRoot.Hex(), BlockNum: cd.claimBlkNum.String()})
+		}
+	}
+	c.lock.Unlock()
+	if len(pc.Segments) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(pc)
+	if err != nil {
+		glog.Errorf("Error encoding claims: %v", err)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		glog.Errorf("Error creating claim dir: %v", err)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
 func (c *BasicClaimManager) SufficientBroadcasterDeposit() (bool, error) {
 	bDeposit, err := c.client.GetBroadcasterDeposit(c.broadcasterAddr)
 	if err != nil {
@@ -134,7 +348,9 @@ func (c *BasicClaimManager) SufficientBroadcasterDeposit() (bool, error) {
 
 	//If broadcaster does not have enough for a segment, return false
 	//If broadcaster has enough for at least one transcoded segment, return true
+	c.lock.Lock()
 	currDeposit := new(big.Int).Sub(bDeposit, c.cost)
+	c.lock.Unlock()
 	if new(big.Int).Sub(currDeposit, new(big.Int).Mul(big.NewInt(int64(len(c.profiles))), c.pricePerSegment)).Cmp(big.NewInt(0)) == -1 {
 		return false, nil
 	} else {
@@ -148,6 +364,8 @@ func (a SortUint64) Len() int           { return len(a) }
 func (a SortUint64) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a SortUint64) Less(i, j int) bool { return a[i] < a[j] }
 
+//makeRanges returns the ranges of segments that can be claimed.  Segments that are claimed already are left out.  It's called with the
+//lock held.
 func (c *BasicClaimManager) makeRanges() [][2]int64 {
 	//Get seqNos, sort them
 	keys := []int64{}
@@ -155,6 +373,9 @@ func (c *BasicClaimManager) makeRanges() [][2]int64 {
 		keys = append(keys, key)
 	}
 	sort.Sort(SortUint64(keys))
+	if len(keys) == 0 {
+		return [][2]int64{}
+	}
 
 	//Iterate through, check to make sure all tHashes are present (otherwise break and start new range),
 	start := keys[0]
@@ -163,10 +384,14 @@ func (c *BasicClaimManager) makeRanges() [][2]int64 {
 		startNewRange := false
 		scm := c.segClaimMap[key]
 
-		//If not all profiles exist in transcoded hashes, remove current key and start new range (don't claim for current segment)
+		//If not all profiles exist in transcoded hashes, or the segment is claimed, remove current key and start new range (don't claim
+		//for current segment)
 		for _, p := range c.profiles {
-			if _, ok := scm.tDataHashes[p]; !ok {
-				ranges = append(ranges, [2]int64{start, keys[i-1]})
+			if _, ok := scm.tDataHashes[p]; !ok || scm.claimBlkNum != nil {
+				//The current range is empty if this segment would have started it
+				if key != start {
+					ranges = append(ranges, [2]int64{start, keys[i-1]})
+				}
 				startNewRange = true
 				break
 			}
@@ -189,6 +414,8 @@ func (c *BasicClaimManager) makeRanges() [][2]int64 {
 
 //Claim creates the onchain claim for all the claims added through AddReceipt
 func (c *BasicClaimManager) Claim() (claimCount int, rc chan types.Receipt, ec chan error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	ranges := c.makeRanges()
 	ec = make(chan error)
 	rc = make(chan types.Receipt)
@@ -197,12 +424,8 @@ func (c *BasicClaimManager) Claim() (claimCount int, rc chan types.Receipt, ec c
 		//create concat hashes for each seg
 		receiptHashes := make([]common.Hash, segRange[1]-segRange[0]+1)
 		for i := segRange[0]; i <= segRange[1]; i++ {
-			segTDataHashes := make([][]byte, len(c.profiles))
-			for pi, p := range c.profiles {
-				segTDataHashes[pi] = []byte(c.segClaimMap[i].tDataHashes[p])
-			}
 			seg, _ := c.segClaimMap[i]
-			seg.claimConcatTDatahash = crypto.Keccak256(segTDataHashes...)
+			seg.claimConcatTDatahash = c.concatTDataHash(seg)
 
 			receipt := &ethTypes.TranscodeReceipt{
 				StreamID:                 c.strmID,
@@ -231,16 +454,28 @@ func (c *BasicClaimManager) Claim() (claimCount int, rc chan types.Receipt, ec c
 				if err != nil {
 					glog.Infof("Error getting block number / hash: %v", err)
 					ec <- err
+					return
 				}
 				// glog.Infof("Got block hash: %x, block number: %v", blkHash, blkNum)
 				//Record claim information for verification later
+				c.lock.Lock()
 				for i := segRange[0]; i <= segRange[1]; i++ {
 					seg, _ := c.segClaimMap[i]
 					seg.claimStart = segRange[0]
 					seg.claimEnd = segRange[1]
 					seg.claimBlkNum = blkNum
 					seg.claimProof = proofs[i-segRange[0]].Bytes()
-					seg.claimId = big.NewInt(int64(rangeIdx))
+					seg.claimId = big.NewInt(c.firstClaimID + int64(rangeIdx))
+					seg.claimRoot = root.Hash
+				}
+				c.lock.Unlock()
+				c.persistLock.Lock()
+				path := c.claimsPath
+				c.persistLock.Unlock()
+				if path != "" {
+					if err := c.PersistClaims(path); err != nil {
+						glog.Errorf("Error persisting claim %v of %v: %v", c.firstClaimID+int64(rangeIdx), c.strmID, err)
+					}
 				}
 
 				rc <- res
@@ -262,8 +497,16 @@ func (c *BasicClaimManager) Verify() error {
 		return err
 	}
 
-	//Iterate through segments, determine which one needs to be verified.
+	//Copy the claims, so receipts can be added while the verifications are submitted
+	c.lock.Lock()
+	claims := make(map[int64]claimData, len(c.segClaimMap))
 	for segNo, scm := range c.segClaimMap {
+		claims[segNo] = *scm
+	}
+	c.lock.Unlock()
+
+	//Iterate through segments, determine which one needs to be verified.
+	for segNo, scm := range claims {
 		if scm.claimBlkNum == nil {
 			glog.Errorf("Claim failed.  Skipping verification for %v.", segNo)
 			continue
@@ -271,9 +514,13 @@ func (c *BasicClaimManager) Verify() error {
 		if c.shouldVerifySegment(segNo, scm.claimStart, scm.claimEnd, scm.claimBlkNum.Int64(), verifyRate) {
 			glog.Infof("Calling verify")
 
-			dataStorageHash, err := c.ipfs.Add(bytes.NewReader(c.segClaimMap[segNo].segData))
+			dataStorageHash, err := c.storage.Add(bytes.NewReader(scm.segData))
 			if err != nil {
-				glog.Errorf("Error uploading segment data to IPFS: %v", err)
+				glog.Errorf("Error uploading segment data to storage: %v", err)
+				continue
+			}
+			if !storage.Verifiable(dataStorageHash) {
+				glog.Errorf("Cannot submit %v for verification of segment %v, the verifier cannot resolve it", dataStorageHash, segNo)
 				continue
 			}
 
@@ -307,8 +554,20 @@ func (c *BasicClaimManager) DistributeFees() error {
 
 	eth.Wait(c.client.Backend(), c.client.RpcTimeout(), new(big.Int).Add(verificationPeriod, slashingPeriod))
 
-	for cid := range c.makeRanges() {
-		resCh, errCh := c.client.DistributeFees(c.jobID, big.NewInt(int64(cid)))
+	//Fees are distributed for the claims that were submitted
+	c.lock.Lock()
+	claimIDs := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, scm := range c.segClaimMap {
+		if scm.claimId != nil && !seen[scm.claimId.Int64()] {
+			seen[scm.claimId.Int64()] = true
+			claimIDs = append(claimIDs, scm.claimId.Int64())
+		}
+	}
+	c.lock.Unlock()
+	sort.Sort(SortUint64(claimIDs))
+	for _, cid := range claimIDs {
+		resCh, errCh := c.client.DistributeFees(c.jobID, big.NewInt(cid))
 		select {
 		case <-resCh:
 			glog.Infof("Distributed fees")
@@ -327,6 +586,15 @@ func (c *BasicClaimManager) DistributeFees() error {
 	return nil
 }
 
+//concatTDataHash hashes the transcoded data hashes of a segment in profile order, like the receipts of the claim.
+func (c *BasicClaimManager) concatTDataHash(seg *claimData) []byte {
+	segTDataHashes := make([][]byte, len(c.profiles))
+	for pi, p := range c.profiles {
+		segTDataHashes[pi] = []byte(seg.tDataHashes[p])
+	}
+	return crypto.Keccak256(segTDataHashes...)
+}
+
 func (c *BasicClaimManager) shouldVerifySegment(seqNum int64, start int64, end int64, blkNum int64, verifyRate uint64) bool {
 	if seqNum < start || seqNum > end {
 		return false
@@ -1,8 +1,12 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -126,6 +130,28 @@ func TestRanges(t *testing.T) {
 	}
 }
 
+func TestRangesIncomplete(t *testing.T) {
+	ps := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps4x3}
+	cm := NewBasicClaimManager("strmID", big.NewInt(5), common.Address{}, big.NewInt(1), ps, &eth.StubClient{}, &ipfs.StubIpfsApi{})
+
+	//0 and 5 are missing a profile (like when an output fails the output check), 5 comes right after a gap
+	for _, i := range []int64{0, 1, 2, 3, 5, 6, 7} {
+		for pi, p := range ps {
+			if (i == 0 || i == 5) && pi == 1 {
+				continue
+			}
+			if err := cm.AddReceipt(i, []byte(fmt.Sprintf("data%v", i)), []byte(fmt.Sprintf("hash%v%v", p.Name, i)), []byte("sig"), p); err != nil {
+				t.Errorf("Error: %v", err)
+			}
+		}
+	}
+
+	ranges := cm.makeRanges()
+	if len(ranges) != 2 || ranges[0] != [2]int64{1, 3} || ranges[1] != [2]int64{6, 7} {
+		t.Errorf("Expecting [[1 3] [6 7]], got %v", ranges)
+	}
+}
+
 func TestClaim(t *testing.T) {
 	ethClient := &eth.StubClient{ClaimStart: make([]*big.Int, 0), ClaimEnd: make([]*big.Int, 0), ClaimJid: make([]*big.Int, 0), ClaimRoot: make(map[[32]byte]bool)}
 	ps := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps4x3, lpmscore.P720p30fps4x3}
@@ -274,3 +300,186 @@ func TestVerify(t *testing.T) {
 		t.Errorf("Expect proof to be %v, got %v", seg.claimProof, ethClient.Proof)
 	}
 }
+
+func TestPersistClaims(t *testing.T) {
+	ps := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps4x3}
+	cm := NewBasicClaimManager("strmID", big.NewInt(5), common.Address{}, big.NewInt(1), ps, &eth.StubClient{}, &ipfs.StubIpfsApi{})
+	dir, err := ioutil.TempDir("", "claims")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "claims", "strmID.json")
+
+	//Nothing to claim - nothing should be written
+	if err := cm.PersistClaims(path); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expecting no claim file")
+	}
+
+	for _, p := range ps {
+		cm.AddReceipt(1, []byte("data1"), []byte("tHash1"), []byte("sig1"), p)
+		cm.AddReceipt(0, []byte("data0"), []byte("tHash0"), []byte("sig0"), p)
+	}
+	//Segment 0 is already claimed
+	seg := cm.segClaimMap[0]
+	seg.claimStart, seg.claimEnd, seg.claimBlkNum, seg.claimId, seg.claimRoot, seg.claimProof = 0, 0, big.NewInt(10), big.NewInt(3), common.HexToHash("0x01"), []byte("proof")
+
+	if err := cm.PersistClaims(path); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading claim file: %v", err)
+	}
+	var pc PersistedClaims
+	if err := json.Unmarshal(b, &pc); err != nil {
+		t.Fatalf("Error decoding claim file: %v", err)
+	}
+	if pc.StreamID != "strmID" || pc.JobID != "5" || len(pc.Profiles) != 2 {
+		t.Errorf("Unexpected claim info: %v", pc)
+	}
+	if len(pc.Segments) != 2 || pc.Segments[1].SeqNo != 1 || string(pc.Segments[1].SegData) != "data1" || pc.Segments[1].ClaimProof != nil {
+		t.Errorf("Expecting segments 0 and 1, got %v", pc.Segments)
+	}
+	if string(pc.Segments[1].TranscodedDataHashes[lpmscore.P240p30fps16x9.Name]) != "tHash1" {
+		t.Errorf("Unexpected transcoded data hashes: %v", pc.Segments[1].TranscodedDataHashes)
+	}
+	//The claim of segment 0 is persisted with it
+	claim := PersistedClaim{ID: 3, Start: 0, End: 0, Root: common.HexToHash("0x01").Hex(), BlockNum: "10"}
+	if len(pc.Claims) != 1 || pc.Claims[0] != claim || string(pc.Segments[0].ClaimProof) != "proof" {
+		t.Errorf("Unexpected claims: %v %v", pc.Claims, pc.Segments[0])
+	}
+}
+
+func TestRecoverClaims(t *testing.T) {
+	ps := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps4x3}
+	cm := NewBasicClaimManager("strmID", big.NewInt(5), common.HexToAddress("0x0102"), big.NewInt(3), ps, &eth.StubClient{}, &ipfs.StubIpfsApi{})
+	dir, err := ioutil.TempDir("", "claims")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "strmID.json")
+	for _, p := range ps {
+		cm.AddReceipt(0, []byte("data0"), []byte("tHash0"), []byte("sig0"), p)
+		cm.AddReceipt(1, []byte("data1"), []byte("tHash1"), []byte("sig1"), p)
+	}
+	if err := cm.PersistClaims(path); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	pc, err := LoadPersistedClaims(path)
+	if err != nil {
+		t.Fatalf("Error loading claims: %v", err)
+	}
+	//The job has 2 claims on-chain already
+	ethClient := &eth.StubClient{ClaimRoot: make(map[[32]byte]bool), Claims: []*eth.Claim{{ClaimBlock: big.NewInt(10)}, {ClaimBlock: big.NewInt(20)}}}
+	rcm, err := RecoverClaimManager(pc, ethClient, &ipfs.StubIpfsApi{})
+	if err != nil {
+		t.Fatalf("Error recovering claims: %v", err)
+	}
+	if rcm.jobID.Int64() != 5 || rcm.broadcasterAddr != common.HexToAddress("0x0102") || rcm.pricePerSegment.Int64() != 3 || rcm.cost.Int64() != 12 {
+		t.Errorf("Unexpected claim manager: %v %v %v %v", rcm.jobID, rcm.broadcasterAddr.Hex(), rcm.pricePerSegment, rcm.cost)
+	}
+
+	//The recovered claim should be the same as the claim of the original manager
+	count, rc, ec := rcm.Claim()
+	if count != 1 {
+		t.Fatalf("Expecting 1 claim, got %v", count)
+	}
+	select {
+	case <-rc:
+	case err := <-ec:
+		t.Fatalf("Error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+	if ethClient.ClaimStart[0].Int64() != 0 || ethClient.ClaimEnd[0].Int64() != 1 {
+		t.Errorf("Unexpected claim range: %v - %v", ethClient.ClaimStart[0], ethClient.ClaimEnd[0])
+	}
+	root, _, _ := ethTypes.NewMerkleTree([]common.Hash{receiptHash(cm, 0), receiptHash(cm, 1)})
+	if !ethClient.ClaimRoot[[32]byte(root.Hash)] {
+		t.Errorf("Expecting the claim root of the original claims")
+	}
+	rcm.lock.Lock()
+	defer rcm.lock.Unlock()
+	if rcm.segClaimMap[1].claimId.Int64() != 2 {
+		t.Errorf("Expecting claim ID 2, got %v", rcm.segClaimMap[1].claimId)
+	}
+}
+
+func receiptHash(cm *BasicClaimManager, seqNo int64) common.Hash {
+	seg := cm.segClaimMap[seqNo]
+	tHashes := make([][]byte, len(cm.profiles))
+	for i, p := range cm.profiles {
+		tHashes[i] = seg.tDataHashes[p]
+	}
+	receipt := &ethTypes.TranscodeReceipt{
+		StreamID:                 cm.strmID,
+		SegmentSequenceNumber:    big.NewInt(seqNo),
+		DataHash:                 seg.dataHash,
+		ConcatTranscodedDataHash: crypto.Keccak256(tHashes...),
+		BroadcasterSig:           seg.bSig,
+	}
+	return receipt.Hash()
+}
+
+func TestClaimPersistsClaims(t *testing.T) {
+	ps := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps4x3}
+	ethClient := &eth.StubClient{ClaimRoot: make(map[[32]byte]bool)}
+	cm := NewBasicClaimManager("strmID", big.NewInt(5), common.Address{}, big.NewInt(1), ps, ethClient, &ipfs.StubIpfsApi{})
+	dir, err := ioutil.TempDir("", "claims")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "strmID.json")
+	cm.SetClaimsPath(path)
+	for _, p := range ps {
+		cm.AddReceipt(0, []byte("data0"), []byte("tHash0"), []byte("sig0"), p)
+		cm.AddReceipt(1, []byte("data1"), []byte("tHash1"), []byte("sig1"), p)
+	}
+
+	count, rc, ec := cm.Claim()
+	if count != 1 {
+		t.Fatalf("Expecting 1 claim, got %v", count)
+	}
+	select {
+	case <-rc:
+	case err := <-ec:
+		t.Fatalf("Error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out")
+	}
+
+	//The claim is persisted when it's submitted
+	pc, err := LoadPersistedClaims(path)
+	if err != nil {
+		t.Fatalf("Error loading claims: %v", err)
+	}
+	root, proofs, _ := ethTypes.NewMerkleTree([]common.Hash{receiptHash(cm, 0), receiptHash(cm, 1)})
+	claim := PersistedClaim{ID: 0, Start: 0, End: 1, Root: root.Hash.Hex(), BlockNum: "0"}
+	if len(pc.Claims) != 1 || pc.Claims[0] != claim {
+		t.Errorf("Expecting claim %v, got %v", claim, pc.Claims)
+	}
+	for i, seg := range pc.Segments {
+		if string(seg.ClaimProof) != string(proofs[i].Bytes()) {
+			t.Errorf("Unexpected proof of segment %v: %x", seg.SeqNo, seg.ClaimProof)
+		}
+	}
+
+	//Recovered segments that are claimed already aren't claimed again, but their fees are distributed
+	rcm, err := RecoverClaimManager(pc, &eth.StubClient{ClaimRoot: make(map[[32]byte]bool), Claims: []*eth.Claim{{ClaimBlock: big.NewInt(1)}}}, &ipfs.StubIpfsApi{})
+	if err != nil {
+		t.Fatalf("Error recovering claims: %v", err)
+	}
+	if count, _, _ := rcm.Claim(); count != 0 {
+		t.Errorf("Expecting no claims, got %v", count)
+	}
+	if seg := rcm.segClaimMap[1]; seg.claimId.Int64() != 0 || seg.claimRoot != root.Hash || string(seg.claimProof) != string(proofs[1].Bytes()) || string(seg.claimConcatTDatahash) != string(cm.segClaimMap[1].claimConcatTDatahash) {
+		t.Errorf("Expecting the claim of segment 1 to be recovered")
+	}
+}
@@ -16,9 +16,9 @@ import (
 	"github.com/ericxtang/m3u8"
 	ethCrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/golang/glog"
-	bnet "github.com/livepeer/go-livepeer-basicnet"
 	"github.com/livepeer/go-livepeer/eth"
 	"github.com/livepeer/go-livepeer/net"
+	bnet "github.com/livepeer/go-livepeer/p2p/basicnet"
 	lpmscore "github.com/livepeer/lpms/core"
 	"github.com/livepeer/lpms/stream"
 	"github.com/livepeer/lpms/transcoder"
@@ -70,6 +70,9 @@ func (n *StubVideoNetwork) Connect(nodeID string, nodeAddr []string) error {
 	return nil
 }
 func (n *StubVideoNetwork) SetupProtocol() error { return nil }
+func (n *StubVideoNetwork) UpstreamPeer(strmID string) string {
+	return "upstream"
+}
 func (n *StubVideoNetwork) SendTranscodeResponse(nid string, sid string, tr map[string]string) error {
 	n.nodeID = nid
 	n.strmID = sid
@@ -142,7 +145,8 @@ func (n *StubBroadcaster) Finish() error {
 }
 
 type StubSubscriber struct {
-	T *testing.T
+	T            *testing.T
+	unsubscribed bool
 }
 
 func (s *StubSubscriber) IsLive() bool   { return true }
@@ -159,7 +163,10 @@ func (s *StubSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint6
 	gotData(100, b, false)
 	return nil
 }
-func (s *StubSubscriber) Unsubscribe() error { return nil }
+func (s *StubSubscriber) Unsubscribe() error {
+	s.unsubscribed = true
+	return nil
+}
 
 func TestTranscode(t *testing.T) {
 	//Set up the node
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+var ErrHLSKey = errors.New("ErrHLSKey")
+
+//HLSKeySize is the size of an AES-128 content key.
+const HLSKeySize = 16
+
+//HLSEncryptionMethod is the EXT-X-KEY method of encrypted streams.  Only whole segment AES-128 is supported: SAMPLE-AES would mean
+//rewriting the TS packets of every segment to encrypt the samples inside them.
+const HLSEncryptionMethod = "AES-128"
+
+//HLSKeyManager hands out the content keys of encrypted HLS streams.  The keys of a stream are numbered, and the key index of a segment
+//is its sequence number divided by the rotation period (see HLSEncryption).
+type HLSKeyManager interface {
+	//Key returns key index of the stream, making it if it doesn't exist yet.  The same index always gets the same key.
+	Key(strmID StreamID, index uint64) ([]byte, error)
+	//RemoveKeys forgets all the keys of the stream, once the node doesn't serve it anymore.
+	RemoveKeys(strmID StreamID) error
+}
+
+//HLSEncryption is how an edge node encrypts the HLS streams it serves.
+type HLSEncryption struct {
+	Keys HLSKeyManager
+	//RotationSegments is how many segments in a row are encrypted with the same key, 0 for one key per stream.
+	RotationSegments uint64
+}
+
+//KeyIndex returns the index of the key segment seqNo is encrypted with.
+func (e *HLSEncryption) KeyIndex(seqNo uint64) uint64 {
+	if e.RotationSegments == 0 {
+		return 0
+	}
+	return seqNo / e.RotationSegments
+}
+
+//EncryptSegment encrypts the data of segment seqNo with its key.
+func (e *HLSEncryption) EncryptSegment(strmID StreamID, seqNo uint64, data []byte) ([]byte, error) {
+	key, err := e.Keys.Key(strmID, e.KeyIndex(seqNo))
+	if err != nil {
+		return nil, err
+	}
+	return EncryptHLSSegment(key, seqNo, data)
+}
+
+//SegmentIV returns the IV of segment seqNo, as the IV attribute of EXT-X-KEY.  It's the sequence number, like the HLS default, but
+//spelled out so the playlist doesn't depend on EXT-X-MEDIA-SEQUENCE lining up with the sequence numbers of the segments.
+func SegmentIV(seqNo uint64) string {
+	return fmt.Sprintf("0x%032x", seqNo)
+}
+
+//EncryptHLSSegment encrypts a segment with AES-128-CBC and PKCS7 padding, as HLS AES-128 expects.
+func EncryptHLSSegment(key []byte, seqNo uint64, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	out := make([]byte, len(data)+pad)
+	copy(out, data)
+	copy(out[len(data):], bytes.Repeat([]byte{byte(pad)}, pad))
+	cipher.NewCBCEncrypter(block, segmentIV(seqNo)).CryptBlocks(out, out)
+	return out, nil
+}
+
+//DecryptHLSSegment undoes EncryptHLSSegment.
+func DecryptHLSSegment(key []byte, seqNo uint64, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrHLSKey
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, segmentIV(seqNo)).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, ErrHLSKey
+	}
+	return out[:len(out)-pad], nil
+}
+
+func segmentIV(seqNo uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], seqNo)
+	return iv
+}
+
+var keyStreamID = regexp.MustCompile("^[[:alnum:]]+$")
+
+//FileKeyManager keeps the keys of each stream in a directory, one file per key, so a restarted node hands out the same keys to players
+//that fetched the playlists before the restart.
+type FileKeyManager struct {
+	dir  string
+	lock sync.Mutex
+	keys map[string][]byte
+}
+
+func NewFileKeyManager(dir string) (*FileKeyManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		glog.Errorf("Error creating key dir %v: %v", dir, err)
+		return nil, err
+	}
+	return &FileKeyManager{dir: dir, keys: make(map[string][]byte)}, nil
+}
+
+func (m *FileKeyManager) Key(strmID StreamID, index uint64) ([]byte, error) {
+	//The stream ID comes from the request path, keep it out of other directories
+	if !keyStreamID.MatchString(string(strmID)) {
+		return nil, ErrHLSKey
+	}
+	path := filepath.Join(m.dir, string(strmID), fmt.Sprintf("%v.key", index))
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if key, ok := m.keys[path]; ok {
+		return key, nil
+	}
+
+	key, err := ioutil.ReadFile(path)
+	if err == nil && len(key) != HLSKeySize {
+		glog.Errorf("Key file %v has the wrong size", path)
+		return nil, ErrHLSKey
+	}
+	if os.IsNotExist(err) {
+		key = make([]byte, HLSKeySize)
+		if _, err = rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = ioutil.WriteFile(path, key, 0600)
+		}
+	}
+	if err != nil {
+		glog.Errorf("Error getting key %v: %v", path, err)
+		return nil, err
+	}
+	m.keys[path] = key
+	return key, nil
+}
+
+func (m *FileKeyManager) RemoveKeys(strmID StreamID) error {
+	if !keyStreamID.MatchString(string(strmID)) {
+		return ErrHLSKey
+	}
+	dir := filepath.Join(m.dir, string(strmID))
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for path := range m.keys {
+		if filepath.Dir(path) == dir {
+			delete(m.keys, path)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		glog.Errorf("Error removing keys of %v: %v", strmID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptHLSSegment(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, HLSKeySize)
+	for _, size := range []int{0, 15, 16, 1000} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		enc, err := EncryptHLSSegment(key, 7, data)
+		if err != nil {
+			t.Fatalf("Error encrypting: %v", err)
+		}
+		if len(enc)%16 != 0 || len(enc) <= size || bytes.Contains(enc, []byte("aaaaaaaaaaaaaaaa")) {
+			t.Errorf("Bad ciphertext for %v bytes: %x", size, enc)
+		}
+		dec, err := DecryptHLSSegment(key, 7, enc)
+		if err != nil || !bytes.Equal(dec, data) {
+			t.Errorf("Round trip of %v bytes failed: %v", size, err)
+		}
+		//The IV is the sequence number, so another segment decrypts to something else
+		if dec, err := DecryptHLSSegment(key, 8, enc); err == nil && bytes.Equal(dec, data) && size > 0 {
+			t.Errorf("Expected the IV to depend on the sequence number")
+		}
+	}
+
+	if iv := SegmentIV(255); iv != "0x000000000000000000000000000000ff" {
+		t.Errorf("Wrong IV: %v", iv)
+	}
+}
+
+func TestFileKeyManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := NewFileKeyManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k0, err := m.Key(StreamID("strm1"), 0)
+	if err != nil || len(k0) != HLSKeySize {
+		t.Fatalf("Bad key: %x %v", k0, err)
+	}
+	k1, _ := m.Key(StreamID("strm1"), 1)
+	other, _ := m.Key(StreamID("strm2"), 0)
+	if bytes.Equal(k0, k1) || bytes.Equal(k0, other) {
+		t.Errorf("Expected different keys per index and stream")
+	}
+
+	//A new manager on the same dir gets the same keys
+	m, _ = NewFileKeyManager(dir)
+	if k, _ := m.Key(StreamID("strm1"), 0); !bytes.Equal(k, k0) {
+		t.Errorf("Expected the stored key, got %x instead of %x", k, k0)
+	}
+
+	if _, err := m.Key(StreamID("../strm1"), 0); err != ErrHLSKey {
+		t.Errorf("Expected ErrHLSKey, got %v", err)
+	}
+
+	//Removed keys are gone from memory and disk, and the other streams keep theirs
+	if err := m.RemoveKeys(StreamID("strm1")); err != nil {
+		t.Errorf("Error removing keys: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "strm1")); !os.IsNotExist(err) {
+		t.Errorf("Expected the key files to be removed, got %v", err)
+	}
+	if k, _ := m.Key(StreamID("strm1"), 0); bytes.Equal(k, k0) {
+		t.Errorf("Expected a new key after removing the keys")
+	}
+	if k, _ := m.Key(StreamID("strm2"), 0); !bytes.Equal(k, other) {
+		t.Errorf("Expected the keys of other streams to stay")
+	}
+	if err := m.RemoveKeys(StreamID("../strm2")); err != ErrHLSKey {
+		t.Errorf("Expected ErrHLSKey, got %v", err)
+	}
+}
+
+func TestHLSEncryptionKeyIndex(t *testing.T) {
+	e := &HLSEncryption{RotationSegments: 10}
+	if e.KeyIndex(9) != 0 || e.KeyIndex(10) != 1 || e.KeyIndex(25) != 2 {
+		t.Errorf("Wrong key index")
+	}
+	e.RotationSegments = 0
+	if e.KeyIndex(25) != 0 {
+		t.Errorf("Expected one key without rotation")
+	}
+}
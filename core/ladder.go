@@ -0,0 +1,107 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+//LadderRules are the rules for building the profiles of a job from the source stream (see BuildLadder).
+type LadderRules struct {
+	//MinBitrateStep is how much lower (as a fraction) the bitrate of a rendition has to be than the next higher rendition, or the source
+	//for the highest rendition.  Renditions closer than that are dropped.
+	MinBitrateStep float64
+	//PricePerRendition is what one rendition is expected to cost per segment.  The ladder gets at most maxPrice / PricePerRendition
+	//renditions, 0 for no cap.
+	PricePerRendition uint64
+}
+
+var DefaultLadderRules = LadderRules{MinBitrateStep: 0.25}
+
+//BuildLadder picks the profiles of a job for the source.  The candidates are the standard video profiles with the aspect ratio closest
+//to the source.  Profiles with a higher resolution, framerate or bitrate than the source are dropped (no upscaling), and so are profiles
+//too close in bitrate to the next higher one.  extra (audio and passthrough profiles) is added to the ladder as it is.
+//
+//If the ladder costs more than maxPrice, the highest renditions are dropped first: viewers with the bandwidth for them can watch the
+//source.
+func BuildLadder(rules LadderRules, src *SourceInfo, extra []lpmscore.VideoProfile, maxPrice uint64) []lpmscore.VideoProfile {
+	srcWidth, srcHeight := parseResolution(src.Resolution)
+	aspect := "16:9"
+	if srcHeight > 0 && math.Abs(float64(srcWidth)/float64(srcHeight)-4.0/3) < math.Abs(float64(srcWidth)/float64(srcHeight)-16.0/9) {
+		aspect = "4:3"
+	}
+
+	candidates := make([]lpmscore.VideoProfile, 0)
+	for _, p := range lpmscore.VideoProfileLookup {
+		if p.AspectRatio == aspect {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.Sort(byBandwidth(candidates))
+
+	ladder := make([]lpmscore.VideoProfile, 0)
+	prev := src.Bandwidth
+	for _, p := range candidates {
+		_, height := parseResolution(p.Resolution)
+		bw := ProfileVariantParams(p).Bandwidth
+		if srcHeight > 0 && height > srcHeight {
+			continue
+		}
+		if src.Framerate > 0 && float64(p.Framerate) > src.Framerate+1 {
+			continue
+		}
+		if prev > 0 && float64(bw) > float64(prev)*(1-rules.MinBitrateStep) {
+			continue
+		}
+		ladder = append(ladder, p)
+		prev = bw
+	}
+	ladder = append(ladder, extra...)
+
+	if rules.PricePerRendition > 0 {
+		max := int(maxPrice / rules.PricePerRendition)
+		if len(ladder) > max {
+			ladder = ladder[len(ladder)-max:]
+		}
+	}
+	return ladder
+}
+
+//ProfileNamesOf returns the names of the profiles, for logging.
+func ProfileNamesOf(profiles []lpmscore.VideoProfile) []string {
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func parseResolution(r string) (int, int) {
+	parts := strings.Split(strings.Replace(r, ":", "x", 1), "x")
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	w, err1 := strconv.Atoi(parts[0])
+	h, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+//byBandwidth sorts profiles by the bandwidth of their variant, highest first.
+type byBandwidth []lpmscore.VideoProfile
+
+func (a byBandwidth) Len() int      { return len(a) }
+func (a byBandwidth) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byBandwidth) Less(i, j int) bool {
+	bi := ProfileVariantParams(a[i]).Bandwidth
+	bj := ProfileVariantParams(a[j]).Bandwidth
+	if bi != bj {
+		return bi > bj
+	}
+	return a[i].Name < a[j].Name
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+func TestBuildLadder(t *testing.T) {
+	ladder := func(rules LadderRules, src *SourceInfo, extra []lpmscore.VideoProfile, maxPrice uint64) string {
+		return fmt.Sprint(ProfileNamesOf(BuildLadder(rules, src, extra, maxPrice)))
+	}
+
+	//720p60 source gets the 16:9 ladder, except renditions too close to the source (P720p60fps16x9) or the next higher one (P360p30fps16x9)
+	src := &SourceInfo{Bandwidth: 6000000, Resolution: "1280x720", Framerate: 60}
+	if l := ladder(DefaultLadderRules, src, nil, 0); l != "[P720p30fps16x9 P576p30fps16x9 P240p30fps16x9 P144p30fps16x9]" {
+		t.Errorf("Unexpected ladder %v", l)
+	}
+
+	//No upscaling in resolution, framerate or bitrate
+	src = &SourceInfo{Bandwidth: 1000000, Resolution: "640x360", Framerate: 30}
+	if l := ladder(DefaultLadderRules, src, nil, 0); l != "[P240p30fps16x9 P144p30fps16x9]" {
+		t.Errorf("Unexpected ladder %v", l)
+	}
+
+	//Renditions too close to each other are dropped
+	src = &SourceInfo{Bandwidth: 8000000, Resolution: "1280x720", Framerate: 30}
+	if l := ladder(LadderRules{MinBitrateStep: 0.5}, src, nil, 0); l != "[P720p30fps16x9 P576p30fps16x9 P240p30fps16x9]" {
+		t.Errorf("Unexpected ladder %v", l)
+	}
+
+	//4:3 source
+	src = &SourceInfo{Bandwidth: 5000000, Resolution: "960x720", Framerate: 30}
+	if l := ladder(DefaultLadderRules, src, nil, 0); l != "[P720p30fps4x3 P360p30fps4x3 P240p30fps4x3]" {
+		t.Errorf("Unexpected ladder %v", l)
+	}
+
+	//Price cap drops the highest renditions, extra profiles are kept
+	src = &SourceInfo{Bandwidth: 6000000, Resolution: "1280x720", Framerate: 30}
+	rules := LadderRules{MinBitrateStep: 0.25, PricePerRendition: 2}
+	if l := ladder(rules, src, []lpmscore.VideoProfile{A64kStereo.VideoProfile()}, 6); l != "[P240p30fps16x9 P144p30fps16x9 A64kStereo]" {
+		t.Errorf("Unexpected ladder %v", l)
+	}
+	if l := ladder(rules, src, nil, 1); l != "[]" {
+		t.Errorf("Expecting empty ladder, got %v", l)
+	}
+}
@@ -8,7 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"os"
+	"path/filepath"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/ericxtang/m3u8"
@@ -18,9 +21,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/eth/signer"
 	ethTypes "github.com/livepeer/go-livepeer/eth/types"
-	"github.com/livepeer/go-livepeer/ipfs"
 	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/go-livepeer/storage"
 	lpmscore "github.com/livepeer/lpms/core"
 	"github.com/livepeer/lpms/stream"
 	"github.com/livepeer/lpms/transcoder"
@@ -33,6 +37,7 @@ var ErrBroadcastJob = errors.New("ErrBroadcastJob")
 var ErrBroadcast = errors.New("ErrBroadcast")
 var ErrEOF = errors.New("ErrEOF")
 var ErrNotFound = errors.New("ErrNotFound")
+var ErrShuttingDown = errors.New("ErrShuttingDown")
 var BroadcastTimeout = time.Second * 30
 var EthRpcTimeout = 5 * time.Second
 var EthEventTimeout = 5 * time.Second
@@ -41,6 +46,10 @@ var DefaultMasterPlaylistWaitTime = 60 * time.Second
 var DefaultJobLength = int64(5760) //Avg 1 day in 15 sec blocks
 var ConnFileWriteFreq = time.Duration(60) * time.Second
 
+//BootstrapBackoff is how long to wait before trying a bootstrap node again, doubling up to BootstrapMaxBackoff.
+var BootstrapBackoff = 1 * time.Second
+var BootstrapMaxBackoff = 5 * time.Minute
+
 //NodeID can be converted from libp2p PeerID.
 type NodeID string
 
@@ -57,10 +66,54 @@ type LivepeerNode struct {
 	VideoCache   VideoCache
 	Eth          eth.LivepeerEthClient
 	EthAccount   string
-	EthPassword  string
-	Ipfs         ipfs.IpfsApi
+	Storage      storage.Storage
 	WorkDir      string
 	PeerConns    []PeerConn
+	Settings     *SettingsStore
+	//OutputChecker checks transcoded segments before they are claimed, nil to skip the check
+	OutputChecker OutputChecker
+	//SourceProber finds out the variant params of broadcast streams, nil to guess them from the resolution
+	SourceProber SourceProber
+	//SegmentFormat is the format of the segments the node broadcasts
+	SegmentFormat SegmentFormat
+	//SegmentFormats finds out the segment formats of transcoders that take segments over HTTP, nil to send them in SegmentFormat
+	SegmentFormats SegmentFormatNegotiator
+	//Capabilities are what the node can do, as reported in its status
+	Capabilities []string
+	//Peers watches the connections to other nodes, nil to leave them to the network
+	Peers PeerManager
+	//SegmentURL is where the node takes the segments of its transcode jobs over HTTP, empty if it only takes them from the network
+	SegmentURL string
+	//Region and TranscoderCapacity (streams at once, 0 if unknown) are announced by transcoders
+	Region             string
+	TranscoderCapacity int
+	//Transcoders are the capability records announced by transcoders
+	Transcoders *CapabilityCache
+	//Streams are the live streams announced by broadcasters
+	Streams *StreamDirectory
+
+	peerLock     sync.Mutex
+	shutdownLock sync.Mutex
+	shuttingDown bool
+	//segmentsStopped is set once Shutdown finished the queued segments, and no more are transcoded
+	segmentsStopped bool
+	//stopStart stops the bootstrap retries and the peer manager started by Start
+	stopStart     context.CancelFunc
+	transcodeJobs map[string]*transcodeJob
+	segWg         sync.WaitGroup
+	claimWg       sync.WaitGroup
+
+	failureLock    sync.Mutex
+	outputFailures []OutputFailure
+}
+
+//transcodeJob keeps track of a running transcode job so it can be wrapped up on shutdown.
+type transcodeJob struct {
+	config net.TranscodeConfig
+	cm     ClaimManager
+	sub    stream.Subscriber
+	//results are the streams the job transcodes into
+	results []StreamID
 }
 
 //NewLivepeerNode creates a new Livepeer Node. Eth can be nil.
@@ -70,32 +123,100 @@ func NewLivepeerNode(e eth.LivepeerEthClient, vn net.VideoNetwork, nodeId NodeID
 		return nil, ErrLivepeerNode
 	}
 
-	return &LivepeerNode{VideoCache: NewBasicVideoCache(vn), VideoNetwork: vn, Identity: nodeId, Addrs: addrs, Eth: e, WorkDir: wd, PeerConns: make([]PeerConn, 0)}, nil
+	settingsPath := ""
+	if wd != "" {
+		settingsPath = filepath.Join(wd, SettingsFile)
+	}
+	settings, err := NewSettingsStore(settingsPath)
+	if err != nil {
+		glog.Errorf("Cannot load settings: %v", err)
+		return nil, err
+	}
+
+	n := &LivepeerNode{VideoCache: NewBasicVideoCache(vn), VideoNetwork: vn, Identity: nodeId, Addrs: addrs, Eth: e, WorkDir: wd, PeerConns: make([]PeerConn, 0), Settings: settings, SegmentFormat: SegmentFormatGob, transcodeJobs: make(map[string]*transcodeJob), Transcoders: NewCapabilityCache(), Streams: NewStreamDirectory()}
+	if r, ok := vn.(net.NodeStatusReporter); ok {
+		r.SetNodeStatusFunc(n.addNodeStatus)
+	}
+	if a, ok := vn.(net.CapabilityAnnouncer); ok {
+		a.ReceivedCapabilities(n.gotCapabilities)
+	}
+	if a, ok := vn.(net.StreamAnnouncer); ok {
+		a.ReceivedStreamAnnouncements(n.gotStreamAnnouncement)
+	}
+	return n, nil
 }
 
-//Start sets up the Livepeer protocol and connects the node to the network
-func (n *LivepeerNode) Start(ctx context.Context, bootID, bootAddr string) error {
+//Start sets up the Livepeer protocol and connects the node to the network through the bootstrap nodes.  It tries them all at once, and
+//returns when each was tried once.  The ones that failed are retried with backoff until the node connects to them, ctx is done or the
+//node shuts down.
+func (n *LivepeerNode) Start(ctx context.Context, bootstrap []PeerConn) error {
 	//Set up protocol (to handle incoming streams)
 	if err := n.VideoNetwork.SetupProtocol(); err != nil {
 		glog.Errorf("Error setting up protocol: %v", err)
 		return err
 	}
-
-	//Connect to bootstrap node.  This currently also kicks off a bootstrap process, which periodically checks for new peers and connect to them.
-	if bootID != "" && bootAddr != "" {
-		if err := n.VideoNetwork.Connect(bootID, []string{bootAddr}); err != nil {
-			glog.Errorf("Cannot connect to node: %v", err)
-		} else {
-			n.PeerConns = append(n.PeerConns, PeerConn{NodeID: bootID, NodeAddr: bootAddr})
+	ctx, cancel := context.WithCancel(ctx)
+	n.shutdownLock.Lock()
+	n.stopStart = cancel
+	n.shutdownLock.Unlock()
+
+	//Connecting to a bootstrap node also kicks off a bootstrap process, which periodically checks for new peers and connect to them.
+	var wg sync.WaitGroup
+	connected := make(chan bool, len(bootstrap))
+	for _, pc := range bootstrap {
+		wg.Add(1)
+		go func(pc PeerConn) {
+			delay := BootstrapBackoff
+			for attempt := 0; ; attempt++ {
+				err := n.VideoNetwork.Connect(pc.NodeID, []string{pc.NodeAddr})
+				if attempt == 0 {
+					connected <- err == nil
+					wg.Done()
+				}
+				if err == nil {
+					glog.V(common.SHORT).Infof("Connected to bootstrap node %v at %v", pc.NodeID, pc.NodeAddr)
+					n.peerLock.Lock()
+					n.PeerConns = append(n.PeerConns, pc)
+					n.peerLock.Unlock()
+					return
+				}
+				glog.Errorf("Cannot connect to bootstrap node %v at %v, retrying in %v: %v", pc.NodeID, pc.NodeAddr, delay, err)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				if delay *= 2; delay > BootstrapMaxBackoff {
+					delay = BootstrapMaxBackoff
+				}
+			}
+		}(pc)
+	}
+	wg.Wait()
+	close(connected)
+	ok := 0
+	for c := range connected {
+		if c {
+			ok++
 		}
 	}
+	if len(bootstrap) > 0 && ok == 0 {
+		glog.Errorf("Cannot connect to any of the %v bootstrap nodes, the node only has the peers from its peer cache for now", len(bootstrap))
+	}
 
-	//TODO:Kick off process to periodically monitor peer connection by pinging them
+	//Ping the peers, replace the ones that stop answering and reconnect when there are too few
+	if n.Peers != nil {
+		n.Peers.Start(ctx, bootstrap)
+	}
 	return nil
 }
 
 //CreateTranscodeJob creates the on-chain transcode job.
 func (n *LivepeerNode) CreateTranscodeJob(strmID StreamID, profiles []lpmscore.VideoProfile, price uint64) error {
+	if n.IsShuttingDown() {
+		glog.Errorf("Cannot create transcode job, node is shutting down")
+		return ErrShuttingDown
+	}
 	if n.Eth == nil {
 		glog.Errorf("Cannot create transcode job, no eth client found")
 		return ErrNotFound
@@ -118,15 +239,19 @@ func (n *LivepeerNode) CreateTranscodeJob(strmID StreamID, profiles []lpmscore.V
 	resCh, errCh := n.Eth.Job(strmID.String(), ethcommon.ToHex(transOpts)[2:], p, big.NewInt(0).Add(blk.Number(), big.NewInt(DefaultJobLength)))
 	select {
 	case <-resCh:
-		glog.Infof("Created broadcast job. Price: %v. Type: %v", p, ethcommon.ToHex(transOpts)[2:])
+		glog.Infof("Created broadcast job. Price: %v. Type: %v. Profiles: %v", p, ethcommon.ToHex(transOpts)[2:], ProfileNamesOf(profiles))
 	case err := <-errCh:
 		glog.Errorf("Error creating broadcast job: %v", err)
+		return ErrBroadcastJob
 	}
 	return nil
 }
 
+//ClaimVerifyAndDistributeFees claims the work of cm, submits the verifications and distributes the fees.  Returns an error if a claim
+//failed or the fees weren't distributed, so persisted claims are kept for later.
 func (n *LivepeerNode) ClaimVerifyAndDistributeFees(cm ClaimManager) error {
 	//Do the claim, wait until it's finished
+	failed := 0
 	count, rc, ec := cm.Claim()
 	for count > 0 {
 		select {
@@ -135,6 +260,7 @@ func (n *LivepeerNode) ClaimVerifyAndDistributeFees(cm ClaimManager) error {
 			count--
 		case err := <-ec:
 			glog.Errorf("Error claim work: %v", err)
+			failed++
 			count--
 		}
 	}
@@ -145,13 +271,59 @@ func (n *LivepeerNode) ClaimVerifyAndDistributeFees(cm ClaimManager) error {
 
 	if err := cm.DistributeFees(); err != nil {
 		glog.Errorf("Error distributing fees: %v", err)
+		return err
 	}
 
+	if failed > 0 {
+		glog.Errorf("%v claim(s) failed", failed)
+		return ErrClaim
+	}
 	return nil
 }
 
+//RecoverClaims submits the claims that were saved in the work dir because the node shut down before claiming them.  The claims are
+//submitted in the background, and each file is removed once its fees are distributed.
+func (n *LivepeerNode) RecoverClaims() {
+	if n.Eth == nil {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(n.WorkDir, "claims", "*.json"))
+	if err != nil {
+		glog.Errorf("Error looking for persisted claims: %v", err)
+		return
+	}
+	for _, f := range files {
+		pc, err := LoadPersistedClaims(f)
+		if err != nil {
+			glog.Errorf("Error loading claims from %v: %v", f, err)
+			continue
+		}
+		cm, err := RecoverClaimManager(pc, n.Eth, n.Storage)
+		if err != nil {
+			glog.Errorf("Error recovering claims from %v: %v", f, err)
+			continue
+		}
+		cm.SetClaimsPath(f)
+		glog.Infof("Resubmitting %v persisted segment claim(s) of %v", len(pc.Segments), pc.StreamID)
+		n.claimWg.Add(1)
+		go func(f string, cm ClaimManager) {
+			defer n.claimWg.Done()
+			if err := n.ClaimVerifyAndDistributeFees(cm); err != nil {
+				glog.Errorf("Error claiming work from %v, keeping the claims: %v", f, err)
+				return
+			}
+			os.Remove(f)
+		}(f, cm)
+	}
+}
+
 //TranscodeAndBroadcast transcodes one stream into multiple streams (specified by TranscodeConfig), broadcasts the streams, and returns a list of streamIDs.
 func (n *LivepeerNode) TranscodeAndBroadcast(config net.TranscodeConfig, cm ClaimManager, t transcoder.Transcoder) ([]StreamID, error) {
+	if n.IsShuttingDown() {
+		glog.Errorf("Cannot start transcode job for %v, node is shutting down", config.StrmID)
+		return nil, ErrShuttingDown
+	}
+
 	//Create the broadcasters
 	tProfiles := make([]lpmscore.VideoProfile, len(config.Profiles), len(config.Profiles))
 	resultStrmIDs := make([]StreamID, len(config.Profiles), len(config.Profiles))
@@ -165,14 +337,14 @@ func (n *LivepeerNode) TranscodeAndBroadcast(config net.TranscodeConfig, cm Clai
 			return nil, ErrTranscode
 		}
 		resultStrmIDs[i] = strmID
-		tProfiles[i] = lpmscore.VideoProfileLookup[vp.Name]
+		tProfiles[i], _ = ProfileLookup(vp.Name)
 
 		pl, err := m3u8.NewMediaPlaylist(stream.DefaultHLSStreamWin, stream.DefaultHLSStreamCap)
 		if err != nil {
 			glog.Errorf("Error making playlist: %v", err)
 			return nil, ErrTranscode
 		}
-		variants[strmID] = &m3u8.Variant{URI: fmt.Sprintf("%v.m3u8", strmID), Chunklist: pl, VariantParams: lpmscore.VideoProfileToVariantParams(tProfiles[i])}
+		variants[strmID] = &m3u8.Variant{URI: fmt.Sprintf("%v.m3u8", strmID), Chunklist: pl, VariantParams: ProfileVariantParams(tProfiles[i])}
 
 		broadcaster, err := n.VideoNetwork.GetBroadcaster(string(strmID))
 		if err != nil {
@@ -187,15 +359,29 @@ func (n *LivepeerNode) TranscodeAndBroadcast(config net.TranscodeConfig, cm Clai
 	if err != nil {
 		glog.Errorf("Error getting subscriber for stream %v from network: %v", config.StrmID, err)
 	}
+	if p, ok := cm.(ClaimPersister); ok && config.PerformOnchainClaim {
+		p.SetClaimsPath(n.claimsFile(config.StrmID))
+	}
+	n.shutdownLock.Lock()
+	n.transcodeJobs[config.StrmID] = &transcodeJob{config: config, cm: cm, sub: sub, results: resultStrmIDs}
+	n.shutdownLock.Unlock()
+	n.setTranscodedStreams(config.StrmID, resultStrmIDs, config.BroadcasterAddress)
 	sub.Subscribe(context.Background(), func(seqNo uint64, data []byte, eof bool) {
 		glog.V(common.DEBUG).Infof("Starting to transcode segment %v", seqNo)
 		totalStart := time.Now()
 		if eof {
+			//If the node is shutting down, Shutdown() is responsible for claiming the work.
+			if !n.finishTranscodeJob(config.StrmID, cm != nil && config.PerformOnchainClaim) {
+				return
+			}
 			if cm != nil && config.PerformOnchainClaim {
 				glog.V(common.SHORT).Infof("Stream finished. Claiming work.")
+				defer n.claimWg.Done()
 
 				if err := n.ClaimVerifyAndDistributeFees(cm); err != nil {
 					glog.Errorf("Error claiming work: %v", err)
+				} else {
+					os.Remove(n.claimsFile(config.StrmID))
 				}
 			}
 			return
@@ -211,16 +397,31 @@ func (n *LivepeerNode) TranscodeAndBroadcast(config net.TranscodeConfig, cm Clai
 				glog.V(common.SHORT).Infof("Broadcaster does not have enough funds. Claiming work.")
 				if err := n.ClaimVerifyAndDistributeFees(cm); err != nil {
 					glog.Errorf("Error claiming work: %v", err)
+				} else {
+					os.Remove(n.claimsFile(config.StrmID))
 				}
 				return
 			}
 		}
 
+		if !n.startSegment() {
+			glog.V(common.SHORT).Infof("Node is shutting down, skipping segment %v", seqNo)
+			return
+		}
+		defer n.segWg.Done()
+
 		//Decode the segment
 		start := time.Now()
 		ss, err := BytesToSignedSegment(data)
 		if err != nil {
 			glog.Errorf("Error decoding byte array into segment: %v", err)
+			n.reportMisbehavior(n.upstreamPeer(config.StrmID), "sent a segment that doesn't decode")
+			return
+		}
+		if (config.BroadcasterAddress != ethcommon.Address{}) && !VerifySegmentSig(config.StrmID, &ss.Seg, ss.Sig, config.BroadcasterAddress) {
+			glog.Errorf("Segment %v of %v isn't signed by the broadcaster %v", seqNo, config.StrmID, config.BroadcasterAddress.Hex())
+			n.reportMisbehavior(n.upstreamPeer(config.StrmID), "sent a segment with a bad signature")
+			return
 		}
 		glog.V(common.DEBUG).Infof("Decoding of segment took %v", time.Since(start))
 		n.transcodeAndBroadcastSeg(&ss.Seg, ss.Sig, cm, t, resultStrmIDs, broadcasters, config)
@@ -237,8 +438,18 @@ func (n *LivepeerNode) transcodeAndBroadcastSeg(seg *stream.HLSSegment, sig []by
 	if err != nil {
 		glog.Errorf("Error transcoding seg: %v - %v", seg.Name, err)
 	}
+	if len(tData) != len(resultStrmIDs) {
+		tData = make([][]byte, len(resultStrmIDs))
+	}
 	glog.V(common.DEBUG).Infof("Transcoding of segment %v took %v", seg.SeqNo, time.Since(start))
 
+	//Check the outputs before broadcasting and claiming them
+	if n.OutputChecker != nil {
+		start = time.Now()
+		tData = n.checkOutputs(seg, t, tData, config.StrmID, config.Profiles)
+		glog.V(common.DEBUG).Infof("Checking output of segment %v took %v", seg.SeqNo, time.Since(start))
+	}
+
 	//Encode and broadcast the segment
 	start = time.Now()
 	for i, resultStrmID := range resultStrmIDs {
@@ -266,70 +477,191 @@ func (n *LivepeerNode) transcodeAndBroadcastSeg(seg *stream.HLSSegment, sig []by
 	}
 }
 
-func (n *LivepeerNode) BroadcastFinishMsg(strmID string) error {
-	b, err := n.VideoNetwork.GetBroadcaster(strmID)
-	if err != nil {
-		glog.Errorf("Error getting broadcaster from network: %v", err)
-		return err
+//NodeAddrs are the addresses other nodes reach the node on.  They come from the network when it knows them, since they change as it finds
+//out whether the node can be reached from outside, and are the addresses the node started with otherwise.
+func (n *LivepeerNode) NodeAddrs() []string {
+	if r, ok := n.VideoNetwork.(net.AddrsReporter); ok {
+		if addrs := r.Addrs(); len(addrs) > 0 {
+			return addrs
+		}
 	}
+	return n.Addrs
+}
 
-	return b.Finish()
+//reportMisbehavior tells the peer manager that a peer sent a bad message.
+func (n *LivepeerNode) reportMisbehavior(nodeID NodeID, reason string) {
+	if n.Peers != nil && nodeID != "" {
+		n.Peers.ReportMisbehavior(nodeID, reason)
+	}
 }
 
-func (n *LivepeerNode) BroadcastManifestToNetwork(manifest stream.HLSVideoManifest) error {
-	//Update the playlist
-	mpl, err := manifest.GetManifest()
-	if err != nil {
-		glog.Errorf("Error getting master playlist: %v", err)
-		return ErrBroadcast
-	}
-	if err = n.VideoNetwork.UpdateMasterPlaylist(manifest.GetManifestID(), mpl); err != nil {
-		glog.Errorf("Error updating master playlist: %v", err)
-		return ErrBroadcast
-	}
-
-	//Set up the callback for TranscodeResponse.  We are assuming there is only 1 stream.
-	if len(manifest.GetVideoStreams()) > 1 {
-		glog.Errorf("Currently only support single-stream manifest")
-		return ErrBroadcast
-	}
-	n.VideoNetwork.ReceivedTranscodeResponse(manifest.GetVideoStreams()[0].GetStreamID(), func(result map[string]string) {
-		//Parse through the results
-		for strmID, tProfile := range result {
-			vParams := lpmscore.VideoProfileToVariantParams(lpmscore.VideoProfileLookup[tProfile])
-			pl, _ := m3u8.NewMediaPlaylist(stream.DefaultHLSStreamWin, stream.DefaultHLSStreamCap)
-			variant := &m3u8.Variant{URI: fmt.Sprintf("%v.m3u8", strmID), Chunklist: pl, VariantParams: vParams}
-			strm := stream.NewBasicHLSVideoStream(strmID, stream.DefaultHLSStreamWin)
-			if err := manifest.AddVideoStream(strm, variant); err != nil {
-				glog.Errorf("Error adding video stream: %v", err)
+//upstreamPeer is the peer the node gets strmID from, if the network knows it.
+func (n *LivepeerNode) upstreamPeer(strmID string) NodeID {
+	if r, ok := n.VideoNetwork.(net.UpstreamReporter); ok {
+		return NodeID(r.UpstreamPeer(strmID))
+	}
+	return ""
+}
+
+//IsShuttingDown returns true once Shutdown has been called.
+func (n *LivepeerNode) IsShuttingDown() bool {
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	return n.shuttingDown
+}
+
+//Shutdown stops the node from taking new transcode jobs, finishes transcoding the segments that are in flight or queued by the
+//subscribers, claims the outstanding work and unsubscribes from the network.  Pending claims are written to the work dir before claiming, so if ctx expires before the claim
+//finishes they can be recovered later.
+func (n *LivepeerNode) Shutdown(ctx context.Context) error {
+	n.shutdownLock.Lock()
+	n.shuttingDown = true
+	if n.stopStart != nil {
+		n.stopStart()
+	}
+	jobs := make([]*transcodeJob, 0, len(n.transcodeJobs))
+	for _, j := range n.transcodeJobs {
+		jobs = append(jobs, j)
+	}
+	n.transcodeJobs = make(map[string]*transcodeJob)
+	n.shutdownLock.Unlock()
+
+	glog.Infof("Shutting down Livepeer node. Waiting for the queued segments of %v transcode job(s) to finish", len(jobs))
+	for _, j := range jobs {
+		if d, ok := j.sub.(net.DrainingSubscriber); ok {
+			if err := d.Drain(ctx); err != nil {
+				glog.Errorf("Timed out waiting for the queued segments of %v", j.config.StrmID)
 			}
+		}
+	}
+	n.shutdownLock.Lock()
+	n.segmentsStopped = true
+	n.shutdownLock.Unlock()
+	if err := waitWithContext(ctx, &n.segWg); err != nil {
+		glog.Errorf("Timed out waiting for segments to finish transcoding")
+	}
 
+	for _, j := range jobs {
+		if j.cm == nil || !j.config.PerformOnchainClaim {
+			continue
 		}
 
-		//Update the master playlist on the network
-		mpl, err := manifest.GetManifest()
-		if err != nil {
-			glog.Errorf("Error getting master playlist: %v", err)
-			return
+		claimFile := ""
+		if p, ok := j.cm.(ClaimPersister); ok {
+			claimFile = n.claimsFile(j.config.StrmID)
+			if err := p.PersistClaims(claimFile); err != nil {
+				glog.Errorf("Error persisting claims for %v: %v", j.config.StrmID, err)
+				claimFile = ""
+			}
 		}
-		if err = n.VideoNetwork.UpdateMasterPlaylist(manifest.GetManifestID(), mpl); err != nil {
-			glog.Errorf("Error updating master playlist on network: %v", err)
-			return
+
+		n.claimWg.Add(1)
+		go func(j *transcodeJob, claimFile string) {
+			defer n.claimWg.Done()
+			if err := n.ClaimVerifyAndDistributeFees(j.cm); err != nil {
+				glog.Errorf("Error claiming work for %v: %v", j.config.StrmID, err)
+				return
+			}
+			if claimFile != "" {
+				os.Remove(claimFile)
+			}
+		}(j, claimFile)
+	}
+	err := waitWithContext(ctx, &n.claimWg)
+	if err != nil {
+		glog.Errorf("Timed out waiting for claims to finish. Pending claims are saved in %v", filepath.Join(n.WorkDir, "claims"))
+	}
+
+	for _, j := range jobs {
+		if j.sub == nil {
+			continue
 		}
+		if err := j.sub.Unsubscribe(); err != nil {
+			glog.Errorf("Error unsubscribing from %v: %v", j.config.StrmID, err)
+		}
+	}
 
-		glog.V(common.SHORT).Infof("Updated master playlist for %v", manifest.GetManifestID())
-	})
+	return err
+}
 
-	return nil
+//TranscodeSource returns the stream that strmID is transcoded from, if it's the result of a transcode job of the node.
+func (n *LivepeerNode) TranscodeSource(strmID StreamID) (StreamID, bool) {
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	for src, j := range n.transcodeJobs {
+		for _, id := range j.results {
+			if id == strmID {
+				return StreamID(src), true
+			}
+		}
+	}
+	return "", false
+}
+
+//claimsFile is where the claims of a job are persisted until its fees are distributed.
+func (n *LivepeerNode) claimsFile(strmID string) string {
+	return filepath.Join(n.WorkDir, "claims", fmt.Sprintf("%v.json", strmID))
+}
+
+//startSegment registers an in-flight segment.  Returns false once Shutdown finished the queued segments.
+func (n *LivepeerNode) startSegment() bool {
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	if n.segmentsStopped {
+		return false
+	}
+	n.segWg.Add(1)
+	return true
+}
+
+//finishTranscodeJob stops tracking a job.  If claim is true, the claim is registered so Shutdown waits for it.  Returns false if the job has
+//already been taken over by Shutdown.
+func (n *LivepeerNode) finishTranscodeJob(strmID string, claim bool) bool {
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	if _, ok := n.transcodeJobs[strmID]; !ok {
+		return false
+	}
+	delete(n.transcodeJobs, strmID)
+	n.setTranscodedStreams(strmID, nil, ethcommon.Address{})
+	if claim {
+		n.claimWg.Add(1)
+	}
+	return true
+}
+
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *LivepeerNode) BroadcastFinishMsg(strmID string) error {
+	b, err := n.VideoNetwork.GetBroadcaster(strmID)
+	if err != nil {
+		glog.Errorf("Error getting broadcaster from network: %v", err)
+		return err
+	}
+
+	return b.Finish()
 }
 
 func (n *LivepeerNode) BroadcastHLSSegToNetwork(strmID string, seg *stream.HLSSegment, b stream.Broadcaster) error {
-	segHash := (&ethTypes.Segment{StreamID: strmID, SegmentSequenceNumber: big.NewInt(int64(seg.SeqNo)), DataHash: crypto.Keccak256Hash(seg.Data)}).Hash()
+	segHash := segmentHash(strmID, seg)
 
 	var sig []byte
 	var err error
-	if c, ok := n.Eth.(*eth.Client); ok {
-		sig, err = c.SignSegmentHash(n.EthPassword, segHash.Bytes())
+	if n.Eth != nil {
+		sig, err = n.Eth.SignSegmentHash(segHash.Bytes())
 		if err != nil {
 			glog.Errorf("Error signing segment %v-%v: %v", strmID, seg.SeqNo, err)
 			return err
@@ -337,7 +669,7 @@ func (n *LivepeerNode) BroadcastHLSSegToNetwork(strmID string, seg *stream.HLSSe
 	}
 
 	//Encode segment into []byte, broadcast it
-	if ssb, err := SignedSegmentToBytes(SignedSegment{Seg: *seg, Sig: sig}); err == nil {
+	if ssb, err := EncodeSignedSegment(SignedSegment{Seg: *seg, Sig: sig, StrmID: strmID, Timestamp: time.Now()}, n.SegmentFormat); err == nil {
 		if err = b.Broadcast(seg.SeqNo, ssb); err != nil {
 			glog.Errorf("Error broadcasting segment to network: %v", err)
 		}
@@ -349,6 +681,20 @@ func (n *LivepeerNode) BroadcastHLSSegToNetwork(strmID string, seg *stream.HLSSe
 	return nil
 }
 
+//segmentHash is the hash of a segment that the broadcaster signs.
+func segmentHash(strmID string, seg *stream.HLSSegment) ethcommon.Hash {
+	return (&ethTypes.Segment{StreamID: strmID, SegmentSequenceNumber: big.NewInt(int64(seg.SeqNo)), DataHash: crypto.Keccak256Hash(seg.Data)}).Hash()
+}
+
+//VerifySegmentSig checks that sig is the signature of segment seg of strmID by the broadcaster with the Eth address addr.
+func VerifySegmentSig(strmID string, seg *stream.HLSSegment, sig []byte, addr ethcommon.Address) bool {
+	if len(sig) != 65 {
+		return false
+	}
+	pub, err := crypto.SigToPub(signer.SegmentSignHash(segmentHash(strmID, seg).Bytes()), sig)
+	return err == nil && crypto.PubkeyToAddress(*pub) == addr
+}
+
 //SubscribeFromNetwork subscribes to a stream on the network.  Returns the stream as a reference.
 func (n *LivepeerNode) SubscribeFromNetwork(ctx context.Context, strmID StreamID, strm stream.HLSVideoStream) error {
 	glog.V(common.DEBUG).Infof("Subscribe from network: %v", strmID)
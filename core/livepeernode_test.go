@@ -1,21 +1,27 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/eth/signer"
 	"github.com/livepeer/go-livepeer/net"
 	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
 )
 
 type StubClaimManager struct {
 	verifyCalled         bool
 	distributeFeesCalled bool
+	claimErr             error
 }
 
 func (cm *StubClaimManager) AddReceipt(seqNo int64, data []byte, tDataHash []byte, bSig []byte, profile lpmscore.VideoProfile) error {
@@ -27,7 +33,11 @@ func (cm *StubClaimManager) Claim() (claimCount int, rc chan types.Receipt, ec c
 	ec = make(chan error)
 	go func() {
 		rc <- types.Receipt{}
-		rc <- types.Receipt{}
+		if cm.claimErr != nil {
+			ec <- cm.claimErr
+		} else {
+			rc <- types.Receipt{}
+		}
 	}()
 	return 2, rc, ec
 }
@@ -104,6 +114,113 @@ func TestTranscodeAndBroadcast(t *testing.T) {
 
 	//TODO: Should have done the claiming
 }
+
+type StubPeerManager struct {
+	lock       sync.Mutex
+	misbehaved map[NodeID]string
+}
+
+func (m *StubPeerManager) Start(ctx context.Context, bootstrap []PeerConn) {}
+func (m *StubPeerManager) Peers() []PeerStats                              { return nil }
+func (m *StubPeerManager) EvictPeer(nodeID NodeID, reason string) error    { return nil }
+func (m *StubPeerManager) ReportMisbehavior(nodeID NodeID, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.misbehaved[nodeID] = reason
+}
+
+func TestBadSegmentSignature(t *testing.T) {
+	key, _ := ethcrypto.GenerateKey()
+	strmID := "strmID"
+	p := []lpmscore.VideoProfile{lpmscore.P144p30fps16x9}
+	config := net.TranscodeConfig{StrmID: strmID, Profiles: p, BroadcasterAddress: ethcrypto.PubkeyToAddress(key.PublicKey)}
+
+	stubnet := &StubVideoNetwork{subscribers: make(map[string]*StubSubscriber)}
+	stubnet.subscribers[strmID] = &StubSubscriber{}
+	n, _ := NewLivepeerNode(nil, stubnet, "12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d", []string{""}, "")
+	pm := &StubPeerManager{misbehaved: make(map[NodeID]string)}
+	n.Peers = pm
+
+	//StubSubscriber sends a segment that isn't signed by the broadcaster, it's dropped and the peer it came from misbehaved
+	tr := &StubTranscoder{InputData: make([][]byte, 0), Profiles: p}
+	if _, err := n.TranscodeAndBroadcast(config, nil, tr); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	start := time.Now()
+	for time.Since(start) < time.Second {
+		pm.lock.Lock()
+		reason := pm.misbehaved["upstream"]
+		pm.lock.Unlock()
+		if reason != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+	if pm.misbehaved["upstream"] == "" {
+		t.Errorf("Expecting the upstream peer to be reported, got %v", pm.misbehaved)
+	}
+	if len(tr.InputData) != 0 {
+		t.Errorf("Expecting the segment to be dropped, got %v segments", len(tr.InputData))
+	}
+}
+
+func TestVerifySegmentSig(t *testing.T) {
+	key, _ := ethcrypto.GenerateKey()
+	addr := ethcrypto.PubkeyToAddress(key.PublicKey)
+	seg := &stream.HLSSegment{SeqNo: 3, Data: []byte("segment")}
+	sig, err := ethcrypto.Sign(signer.SegmentSignHash(segmentHash("strmID", seg).Bytes()), key)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !VerifySegmentSig("strmID", seg, sig, addr) {
+		t.Errorf("Expecting the signature to verify")
+	}
+	other, _ := ethcrypto.GenerateKey()
+	if VerifySegmentSig("strmID", seg, sig, ethcrypto.PubkeyToAddress(other.PublicKey)) {
+		t.Errorf("Expecting the signature not to verify for another address")
+	}
+	if VerifySegmentSig("otherStrmID", seg, sig, addr) || VerifySegmentSig("strmID", &stream.HLSSegment{SeqNo: 4, Data: seg.Data}, sig, addr) {
+		t.Errorf("Expecting the signature not to verify for another segment")
+	}
+	if VerifySegmentSig("strmID", seg, []byte("test sig"), addr) {
+		t.Errorf("Expecting a bad signature not to verify")
+	}
+}
+
+func TestSourceOfTranscodedStream(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	strmID := "strmID"
+	p := []lpmscore.VideoProfile{lpmscore.P720p60fps16x9, lpmscore.P144p30fps16x9}
+	config := net.TranscodeConfig{StrmID: strmID, Profiles: p, JobID: big.NewInt(0)}
+
+	stubnet := &StubVideoNetwork{subscribers: make(map[string]*StubSubscriber)}
+	stubnet.subscribers[strmID] = &StubSubscriber{}
+	n, err := NewLivepeerNode(&eth.StubClient{}, stubnet, nid, []string{""}, "")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	ids, err := n.TranscodeAndBroadcast(config, &StubClaimManager{}, &StubTranscoder{Profiles: p})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, id := range ids {
+		if src, ok := n.TranscodeSource(id); !ok || src != StreamID(strmID) {
+			t.Errorf("Expecting %v to be transcoded from %v, got %v %v", id, strmID, src, ok)
+		}
+	}
+	if _, ok := n.TranscodeSource(StreamID(strmID)); ok {
+		t.Errorf("Expecting the source not to be a transcoded stream")
+	}
+	//The job ends with the stream
+	n.finishTranscodeJob(strmID, false)
+	if _, ok := n.TranscodeSource(ids[0]); ok {
+		t.Errorf("Expecting the job to be gone")
+	}
+}
+
 func TestClaimVerifyDistributeFee(t *testing.T) {
 	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
 	n, err := NewLivepeerNode(&eth.StubClient{}, &StubVideoNetwork{}, nid, []string{""}, "")
@@ -124,3 +241,211 @@ func TestClaimVerifyDistributeFee(t *testing.T) {
 		t.Errorf("Expect distributeFees to be called")
 	}
 }
+
+func TestClaimVerifyDistributeFeeFailedClaim(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	n, err := NewLivepeerNode(&eth.StubClient{}, &StubVideoNetwork{}, nid, []string{""}, "")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	//Persisted claims are kept if one of the claims fails
+	cm := &StubClaimManager{claimErr: fmt.Errorf("claim failed")}
+	if err := n.ClaimVerifyAndDistributeFees(cm); err != ErrClaim {
+		t.Errorf("Expecting ErrClaim, got %v", err)
+	}
+	if cm.verifyCalled == false || cm.distributeFeesCalled == false {
+		t.Errorf("Expect the successful claims to be verified and paid")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	strmID := "strmID"
+	p := []lpmscore.VideoProfile{lpmscore.P720p60fps16x9, lpmscore.P144p30fps16x9}
+	config := net.TranscodeConfig{StrmID: strmID, Profiles: p, PerformOnchainClaim: true, JobID: big.NewInt(0)}
+
+	stubnet := &StubVideoNetwork{subscribers: make(map[string]*StubSubscriber)}
+	stubnet.subscribers[strmID] = &StubSubscriber{}
+	n, err := NewLivepeerNode(&eth.StubClient{}, stubnet, nid, []string{""}, "")
+	if err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	cm := &StubClaimManager{}
+	tr := &StubTranscoder{InputData: make([][]byte, 0), Profiles: p}
+	if _, err := n.TranscodeAndBroadcast(config, cm, tr); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Errorf("Error shutting down: %v", err)
+	}
+
+	//Outstanding work should be claimed
+	if cm.verifyCalled == false || cm.distributeFeesCalled == false {
+		t.Errorf("Expect outstanding work to be claimed")
+	}
+
+	//Should not take new jobs
+	if _, err := n.TranscodeAndBroadcast(config, &StubClaimManager{}, tr); err != ErrShuttingDown {
+		t.Errorf("Expecting ErrShuttingDown, got %v", err)
+	}
+}
+
+//queuedSubscriber has segments queued when the node shuts down, which it passes on when it's drained.
+type queuedSubscriber struct {
+	queued  []uint64
+	gotData func(seqNo uint64, data []byte, eof bool)
+}
+
+func (s *queuedSubscriber) IsLive() bool       { return true }
+func (s *queuedSubscriber) String() string     { return "" }
+func (s *queuedSubscriber) Unsubscribe() error { return nil }
+func (s *queuedSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint64, data []byte, eof bool)) error {
+	s.gotData = gotData
+	return nil
+}
+
+func (s *queuedSubscriber) send(seqNo uint64) {
+	b, _ := SignedSegmentToBytes(SignedSegment{Seg: stream.HLSSegment{SeqNo: seqNo, Name: fmt.Sprintf("test_%v.ts", seqNo), Data: []byte("data"), Duration: 1}})
+	s.gotData(seqNo, b, false)
+}
+
+func (s *queuedSubscriber) Drain(ctx context.Context) error {
+	for _, seqNo := range s.queued {
+		s.send(seqNo)
+	}
+	s.queued = nil
+	return nil
+}
+
+func TestShutdownQueuedSegments(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	p := []lpmscore.VideoProfile{lpmscore.P720p60fps16x9}
+	config := net.TranscodeConfig{StrmID: "strmID", Profiles: p, PerformOnchainClaim: true, JobID: big.NewInt(0)}
+	sub := &queuedSubscriber{queued: []uint64{1}}
+	n, err := NewLivepeerNode(&eth.StubClient{}, &lateSubscriberNetwork{StubVideoNetwork: &StubVideoNetwork{T: t}, sub: sub}, nid, []string{""}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	cm := &StubClaimManager{}
+	tr := &StubTranscoder{InputData: make([][]byte, 0), Profiles: p}
+	if _, err := n.TranscodeAndBroadcast(config, cm, tr); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Errorf("Error shutting down: %v", err)
+	}
+
+	//The queued segment is transcoded before the work is claimed
+	if len(tr.InputData) != 1 {
+		t.Errorf("Expecting the queued segment to be transcoded, got %v", len(tr.InputData))
+	}
+	if !cm.verifyCalled || !cm.distributeFeesCalled {
+		t.Errorf("Expect outstanding work to be claimed")
+	}
+	//Segments that come after aren't
+	sub.send(2)
+	if len(tr.InputData) != 1 {
+		t.Errorf("Expecting no segments to be transcoded after shutting down, got %v", len(tr.InputData))
+	}
+}
+
+//flakyNetwork fails to connect to each node the given number of times
+type flakyNetwork struct {
+	StubVideoNetwork
+	lock     sync.Mutex
+	failures map[string]int
+	attempts map[string]int
+}
+
+func (n *flakyNetwork) Connect(nodeID string, nodeAddr []string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.attempts[nodeID]++
+	if n.attempts[nodeID] <= n.failures[nodeID] {
+		return fmt.Errorf("can't connect")
+	}
+	return nil
+}
+
+func (n *flakyNetwork) attemptCount(nodeID string) int {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.attempts[nodeID]
+}
+
+func TestStartBootstrap(t *testing.T) {
+	oldBackoff, oldMax := BootstrapBackoff, BootstrapMaxBackoff
+	defer func() { BootstrapBackoff, BootstrapMaxBackoff = oldBackoff, oldMax }()
+	BootstrapBackoff, BootstrapMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	nw := &flakyNetwork{failures: map[string]int{"down": 1000, "flaky": 3}, attempts: make(map[string]int)}
+	n, err := NewLivepeerNode(nil, nw, NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d"), []string{""}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bootstrap := []PeerConn{{NodeID: "down", NodeAddr: "/ip4/127.0.0.1/tcp/1"}, {NodeID: "flaky", NodeAddr: "/ip4/127.0.0.1/tcp/2"}, {NodeID: "up", NodeAddr: "/ip4/127.0.0.1/tcp/3"}}
+	if err := n.Start(ctx, bootstrap); err != nil {
+		t.Fatalf("Error starting: %v", err)
+	}
+	//Each node was tried once by the time Start returns
+	for _, pc := range bootstrap {
+		if nw.attemptCount(pc.NodeID) < 1 {
+			t.Errorf("Expecting %v to be tried", pc.NodeID)
+		}
+	}
+
+	//The flaky node is retried until the node connects
+	start := time.Now()
+	for nw.attemptCount("flaky") < 4 {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Timed out waiting for retries")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c := nw.attemptCount("flaky"); c != 4 {
+		t.Errorf("Expecting 4 attempts for the flaky node, got %v", c)
+	}
+	n.peerLock.Lock()
+	if len(n.PeerConns) != 2 {
+		t.Errorf("Expecting 2 connected bootstrap nodes, got %v", n.PeerConns)
+	}
+	n.peerLock.Unlock()
+
+	//Retries stop with the context
+	cancel()
+	time.Sleep(30 * time.Millisecond)
+	c := nw.attemptCount("down")
+	time.Sleep(50 * time.Millisecond)
+	if nw.attemptCount("down") != c {
+		t.Errorf("Expecting the retries to stop")
+	}
+
+	//And when the node shuts down
+	nw = &flakyNetwork{failures: map[string]int{"down": 1000}, attempts: make(map[string]int)}
+	n, err = NewLivepeerNode(nil, nw, NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d"), []string{""}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := n.Start(context.Background(), bootstrap[:1]); err != nil {
+		t.Fatalf("Error starting: %v", err)
+	}
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Error shutting down: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	c = nw.attemptCount("down")
+	time.Sleep(50 * time.Millisecond)
+	if nw.attemptCount("down") != c {
+		t.Errorf("Expecting the retries to stop on shutdown")
+	}
+}
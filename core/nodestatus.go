@@ -0,0 +1,55 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/livepeer/go-livepeer/net"
+)
+
+//LivepeerVersion is the software version of the node.
+const LivepeerVersion = "0.1.7-unstable"
+
+//Capabilities the node reports in its status.
+const (
+	CapabilityBroadcaster   = "broadcaster"
+	CapabilityTranscoder    = "transcoder"
+	CapabilityBootnode      = "bootnode"
+	CapabilityHLSEncryption = "hls-aes128"
+)
+
+//SegmentFormatCapability is the capability of decoding segments in format f.
+func SegmentFormatCapability(f SegmentFormat) string {
+	return fmt.Sprintf("segmentformat/%v", f)
+}
+
+//addNodeStatus adds what the network doesn't know about the node to its status: the version, capabilities, Eth address, segment URL,
+//addresses and the transcode jobs it's working on.
+func (n *LivepeerNode) addNodeStatus(status *net.NodeStatus) {
+	status.Version = LivepeerVersion
+	status.Capabilities = append([]string{}, n.Capabilities...)
+	status.EthAddress = n.EthAccount
+	status.SegmentURL = n.SegmentURL
+	status.Addrs = n.NodeAddrs()
+
+	n.shutdownLock.Lock()
+	defer n.shutdownLock.Unlock()
+	status.Jobs = make([]net.JobStatus, 0, len(n.transcodeJobs))
+	for strmID, job := range n.transcodeJobs {
+		js := net.JobStatus{StreamID: strmID, Profiles: make([]string, 0, len(job.config.Profiles))}
+		if job.config.JobID != nil {
+			js.JobID = job.config.JobID.String()
+		}
+		for _, p := range job.config.Profiles {
+			js.Profiles = append(js.Profiles, p.Name)
+		}
+		status.Jobs = append(status.Jobs, js)
+	}
+	sort.Sort(jobsByStream(status.Jobs))
+}
+
+type jobsByStream []net.JobStatus
+
+func (j jobsByStream) Len() int           { return len(j) }
+func (j jobsByStream) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j jobsByStream) Less(a, b int) bool { return j[a].StreamID < j[b].StreamID }
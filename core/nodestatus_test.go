@@ -0,0 +1,155 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/livepeer/go-livepeer/net"
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+func TestNodeStatus(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	n, err := NewLivepeerNode(nil, &StubVideoNetwork{}, nid, []string{""}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	n.EthAccount = "0x0ddb225031ccb58ff42866f82d907f7766899014"
+	n.Capabilities = []string{CapabilityTranscoder, SegmentFormatCapability(SegmentFormatV1)}
+	n.transcodeJobs["strmB"] = &transcodeJob{config: net.TranscodeConfig{StrmID: "strmB", Profiles: []lpmscore.VideoProfile{lpmscore.P240p30fps16x9}}}
+	n.transcodeJobs["strmA"] = &transcodeJob{config: net.TranscodeConfig{StrmID: "strmA", Profiles: []lpmscore.VideoProfile{lpmscore.P360p30fps16x9, lpmscore.P240p30fps16x9}, JobID: big.NewInt(5)}}
+
+	//A playlist with the characters that broke the old format
+	mpl := m3u8.NewMasterPlaylist()
+	pl, _ := m3u8.NewMediaPlaylist(10, 10)
+	mpl.Append("a|b[]c.m3u8", pl, m3u8.VariantParams{Bandwidth: 100000})
+	status := &net.NodeStatus{NodeID: string(nid), Manifests: map[string]*m3u8.MasterPlaylist{"mid": mpl}, Relays: []net.RelayStatus{{StreamID: "strmC", UpstreamPeer: "peer", Listeners: 2}}}
+	n.addNodeStatus(status)
+
+	dec := &net.NodeStatus{}
+	if err := dec.FromString(status.String()); err != nil {
+		t.Fatalf("Error decoding status: %v", err)
+	}
+	if dec.NodeID != string(nid) || dec.Version != LivepeerVersion || dec.EthAddress != n.EthAccount || dec.StatusVersion != net.NodeStatusVersion {
+		t.Errorf("Wrong status: %+v", dec)
+	}
+	if len(dec.Capabilities) != 2 || dec.Capabilities[1] != "segmentformat/lpss1" {
+		t.Errorf("Wrong capabilities: %v", dec.Capabilities)
+	}
+	if m, ok := dec.Manifests["mid"]; !ok || len(m.Variants) != 1 || m.Variants[0].URI != "a|b[]c.m3u8" {
+		t.Errorf("Wrong manifests: %v", dec.Manifests)
+	}
+	if len(dec.Relays) != 1 || dec.Relays[0] != status.Relays[0] {
+		t.Errorf("Wrong relays: %v", dec.Relays)
+	}
+	if len(dec.Jobs) != 2 || dec.Jobs[0].StreamID != "strmA" || dec.Jobs[0].JobID != "5" || len(dec.Jobs[0].Profiles) != 2 || dec.Jobs[1].JobID != "" {
+		t.Errorf("Wrong jobs: %+v", dec.Jobs)
+	}
+
+	//Status of a node from before the versioned encoding
+	mpl = m3u8.NewMasterPlaylist()
+	mpl.Append("test.m3u8", pl, m3u8.VariantParams{Bandwidth: 100000})
+	legacy := string(nid) + "|mid[]" + mpl.String()
+	dec = &net.NodeStatus{}
+	if err := dec.FromString(legacy); err != nil {
+		t.Fatalf("Error decoding legacy status: %v", err)
+	}
+	if dec.NodeID != string(nid) || dec.StatusVersion != 0 || dec.Version != "" || len(dec.Manifests) != 1 {
+		t.Errorf("Wrong legacy status: %+v", dec)
+	}
+
+	if err := dec.FromString(`{"NodeID":"abc"}`); err != net.ErrNodeStatus {
+		t.Errorf("Expecting ErrNodeStatus without a version, got %v", err)
+	}
+}
+
+//oldFromString is NodeStatus.FromString of nodes from before the versioned encoding.
+func oldFromString(n *net.NodeStatus, str string) error {
+	arr := strings.Split(str, "|")
+	if len(arr[0]) != 68 {
+		return errors.New("Wrong format for NodeStatus")
+	} else {
+		n.NodeID = arr[0]
+	}
+
+	manifests := make(map[string]*m3u8.MasterPlaylist, 0)
+	for _, mstr := range arr[1:] {
+		mstrArr := strings.Split(mstr, "[]")
+		if len(mstrArr) == 2 {
+			m := m3u8.NewMasterPlaylist()
+			if err := m.DecodeFrom(strings.NewReader(mstrArr[1]), true); err == nil {
+				manifests[mstrArr[0]] = m
+			}
+		}
+	}
+	n.Manifests = manifests
+	return nil
+}
+
+func TestNodeStatusForOldNodes(t *testing.T) {
+	nid := NodeID("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d")
+	n, _ := NewLivepeerNode(nil, &StubVideoNetwork{}, nid, []string{""}, "")
+	n.Capabilities = []string{CapabilityTranscoder}
+	mpl := m3u8.NewMasterPlaylist()
+	pl, _ := m3u8.NewMediaPlaylist(10, 10)
+	mpl.Append("test.m3u8", pl, m3u8.VariantParams{Bandwidth: 100000})
+	status := &net.NodeStatus{NodeID: string(nid), Manifests: map[string]*m3u8.MasterPlaylist{"mid": mpl}}
+	n.addNodeStatus(status)
+
+	//Nodes that don't send a version get the format they decode
+	dec := &net.NodeStatus{}
+	if err := oldFromString(dec, status.Encode(0)); err != nil {
+		t.Fatalf("Old nodes cannot decode the status: %v", err)
+	}
+	if dec.NodeID != string(nid) || len(dec.Manifests) != 1 || dec.Manifests["mid"].Variants[0].URI != "test.m3u8" {
+		t.Errorf("Wrong status: %+v", dec)
+	}
+	//Which new nodes decode as well
+	dec = &net.NodeStatus{}
+	if err := dec.FromString(status.Encode(0)); err != nil || dec.NodeID != string(nid) || len(dec.Manifests) != 1 || dec.StatusVersion != 0 {
+		t.Errorf("Wrong legacy status: %+v %v", dec, err)
+	}
+
+	//Nodes that know the versioned encoding get everything
+	dec = &net.NodeStatus{}
+	if err := dec.FromString(status.Encode(net.NodeStatusVersion)); err != nil || dec.StatusVersion != net.NodeStatusVersion || len(dec.Capabilities) != 1 {
+		t.Errorf("Wrong status: %+v %v", dec, err)
+	}
+	if err := oldFromString(&net.NodeStatus{}, status.Encode(net.NodeStatusVersion)); err == nil {
+		t.Errorf("Expecting old nodes to reject the versioned encoding")
+	}
+}
+
+type addrsVideoNetwork struct {
+	StubVideoNetwork
+	addrs []string
+}
+
+func (n *addrsVideoNetwork) Addrs() []string { return n.addrs }
+
+func TestNodeStatusAddrs(t *testing.T) {
+	vn := &addrsVideoNetwork{}
+	n, err := NewLivepeerNode(nil, vn, NodeID("nid"), []string{"/ip4/10.0.0.2/tcp/15000"}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	//The addresses the node started with, until the network knows better
+	status := &net.NodeStatus{NodeID: "nid"}
+	n.addNodeStatus(status)
+	if len(status.Addrs) != 1 || status.Addrs[0] != "/ip4/10.0.0.2/tcp/15000" {
+		t.Errorf("Expecting the start addresses, got %v", status.Addrs)
+	}
+
+	vn.addrs = []string{"/ip4/1.2.3.4/tcp/15000"}
+	n.addNodeStatus(status)
+	dec := &net.NodeStatus{}
+	if err := dec.FromString(status.String()); err != nil {
+		t.Fatalf("Error decoding status: %v", err)
+	}
+	if len(dec.Addrs) != 1 || dec.Addrs[0] != "/ip4/1.2.3.4/tcp/15000" {
+		t.Errorf("Expecting the network addresses, got %v", dec.Addrs)
+	}
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/livepeer/lpms/transcoder"
+)
+
+//OutputCheckRetries is how many times a segment is transcoded again when some of its outputs fail the output check.
+var OutputCheckRetries = 1
+
+//MaxOutputFailures is how many output check failures the node remembers.
+var MaxOutputFailures = 100
+
+//DefaultDurationTolerance is how far (in seconds) the duration of a transcoded segment can be from the source segment.
+var DefaultDurationTolerance = 0.5
+
+const tsPacketSize = 188
+
+//OutputChecker checks transcoded segments before they are broadcast and claimed, so broken output is caught locally instead of in
+//on-chain verification.
+type OutputChecker interface {
+	//Check returns the reasons out doesn't match the profile and the source segment, or nil if it looks good.
+	Check(src *stream.HLSSegment, out []byte, profile lpmscore.VideoProfile) []string
+}
+
+//OutputFailure is a transcoded segment that failed the output check and was left out of the claim.
+type OutputFailure struct {
+	StreamID string
+	SeqNo    uint64
+	Profile  string
+	Reasons  []string
+	Time     time.Time
+}
+
+//FFProbeChecker checks the outputs with ffprobe: the container has to be MPEG-TS, the resolution and framerate (or for audio profiles
+//the codec, channels and sample rate) have to match the profile, the duration has to match the source segment and the bitrate can't be 0.
+type FFProbeChecker struct {
+	FFProbePath       string //Directory of the ffprobe binary, empty to look it up in PATH
+	WorkDir           string
+	DurationTolerance float64
+}
+
+func NewFFProbeChecker(ffprobePath, workDir string) *FFProbeChecker {
+	return &FFProbeChecker{FFProbePath: ffprobePath, WorkDir: workDir, DurationTolerance: DefaultDurationTolerance}
+}
+
+func (c *FFProbeChecker) Check(src *stream.HLSSegment, out []byte, profile lpmscore.VideoProfile) []string {
+	//Cheap checks first, no need to run ffprobe on data that isn't TS
+	if reasons := checkTSPackets(out); reasons != nil {
+		return reasons
+	}
+
+	probe, err := ffprobe(c.FFProbePath, c.WorkDir, out)
+	if err != nil {
+		return []string{fmt.Sprintf("ffprobe failed: %v", err)}
+	}
+	return checkProbe(probe, src.Duration, profile, c.DurationTolerance)
+}
+
+func ffprobe(ffprobePath, workDir string, data []byte) (*probeResult, error) {
+	if workDir != "" {
+		if err := os.MkdirAll(workDir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	//ffprobe can't get the duration of a TS stream from a pipe, so write it out
+	f, err := ioutil.TempFile(workDir, "probe")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path.Join(ffprobePath, "ffprobe"), "-v", "error", "-print_format", "json", "-show_format", "-show_streams", f.Name())
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v %v", err, strings.TrimSpace(stderr.String()))
+	}
+	var probe probeResult
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+	return &probe, nil
+}
+
+type probeResult struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		Profile    string `json:"profile"`
+		Level      int    `json:"level"`
+		Width      int    `json:"width"`
+		Height     int    `json:"height"`
+		RFrameRate string `json:"r_frame_rate"`
+		Channels   int    `json:"channels"`
+		SampleRate string `json:"sample_rate"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+//checkTSPackets makes sure data is a whole number of TS packets, each starting with the sync byte.
+func checkTSPackets(data []byte) []string {
+	if len(data) == 0 {
+		return []string{"empty output"}
+	}
+	if len(data)%tsPacketSize != 0 {
+		return []string{fmt.Sprintf("truncated output, %v bytes is not a whole number of TS packets", len(data))}
+	}
+	for i := 0; i < len(data); i += tsPacketSize {
+		if data[i] != 0x47 {
+			return []string{fmt.Sprintf("missing TS sync byte at offset %v", i)}
+		}
+	}
+	return nil
+}
+
+func checkProbe(p *probeResult, srcDuration float64, profile lpmscore.VideoProfile, tolerance float64) []string {
+	reasons := make([]string, 0)
+	if !strings.Contains(p.Format.FormatName, "mpegts") {
+		reasons = append(reasons, fmt.Sprintf("container is %q, not mpegts", p.Format.FormatName))
+	}
+
+	if a, ok := AudioProfileLookup[profile.Name]; ok {
+		reasons = append(reasons, checkAudioStreams(p, a)...)
+	} else if video := findStream(p, "video"); video == -1 {
+		reasons = append(reasons, "no video stream")
+	} else if profile.Name != PassthroughProfile.Name {
+		//Passthrough keeps whatever resolution and framerate the source has
+		s := p.Streams[video]
+		if res := fmt.Sprintf("%vx%v", s.Width, s.Height); res != profile.Resolution {
+			reasons = append(reasons, fmt.Sprintf("resolution is %v, expected %v", res, profile.Resolution))
+		}
+		if fps, ok := parseFrameRate(s.RFrameRate); !ok || math.Abs(fps-float64(profile.Framerate)) > 1 {
+			reasons = append(reasons, fmt.Sprintf("framerate is %v, expected %v", s.RFrameRate, profile.Framerate))
+		}
+	}
+
+	if d, err := strconv.ParseFloat(p.Format.Duration, 64); err != nil {
+		reasons = append(reasons, fmt.Sprintf("unknown duration %q", p.Format.Duration))
+	} else if srcDuration > 0 && math.Abs(d-srcDuration) > tolerance {
+		reasons = append(reasons, fmt.Sprintf("duration is %.3fs, source segment is %.3fs", d, srcDuration))
+	}
+
+	if br, err := strconv.ParseInt(p.Format.BitRate, 10, 64); err != nil || br <= 0 {
+		reasons = append(reasons, fmt.Sprintf("bitrate is %q", p.Format.BitRate))
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+	return reasons
+}
+
+//checkAudioStreams makes sure an audio rendition has only audio, with the channels and sample rate of the profile.
+func checkAudioStreams(p *probeResult, a AudioProfile) []string {
+	reasons := make([]string, 0)
+	if findStream(p, "video") != -1 {
+		reasons = append(reasons, "video stream in audio rendition")
+	}
+	audio := findStream(p, "audio")
+	if audio == -1 {
+		return append(reasons, "no audio stream")
+	}
+	s := p.Streams[audio]
+	if s.CodecName != a.Codec {
+		reasons = append(reasons, fmt.Sprintf("audio codec is %v, expected %v", s.CodecName, a.Codec))
+	}
+	if s.Channels != int(a.Channels) {
+		reasons = append(reasons, fmt.Sprintf("audio has %v channels, expected %v", s.Channels, a.Channels))
+	}
+	if s.SampleRate != fmt.Sprintf("%d", a.SampleRate) {
+		reasons = append(reasons, fmt.Sprintf("sample rate is %v, expected %v", s.SampleRate, a.SampleRate))
+	}
+	return reasons
+}
+
+func findStream(p *probeResult, codecType string) int {
+	for i, s := range p.Streams {
+		if s.CodecType == codecType {
+			return i
+		}
+	}
+	return -1
+}
+
+//parseFrameRate parses ffprobe rates like 30/1 or 30000/1001.
+func parseFrameRate(r string) (float64, bool) {
+	parts := strings.Split(r, "/")
+	num, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	if len(parts) == 1 {
+		return num, true
+	}
+	den, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
+
+//checkOutputs runs the output checker on the transcoded segments.  The segment is transcoded again (up to OutputCheckRetries times) if
+//some outputs fail, and the outputs that keep failing are set to nil so they are not broadcast or claimed.
+func (n *LivepeerNode) checkOutputs(seg *stream.HLSSegment, t transcoder.Transcoder, tData [][]byte, strmID string, profiles []lpmscore.VideoProfile) [][]byte {
+	failed := make(map[int][]string)
+	for i, p := range profiles {
+		if reasons := n.OutputChecker.Check(seg, tData[i], p); reasons != nil {
+			failed[i] = reasons
+		}
+	}
+
+	for attempt := 0; len(failed) > 0 && attempt < OutputCheckRetries; attempt++ {
+		glog.Infof("%v output(s) of segment %v failed the output check, transcoding again", len(failed), seg.SeqNo)
+		retry, err := t.Transcode(seg.Data)
+		if err != nil || len(retry) != len(profiles) {
+			glog.Errorf("Error transcoding seg %v again: %v", seg.Name, err)
+			continue
+		}
+		for i := range failed {
+			if reasons := n.OutputChecker.Check(seg, retry[i], profiles[i]); reasons != nil {
+				failed[i] = reasons
+			} else {
+				tData[i] = retry[i]
+				delete(failed, i)
+			}
+		}
+	}
+
+	for i, reasons := range failed {
+		glog.Errorf("Leaving %v output of segment %v out of the claim: %v", profiles[i].Name, seg.SeqNo, strings.Join(reasons, ", "))
+		n.recordOutputFailure(OutputFailure{StreamID: strmID, SeqNo: seg.SeqNo, Profile: profiles[i].Name, Reasons: reasons, Time: time.Now()})
+		tData[i] = nil
+	}
+	return tData
+}
+
+func (n *LivepeerNode) recordOutputFailure(f OutputFailure) {
+	n.failureLock.Lock()
+	defer n.failureLock.Unlock()
+	n.outputFailures = append(n.outputFailures, f)
+	if len(n.outputFailures) > MaxOutputFailures {
+		n.outputFailures = n.outputFailures[len(n.outputFailures)-MaxOutputFailures:]
+	}
+}
+
+//OutputFailures returns the most recent segments that failed the output check, oldest first.
+func (n *LivepeerNode) OutputFailures() []OutputFailure {
+	n.failureLock.Lock()
+	defer n.failureLock.Unlock()
+	return append([]OutputFailure{}, n.outputFailures...)
+}
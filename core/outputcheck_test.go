@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+func TestCheckTSPackets(t *testing.T) {
+	packet := append([]byte{0x47}, make([]byte, tsPacketSize-1)...)
+	if reasons := checkTSPackets(bytes.Repeat(packet, 3)); reasons != nil {
+		t.Errorf("Expecting valid TS, got %v", reasons)
+	}
+	if reasons := checkTSPackets(nil); reasons == nil {
+		t.Errorf("Expecting empty output to fail")
+	}
+	if reasons := checkTSPackets(bytes.Repeat(packet, 3)[:tsPacketSize*2+10]); reasons == nil {
+		t.Errorf("Expecting truncated output to fail")
+	}
+	if reasons := checkTSPackets(append(bytes.Repeat(packet, 2), make([]byte, tsPacketSize)...)); reasons == nil {
+		t.Errorf("Expecting missing sync byte to fail")
+	}
+}
+
+func TestCheckProbe(t *testing.T) {
+	parse := func(s string) *probeResult {
+		var p probeResult
+		if err := json.Unmarshal([]byte(s), &p); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		return &p
+	}
+
+	good := parse(`{"streams":[{"codec_type":"audio"},{"codec_type":"video","width":426,"height":240,"r_frame_rate":"30/1"}],
+		"format":{"format_name":"mpegts","duration":"4.004000","bit_rate":"400123"}}`)
+	if reasons := checkProbe(good, 4, lpmscore.P240p30fps16x9, DefaultDurationTolerance); reasons != nil {
+		t.Errorf("Expecting output to pass, got %v", reasons)
+	}
+
+	//Wrong profile
+	if reasons := checkProbe(good, 4, lpmscore.P720p60fps16x9, DefaultDurationTolerance); len(reasons) != 2 {
+		t.Errorf("Expecting resolution and framerate to fail, got %v", reasons)
+	}
+	//Duration doesn't match the source segment
+	if reasons := checkProbe(good, 2, lpmscore.P240p30fps16x9, DefaultDurationTolerance); len(reasons) != 1 {
+		t.Errorf("Expecting duration to fail, got %v", reasons)
+	}
+
+	bad := parse(`{"streams":[{"codec_type":"audio"}],"format":{"format_name":"mp4","duration":"N/A","bit_rate":"0"}}`)
+	if reasons := checkProbe(bad, 4, lpmscore.P240p30fps16x9, DefaultDurationTolerance); len(reasons) != 4 {
+		t.Errorf("Expecting container, video stream, duration and bitrate to fail, got %v", reasons)
+	}
+
+	audio := parse(`{"streams":[{"codec_type":"audio","codec_name":"aac","channels":2,"sample_rate":"48000"}],
+		"format":{"format_name":"mpegts","duration":"4.010000","bit_rate":"130000"}}`)
+	if reasons := checkProbe(audio, 4, A128kStereo.VideoProfile(), DefaultDurationTolerance); reasons != nil {
+		t.Errorf("Expecting audio output to pass, got %v", reasons)
+	}
+	if reasons := checkProbe(audio, 4, A32kMono.VideoProfile(), DefaultDurationTolerance); len(reasons) != 2 {
+		t.Errorf("Expecting channels and sample rate to fail, got %v", reasons)
+	}
+	//Video in an audio rendition
+	if reasons := checkProbe(good, 4, A128kStereo.VideoProfile(), DefaultDurationTolerance); len(reasons) != 4 {
+		t.Errorf("Expecting video stream, codec, channels and sample rate to fail, got %v", reasons)
+	}
+}
+
+type StubOutputChecker struct {
+	//Number of times each profile fails before it passes, -1 to always fail
+	Failures map[string]int
+}
+
+func (c *StubOutputChecker) Check(src *stream.HLSSegment, out []byte, profile lpmscore.VideoProfile) []string {
+	f := c.Failures[profile.Name]
+	if f == 0 {
+		return nil
+	}
+	if f > 0 {
+		c.Failures[profile.Name] = f - 1
+	}
+	return []string{"bad output"}
+}
+
+func TestCheckOutputs(t *testing.T) {
+	stubnet := &StubVideoNetwork{subscribers: make(map[string]*StubSubscriber)}
+	n, err := NewLivepeerNode(nil, stubnet, NodeID("nid"), []string{""}, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	p := []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps16x9, lpmscore.P144p30fps16x9}
+	n.OutputChecker = &StubOutputChecker{Failures: map[string]int{p[1].Name: 1, p[2].Name: -1}}
+	tr := &StubTranscoder{Profiles: p}
+	seg := &stream.HLSSegment{SeqNo: 7, Data: []byte("seg"), Duration: 4}
+	tData, _ := tr.Transcode(seg.Data)
+
+	tData = n.checkOutputs(seg, tr, tData, "strmID", p)
+	//The second profile passes after the retry, the third one is left out
+	if len(tr.InputData) != 1+OutputCheckRetries {
+		t.Errorf("Expecting the segment to be transcoded again, got %v transcodes", len(tr.InputData))
+	}
+	if tData[0] == nil || tData[1] == nil || tData[2] != nil {
+		t.Errorf("Expecting only the third output to be left out, got %q", tData)
+	}
+
+	failures := n.OutputFailures()
+	if len(failures) != 1 || failures[0].Profile != p[2].Name || failures[0].SeqNo != 7 || failures[0].StreamID != "strmID" || len(failures[0].Reasons) != 1 {
+		t.Errorf("Unexpected failures %+v", failures)
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+//PeerStats is what the node knows about the health of a peer.
+type PeerStats struct {
+	NodeID    string
+	Addrs     []string
+	Connected bool
+	//RTT is the smoothed round trip time of the pings, 0 until the first ping is answered
+	RTT time.Duration
+	//LastSeen is when the peer last answered a ping
+	LastSeen time.Time
+	//PingFailures is how many pings in a row the peer didn't answer
+	PingFailures int
+	//Misbehavior counts the bad messages from the peer
+	Misbehavior int
+	//EvictedUntil is when the node will connect to the peer again after evicting it, zero if it wasn't evicted
+	EvictedUntil time.Time `json:",omitempty"`
+	EvictReason  string    `json:",omitempty"`
+}
+
+//PeerManager keeps the node connected to a number of healthy peers.
+type PeerManager interface {
+	//Start watches the peers until ctx is done.  The bootstrap peers are reconnected first, and are kept when there are too many peers.
+	Start(ctx context.Context, bootstrap []PeerConn)
+	Peers() []PeerStats
+	//EvictPeer disconnects from a peer and doesn't connect to it again for a while
+	EvictPeer(nodeID NodeID, reason string) error
+	//ReportMisbehavior counts a bad message from a peer, peers that send too many are evicted
+	ReportMisbehavior(nodeID NodeID, reason string)
+}
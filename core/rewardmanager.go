@@ -14,11 +14,12 @@ type RewardManager struct {
 	checkFreq       time.Duration
 	lastRewardRound *big.Int
 	client          eth.LivepeerEthClient
+	settings        *SettingsStore
 }
 
-//NewRewardManager creates a new reward manager with a given checkFrequency.
-func NewRewardManager(checkFreq time.Duration, client eth.LivepeerEthClient) *RewardManager {
-	return &RewardManager{checkFreq: checkFreq, client: client}
+//NewRewardManager creates a new reward manager with a given checkFrequency.  Reward is only called while AutoReward is on in settings.
+func NewRewardManager(checkFreq time.Duration, client eth.LivepeerEthClient, settings *SettingsStore) *RewardManager {
+	return &RewardManager{checkFreq: checkFreq, client: client, settings: settings}
 }
 
 //Start repeatedly calls reward.
@@ -30,12 +31,28 @@ func (r *RewardManager) Start(ctx context.Context) {
 
 	r.lastRewardRound = lastRewardRound
 
+	autoReward := true
+	var settingsCh <-chan Settings
+	if r.settings != nil {
+		var unsubscribe func()
+		settingsCh, unsubscribe = r.settings.Subscribe()
+		defer unsubscribe()
+		autoReward = r.settings.Get().AutoReward
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case s := <-settingsCh:
+			if s.AutoReward != autoReward {
+				glog.Infof("Auto reward set to %v", s.AutoReward)
+			}
+			autoReward = s.AutoReward
 		case <-time.After(r.checkFreq):
-			r.callReward()
+			if autoReward {
+				r.callReward()
+			}
 		}
 	}
 }
@@ -0,0 +1,52 @@
+// Code generated by protoc-gen-gogo.
+// source: segment.proto
+// DO NOT EDIT!
+
+/*
+Package core is a generated protocol buffer package.
+
+It is generated from these files:
+
+	segment.proto
+
+It has these top-level messages:
+
+	WireSegment
+*/
+package core
+
+import proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// WireSegment is a core.SignedSegment on the wire
+type WireSegment struct {
+	// Stream the segment belongs to
+	StreamId string `protobuf:"bytes,1,opt,name=stream_id,proto3" json:"stream_id,omitempty"`
+	SeqNo    uint64 `protobuf:"varint,2,opt,name=seq_no,proto3" json:"seq_no,omitempty"`
+	Name     string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	// Seconds
+	Duration float64 `protobuf:"fixed64,4,opt,name=duration,proto3" json:"duration,omitempty"`
+	// When the broadcaster signed the segment, unix nanoseconds
+	Timestamp int64 `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// RFC 6381 codec string of the segment, empty if unknown
+	Codecs string `protobuf:"bytes,6,opt,name=codecs,proto3" json:"codecs,omitempty"`
+	Data   []byte `protobuf:"bytes,7,opt,name=data,proto3" json:"data,omitempty"`
+	// keccak256 of data, which is what the segment hash of the signature commits to
+	DataHash []byte `protobuf:"bytes,8,opt,name=data_hash,proto3" json:"data_hash,omitempty"`
+	// Broadcaster signature of the segment hash, empty for offchain broadcasters
+	Sig []byte `protobuf:"bytes,9,opt,name=sig,proto3" json:"sig,omitempty"`
+}
+
+func (m *WireSegment) Reset()         { *m = WireSegment{} }
+func (m *WireSegment) String() string { return proto.CompactTextString(m) }
+func (*WireSegment) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterType((*WireSegment)(nil), "core.WireSegment")
+}
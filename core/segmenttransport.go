@@ -0,0 +1,67 @@
+package core
+
+import (
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+//SegmentTransport is how the source segments of a transcode job get to the transcoder.
+type SegmentTransport string
+
+const (
+	//SegmentTransportP2P broadcasts the segments to the network, and the transcoder subscribes to them
+	SegmentTransportP2P SegmentTransport = "p2p"
+	//SegmentTransportHTTP sends the segments straight to the transcoder over HTTP as well, if it takes them.  The transcoded segments come
+	//back in the response.
+	SegmentTransportHTTP SegmentTransport = "http"
+)
+
+//CapabilityHTTPSegments is the capability of taking the segments of transcode jobs over HTTP.
+const CapabilityHTTPSegments = "segmenttransport/http"
+
+//SegmentURLTimeout is how long to wait for the status of a transcoder to find its segment URL.
+var SegmentURLTimeout = 30 * time.Second
+
+//ValidSegmentTransport tells if t is a transport the node knows.
+func ValidSegmentTransport(t SegmentTransport) bool {
+	return t == SegmentTransportP2P || t == SegmentTransportHTTP
+}
+
+//PeerSegmentURL asks a node for the URL it takes segments on.  It's empty if the node only takes them from the network.
+func (n *LivepeerNode) PeerSegmentURL(nid NodeID) (string, error) {
+	c, err := n.VideoNetwork.GetNodeStatus(string(nid))
+	if err != nil {
+		return "", err
+	}
+	timer := time.NewTimer(SegmentURLTimeout)
+	defer timer.Stop()
+	select {
+	case status := <-c:
+		if status == nil {
+			glog.Errorf("Cannot get the status of %v", nid)
+			return "", ErrNotFound
+		}
+		return status.SegmentURL, nil
+	case <-timer.C:
+		return "", ErrNotFound
+	}
+}
+
+//setTranscodedStreams tells the network which streams a job transcodes into, and who signs its segments, if it takes segments over HTTP.
+func (n *LivepeerNode) setTranscodedStreams(strmID string, resultStrmIDs []StreamID, broadcaster ethcommon.Address) {
+	r, ok := n.VideoNetwork.(net.SegmentRouter)
+	if !ok {
+		return
+	}
+	var ids []string
+	if resultStrmIDs != nil {
+		ids = make([]string, 0, len(resultStrmIDs))
+		for _, id := range resultStrmIDs {
+			ids = append(ids, string(id))
+		}
+	}
+	r.SetTranscodedStreams(strmID, ids, broadcaster)
+}
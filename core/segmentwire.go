@@ -0,0 +1,140 @@
+package core
+
+//go:generate protoc --gogo_out=. segment.proto
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+	"github.com/livepeer/lpms/stream"
+	proto "gx/ipfs/QmZ4Qi3GaRbjcx28Sme5eMH7RQjGkt8wHxt2a65oLaeFEV/gogo-protobuf/proto"
+)
+
+var ErrSegmentFormat = errors.New("ErrSegmentFormat")
+
+//SegmentFormat is an encoding of signed segments on the wire.
+type SegmentFormat string
+
+const (
+	//SegmentFormatGob is the gob encoding of SignedSegment.  It's what older nodes send and understand.
+	SegmentFormatGob SegmentFormat = "gob"
+	//SegmentFormatV1 is version 1 of the protobuf encoding in segment.proto.
+	SegmentFormatV1 SegmentFormat = "lpss1"
+)
+
+//SegmentFormats are the formats this node decodes, preferred first.
+var SegmentFormats = []SegmentFormat{SegmentFormatV1, SegmentFormatGob}
+
+//segmentHeader starts every segment in a versioned format, followed by the version.  gob never starts with 0x00.
+var segmentHeader = []byte{0x00, 'L', 'P'}
+
+const segmentVersion1 = 1
+
+//SegmentFormatNegotiator asks other nodes which segment formats they decode.
+type SegmentFormatNegotiator interface {
+	PeerSegmentFormats(nodeID NodeID) ([]SegmentFormat, error)
+}
+
+//NegotiateSegmentFormat picks the format to send segments in to a peer that decodes theirs.  It's the first of SegmentFormats the
+//peer knows, and gob if the peer didn't say.
+func NegotiateSegmentFormat(theirs []SegmentFormat) SegmentFormat {
+	for _, ours := range SegmentFormats {
+		for _, f := range theirs {
+			if f == ours {
+				return f
+			}
+		}
+	}
+	return SegmentFormatGob
+}
+
+//ParseSegmentFormats parses a comma separated list of formats, leaving out the ones this node doesn't know.
+func ParseSegmentFormats(s string) []SegmentFormat {
+	formats := make([]SegmentFormat, 0)
+	for _, name := range strings.Split(s, ",") {
+		f := SegmentFormat(strings.TrimSpace(name))
+		if f.IsKnown() {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+func (f SegmentFormat) IsKnown() bool {
+	for _, known := range SegmentFormats {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+//EncodeSignedSegment encodes a segment in format f.
+func EncodeSignedSegment(ss SignedSegment, f SegmentFormat) ([]byte, error) {
+	switch f {
+	case SegmentFormatGob:
+		return SignedSegmentToBytes(ss)
+	case SegmentFormatV1:
+		ts := int64(0)
+		if !ss.Timestamp.IsZero() {
+			ts = ss.Timestamp.UnixNano()
+		}
+		m := &WireSegment{
+			StreamId:  ss.StrmID,
+			SeqNo:     ss.Seg.SeqNo,
+			Name:      ss.Seg.Name,
+			Duration:  ss.Seg.Duration,
+			Timestamp: ts,
+			Codecs:    ss.Codecs,
+			Data:      ss.Seg.Data,
+			DataHash:  crypto.Keccak256(ss.Seg.Data),
+			Sig:       ss.Sig,
+		}
+		data, err := proto.Marshal(m)
+		if err != nil {
+			glog.Errorf("Error encoding segment: %v", err)
+			return nil, err
+		}
+		return append(append(append([]byte{}, segmentHeader...), segmentVersion1), data...), nil
+	}
+	return nil, ErrSegmentFormat
+}
+
+//SegmentFormatOf is the format data is encoded in.
+func SegmentFormatOf(data []byte) SegmentFormat {
+	if len(data) > len(segmentHeader) && bytes.HasPrefix(data, segmentHeader) && data[len(segmentHeader)] == segmentVersion1 {
+		return SegmentFormatV1
+	}
+	return SegmentFormatGob
+}
+
+//decodeVersionedSegment decodes a segment that starts with segmentHeader.
+func decodeVersionedSegment(data []byte) (SignedSegment, error) {
+	if len(data) <= len(segmentHeader) || data[len(segmentHeader)] != segmentVersion1 {
+		glog.Errorf("Unknown segment format version")
+		return SignedSegment{}, ErrSegmentFormat
+	}
+	m := &WireSegment{}
+	if err := proto.Unmarshal(data[len(segmentHeader)+1:], m); err != nil {
+		glog.Errorf("Error decoding segment: %v", err)
+		return SignedSegment{}, err
+	}
+	if !bytes.Equal(m.DataHash, crypto.Keccak256(m.Data)) {
+		glog.Errorf("Segment %v-%v doesn't match its data hash", m.StreamId, m.SeqNo)
+		return SignedSegment{}, ErrSegmentFormat
+	}
+	ss := SignedSegment{
+		Seg:    stream.HLSSegment{SeqNo: m.SeqNo, Name: m.Name, Data: m.Data, Duration: m.Duration},
+		Sig:    m.Sig,
+		StrmID: m.StreamId,
+		Codecs: m.Codecs,
+	}
+	if m.Timestamp != 0 {
+		ss.Timestamp = time.Unix(0, m.Timestamp)
+	}
+	return ss, nil
+}
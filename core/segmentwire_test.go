@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/livepeer/lpms/stream"
+)
+
+func TestEncodeSignedSegment(t *testing.T) {
+	ts := time.Unix(1500000000, 123)
+	ss := SignedSegment{
+		Seg:       stream.HLSSegment{SeqNo: 9, Name: "seg9.ts", Data: []byte("data"), Duration: 2.5},
+		Sig:       []byte("sig"),
+		StrmID:    "strm",
+		Timestamp: ts,
+		Codecs:    "avc1.4d401f",
+	}
+
+	//lpss1 keeps everything
+	data, err := EncodeSignedSegment(ss, SegmentFormatV1)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte{0x00, 'L', 'P', 1}) {
+		t.Errorf("Missing header: %x", data[:4])
+	}
+	if f := SegmentFormatOf(data); f != SegmentFormatV1 {
+		t.Errorf("Expecting lpss1, got %v", f)
+	}
+	dec, err := BytesToSignedSegment(data)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if dec.Seg.SeqNo != 9 || dec.Seg.Name != "seg9.ts" || !bytes.Equal(dec.Seg.Data, []byte("data")) || dec.Seg.Duration != 2.5 ||
+		!bytes.Equal(dec.Sig, []byte("sig")) || dec.StrmID != "strm" || !dec.Timestamp.Equal(ts) || dec.Codecs != "avc1.4d401f" {
+		t.Errorf("Wrong segment: %+v", dec)
+	}
+
+	//gob is what older nodes send
+	data, err = EncodeSignedSegment(ss, SegmentFormatGob)
+	if err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	if f := SegmentFormatOf(data); f != SegmentFormatGob {
+		t.Errorf("Expecting gob, got %v", f)
+	}
+	old, _ := SignedSegmentToBytes(SignedSegment{Seg: ss.Seg, Sig: ss.Sig})
+	for _, d := range [][]byte{data, old} {
+		dec, err = BytesToSignedSegment(d)
+		if err != nil || dec.Seg.SeqNo != 9 || !bytes.Equal(dec.Seg.Data, []byte("data")) || !bytes.Equal(dec.Sig, []byte("sig")) {
+			t.Errorf("Wrong gob segment: %+v %v", dec, err)
+		}
+	}
+
+	if _, err := EncodeSignedSegment(ss, SegmentFormat("foo")); err != ErrSegmentFormat {
+		t.Errorf("Expected ErrSegmentFormat, got %v", err)
+	}
+}
+
+func TestDecodeBadSegment(t *testing.T) {
+	ss := SignedSegment{Seg: stream.HLSSegment{SeqNo: 1, Data: []byte("data")}, Sig: []byte("sig")}
+	data, _ := EncodeSignedSegment(ss, SegmentFormatV1)
+
+	//Data that doesn't match its hash
+	bad := append([]byte{}, data...)
+	i := bytes.Index(bad, []byte("data"))
+	bad[i] = 'x'
+	if _, err := BytesToSignedSegment(bad); err != ErrSegmentFormat {
+		t.Errorf("Expected ErrSegmentFormat for tampered data, got %v", err)
+	}
+
+	//Version this node doesn't know
+	bad = append([]byte{}, data...)
+	bad[len(segmentHeader)] = 2
+	if _, err := BytesToSignedSegment(bad); err != ErrSegmentFormat {
+		t.Errorf("Expected ErrSegmentFormat for unknown version, got %v", err)
+	}
+
+	if _, err := BytesToSignedSegment(segmentHeader); err != ErrSegmentFormat {
+		t.Errorf("Expected ErrSegmentFormat for a bare header, got %v", err)
+	}
+}
+
+func TestNegotiateSegmentFormat(t *testing.T) {
+	if f := NegotiateSegmentFormat([]SegmentFormat{SegmentFormatGob, SegmentFormatV1}); f != SegmentFormatV1 {
+		t.Errorf("Expected lpss1, got %v", f)
+	}
+	if f := NegotiateSegmentFormat([]SegmentFormat{SegmentFormatGob}); f != SegmentFormatGob {
+		t.Errorf("Expected gob, got %v", f)
+	}
+	if f := NegotiateSegmentFormat(nil); f != SegmentFormatGob {
+		t.Errorf("Expected gob, got %v", f)
+	}
+
+	formats := ParseSegmentFormats(" lpss1, lpss9,gob\n")
+	if len(formats) != 2 || formats[0] != SegmentFormatV1 || formats[1] != SegmentFormatGob {
+		t.Errorf("Wrong formats: %v", formats)
+	}
+	if formats := ParseSegmentFormats(""); len(formats) != 0 {
+		t.Errorf("Expected no formats, got %v", formats)
+	}
+}
@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+var ErrSettings = errors.New("ErrSettings")
+
+//SettingsFile is the name of the file in the data dir the settings are persisted to.
+const SettingsFile = "settings.json"
+
+//Settings are the broadcaster and transcoder settings that can be changed while the node is running.
+type Settings struct {
+	//Broadcaster settings, used when creating transcode jobs
+	BroadcastPrice            uint64
+	BroadcastJobVideoProfiles []lpmscore.VideoProfile
+	BroadcastSegmentTransport SegmentTransport
+
+	//Transcoder settings
+	TranscoderFeeCut       uint8
+	TranscoderRewardCut    uint8
+	TranscoderSegmentPrice *big.Int
+	//Job policy - jobs are skipped if we are not accepting jobs or if the broadcaster deposit is below the minimum
+	TranscoderAcceptJobs            bool
+	TranscoderMinBroadcasterDeposit *big.Int
+	//Whether the reward manager should call reward every round
+	AutoReward bool
+}
+
+//DefaultSettings returns the settings used when nothing has been persisted yet.
+func DefaultSettings() Settings {
+	return Settings{
+		BroadcastPrice:                  1,
+		BroadcastJobVideoProfiles:       []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, lpmscore.P360p30fps16x9},
+		BroadcastSegmentTransport:       SegmentTransportP2P,
+		TranscoderFeeCut:                10,
+		TranscoderRewardCut:             10,
+		TranscoderSegmentPrice:          big.NewInt(150),
+		TranscoderAcceptJobs:            true,
+		TranscoderMinBroadcasterDeposit: big.NewInt(1),
+		AutoReward:                      true,
+	}
+}
+
+func (s Settings) copy() Settings {
+	c := s
+	c.BroadcastJobVideoProfiles = append([]lpmscore.VideoProfile{}, s.BroadcastJobVideoProfiles...)
+	if s.TranscoderSegmentPrice != nil {
+		c.TranscoderSegmentPrice = new(big.Int).Set(s.TranscoderSegmentPrice)
+	}
+	if s.TranscoderMinBroadcasterDeposit != nil {
+		c.TranscoderMinBroadcasterDeposit = new(big.Int).Set(s.TranscoderMinBroadcasterDeposit)
+	}
+	return c
+}
+
+func (s Settings) validate() error {
+	if len(s.BroadcastJobVideoProfiles) == 0 {
+		glog.Errorf("Need at least one broadcast job video profile")
+		return ErrSettings
+	}
+	for _, p := range s.BroadcastJobVideoProfiles {
+		if _, ok := ProfileLookup(p.Name); !ok {
+			glog.Errorf("Unknown video profile: %v", p.Name)
+			return ErrSettings
+		}
+	}
+	if !ValidSegmentTransport(s.BroadcastSegmentTransport) {
+		glog.Errorf("Unknown segment transport: %v", s.BroadcastSegmentTransport)
+		return ErrSettings
+	}
+	if s.TranscoderFeeCut > 100 || s.TranscoderRewardCut > 100 {
+		glog.Errorf("Fee cut and reward cut need to be between 0 and 100")
+		return ErrSettings
+	}
+	if s.TranscoderSegmentPrice == nil || s.TranscoderSegmentPrice.Sign() < 0 {
+		glog.Errorf("Invalid transcoder segment price: %v", s.TranscoderSegmentPrice)
+		return ErrSettings
+	}
+	if s.TranscoderMinBroadcasterDeposit == nil || s.TranscoderMinBroadcasterDeposit.Sign() < 0 {
+		glog.Errorf("Invalid min broadcaster deposit: %v", s.TranscoderMinBroadcasterDeposit)
+		return ErrSettings
+	}
+	return nil
+}
+
+//persistedSettings is the on-disk format.  Video profiles are stored by name.
+type persistedSettings struct {
+	BroadcastPrice                  uint64
+	BroadcastJobVideoProfiles       []string
+	BroadcastSegmentTransport       SegmentTransport `json:",omitempty"`
+	TranscoderFeeCut                uint8
+	TranscoderRewardCut             uint8
+	TranscoderSegmentPrice          *big.Int
+	TranscoderAcceptJobs            bool
+	TranscoderMinBroadcasterDeposit *big.Int
+	AutoReward                      bool
+}
+
+//SettingsStore holds the node settings.  It's safe for concurrent use - readers get a copy of the settings, and updates are applied
+//atomically, persisted, and sent to the subscribers.
+type SettingsStore struct {
+	lock     sync.RWMutex
+	settings Settings
+	path     string
+	subs     map[int]chan Settings
+	nextSub  int
+}
+
+//NewSettingsStore creates a settings store backed by the file at path.  If the file doesn't exist the default settings are used.  If
+//path is empty, the settings are not persisted.
+func NewSettingsStore(path string) (*SettingsStore, error) {
+	s := &SettingsStore{settings: DefaultSettings(), path: path, subs: make(map[int]chan Settings)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		glog.Errorf("Error reading settings from %v: %v", path, err)
+		return nil, err
+	}
+
+	var ps persistedSettings
+	if err := json.Unmarshal(data, &ps); err != nil {
+		glog.Errorf("Error parsing settings from %v: %v", path, err)
+		return nil, err
+	}
+	settings := Settings{
+		BroadcastPrice:                  ps.BroadcastPrice,
+		BroadcastJobVideoProfiles:       make([]lpmscore.VideoProfile, 0, len(ps.BroadcastJobVideoProfiles)),
+		BroadcastSegmentTransport:       ps.BroadcastSegmentTransport,
+		TranscoderFeeCut:                ps.TranscoderFeeCut,
+		TranscoderRewardCut:             ps.TranscoderRewardCut,
+		TranscoderSegmentPrice:          ps.TranscoderSegmentPrice,
+		TranscoderAcceptJobs:            ps.TranscoderAcceptJobs,
+		TranscoderMinBroadcasterDeposit: ps.TranscoderMinBroadcasterDeposit,
+		AutoReward:                      ps.AutoReward,
+	}
+	//Settings from before the segment transports
+	if settings.BroadcastSegmentTransport == "" {
+		settings.BroadcastSegmentTransport = SegmentTransportP2P
+	}
+	for _, name := range ps.BroadcastJobVideoProfiles {
+		p, _ := ProfileLookup(name)
+		settings.BroadcastJobVideoProfiles = append(settings.BroadcastJobVideoProfiles, p)
+	}
+	if err := settings.validate(); err != nil {
+		glog.Errorf("Invalid settings in %v", path)
+		return nil, err
+	}
+	s.settings = settings
+	return s, nil
+}
+
+//Get returns a copy of the current settings.
+func (s *SettingsStore) Get() Settings {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.settings.copy()
+}
+
+//Update applies update to a copy of the current settings.  If update returns an error or the new settings are invalid, nothing changes.
+//Otherwise the new settings are persisted and sent to the subscribers.
+func (s *SettingsStore) Update(update func(*Settings) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	settings := s.settings.copy()
+	if err := update(&settings); err != nil {
+		return err
+	}
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	if err := s.persist(settings); err != nil {
+		return err
+	}
+	s.settings = settings
+
+	for _, c := range s.subs {
+		//Drop the stale value if the subscriber hasn't picked it up yet, so it always gets the latest settings
+		select {
+		case <-c:
+		default:
+		}
+		c <- settings.copy()
+	}
+	return nil
+}
+
+//Subscribe returns a channel that receives the new settings after every update, and a function to cancel the subscription.
+func (s *SettingsStore) Subscribe() (<-chan Settings, func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := s.nextSub
+	s.nextSub++
+	c := make(chan Settings, 1)
+	s.subs[id] = c
+	return c, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+func (s *SettingsStore) persist(settings Settings) error {
+	if s.path == "" {
+		return nil
+	}
+
+	ps := persistedSettings{
+		BroadcastPrice:                  settings.BroadcastPrice,
+		BroadcastJobVideoProfiles:       make([]string, 0, len(settings.BroadcastJobVideoProfiles)),
+		BroadcastSegmentTransport:       settings.BroadcastSegmentTransport,
+		TranscoderFeeCut:                settings.TranscoderFeeCut,
+		TranscoderRewardCut:             settings.TranscoderRewardCut,
+		TranscoderSegmentPrice:          settings.TranscoderSegmentPrice,
+		TranscoderAcceptJobs:            settings.TranscoderAcceptJobs,
+		TranscoderMinBroadcasterDeposit: settings.TranscoderMinBroadcasterDeposit,
+		AutoReward:                      settings.AutoReward,
+	}
+	for _, p := range settings.BroadcastJobVideoProfiles {
+		ps.BroadcastJobVideoProfiles = append(ps.BroadcastJobVideoProfiles, p.Name)
+	}
+	data, err := json.Marshal(ps)
+	if err != nil {
+		glog.Errorf("Error encoding settings: %v", err)
+		return err
+	}
+
+	//Write to a temp file first so a crash doesn't leave a half-written settings file
+	tmp := s.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		glog.Errorf("Error creating settings dir: %v", err)
+		return err
+	}
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		glog.Errorf("Error writing settings: %v", err)
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
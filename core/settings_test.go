@@ -0,0 +1,118 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+func TestSettingsUpdate(t *testing.T) {
+	s, err := NewSettingsStore("")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	//Changing the copy shouldn't change the store
+	settings := s.Get()
+	settings.TranscoderSegmentPrice.SetInt64(1)
+	settings.BroadcastJobVideoProfiles[0] = lpmscore.P720p30fps16x9
+	if s.Get().TranscoderSegmentPrice.Int64() != 150 || s.Get().BroadcastJobVideoProfiles[0] != lpmscore.P240p30fps16x9 {
+		t.Errorf("Settings changed without Update: %v", s.Get())
+	}
+
+	//Invalid settings should be rejected
+	if err := s.Update(func(settings *Settings) error {
+		settings.BroadcastPrice = 5
+		settings.BroadcastJobVideoProfiles = []lpmscore.VideoProfile{}
+		return nil
+	}); err != ErrSettings {
+		t.Errorf("Expecting ErrSettings, got %v", err)
+	}
+	if s.Get().BroadcastPrice != 1 {
+		t.Errorf("Invalid update should not be applied")
+	}
+	if err := s.Update(func(settings *Settings) error {
+		settings.BroadcastSegmentTransport = "carrier pigeon"
+		return nil
+	}); err != ErrSettings {
+		t.Errorf("Expecting ErrSettings for an unknown segment transport, got %v", err)
+	}
+
+	c, unsubscribe := s.Subscribe()
+	if err := s.Update(func(settings *Settings) error {
+		settings.BroadcastPrice = 5
+		return nil
+	}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	select {
+	case settings := <-c:
+		if settings.BroadcastPrice != 5 {
+			t.Errorf("Expecting 5, got %v", settings.BroadcastPrice)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Subscriber didn't get the update")
+	}
+
+	//Subscribers only get the latest settings, and updates don't block on slow subscribers
+	for i := uint64(6); i < 10; i++ {
+		s.Update(func(settings *Settings) error {
+			settings.BroadcastPrice = i
+			return nil
+		})
+	}
+	if settings := <-c; settings.BroadcastPrice != 9 {
+		t.Errorf("Expecting 9, got %v", settings.BroadcastPrice)
+	}
+
+	unsubscribe()
+	s.Update(func(settings *Settings) error {
+		settings.BroadcastPrice = 10
+		return nil
+	})
+	select {
+	case <-c:
+		t.Errorf("Should not get updates after unsubscribing")
+	default:
+	}
+}
+
+func TestSettingsPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "settings")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, SettingsFile)
+
+	s, err := NewSettingsStore(path)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := s.Update(func(settings *Settings) error {
+		settings.BroadcastJobVideoProfiles = []lpmscore.VideoProfile{lpmscore.P144p30fps16x9}
+		settings.TranscoderMinBroadcasterDeposit = big.NewInt(1000)
+		settings.AutoReward = false
+		settings.BroadcastSegmentTransport = SegmentTransportHTTP
+		return nil
+	}); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+
+	s, err = NewSettingsStore(path)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	settings := s.Get()
+	if len(settings.BroadcastJobVideoProfiles) != 1 || settings.BroadcastJobVideoProfiles[0] != lpmscore.P144p30fps16x9 {
+		t.Errorf("Unexpected profiles: %v", settings.BroadcastJobVideoProfiles)
+	}
+	if settings.TranscoderMinBroadcasterDeposit.Int64() != 1000 || settings.AutoReward || settings.BroadcastSegmentTransport != SegmentTransportHTTP {
+		t.Errorf("Unexpected settings: %v", settings)
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ericxtang/m3u8"
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+//PassthroughProfile remuxes the source without re-encoding it.  It has no resolution or bitrate of its own, so its variant params in the
+//master playlist are the ones of the source.
+var PassthroughProfile = lpmscore.VideoProfile{Name: "Passthrough"}
+
+func IsPassthroughProfile(name string) bool {
+	return name == PassthroughProfile.Name
+}
+
+//SourceProber finds out what a source stream really is from one of its segments, so the master playlist doesn't have to rely on a profile
+//guessed from the resolution.
+type SourceProber interface {
+	ProbeSource(seg *stream.HLSSegment) (*SourceInfo, error)
+}
+
+//SourceInfo is what the prober found out about a source stream.
+type SourceInfo struct {
+	Bandwidth  uint32
+	Resolution string
+	Framerate  float64
+	//Codecs is the RFC 6381 codec string, empty if one of the codecs isn't known
+	Codecs string
+}
+
+//VariantParams returns the params of the source in the master playlist.  The m3u8 writer has no FRAME-RATE attribute, so the framerate
+//isn't in the playlist.
+func (i *SourceInfo) VariantParams() m3u8.VariantParams {
+	return m3u8.VariantParams{Bandwidth: i.Bandwidth, Resolution: i.Resolution, Codecs: i.Codecs}
+}
+
+func (i *SourceInfo) String() string {
+	return fmt.Sprintf("%v %.3gfps %vbps %q", i.Resolution, i.Framerate, i.Bandwidth, i.Codecs)
+}
+
+//ProbeSource probes a segment of the source stream with ffprobe.  The bandwidth is the bitrate of the segment.
+func (c *FFProbeChecker) ProbeSource(seg *stream.HLSSegment) (*SourceInfo, error) {
+	probe, err := ffprobe(c.FFProbePath, c.WorkDir, seg.Data)
+	if err != nil {
+		return nil, err
+	}
+	return sourceInfo(probe, len(seg.Data), seg.Duration)
+}
+
+func sourceInfo(p *probeResult, size int, duration float64) (*SourceInfo, error) {
+	video := findStream(p, "video")
+	if video == -1 {
+		return nil, fmt.Errorf("no video stream")
+	}
+	info := &SourceInfo{}
+	v := p.Streams[video]
+	info.Resolution = fmt.Sprintf("%vx%v", v.Width, v.Height)
+	info.Framerate, _ = parseFrameRate(v.RFrameRate)
+
+	if br, err := strconv.ParseUint(p.Format.BitRate, 10, 32); err == nil && br > 0 {
+		info.Bandwidth = uint32(br)
+	} else if duration > 0 {
+		info.Bandwidth = uint32(float64(size*8) / duration)
+	}
+
+	codecs := videoCodecs(v.CodecName, v.Profile, v.Level)
+	if codecs != "" {
+		if audio := findStream(p, "audio"); audio != -1 {
+			a := p.Streams[audio]
+			if ac := audioCodecsString(a.CodecName, a.Profile); ac != "" {
+				codecs = codecs + "," + ac
+			} else {
+				codecs = ""
+			}
+		}
+	}
+	info.Codecs = codecs
+	return info, nil
+}
+
+//avcProfiles maps the ffprobe H.264 profiles to profile_idc and the constraint flags
+var avcProfiles = map[string][2]byte{
+	"Baseline":             {0x42, 0x00},
+	"Constrained Baseline": {0x42, 0xe0},
+	"Main":                 {0x4d, 0x40},
+	"High":                 {0x64, 0x00},
+}
+
+func videoCodecs(codec, profile string, level int) string {
+	p, ok := avcProfiles[profile]
+	if codec != "h264" || !ok || level <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("avc1.%02x%02x%02x", p[0], p[1], level)
+}
+
+func audioCodecsString(codec, profile string) string {
+	switch {
+	case codec == "aac" && profile == "LC":
+		return "mp4a.40.2"
+	case codec == "aac" && profile == "HE-AAC":
+		return "mp4a.40.5"
+	case codec == "aac" && profile == "HE-AACv2":
+		return "mp4a.40.29"
+	case codec == "mp3":
+		return "mp4a.40.34"
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSourceInfo(t *testing.T) {
+	var p probeResult
+	if err := json.Unmarshal([]byte(`{"streams":[
+		{"codec_type":"video","codec_name":"h264","profile":"High","level":31,"width":1280,"height":720,"r_frame_rate":"30000/1001"},
+		{"codec_type":"audio","codec_name":"aac","profile":"LC","channels":2,"sample_rate":"44100"}],
+		"format":{"format_name":"mpegts","duration":"4.000000","bit_rate":"3100000"}}`), &p); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	info, err := sourceInfo(&p, 1000, 4)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if info.Bandwidth != 3100000 || info.Resolution != "1280x720" || info.Codecs != "avc1.64001f,mp4a.40.2" || info.Framerate < 29.9 || info.Framerate > 30 {
+		t.Errorf("Unexpected source info %v", info)
+	}
+
+	//No bitrate from ffprobe, unknown audio codec
+	p.Format.BitRate = "N/A"
+	p.Streams[1].CodecName = "opus"
+	if info, err = sourceInfo(&p, 1000, 4); err != nil || info.Bandwidth != 2000 || info.Codecs != "" {
+		t.Errorf("Expecting bandwidth from the segment size and no codecs, got %v %v", info, err)
+	}
+
+	p.Streams = p.Streams[1:]
+	if _, err := sourceInfo(&p, 1000, 4); err == nil {
+		t.Errorf("Expecting error without video stream")
+	}
+}
+
+func TestVideoCodecs(t *testing.T) {
+	if c := videoCodecs("h264", "Constrained Baseline", 30); c != "avc1.42e01e" {
+		t.Errorf("Unexpected codecs %v", c)
+	}
+	if c := videoCodecs("h264", "Main", 40); c != "avc1.4d4028" {
+		t.Errorf("Unexpected codecs %v", c)
+	}
+	if c := videoCodecs("hevc", "Main", 93); c != "" {
+		t.Errorf("Expecting no codecs for hevc, got %v", c)
+	}
+}
@@ -33,4 +33,9 @@ func TestStreamID(t *testing.T) {
 	if bytes.Compare(vid, id.GetVideoID()) != 0 {
 		t.Errorf("Expecting: %v, got %v", vid, id.GetVideoID())
 	}
+
+	mid, _ := MakeManifestID(nid, vid)
+	if id.ManifestID() != mid {
+		t.Errorf("Expecting: %v, got %v", mid, id.ManifestID())
+	}
 }
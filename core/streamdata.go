@@ -56,6 +56,11 @@ func (id *StreamID) GetRendition() string {
 	return string((*id)[NodeIDLength+2*HashLength:])
 }
 
+//ManifestID returns the manifest the stream is in.
+func (id *StreamID) ManifestID() ManifestID {
+	return ManifestID((*id)[:NodeIDLength+2*HashLength])
+}
+
 func (id *StreamID) IsValid() bool {
 	return len(*id) > (NodeIDLength + 2*HashLength)
 }
@@ -98,9 +103,14 @@ func (id ManifestID) String() string {
 type SignedSegment struct {
 	Seg stream.HLSSegment
 	Sig []byte
+	//StrmID, Timestamp (when the segment was signed) and Codecs are metadata.  Nodes that only know the gob format drop them.
+	StrmID    string
+	Timestamp time.Time
+	Codecs    string
 }
 
-//Convenience function to convert between SignedSegments and byte slices to put on the wire.
+//Convenience function to convert between SignedSegments and byte slices to put on the wire.  It uses the gob format, which every
+//node understands, see EncodeSignedSegment for the other formats.
 func SignedSegmentToBytes(ss SignedSegment) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -112,8 +122,11 @@ func SignedSegmentToBytes(ss SignedSegment) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-//Convenience function to convert between SignedSegments and byte slices to put on the wire.
+//Convenience function to convert between SignedSegments and byte slices to put on the wire.  It decodes every format in SegmentFormats.
 func BytesToSignedSegment(data []byte) (SignedSegment, error) {
+	if bytes.HasPrefix(data, segmentHeader) {
+		return decodeVersionedSegment(data)
+	}
 	dec := gob.NewDecoder(bytes.NewReader(data))
 	var ss SignedSegment
 	err := dec.Decode(&ss)
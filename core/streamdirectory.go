@@ -0,0 +1,200 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+var ErrStreamRecord = errors.New("ErrStreamRecord")
+
+//StreamAnnounceInterval is how often broadcasters announce their live streams.
+var StreamAnnounceInterval = time.Minute
+
+//StreamRecordTTL is how long a stream is listed without a newer announcement.  Streams of broadcasters that go offline drop out.
+var StreamRecordTTL = 3 * StreamAnnounceInterval
+
+//StreamRecord is what a broadcaster announces about a live stream, so viewers can find it without knowing its manifest ID.
+type StreamRecord struct {
+	ManifestID string
+	NodeID     string
+	EthAddress ethcommon.Address
+	Title      string   `json:",omitempty"`
+	Tags       []string `json:",omitempty"`
+	//Renditions are the names of the profiles the stream is transcoded into
+	Renditions []string
+	StartTime  time.Time
+	//Ended is set in the last announcement of a stream, so nodes drop it before it expires
+	Ended     bool `json:",omitempty"`
+	Timestamp time.Time
+}
+
+//HasTag tells if the stream has tag, ignoring case.
+func (r *StreamRecord) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+//Matches tells if the title or one of the tags of the stream has q in it, ignoring case.
+func (r *StreamRecord) Matches(q string) bool {
+	q = strings.ToLower(q)
+	if strings.Contains(strings.ToLower(r.Title), q) {
+		return true
+	}
+	for _, t := range r.Tags {
+		if strings.Contains(strings.ToLower(t), q) {
+			return true
+		}
+	}
+	return false
+}
+
+//EncodeStreamRecord signs the record with sign, which signs a hash with the key of the record's EthAddress.
+func EncodeStreamRecord(rec *StreamRecord, sign func(hash []byte) ([]byte, error)) ([]byte, error) {
+	return encodeSigned(rec, sign)
+}
+
+//DecodeStreamRecord decodes an announced record, and checks it was signed by its EthAddress.
+func DecodeStreamRecord(data []byte) (*StreamRecord, error) {
+	var rec StreamRecord
+	addr, err := decodeSigned(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+	if addr != rec.EthAddress {
+		glog.Errorf("Stream record of %v isn't signed by %v", rec.ManifestID, rec.EthAddress.Hex())
+		return nil, ErrStreamRecord
+	}
+	return &rec, nil
+}
+
+//StreamDirectory keeps the latest record of each live stream, until the stream ends or the record expires.
+type StreamDirectory struct {
+	lock    sync.Mutex
+	records map[streamKey]*StreamRecord
+	now     func() time.Time
+}
+
+//streamKey is what the directory keeps records by: the node that signed the announcement, and the stream.
+type streamKey struct {
+	nodeID     string
+	manifestID string
+}
+
+func NewStreamDirectory() *StreamDirectory {
+	return &StreamDirectory{records: make(map[streamKey]*StreamRecord), now: time.Now}
+}
+
+//Add keeps rec if it's newer than the record the directory has of the stream from nodeID, the node that signed the announcement.  Records
+//of other nodes, that expired already, or that are too far in the future, are dropped.  Records of ended streams are kept until they expire,
+//so older announcements don't list the stream again.
+func (d *StreamDirectory) Add(nodeID string, rec *StreamRecord) bool {
+	if rec.NodeID != nodeID {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	if now.Sub(rec.Timestamp) > StreamRecordTTL || rec.Timestamp.Sub(now) > MaxCapabilityClockSkew {
+		return false
+	}
+	key := streamKey{nodeID: nodeID, manifestID: rec.ManifestID}
+	if old, ok := d.records[key]; ok && !rec.Timestamp.After(old.Timestamp) {
+		return false
+	}
+	d.records[key] = rec
+	return true
+}
+
+//Records returns the live streams, by manifest ID.
+func (d *StreamDirectory) Records() []*StreamRecord {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := d.now()
+	recs := make([]*StreamRecord, 0, len(d.records))
+	for key, rec := range d.records {
+		if now.Sub(rec.Timestamp) > StreamRecordTTL {
+			delete(d.records, key)
+			continue
+		}
+		if !rec.Ended {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].ManifestID != recs[j].ManifestID {
+			return recs[i].ManifestID < recs[j].ManifestID
+		}
+		return recs[i].NodeID < recs[j].NodeID
+	})
+	return recs
+}
+
+//AnnounceStream announces the live stream mid every StreamAnnounceInterval until ctx is done, and then announces that it ended.
+//renditions is called for each announcement, since the renditions change once the ladder of the stream is built.  The records are signed
+//with the Eth account of the node, so it needs one.
+func (n *LivepeerNode) AnnounceStream(ctx context.Context, mid ManifestID, title string, tags []string, renditions func() []string) error {
+	a, ok := n.VideoNetwork.(net.StreamAnnouncer)
+	if !ok || n.Eth == nil {
+		glog.Errorf("Cannot announce %v without a network that spreads announcements and an Eth account", mid)
+		return ErrStreamRecord
+	}
+	ticker := time.NewTicker(StreamAnnounceInterval)
+	defer ticker.Stop()
+	start := time.Now()
+	for {
+		rec := &StreamRecord{
+			ManifestID: string(mid),
+			NodeID:     string(n.Identity),
+			EthAddress: n.Eth.Account().Address,
+			Title:      title,
+			Tags:       tags,
+			Renditions: renditions(),
+			StartTime:  start,
+			Ended:      ctx.Err() != nil,
+			Timestamp:  time.Now(),
+		}
+		//The node lists its own streams too, since announcements don't come back to it
+		n.Streams.Add(string(n.Identity), rec)
+		data, err := EncodeStreamRecord(rec, n.Eth.SignSegmentHash)
+		if err != nil {
+			glog.Errorf("Error signing stream record of %v: %v", mid, err)
+		} else if err := a.AnnounceStream(data); err != nil {
+			glog.Errorf("Error announcing %v: %v", mid, err)
+		}
+		if rec.Ended {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+//gotStreamAnnouncement adds the streams that other nodes announce to the directory, and returns false for the ones that aren't passed on.
+//nodeID signed the announcement, and nodes can only announce their own streams.
+func (n *LivepeerNode) gotStreamAnnouncement(nodeID string, data []byte) bool {
+	rec, err := DecodeStreamRecord(data)
+	if err != nil {
+		glog.Errorf("Dropping stream record announced by %v: %v", nodeID, err)
+		return false
+	}
+	mid := ManifestID(rec.ManifestID)
+	if rec.NodeID != nodeID || !mid.IsValid() || mid.GetNodeID() != NodeID(nodeID) {
+		glog.Errorf("Dropping stream record of %v announced by %v", rec.ManifestID, nodeID)
+		return false
+	}
+	return n.Streams.Add(nodeID, rec)
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/eth/signer"
+)
+
+func TestStreamRecordSignature(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	sign := func(hash []byte) ([]byte, error) { return crypto.Sign(signer.SegmentSignHash(hash), key) }
+	nodeID := NodeID("12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2")
+	mid, err := MakeManifestID(nodeID, RandomVideoID())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	rec := &StreamRecord{
+		ManifestID: string(mid),
+		NodeID:     string(nodeID),
+		EthAddress: crypto.PubkeyToAddress(key.PublicKey),
+		Title:      "Chess Finals",
+		Tags:       []string{"chess", "sports"},
+		Renditions: []string{"P240p30fps16x9"},
+		StartTime:  time.Now(),
+		Timestamp:  time.Now(),
+	}
+	data, err := EncodeStreamRecord(rec, sign)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	got, err := DecodeStreamRecord(data)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got.ManifestID != rec.ManifestID || got.EthAddress != rec.EthAddress || got.Title != "Chess Finals" || !got.HasTag("Sports") {
+		t.Errorf("Expecting %+v, got %+v", rec, got)
+	}
+	if !got.Matches("finals") || !got.Matches("CHESS") || got.Matches("music") {
+		t.Errorf("Expecting the record to match its title and tags only")
+	}
+
+	//The node that announces a stream gets it listed
+	n := &LivepeerNode{Streams: NewStreamDirectory()}
+	if !n.gotStreamAnnouncement(string(nodeID), data) {
+		t.Errorf("Expecting the record to be taken")
+	}
+	if recs := n.Streams.Records(); len(recs) != 1 || recs[0].ManifestID != rec.ManifestID {
+		t.Errorf("Expecting %v to be listed, got %+v", mid, recs)
+	}
+	//Nodes can't announce the streams of others
+	n = &LivepeerNode{Streams: NewStreamDirectory()}
+	if n.gotStreamAnnouncement("12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d", data) {
+		t.Errorf("Expecting the record of another node to be dropped")
+	}
+	if recs := n.Streams.Records(); len(recs) != 0 {
+		t.Errorf("Expecting the record to be dropped, got %+v", recs)
+	}
+
+	//A record for someone else's address doesn't decode
+	other, _ := crypto.GenerateKey()
+	rec.EthAddress = crypto.PubkeyToAddress(other.PublicKey)
+	data, err = EncodeStreamRecord(rec, sign)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if _, err := DecodeStreamRecord(data); err != ErrStreamRecord {
+		t.Errorf("Expecting ErrStreamRecord, got %v", err)
+	}
+}
+
+func TestStreamDirectory(t *testing.T) {
+	now := time.Now()
+	d := NewStreamDirectory()
+	d.now = func() time.Time { return now }
+
+	if !d.Add("n", &StreamRecord{ManifestID: "b", NodeID: "n", Timestamp: now}) || !d.Add("n", &StreamRecord{ManifestID: "a", NodeID: "n", Timestamp: now}) {
+		t.Errorf("Expecting the records to be added")
+	}
+	//Older records are dropped, newer ones replace
+	if d.Add("n", &StreamRecord{ManifestID: "a", NodeID: "n", Timestamp: now.Add(-time.Second), Title: "old"}) {
+		t.Errorf("Expecting the older record to be dropped")
+	}
+	if !d.Add("n", &StreamRecord{ManifestID: "a", NodeID: "n", Timestamp: now.Add(time.Second), Title: "new"}) {
+		t.Errorf("Expecting the newer record to be added")
+	}
+	//Nodes can't add records in the name of others, nor replace them
+	if d.Add("m", &StreamRecord{ManifestID: "a", NodeID: "n", Timestamp: now.Add(2 * time.Second), Title: "forged"}) {
+		t.Errorf("Expecting the record of another node to be dropped")
+	}
+	d.Add("m", &StreamRecord{ManifestID: "a", NodeID: "m", Timestamp: now.Add(2 * time.Second), Title: "other"})
+	//Expired records and records from too far in the future are dropped
+	if d.Add("n", &StreamRecord{ManifestID: "c", NodeID: "n", Timestamp: now.Add(-StreamRecordTTL - time.Second)}) {
+		t.Errorf("Expecting the expired record to be dropped")
+	}
+	if d.Add("n", &StreamRecord{ManifestID: "c", NodeID: "n", Timestamp: now.Add(MaxCapabilityClockSkew + time.Second)}) {
+		t.Errorf("Expecting the future record to be dropped")
+	}
+	recs := d.Records()
+	if len(recs) != 3 || recs[0].ManifestID != "a" || recs[0].NodeID != "m" || recs[1].Title != "new" || recs[2].ManifestID != "b" {
+		t.Errorf("Expecting a of m and n, and b, got %+v", recs)
+	}
+
+	//Ended streams aren't listed, and older announcements don't list them again
+	if !d.Add("n", &StreamRecord{ManifestID: "b", NodeID: "n", Timestamp: now.Add(time.Second), Ended: true}) {
+		t.Errorf("Expecting the ended record to be added")
+	}
+	d.Add("n", &StreamRecord{ManifestID: "b", NodeID: "n", Timestamp: now})
+	if recs := d.Records(); len(recs) != 2 || recs[0].ManifestID != "a" || recs[1].ManifestID != "a" {
+		t.Errorf("Expecting b to end, got %+v", recs)
+	}
+
+	now = now.Add(StreamRecordTTL + 3*time.Second)
+	if recs := d.Records(); len(recs) != 0 {
+		t.Errorf("Expecting a to expire, got %+v", recs)
+	}
+}
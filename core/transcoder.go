@@ -0,0 +1,97 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+//FFMpegTranscoder transcodes segments into video and audio-only renditions with one ffmpeg run.  It works like the lpms segment
+//transcoder, plus audio profiles and the passthrough profile, which is remuxed without re-encoding.  If the job has an audio rendition,
+//the video renditions are encoded without audio, since players get the audio from the shared audio rendition (see SharedAudioRendition).
+type FFMpegTranscoder struct {
+	profiles   []lpmscore.VideoProfile
+	ffmpegPath string
+	workDir    string
+}
+
+func NewFFMpegTranscoder(ps []lpmscore.VideoProfile, ffmpegPath, workDir string) *FFMpegTranscoder {
+	return &FFMpegTranscoder{profiles: ps, ffmpegPath: ffmpegPath, workDir: workDir}
+}
+
+func (t *FFMpegTranscoder) Transcode(d []byte) ([][]byte, error) {
+	if err := os.MkdirAll(t.workDir, 0700); err != nil {
+		glog.Errorf("Transcoder cannot create workdir: %v", err)
+		return nil, err
+	}
+	in, err := ioutil.TempFile(t.workDir, "seg")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(in.Name())
+	if _, err := in.Write(d); err != nil {
+		in.Close()
+		return nil, err
+	}
+	if err := in.Close(); err != nil {
+		return nil, err
+	}
+
+	outs := make([]string, len(t.profiles))
+	for i := range t.profiles {
+		outs[i] = fmt.Sprintf("%v_out%v.ts", in.Name(), i)
+		defer os.Remove(outs[i])
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path.Join(t.ffmpegPath, "ffmpeg"), ffmpegArgs(in.Name(), outs, t.profiles)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		glog.Errorf("Error running ffmpeg: %v %v", err, strings.TrimSpace(stderr.String()))
+		return nil, err
+	}
+
+	dout := make([][]byte, len(t.profiles))
+	for i := range t.profiles {
+		if dout[i], err = ioutil.ReadFile(outs[i]); err != nil {
+			glog.Errorf("Cannot read transcode output: %v", err)
+		}
+	}
+	return dout, nil
+}
+
+//ffmpegArgs makes the ffmpeg arguments to transcode in into outs, one output per profile.  The video arguments are the ones the lpms
+//segment transcoder uses, so video renditions come out the same.
+func ffmpegArgs(in string, outs []string, profiles []lpmscore.VideoProfile) []string {
+	sharedAudio := false
+	for _, p := range profiles {
+		sharedAudio = sharedAudio || IsAudioProfile(p.Name)
+	}
+
+	args := []string{"-i", in}
+	for i, p := range profiles {
+		if a, ok := AudioProfileLookup[p.Name]; ok {
+			args = append(args, "-vn", "-c:a", audioCodecs[a.Codec].encoder, "-b:a", a.Bitrate, "-ac", fmt.Sprintf("%d", a.Channels), "-ar", fmt.Sprintf("%d", a.SampleRate), "-copyts", outs[i])
+			continue
+		}
+		if IsPassthroughProfile(p.Name) {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args, "-c:v", "libx264", "-s", p.Resolution, "-minrate", p.Bitrate, "-maxrate", p.Bitrate, "-bufsize", p.Bitrate, "-r", fmt.Sprintf("%d", p.Framerate), "-threads", "1")
+		}
+		if sharedAudio {
+			args = append(args, "-an")
+		} else if IsPassthroughProfile(p.Name) {
+			args = append(args, "-c:a", "copy")
+		}
+		args = append(args, "-copyts", outs[i])
+	}
+	return args
+}
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	lpmscore "github.com/livepeer/lpms/core"
+)
+
+func TestFFMpegArgs(t *testing.T) {
+	//Video only, same as the lpms transcoder
+	args := strings.Join(ffmpegArgs("in.ts", []string{"out0.ts"}, []lpmscore.VideoProfile{lpmscore.P240p30fps16x9}), " ")
+	if args != "-i in.ts -c:v libx264 -s 426x240 -minrate 600k -maxrate 600k -bufsize 600k -r 30 -threads 1 -copyts out0.ts" {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	//Video renditions drop their audio when there is an audio rendition
+	args = strings.Join(ffmpegArgs("in.ts", []string{"out0.ts", "out1.ts"}, []lpmscore.VideoProfile{lpmscore.P240p30fps16x9, A64kStereo.VideoProfile()}), " ")
+	if args != "-i in.ts -c:v libx264 -s 426x240 -minrate 600k -maxrate 600k -bufsize 600k -r 30 -threads 1 -an -copyts out0.ts "+
+		"-vn -c:a aac -b:a 64k -ac 2 -ar 44100 -copyts out1.ts" {
+		t.Errorf("Unexpected args %v", args)
+	}
+
+	//Passthrough remuxes
+	args = strings.Join(ffmpegArgs("in.ts", []string{"out0.ts"}, []lpmscore.VideoProfile{PassthroughProfile}), " ")
+	if args != "-i in.ts -c:v copy -c:a copy -copyts out0.ts" {
+		t.Errorf("Unexpected args %v", args)
+	}
+}
@@ -22,25 +22,47 @@ type VideoCache interface {
 	GetHLSSegment(streamID StreamID, segName string) *stream.HLSSegment
 	GetHLSSubscriber(streamID StreamID) (stream.Subscriber, error)
 	EvictHLSSubscriber(streamID StreamID)
+	LatestSegmentSeqNo(streamID StreamID) (uint64, bool)
 }
 
 type segCache struct {
 	cacheLen int
 	cache    []*stream.HLSSegment
+	//discontinuity are the cached segments that come after lost segments
+	discontinuity map[uint64]bool
+	//lock guards the cache, the subscriber inserts segments while HTTP requests read the playlist
+	lock sync.Mutex
 }
 
 func newSegCache(len int) *segCache {
-	return &segCache{cacheLen: len, cache: make([]*stream.HLSSegment, 0)}
+	return &segCache{cacheLen: len, cache: make([]*stream.HLSSegment, 0), discontinuity: make(map[uint64]bool)}
 }
 
+//Insert adds a segment to the end of the cache.  The network passes segments on in order, and skips the ones that are lost for good - so
+//segments that aren't newer than the last one are dropped, and a jump in seqNo is a discontinuity.
 func (sc *segCache) Insert(seg *stream.HLSSegment) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if n := len(sc.cache); n > 0 {
+		last := sc.cache[n-1].SeqNo
+		if seg.SeqNo <= last {
+			return
+		}
+		if seg.SeqNo != last+1 {
+			glog.Errorf("Segments %v-%v before %v are lost, marking a discontinuity", last+1, seg.SeqNo-1, seg.Name)
+			sc.discontinuity[seg.SeqNo] = true
+		}
+	}
 	if len(sc.cache) >= sc.cacheLen {
+		delete(sc.discontinuity, sc.cache[0].SeqNo)
 		sc.cache = sc.cache[1:]
 	}
 	sc.cache = append(sc.cache, seg)
 }
 
 func (sc *segCache) GetSeg(segName string) *stream.HLSSegment {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
 	for _, s := range sc.cache {
 		if s.Name == segName {
 			return s
@@ -49,11 +71,26 @@ func (sc *segCache) GetSeg(segName string) *stream.HLSSegment {
 	return nil
 }
 
+//LatestSeqNo returns the sequence number of the newest segment.  Returns false if the cache is empty.
+func (sc *segCache) LatestSeqNo() (uint64, bool) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+	if len(sc.cache) == 0 {
+		return 0, false
+	}
+	return sc.cache[len(sc.cache)-1].SeqNo, true
+}
+
 func (sc *segCache) GetMediaPlaylist() *m3u8.MediaPlaylist {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
 	//Make a media playlist
 	pl, _ := m3u8.NewMediaPlaylist(uint(sc.cacheLen), uint(sc.cacheLen))
 	for _, seg := range sc.cache {
 		pl.Append(seg.Name, seg.Duration, "")
+		if sc.discontinuity[seg.SeqNo] {
+			pl.SetDiscontinuity()
+		}
 	}
 	pl.SeqNo = sc.cache[0].SeqNo
 	return pl
@@ -62,11 +99,13 @@ func (sc *segCache) GetMediaPlaylist() *m3u8.MediaPlaylist {
 type BasicVideoCache struct {
 	network  net.VideoNetwork
 	segCache map[StreamID]*segCache
-	segLock  sync.Mutex
+	//subs are the subscriptions that fill segCache, so they can be ended
+	subs    map[StreamID]stream.Subscriber
+	segLock sync.Mutex
 }
 
 func NewBasicVideoCache(nw net.VideoNetwork) *BasicVideoCache {
-	return &BasicVideoCache{network: nw, segCache: make(map[StreamID]*segCache), segLock: sync.Mutex{}}
+	return &BasicVideoCache{network: nw, segCache: make(map[StreamID]*segCache), subs: make(map[StreamID]stream.Subscriber), segLock: sync.Mutex{}}
 }
 
 func (c *BasicVideoCache) GetCache(strmID StreamID) (*segCache, bool) {
@@ -77,6 +116,19 @@ func (c *BasicVideoCache) GetCache(strmID StreamID) (*segCache, bool) {
 	return sc, ok
 }
 
+//getOrCreateCache returns the cache of strmID, and creates it if there is none.  Returns true if it was created.
+func (c *BasicVideoCache) getOrCreateCache(strmID StreamID) (*segCache, bool) {
+	c.segLock.Lock()
+	defer c.segLock.Unlock()
+
+	if sc, ok := c.segCache[strmID]; ok {
+		return sc, false
+	}
+	sc := newSegCache(SegCacheLen)
+	c.segCache[strmID] = sc
+	return sc, true
+}
+
 func (c *BasicVideoCache) DeleteCache(strmID StreamID) {
 	c.segLock.Lock()
 	defer c.segLock.Unlock()
@@ -107,8 +159,18 @@ func (c *BasicVideoCache) GetHLSSubscriber(streamID StreamID) (stream.Subscriber
 	return c.network.GetSubscriber(string(streamID))
 }
 
+//EvictHLSSubscriber ends the subscription to streamID, and drops its cached segments.
 func (c *BasicVideoCache) EvictHLSSubscriber(streamID StreamID) {
-	return
+	c.segLock.Lock()
+	sub := c.subs[streamID]
+	delete(c.subs, streamID)
+	delete(c.segCache, streamID)
+	c.segLock.Unlock()
+	if sub != nil {
+		if err := sub.Unsubscribe(); err != nil {
+			glog.Errorf("Error unsubscribing from %v: %v", streamID, err)
+		}
+	}
 }
 
 func (c *BasicVideoCache) GetHLSMediaPlaylist(streamID StreamID) *m3u8.MediaPlaylist {
@@ -117,8 +179,9 @@ func (c *BasicVideoCache) GetHLSMediaPlaylist(streamID StreamID) *m3u8.MediaPlay
 		return cache.GetMediaPlaylist()
 	}
 
-	//If we don't already have the stream, subscribe and return the playlist
-	plChan := make(chan *m3u8.MediaPlaylist)
+	//If we don't already have the stream, subscribe and return the playlist.  plChan has room for the playlist, so the subscriber doesn't
+	//block on it when the first segment comes after we stopped waiting.
+	plChan := make(chan *m3u8.MediaPlaylist, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	go func(ctx context.Context, plChan chan *m3u8.MediaPlaylist, streamID StreamID) {
 		sub, err := c.GetHLSSubscriber(streamID)
@@ -128,35 +191,47 @@ func (c *BasicVideoCache) GetHLSMediaPlaylist(streamID StreamID) *m3u8.MediaPlay
 			return
 		}
 		glog.Infof("Subscriber for stream: %v - %v", streamID, sub)
+		c.segLock.Lock()
+		c.subs[streamID] = sub
+		c.segLock.Unlock()
 		subCtx, cancelSub := context.WithCancel(context.Background())
 		sub.Subscribe(subCtx, func(seqNo uint64, data []byte, eof bool) {
 			glog.Infof("Subscriber got msg: %v", seqNo)
 			if eof {
 				//Remove cache entry
+				c.segLock.Lock()
+				delete(c.subs, streamID)
+				c.segLock.Unlock()
 				c.DeleteCache(streamID)
 				return
 			}
 
+			if subCtx.Err() != nil {
+				return
+			}
 			ss, err := BytesToSignedSegment(data)
 			if err != nil {
 				glog.Errorf("Error converting bytes to segment: %v", err)
+				return
 			}
-			//If first data, insert pl into chan
-			cache, ok := c.segCache[streamID]
-			if !ok {
-				cache = newSegCache(SegCacheLen)
-				c.segCache[streamID] = cache
-				cache.Insert(&ss.Seg)
+			//Add data to cache, and if first data, insert pl into chan
+			cache, created := c.getOrCreateCache(streamID)
+			cache.Insert(&ss.Seg)
+			if created {
 				plChan <- cache.GetMediaPlaylist()
 			}
-
-			//Add data to cache
-			cache.Insert(&ss.Seg)
 		})
 
 		select {
 		case <-ctx.Done():
 			cancelSub()
+			//Nobody waits for the playlist anymore, so drop the subscription and anything it cached
+			c.segLock.Lock()
+			if c.subs[streamID] == sub {
+				delete(c.subs, streamID)
+				delete(c.segCache, streamID)
+			}
+			c.segLock.Unlock()
 		}
 	}(ctx, plChan, streamID)
 
@@ -171,8 +246,17 @@ func (c *BasicVideoCache) GetHLSMediaPlaylist(streamID StreamID) *m3u8.MediaPlay
 	}
 }
 
+//LatestSegmentSeqNo returns the sequence number of the newest cached segment of the stream.  Returns false if nothing is cached.
+func (c *BasicVideoCache) LatestSegmentSeqNo(streamID StreamID) (uint64, bool) {
+	cache, ok := c.GetCache(streamID)
+	if !ok {
+		return 0, false
+	}
+	return cache.LatestSeqNo()
+}
+
 func (c *BasicVideoCache) GetHLSSegment(streamID StreamID, segName string) *stream.HLSSegment {
-	if cache, ok := c.segCache[streamID]; !ok {
+	if cache, ok := c.GetCache(streamID); !ok {
 		return nil
 	} else {
 		return cache.GetSeg(segName)
@@ -1,10 +1,15 @@
 package core
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ericxtang/m3u8"
+	"github.com/livepeer/lpms/stream"
 )
 
 func TestGetMasterPlaylist(t *testing.T) {
@@ -63,7 +68,26 @@ func TestGetHLSSubscriber(t *testing.T) {
 }
 
 func TestEvictHLSSubscriber(t *testing.T) {
-	//so simple...
+	stubnet := &StubVideoNetwork{
+		subscribers: make(map[string]*StubSubscriber),
+	}
+	c := NewBasicVideoCache(stubnet)
+	strmID := "122011e494a06b20bf7a80f40e80d538675cc0b168c21912d33e0179617d5d4fe4e0Test"
+	sub := &StubSubscriber{}
+	stubnet.subscribers[strmID] = sub
+	if pl := c.GetHLSMediaPlaylist(StreamID(strmID)); pl == nil {
+		t.Fatalf("Expecting pl, got nil")
+	}
+
+	c.EvictHLSSubscriber(StreamID(strmID))
+	if !sub.unsubscribed {
+		t.Errorf("Expecting the subscription to end")
+	}
+	if _, ok := c.GetCache(StreamID(strmID)); ok {
+		t.Errorf("Expecting the cached segments to be dropped")
+	}
+	//Streams the cache doesn't subscribe to are ignored
+	c.EvictHLSSubscriber("unknown")
 }
 
 func TestGetHLSMediaPlaylist(t *testing.T) {
@@ -90,6 +114,141 @@ func TestGetHLSMediaPlaylist(t *testing.T) {
 	}
 }
 
+//lateSubscriber sends a segment that doesn't decode, and then a good one after delay.
+type lateSubscriber struct {
+	delay time.Duration
+	done  chan struct{}
+}
+
+func (s *lateSubscriber) IsLive() bool       { return true }
+func (s *lateSubscriber) String() string     { return "" }
+func (s *lateSubscriber) Unsubscribe() error { return nil }
+func (s *lateSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint64, data []byte, eof bool)) error {
+	go func() {
+		defer close(s.done)
+		gotData(1, []byte("junk"), false)
+		time.Sleep(s.delay)
+		b, _ := SignedSegmentToBytes(SignedSegment{Seg: stream.HLSSegment{SeqNo: 2, Name: "test_2.ts", Duration: 1}})
+		gotData(2, b, false)
+	}()
+	return nil
+}
+
+type lateSubscriberNetwork struct {
+	*StubVideoNetwork
+	sub stream.Subscriber
+}
+
+func (n *lateSubscriberNetwork) GetSubscriber(strmID string) (stream.Subscriber, error) {
+	return n.sub, nil
+}
+
+func TestGetHLSMediaPlaylistLate(t *testing.T) {
+	defer func(wait time.Duration) { GetMediaPlaylistWaitTime = wait }(GetMediaPlaylistWaitTime)
+	GetMediaPlaylistWaitTime = 100 * time.Millisecond
+	sub := &lateSubscriber{delay: 300 * time.Millisecond, done: make(chan struct{})}
+	c := NewBasicVideoCache(&lateSubscriberNetwork{StubVideoNetwork: &StubVideoNetwork{}, sub: sub})
+	strmID := StreamID("122011e494a06b20bf7a80f40e80d538675cc0b168c21912d33e0179617d5d4fe4e0Test")
+
+	//Segments that don't decode aren't cached, so there is no playlist before the wait is over
+	if pl := c.GetHLSMediaPlaylist(strmID); pl != nil {
+		t.Errorf("Expecting no playlist, got %v", pl)
+	}
+	//The segment that comes after doesn't block the subscriber, and the subscription is dropped
+	select {
+	case <-sub.done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expecting the subscriber not to block")
+	}
+	c.segLock.Lock()
+	defer c.segLock.Unlock()
+	if len(c.subs) != 0 || len(c.segCache) != 0 {
+		t.Errorf("Expecting no subscription and no cache, got %v %v", c.subs, c.segCache)
+	}
+}
+
+func TestBasicVideoCacheConcurrent(t *testing.T) {
+	//Run with -race: subscribers fill the cache while HTTP requests read it and evict subscriptions
+	stubnet := &StubVideoNetwork{subscribers: make(map[string]*StubSubscriber)}
+	c := NewBasicVideoCache(stubnet)
+	strmIDs := make([]StreamID, 0)
+	for i := 0; i < 5; i++ {
+		strmID := fmt.Sprintf("122011e494a06b20bf7a80f40e80d538675cc0b168c21912d33e0179617d5d4fe4e0Test%v", i)
+		stubnet.subscribers[strmID] = &StubSubscriber{}
+		strmIDs = append(strmIDs, StreamID(strmID))
+	}
+	var wg sync.WaitGroup
+	for _, strmID := range strmIDs {
+		wg.Add(2)
+		go func(strmID StreamID) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				c.GetHLSMediaPlaylist(strmID)
+			}
+		}(strmID)
+		go func(strmID StreamID) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				c.GetHLSSegment(strmID, "test.ts")
+				c.LatestSegmentSeqNo(strmID)
+				c.EvictHLSSubscriber(strmID)
+			}
+		}(strmID)
+	}
+	wg.Wait()
+}
+
+func TestSegCacheDiscontinuity(t *testing.T) {
+	sc := newSegCache(4)
+	for _, seqNo := range []uint64{1, 2, 2, 1, 5, 6} {
+		sc.Insert(&stream.HLSSegment{SeqNo: seqNo, Name: fmt.Sprintf("test_%v.ts", seqNo), Duration: 2})
+	}
+	//Duplicates and late segments are dropped, and the jump to 5 is a discontinuity
+	pl := sc.GetMediaPlaylist()
+	if pl.Count() != 4 || pl.SeqNo != 1 {
+		t.Errorf("Expecting 1, 2, 5 and 6, got %v", pl)
+	}
+	if !strings.Contains(pl.String(), "test_2.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:2.000,\ntest_5.ts") || strings.Count(pl.String(), "DISCONTINUITY") != 1 {
+		t.Errorf("Expecting a discontinuity before test_5.ts, got %v", pl)
+	}
+
+	//The discontinuity leaves with its segment
+	sc.Insert(&stream.HLSSegment{SeqNo: 7, Name: "test_7.ts", Duration: 2})
+	sc.Insert(&stream.HLSSegment{SeqNo: 8, Name: "test_8.ts", Duration: 2})
+	sc.Insert(&stream.HLSSegment{SeqNo: 9, Name: "test_9.ts", Duration: 2})
+	if pl := sc.GetMediaPlaylist(); strings.Contains(pl.String(), "DISCONTINUITY") || len(sc.discontinuity) != 0 {
+		t.Errorf("Expecting no discontinuity, got %v", pl)
+	}
+}
+
+func TestSegCacheConcurrent(t *testing.T) {
+	//Run with -race: the subscriber inserts segments while HTTP requests read them
+	sc := newSegCache(4)
+	sc.Insert(&stream.HLSSegment{SeqNo: 0, Name: "test_0.ts", Duration: 2})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := uint64(1); i < 100; i++ {
+			sc.Insert(&stream.HLSSegment{SeqNo: i, Name: fmt.Sprintf("test_%v.ts", i), Duration: 2})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if pl := sc.GetMediaPlaylist(); pl.Count() == 0 {
+				t.Errorf("Expecting segments in the playlist")
+			}
+			sc.GetSeg(fmt.Sprintf("test_%v.ts", i))
+			sc.LatestSeqNo()
+		}
+	}()
+	wg.Wait()
+	if seqNo, ok := sc.LatestSeqNo(); !ok || seqNo != 99 {
+		t.Errorf("Expecting 99, got %v", seqNo)
+	}
+}
+
 func TestGetHLSSegment(t *testing.T) {
 	//so simple...
 }
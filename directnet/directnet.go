@@ -0,0 +1,514 @@
+/*
+Package directnet sends the segments of transcode jobs straight from the broadcaster to the transcoder over HTTP, next to the p2p network.
+
+The transcoder only takes segments signed by the broadcaster of the job, and only over https.  The broadcaster still broadcasts every segment to the network, for the viewers of the source and as the fallback path.  With a route to
+the transcoder, it also POSTs the segment to the transcoder, which transcodes it and answers with the transcoded segments.  Both nodes
+get each segment on whichever path is first, and drop the copy that comes second.  Over https, the segments go over HTTP/2.
+*/
+package directnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/stream"
+)
+
+var ErrNoAnnouncer = errors.New("ErrNoAnnouncer")
+var ErrSegment = errors.New("ErrSegment")
+var ErrSegmentSig = errors.New("ErrSegmentSig")
+
+//SegmentPath is where transcoders take segments.
+const SegmentPath = "/segment"
+
+//SegmentResultTimeout is how long a transcoder takes to answer with the transcoded segments.  Renditions that aren't done by then are left
+//out of the response, and come through the network.
+var SegmentResultTimeout = 30 * time.Second
+
+//MaxRouteFailures is how many segments in a row can fail to go over HTTP before the broadcaster drops the route, and only uses the network.
+var MaxRouteFailures = 3
+
+//resultCacheLen is how many segments of each job the transcoder keeps the transcoded segments of, for requests that come after the
+//segment came through the network.
+const resultCacheLen = 8
+
+//MaxSegmentSize is the biggest segment the transcoder takes.
+var MaxSegmentSize int64 = 50 * 1024 * 1024
+
+//DirectVideoNetwork wraps a VideoNetwork, and sends the segments of the streams with a route over HTTP as well.
+type DirectVideoNetwork struct {
+	net.VideoNetwork
+	client *http.Client
+
+	lock        sync.Mutex
+	routes      map[string]*route
+	subscribers map[string]*directSubscriber
+	//jobs are the transcode jobs by source stream, sources the source stream of each transcoded stream
+	jobs    map[string]*jobResults
+	sources map[string]string
+}
+
+type route struct {
+	url      string
+	format   core.SegmentFormat
+	failures int
+}
+
+//segmentResponse is the answer of the transcoder.  Data is the transcoded segment, encoded like it's broadcast.
+type segmentResponse struct {
+	Segments []transcodedSegment
+}
+
+type transcodedSegment struct {
+	StrmID string
+	SeqNo  uint64
+	Data   []byte
+}
+
+//NewDirectVideoNetwork wraps nw.  The transcoder serves the segment requests with the network as http.Handler on SegmentPath.
+func NewDirectVideoNetwork(nw net.VideoNetwork) *DirectVideoNetwork {
+	return &DirectVideoNetwork{
+		VideoNetwork: nw,
+		client:       &http.Client{Timeout: SegmentResultTimeout + 10*time.Second},
+		routes:       make(map[string]*route),
+		subscribers:  make(map[string]*directSubscriber),
+		jobs:         make(map[string]*jobResults),
+		sources:      make(map[string]string),
+	}
+}
+
+func (n *DirectVideoNetwork) String() string {
+	return fmt.Sprintf("DirectVideoNetwork over %v", n.VideoNetwork)
+}
+
+//GetBroadcaster returns a broadcaster that broadcasts to the network, and sends to the route of the stream if it has one.
+func (n *DirectVideoNetwork) GetBroadcaster(strmID string) (stream.Broadcaster, error) {
+	b, err := n.VideoNetwork.GetBroadcaster(strmID)
+	if err != nil {
+		return nil, err
+	}
+	return &directBroadcaster{Broadcaster: b, network: n, strmID: strmID}, nil
+}
+
+//GetSubscriber returns a subscriber that gets the segments of the stream from the network and over HTTP.
+func (n *DirectVideoNetwork) GetSubscriber(strmID string) (stream.Subscriber, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if s, ok := n.subscribers[strmID]; ok {
+		return s, nil
+	}
+	sub, err := n.VideoNetwork.GetSubscriber(strmID)
+	if err != nil {
+		return nil, err
+	}
+	s := &directSubscriber{Subscriber: sub, network: n, strmID: strmID, seen: make(map[uint64]bool)}
+	n.subscribers[strmID] = s
+	return s, nil
+}
+
+func (n *DirectVideoNetwork) SetSegmentRoute(strmID string, url string, format string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if url == "" {
+		delete(n.routes, strmID)
+		return
+	}
+	glog.V(common.SHORT).Infof("Sending the segments of %v to %v in format %v", strmID, url, format)
+	n.routes[strmID] = &route{url: url, format: core.SegmentFormat(format)}
+}
+
+func (n *DirectVideoNetwork) SetTranscodedStreams(strmID string, transcodedStrmIDs []string, broadcaster ethcommon.Address) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if j, ok := n.jobs[strmID]; ok {
+		for _, id := range j.strmIDs {
+			delete(n.sources, id)
+		}
+		delete(n.jobs, strmID)
+	}
+	if transcodedStrmIDs == nil {
+		return
+	}
+	n.jobs[strmID] = newJobResults(transcodedStrmIDs, broadcaster)
+	for _, id := range transcodedStrmIDs {
+		n.sources[id] = strmID
+	}
+}
+
+//SetNodeStatusFunc passes f on to the network, if it reports the status of the node.
+func (n *DirectVideoNetwork) SetNodeStatusFunc(f func(status *net.NodeStatus)) {
+	if r, ok := n.VideoNetwork.(net.NodeStatusReporter); ok {
+		r.SetNodeStatusFunc(f)
+	}
+}
+
+//GetLocalStreams are the streams of the network, if it lists them.
+func (n *DirectVideoNetwork) GetLocalStreams() []string {
+	if l, ok := n.VideoNetwork.(interface {
+		GetLocalStreams() []string
+	}); ok {
+		return l.GetLocalStreams()
+	}
+	return []string{}
+}
+
+//Addrs are the addresses the network advertises, if it knows them.
+func (n *DirectVideoNetwork) Addrs() []string {
+	if r, ok := n.VideoNetwork.(net.AddrsReporter); ok {
+		return r.Addrs()
+	}
+	return nil
+}
+
+//UpstreamPeer is the peer the network gets strmID from, if it knows it.
+func (n *DirectVideoNetwork) UpstreamPeer(strmID string) string {
+	if r, ok := n.VideoNetwork.(net.UpstreamReporter); ok {
+		return r.UpstreamPeer(strmID)
+	}
+	return ""
+}
+
+//AnnounceCapabilities passes the announcement on to the network, if it spreads announcements.
+func (n *DirectVideoNetwork) AnnounceCapabilities(data []byte) error {
+	if a, ok := n.VideoNetwork.(net.CapabilityAnnouncer); ok {
+		return a.AnnounceCapabilities(data)
+	}
+	return ErrNoAnnouncer
+}
+
+//ReceivedCapabilities passes gotAnnouncement on to the network, if it spreads announcements.
+func (n *DirectVideoNetwork) ReceivedCapabilities(gotAnnouncement func(nodeID string, data []byte) bool) {
+	if a, ok := n.VideoNetwork.(net.CapabilityAnnouncer); ok {
+		a.ReceivedCapabilities(gotAnnouncement)
+	}
+}
+
+//AnnounceStream passes the announcement on to the network, if it spreads announcements.
+func (n *DirectVideoNetwork) AnnounceStream(data []byte) error {
+	if a, ok := n.VideoNetwork.(net.StreamAnnouncer); ok {
+		return a.AnnounceStream(data)
+	}
+	return ErrNoAnnouncer
+}
+
+//ReceivedStreamAnnouncements passes gotAnnouncement on to the network, if it spreads announcements.
+func (n *DirectVideoNetwork) ReceivedStreamAnnouncements(gotAnnouncement func(nodeID string, data []byte) bool) {
+	if a, ok := n.VideoNetwork.(net.StreamAnnouncer); ok {
+		a.ReceivedStreamAnnouncements(gotAnnouncement)
+	}
+}
+
+//ServeHTTP takes a segment of a transcode job, and answers with the transcoded segments.  The query has the strmID and seqNo of the
+//segment, the body is the segment as it's broadcast.
+func (n *DirectVideoNetwork) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	strmID := r.URL.Query().Get("strmID")
+	seqNo, err := strconv.ParseUint(r.URL.Query().Get("seqNo"), 10, 64)
+	if strmID == "" || err != nil {
+		http.Error(w, "Need strmID and seqNo", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxSegmentSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading segment: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	n.lock.Lock()
+	j := n.jobs[strmID]
+	s := n.subscribers[strmID]
+	n.lock.Unlock()
+	if j == nil || s == nil || !s.subscribed() {
+		http.Error(w, "Not transcoding the stream", http.StatusNotFound)
+		return
+	}
+	//The first copy of each segment is transcoded, so a segment that isn't from the broadcaster would keep the real one out
+	if err := j.verify(strmID, seqNo, data); err != nil {
+		glog.Errorf("Refusing segment %v of %v from %v: %v", seqNo, strmID, r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	done := j.wait(seqNo)
+	go s.insert(seqNo, data)
+	timer := time.NewTimer(SegmentResultTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		glog.Errorf("Segment %v of %v wasn't transcoded after %v, answering with what's done", seqNo, strmID, SegmentResultTimeout)
+	case <-r.Context().Done():
+		return
+	}
+
+	resp := segmentResponse{Segments: j.results(seqNo)}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		glog.Errorf("Error writing transcoded segments of %v: %v", strmID, err)
+	}
+}
+
+//gotTranscoded keeps a transcoded segment for the segment requests of its job.
+func (n *DirectVideoNetwork) gotTranscoded(strmID string, seqNo uint64, data []byte) {
+	n.lock.Lock()
+	source, ok := n.sources[strmID]
+	j := n.jobs[source]
+	n.lock.Unlock()
+	if ok && j != nil {
+		j.add(strmID, seqNo, data)
+	}
+}
+
+//sendSegment sends a segment over the route of its stream, and adds the transcoded segments in the response to their streams.
+func (n *DirectVideoNetwork) sendSegment(strmID string, seqNo uint64, data []byte) {
+	n.lock.Lock()
+	rt := n.routes[strmID]
+	n.lock.Unlock()
+	if rt == nil {
+		return
+	}
+
+	data, err := reencodeSegment(data, rt.format)
+	if err != nil {
+		glog.Errorf("Error encoding segment %v of %v in format %v: %v", seqNo, strmID, rt.format, err)
+		return
+	}
+	start := time.Now()
+	resp, err := n.postSegment(rt.url, strmID, seqNo, data)
+	if err != nil {
+		n.lock.Lock()
+		defer n.lock.Unlock()
+		rt.failures++
+		glog.Errorf("Error sending segment %v of %v to %v (%v/%v): %v", seqNo, strmID, rt.url, rt.failures, MaxRouteFailures, err)
+		if rt.failures >= MaxRouteFailures && n.routes[strmID] == rt {
+			glog.Errorf("Cannot send segments of %v to %v, only broadcasting them to the network", strmID, rt.url)
+			delete(n.routes, strmID)
+		}
+		return
+	}
+	n.lock.Lock()
+	rt.failures = 0
+	n.lock.Unlock()
+	glog.V(common.DEBUG).Infof("Segment %v of %v was transcoded by %v in %v", seqNo, strmID, rt.url, time.Since(start))
+
+	for _, seg := range resp.Segments {
+		n.lock.Lock()
+		s := n.subscribers[seg.StrmID]
+		n.lock.Unlock()
+		if s != nil {
+			s.insert(seg.SeqNo, seg.Data)
+		}
+	}
+}
+
+//reencodeSegment encodes a broadcast segment in format f, if it's in another one.
+func reencodeSegment(data []byte, f core.SegmentFormat) ([]byte, error) {
+	if f == "" || core.SegmentFormatOf(data) == f {
+		return data, nil
+	}
+	ss, err := core.BytesToSignedSegment(data)
+	if err != nil {
+		return nil, err
+	}
+	return core.EncodeSignedSegment(ss, f)
+}
+
+func (n *DirectVideoNetwork) postSegment(segmentURL string, strmID string, seqNo uint64, data []byte) (*segmentResponse, error) {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = SegmentPath
+	u.RawQuery = url.Values{"strmID": {strmID}, "seqNo": {strconv.FormatUint(seqNo, 10)}}.Encode()
+	resp, err := n.client.Post(u.String(), "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	var sr segmentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, err
+	}
+	return &sr, nil
+}
+
+//directBroadcaster broadcasts segments to the network, and sends them over the route of the stream.  On the transcoder, it keeps the
+//transcoded segments for the segment requests.
+type directBroadcaster struct {
+	stream.Broadcaster
+	network *DirectVideoNetwork
+	strmID  string
+}
+
+func (b *directBroadcaster) Broadcast(seqNo uint64, data []byte) error {
+	err := b.Broadcaster.Broadcast(seqNo, data)
+	b.network.gotTranscoded(b.strmID, seqNo, data)
+	go b.network.sendSegment(b.strmID, seqNo, data)
+	return err
+}
+
+//directSubscriber gets segments from the network and over HTTP, and passes on the first copy of each.
+type directSubscriber struct {
+	stream.Subscriber
+	network *DirectVideoNetwork
+	strmID  string
+
+	lock    sync.Mutex
+	gotData func(seqNo uint64, data []byte, eof bool)
+	seen    map[uint64]bool
+	maxSeen uint64
+}
+
+func (s *directSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint64, data []byte, eof bool)) error {
+	s.lock.Lock()
+	s.gotData = gotData
+	s.lock.Unlock()
+	return s.Subscriber.Subscribe(ctx, func(seqNo uint64, data []byte, eof bool) {
+		if eof {
+			gotData(seqNo, data, eof)
+			return
+		}
+		s.insert(seqNo, data)
+	})
+}
+
+func (s *directSubscriber) Unsubscribe() error {
+	s.lock.Lock()
+	s.gotData = nil
+	s.lock.Unlock()
+	s.network.lock.Lock()
+	if s.network.subscribers[s.strmID] == s {
+		delete(s.network.subscribers, s.strmID)
+	}
+	s.network.lock.Unlock()
+	return s.Subscriber.Unsubscribe()
+}
+
+func (s *directSubscriber) subscribed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.gotData != nil
+}
+
+//insert passes a segment on, unless it came already.
+func (s *directSubscriber) insert(seqNo uint64, data []byte) {
+	s.lock.Lock()
+	gotData := s.gotData
+	if gotData == nil || s.seen[seqNo] {
+		s.lock.Unlock()
+		return
+	}
+	s.seen[seqNo] = true
+	if seqNo > s.maxSeen {
+		s.maxSeen = seqNo
+	}
+	for n := range s.seen {
+		if n+resultCacheLen < s.maxSeen {
+			delete(s.seen, n)
+		}
+	}
+	s.lock.Unlock()
+	gotData(seqNo, data, false)
+}
+
+//jobResults keeps the transcoded segments of the latest segments of a job.
+type jobResults struct {
+	strmIDs []string
+	//broadcaster signs the segments of the job, empty if the signatures aren't checked
+	broadcaster ethcommon.Address
+
+	lock    sync.Mutex
+	segs    map[uint64]map[string][]byte
+	waiting map[uint64]chan struct{}
+}
+
+func newJobResults(strmIDs []string, broadcaster ethcommon.Address) *jobResults {
+	return &jobResults{strmIDs: strmIDs, broadcaster: broadcaster, segs: make(map[uint64]map[string][]byte), waiting: make(map[uint64]chan struct{})}
+}
+
+//verify checks that data is segment seqNo of strmID, signed by the broadcaster of the job.
+func (j *jobResults) verify(strmID string, seqNo uint64, data []byte) error {
+	ss, err := core.BytesToSignedSegment(data)
+	if err != nil {
+		return ErrSegment
+	}
+	if ss.Seg.SeqNo != seqNo {
+		return ErrSegment
+	}
+	if (j.broadcaster != ethcommon.Address{}) && !core.VerifySegmentSig(strmID, &ss.Seg, ss.Sig, j.broadcaster) {
+		return ErrSegmentSig
+	}
+	return nil
+}
+
+//wait returns a channel that's closed once all the streams have segment seqNo.
+func (j *jobResults) wait(seqNo uint64) chan struct{} {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	c, ok := j.waiting[seqNo]
+	if !ok {
+		c = make(chan struct{})
+		j.waiting[seqNo] = c
+		if len(j.segs[seqNo]) == len(j.strmIDs) {
+			close(c)
+		}
+	}
+	return c
+}
+
+func (j *jobResults) add(strmID string, seqNo uint64, data []byte) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	segs, ok := j.segs[seqNo]
+	if !ok {
+		segs = make(map[string][]byte)
+		j.segs[seqNo] = segs
+	}
+	segs[strmID] = data
+	if c, ok := j.waiting[seqNo]; ok && len(segs) == len(j.strmIDs) {
+		select {
+		case <-c:
+		default:
+			close(c)
+		}
+	}
+	for n := range j.segs {
+		if n+resultCacheLen < seqNo {
+			delete(j.segs, n)
+			delete(j.waiting, n)
+		}
+	}
+}
+
+func (j *jobResults) results(seqNo uint64) []transcodedSegment {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	res := make([]transcodedSegment, 0, len(j.segs[seqNo]))
+	for _, strmID := range j.strmIDs {
+		if data, ok := j.segs[seqNo][strmID]; ok {
+			res = append(res, transcodedSegment{StrmID: strmID, SeqNo: seqNo, Data: data})
+		}
+	}
+	return res
+}
@@ -0,0 +1,248 @@
+package directnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/eth/signer"
+	ethTypes "github.com/livepeer/go-livepeer/eth/types"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/stream"
+)
+
+type stubSubscriber struct {
+	lock    sync.Mutex
+	gotData func(seqNo uint64, data []byte, eof bool)
+}
+
+func (s *stubSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint64, data []byte, eof bool)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gotData = gotData
+	return nil
+}
+func (s *stubSubscriber) IsLive() bool       { return true }
+func (s *stubSubscriber) String() string     { return "stubSubscriber" }
+func (s *stubSubscriber) Unsubscribe() error { return nil }
+
+type stubBroadcaster struct {
+	lock sync.Mutex
+	segs map[uint64][]byte
+}
+
+func (b *stubBroadcaster) Broadcast(seqNo uint64, data []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.segs[seqNo] = data
+	return nil
+}
+func (b *stubBroadcaster) IsLive() bool   { return true }
+func (b *stubBroadcaster) Finish() error  { return nil }
+func (b *stubBroadcaster) String() string { return "stubBroadcaster" }
+
+//stubNetwork doesn't deliver anything by itself, so segments only come over HTTP unless the test inserts them.
+type stubNetwork struct {
+	subscribers  map[string]*stubSubscriber
+	broadcasters map[string]*stubBroadcaster
+}
+
+func newStubNetwork() *stubNetwork {
+	return &stubNetwork{subscribers: make(map[string]*stubSubscriber), broadcasters: make(map[string]*stubBroadcaster)}
+}
+
+func (n *stubNetwork) GetMasterPlaylist(nodeID string, manifestID string) (chan *m3u8.MasterPlaylist, error) {
+	return nil, nil
+}
+func (n *stubNetwork) GetSubscriber(strmID string) (stream.Subscriber, error) {
+	s := &stubSubscriber{}
+	n.subscribers[strmID] = s
+	return s, nil
+}
+func (n *stubNetwork) UpdateMasterPlaylist(manifestID string, mpl *m3u8.MasterPlaylist) error {
+	return nil
+}
+func (n *stubNetwork) GetBroadcaster(strmID string) (stream.Broadcaster, error) {
+	b := &stubBroadcaster{segs: make(map[uint64][]byte)}
+	n.broadcasters[strmID] = b
+	return b, nil
+}
+func (n *stubNetwork) GetNodeID() string                              { return "stub" }
+func (n *stubNetwork) Connect(nodeID string, nodeAddr []string) error { return nil }
+func (n *stubNetwork) SetupProtocol() error                           { return nil }
+func (n *stubNetwork) SendTranscodeResponse(nodeID string, manifestID string, transcodeResult map[string]string) error {
+	return nil
+}
+func (n *stubNetwork) ReceivedTranscodeResponse(manifestID string, gotResult func(transcodeResult map[string]string)) {
+}
+func (n *stubNetwork) GetNodeStatus(nodeID string) (chan *net.NodeStatus, error) { return nil, nil }
+func (n *stubNetwork) String() string                                            { return "stubNetwork" }
+
+//signedSegment is segment seqNo of strmID, signed with key and encoded like it's broadcast.
+func signedSegment(t *testing.T, key *ecdsa.PrivateKey, strmID string, seqNo uint64, data string) []byte {
+	seg := stream.HLSSegment{SeqNo: seqNo, Data: []byte(data)}
+	hash := (&ethTypes.Segment{StreamID: strmID, SegmentSequenceNumber: big.NewInt(int64(seqNo)), DataHash: crypto.Keccak256Hash(seg.Data)}).Hash()
+	sig, err := crypto.Sign(signer.SegmentSignHash(hash.Bytes()), key)
+	if err != nil {
+		t.Fatalf("Error signing segment: %v", err)
+	}
+	ssb, err := core.SignedSegmentToBytes(core.SignedSegment{Seg: seg, Sig: sig})
+	if err != nil {
+		t.Fatalf("Error encoding segment: %v", err)
+	}
+	return ssb
+}
+
+func TestSegmentRoute(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	seg1 := signedSegment(t, key, "src", 1, "seg1")
+
+	//The transcoder "transcodes" src into t1 by prefixing the segments
+	tn := NewDirectVideoNetwork(newStubNetwork())
+	tn.SetTranscodedStreams("src", []string{"t1"}, crypto.PubkeyToAddress(key.PublicKey))
+	tb, _ := tn.GetBroadcaster("t1")
+	tsub, _ := tn.GetSubscriber("src")
+	tsub.Subscribe(context.Background(), func(seqNo uint64, data []byte, eof bool) {
+		tb.Broadcast(seqNo, append([]byte("t1:"), data...))
+	})
+	ts := httptest.NewServer(tn)
+	defer ts.Close()
+
+	bnw := newStubNetwork()
+	bn := NewDirectVideoNetwork(bnw)
+	got := make(chan string, 10)
+	bsub, _ := bn.GetSubscriber("t1")
+	bsub.Subscribe(context.Background(), func(seqNo uint64, data []byte, eof bool) {
+		got <- string(data)
+	})
+	bn.SetSegmentRoute("src", ts.URL, "")
+	bb, _ := bn.GetBroadcaster("src")
+	if err := bb.Broadcast(1, seg1); err != nil {
+		t.Fatalf("Error broadcasting: %v", err)
+	}
+	select {
+	case data := <-got:
+		if data != "t1:"+string(seg1) {
+			t.Errorf("Expecting the transcoded segment, got %v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting the transcoded segment over HTTP")
+	}
+	//Still broadcast to the network
+	if !bytes.Equal(bnw.broadcasters["src"].segs[1], seg1) {
+		t.Errorf("Expecting the segment to be broadcast as well")
+	}
+
+	//The copy from the network is dropped, newer segments from the network are passed on
+	bnw.subscribers["t1"].gotData(1, []byte("t1:seg1"), false)
+	bnw.subscribers["t1"].gotData(2, []byte("t1:seg2"), false)
+	if data := <-got; data != "t1:seg2" {
+		t.Errorf("Expecting only the second segment from the network, got %v", data)
+	}
+
+	//Transcoders that aren't on the job answer with an error, and the route is dropped after MaxRouteFailures
+	tn.SetTranscodedStreams("src", nil, ethcommon.Address{})
+	for i := 0; i < MaxRouteFailures; i++ {
+		bn.sendSegment("src", uint64(10+i), []byte("seg"))
+	}
+	bn.lock.Lock()
+	_, routed := bn.routes["src"]
+	bn.lock.Unlock()
+	if routed {
+		t.Errorf("Expecting the route to be dropped")
+	}
+}
+
+func TestReencodeSegment(t *testing.T) {
+	ss := core.SignedSegment{Seg: stream.HLSSegment{SeqNo: 3, Data: []byte("seg3")}, Sig: []byte("sig"), StrmID: "src", Codecs: "avc1.4d401f"}
+	data, _ := core.SignedSegmentToBytes(ss)
+
+	//The transcoder gets the format it negotiated, the network keeps the broadcast one
+	v1, err := reencodeSegment(data, core.SegmentFormatV1)
+	if err != nil || core.SegmentFormatOf(v1) != core.SegmentFormatV1 {
+		t.Fatalf("Expecting an lpss1 segment, got %v", err)
+	}
+	dec, err := core.BytesToSignedSegment(v1)
+	if err != nil || dec.Seg.SeqNo != 3 || string(dec.Seg.Data) != "seg3" || string(dec.Sig) != "sig" || dec.StrmID != "src" || dec.Codecs != "avc1.4d401f" {
+		t.Errorf("Wrong segment: %+v %v", dec, err)
+	}
+	if core.SegmentFormatOf(data) != core.SegmentFormatGob {
+		t.Errorf("Expecting the broadcast segment to stay gob")
+	}
+
+	//Segments already in the format are sent as they are
+	for _, f := range []core.SegmentFormat{"", core.SegmentFormatGob} {
+		if same, err := reencodeSegment(data, f); err != nil || &same[0] != &data[0] {
+			t.Errorf("Expecting the segment as it is for %q, got %v", f, err)
+		}
+	}
+}
+
+func TestServeSegmentErrors(t *testing.T) {
+	n := NewDirectVideoNetwork(newStubNetwork())
+	for _, tc := range []struct {
+		method, url string
+		code        int
+	}{
+		{"GET", "/segment?strmID=src&seqNo=1", http.StatusMethodNotAllowed},
+		{"POST", "/segment?strmID=src", http.StatusBadRequest},
+		{"POST", "/segment?strmID=src&seqNo=1", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("%v %v: expecting %v, got %v", tc.method, tc.url, tc.code, w.Code)
+		}
+	}
+}
+
+func TestServeSegmentSignature(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	n := NewDirectVideoNetwork(newStubNetwork())
+	n.SetTranscodedStreams("src", []string{"t1"}, crypto.PubkeyToAddress(key.PublicKey))
+	var lock sync.Mutex
+	got := make([]uint64, 0)
+	sub, _ := n.GetSubscriber("src")
+	sub.Subscribe(context.Background(), func(seqNo uint64, data []byte, eof bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		got = append(got, seqNo)
+	})
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		data []byte
+	}{
+		{"not a segment", "/segment?strmID=src&seqNo=1", []byte("seg1")},
+		{"signed by someone else", "/segment?strmID=src&seqNo=1", signedSegment(t, other, "src", 1, "seg1")},
+		{"signed for another stream", "/segment?strmID=src&seqNo=1", signedSegment(t, key, "other", 1, "seg1")},
+		{"another seqNo", "/segment?strmID=src&seqNo=2", signedSegment(t, key, "src", 1, "seg1")},
+	} {
+		w := httptest.NewRecorder()
+		n.ServeHTTP(w, httptest.NewRequest("POST", tc.url, bytes.NewReader(tc.data)))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%v: expecting %v, got %v", tc.name, http.StatusForbidden, w.Code)
+		}
+	}
+
+	//The segment from the broadcaster still gets through the network
+	n.VideoNetwork.(*stubNetwork).subscribers["src"].gotData(1, signedSegment(t, key, "src", 1, "seg1"), false)
+	time.Sleep(20 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(got) != 1 || got[0] != 1 {
+		t.Errorf("Expecting only the segment from the network, got %v", got)
+	}
+}
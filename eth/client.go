@@ -29,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/eth/contracts"
+	"github.com/livepeer/go-livepeer/eth/signer"
 )
 
 var ProtocolCyclesPerRound = 2
@@ -71,6 +72,7 @@ type LivepeerEthClient interface {
 	SlashingPeriod() (*big.Int, error)
 	LastRewardRound() (*big.Int, error)
 	IsRegisteredTranscoder() (bool, error)
+	IsTranscoderInPool(addr common.Address) (bool, error)
 	TranscoderBond() (*big.Int, error)
 	GetCandidateTranscodersStats() ([]TranscoderStats, error)
 	GetControllerAddr() string
@@ -82,11 +84,12 @@ type LivepeerEthClient interface {
 	GetBlockInfoByTxHash(ctx context.Context, hash common.Hash) (blkNum *big.Int, blkHash common.Hash, err error)
 	GetBlockHashByNumber(ctx context.Context, num *big.Int) (common.Hash, error)
 	IsAssignedTranscoder(maxPricePerSegment *big.Int) bool
+	SignSegmentHash(hash []byte) ([]byte, error)
 }
 
 type Client struct {
 	account               accounts.Account
-	keyStore              *keystore.KeyStore
+	signer                signer.Signer
 	transactOpts          bind.TransactOpts
 	backend               *ethclient.Client
 	controllerAddr        common.Address
@@ -137,14 +140,10 @@ type Claim struct {
 	Status               uint8
 }
 
-func NewClient(account accounts.Account, passphrase string, keystoreDir string, backend *ethclient.Client, gasPrice *big.Int, controllerAddr common.Address, rpcTimeout time.Duration, eventTimeout time.Duration) (*Client, error) {
-	keyStore := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
-
-	transactOpts, err := NewTransactOptsForAccount(account, passphrase, keyStore)
-	if err != nil {
-		return nil, err
-	}
-
+//NewClient creates a client that signs with s, which can be a keystore or a remote signer process.
+func NewClient(s signer.Signer, backend *ethclient.Client, gasPrice *big.Int, controllerAddr common.Address, rpcTimeout time.Duration, eventTimeout time.Duration) (*Client, error) {
+	transactOpts := signer.TransactOpts(s)
+	transactOpts.GasLimit = big.NewInt(4000000)
 	transactOpts.GasPrice = gasPrice
 
 	controller, err := contracts.NewController(controllerAddr, backend)
@@ -154,8 +153,8 @@ func NewClient(account accounts.Account, passphrase string, keystoreDir string,
 	}
 
 	client := &Client{
-		account:        account,
-		keyStore:       keyStore,
+		account:        s.Account(),
+		signer:         s,
 		transactOpts:   *transactOpts,
 		backend:        backend,
 		controllerAddr: controllerAddr,
@@ -581,7 +580,12 @@ func (c *Client) CurrentRoundInitialized() (bool, error) {
 }
 
 func (c *Client) IsRegisteredTranscoder() (bool, error) {
-	status, err := c.bondingManagerSession.TranscoderStatus(c.account.Address)
+	return c.IsTranscoderInPool(c.account.Address)
+}
+
+//IsTranscoderInPool tells if addr is registered as a transcoder, which puts it in the transcoder pool.
+func (c *Client) IsTranscoderInPool(addr common.Address) (bool, error) {
+	status, err := c.bondingManagerSession.TranscoderStatus(addr)
 	if err != nil {
 		return false, err
 	}
@@ -807,17 +811,8 @@ func (c *Client) IsAssignedTranscoder(maxPricePerSegment *big.Int) bool {
 
 // HELPERS
 
-func (c *Client) SignSegmentHash(passphrase string, hash []byte) ([]byte, error) {
-	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", 32, hash)
-	signHash := crypto.Keccak256([]byte(msg))
-
-	sig, err := c.keyStore.SignHashWithPassphrase(c.account, passphrase, signHash)
-	if err != nil {
-		glog.Errorf("Error signing segment hash: %v", err)
-		return nil, err
-	}
-
-	return sig, nil
+func (c *Client) SignSegmentHash(hash []byte) ([]byte, error) {
+	return c.signer.SignSegmentHash(hash)
 }
 
 func (c *Client) GetReceipt(tx *types.Transaction) (*types.Receipt, error) {
@@ -0,0 +1,214 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/eth/contracts"
+)
+
+var ErrPolicy = errors.New("ErrPolicy")
+
+//contractABIs are the Livepeer contracts the policy knows the methods of, by name.
+var contractABIs = map[string]string{
+	"BondingManager":      contracts.BondingManagerABI,
+	"JobsManager":         contracts.JobsManagerABI,
+	"RoundsManager":       contracts.RoundsManagerABI,
+	"LivepeerToken":       contracts.LivepeerTokenABI,
+	"LivepeerTokenFaucet": contracts.LivepeerTokenFaucetABI,
+}
+
+//approveSpenders are the contracts the node approves to spend its tokens.
+var approveSpenders = map[string]bool{"BondingManager": true, "JobsManager": true}
+
+//DefaultMethods are all the contract methods a node sends transactions for.  LivepeerToken.approve is only allowed for the
+//approveSpenders, up to MaxApprove.
+var DefaultMethods = []string{
+	"BondingManager.transcoder",
+	"BondingManager.bond",
+	"BondingManager.unbond",
+	"BondingManager.withdraw",
+	"BondingManager.reward",
+	"JobsManager.deposit",
+	"JobsManager.withdraw",
+	"JobsManager.job",
+	"JobsManager.claimWork",
+	"JobsManager.verify",
+	"JobsManager.distributeFees",
+	"JobsManager.batchDistributeFees",
+	"RoundsManager.initializeRound",
+	"LivepeerToken.approve",
+	"LivepeerTokenFaucet.request",
+}
+
+//SpendWindow is the period MaxWindowCost applies to.
+var SpendWindow = 24 * time.Hour
+
+//Policy decides which transactions the signer is allowed to sign.  Transactions have to call an allowed method of the contract at their
+//address, and stay within the spending limits.  The cost of a transaction is its value plus the max gas fee.
+type Policy struct {
+	//methods maps the selectors of the allowed methods of each contract to their names
+	methods map[string]map[string]string
+	//Contracts are the addresses transactions can be sent to, with the contract at each.  See ResolveContracts.
+	Contracts map[common.Address]string
+	//MaxApprove is the max amount of tokens a LivepeerToken.approve tx can allow to be spent.  Nil means approvals aren't allowed.
+	MaxApprove *big.Int
+	//MaxTxCost is the max cost of a single transaction.  Nil means no limit.
+	MaxTxCost *big.Int
+	//MaxWindowCost is the max total cost of the transactions signed in a SpendWindow.  Nil means no limit.
+	MaxWindowCost *big.Int
+
+	lock        sync.Mutex
+	windowStart time.Time
+	spent       *big.Int
+}
+
+//NewPolicy creates a policy that allows the given methods, in the format Contract.method (e.g. BondingManager.bond).
+func NewPolicy(methods []string) (*Policy, error) {
+	abis := make(map[string]abi.ABI)
+	for name, def := range contractABIs {
+		a, err := abi.JSON(strings.NewReader(def))
+		if err != nil {
+			return nil, err
+		}
+		abis[name] = a
+	}
+
+	p := &Policy{methods: make(map[string]map[string]string), Contracts: make(map[common.Address]string), spent: big.NewInt(0)}
+	for _, m := range methods {
+		parts := strings.Split(strings.TrimSpace(m), ".")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid method %q, expecting Contract.method", m)
+		}
+		a, ok := abis[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown contract %q", parts[0])
+		}
+		method, ok := a.Methods[parts[1]]
+		if !ok || method.Const {
+			return nil, fmt.Errorf("unknown method %q", m)
+		}
+		if p.methods[parts[0]] == nil {
+			p.methods[parts[0]] = make(map[string]string)
+		}
+		p.methods[parts[0]][string(method.Id())] = parts[0] + "." + parts[1]
+	}
+	return p, nil
+}
+
+//ResolveContracts sets Contracts to the addresses of the Livepeer contracts, as registered in the Controller at controllerAddr.
+func (p *Policy) ResolveContracts(caller bind.ContractCaller, controllerAddr common.Address) error {
+	controller, err := contracts.NewControllerCaller(controllerAddr, caller)
+	if err != nil {
+		return err
+	}
+	resolved := make(map[common.Address]string)
+	for name := range contractABIs {
+		addr, err := controller.GetContract(&bind.CallOpts{}, crypto.Keccak256Hash([]byte(name)))
+		if err != nil {
+			glog.Errorf("Error getting %v address: %v", name, err)
+			return err
+		}
+		if addr == (common.Address{}) {
+			return fmt.Errorf("%v is not registered in the Controller at %v", name, controllerAddr.Hex())
+		}
+		glog.Infof("%v: %v", name, addr.Hex())
+		resolved[addr] = name
+	}
+	p.Contracts = resolved
+	return nil
+}
+
+//Check returns ErrPolicy (wrapped with the reason) if tx isn't allowed.  Allowed transactions count towards the spending limit.
+func (p *Policy) Check(tx *types.Transaction) error {
+	if tx.To() == nil {
+		return policyError("contract creation is not allowed")
+	}
+	contract, ok := p.Contracts[*tx.To()]
+	if !ok {
+		return policyError("contract %v is not allowed", tx.To().Hex())
+	}
+	if len(tx.Data()) < 4 {
+		return policyError("transactions need to call a contract method")
+	}
+	name, ok := p.methods[contract][string(tx.Data()[:4])]
+	if !ok {
+		return policyError("method %x of %v is not allowed", tx.Data()[:4], contract)
+	}
+	if name == "LivepeerToken.approve" {
+		if err := p.checkApprove(tx.Data()[4:]); err != nil {
+			return err
+		}
+	}
+
+	cost := new(big.Int).Mul(tx.Gas(), tx.GasPrice())
+	cost.Add(cost, tx.Value())
+	if p.MaxTxCost != nil && cost.Cmp(p.MaxTxCost) > 0 {
+		return policyError("tx cost %v is over the limit of %v", cost, p.MaxTxCost)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if time.Since(p.windowStart) > SpendWindow {
+		p.windowStart = time.Now()
+		p.spent = big.NewInt(0)
+	}
+	spent := new(big.Int).Add(p.spent, cost)
+	if p.MaxWindowCost != nil && spent.Cmp(p.MaxWindowCost) > 0 {
+		return policyError("tx cost %v would exceed the spending limit of %v per %v (spent %v)", cost, p.MaxWindowCost, SpendWindow, p.spent)
+	}
+	p.spent = spent
+	return nil
+}
+
+//checkApprove checks the arguments of an approve(address spender, uint256 value) call.
+func (p *Policy) checkApprove(args []byte) error {
+	if len(args) != 64 {
+		return policyError("invalid approve arguments")
+	}
+	spender := common.BytesToAddress(args[12:32])
+	if !approveSpenders[p.Contracts[spender]] {
+		return policyError("approving %v to spend tokens is not allowed", spender.Hex())
+	}
+	value := new(big.Int).SetBytes(args[32:64])
+	if p.MaxApprove == nil || value.Cmp(p.MaxApprove) > 0 {
+		return policyError("approving %v tokens is over the limit of %v", value, p.MaxApprove)
+	}
+	return nil
+}
+
+//MethodName returns the name of the method tx calls, or "" if the method is not allowed.
+func (p *Policy) MethodName(tx *types.Transaction) string {
+	if tx.To() == nil || len(tx.Data()) < 4 {
+		return ""
+	}
+	return p.methods[p.Contracts[*tx.To()]][string(tx.Data()[:4])]
+}
+
+type policyErr struct {
+	reason string
+}
+
+func (e policyErr) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPolicy, e.reason)
+}
+
+func policyError(format string, args ...interface{}) error {
+	return policyErr{reason: fmt.Sprintf(format, args...)}
+}
+
+//IsPolicyError returns whether err is a policy violation.
+func IsPolicyError(err error) bool {
+	_, ok := err.(policyErr)
+	return ok
+}
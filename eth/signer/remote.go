@@ -0,0 +1,154 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/glog"
+)
+
+//RemoteSignerTimeout is how long to wait for the signer process.
+var RemoteSignerTimeout = 30 * time.Second
+
+//The messages exchanged with the signer process
+type accountResponse struct {
+	Address common.Address
+}
+
+type signTxRequest struct {
+	Tx hexutil.Bytes //RLP encoded
+}
+
+type signTxResponse struct {
+	Tx hexutil.Bytes //RLP encoded
+}
+
+type signSegmentHashRequest struct {
+	Hash hexutil.Bytes
+}
+
+type signSegmentHashResponse struct {
+	Sig hexutil.Bytes
+}
+
+//RemoteSigner delegates signing to a signer process (see Server).
+type RemoteSigner struct {
+	url       string
+	authToken string
+	client    *http.Client
+	account   accounts.Account
+}
+
+//NewRemoteSigner connects to the signer at addr, which is either a unix socket (unix:///path/to/socket) or an http url.  authToken is
+//the token the signer was started with, and is required over http, where anyone who can reach the signer could use it otherwise.
+func NewRemoteSigner(addr string, authToken string) (*RemoteSigner, error) {
+	if !strings.HasPrefix(addr, "unix://") && authToken == "" {
+		return nil, ErrAuthToken
+	}
+	s := &RemoteSigner{url: strings.TrimSuffix(addr, "/"), authToken: authToken, client: &http.Client{Timeout: RemoteSignerTimeout}}
+	if strings.HasPrefix(addr, "unix://") {
+		path := strings.TrimPrefix(addr, "unix://")
+		s.url = "http://signer"
+		s.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+
+	var resp accountResponse
+	if err := s.call("GET", "/account", nil, &resp); err != nil {
+		glog.Errorf("Error getting account from signer at %v: %v", addr, err)
+		return nil, err
+	}
+	s.account = accounts.Account{Address: resp.Address}
+	return s, nil
+}
+
+func (s *RemoteSigner) Account() accounts.Account {
+	return s.account
+}
+
+func (s *RemoteSigner) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp signTxResponse
+	if err := s.call("POST", "/signTx", signTxRequest{Tx: data}, &resp); err != nil {
+		glog.Errorf("Error signing tx %v: %v", tx.Hash().Hex(), err)
+		return nil, err
+	}
+
+	signed := new(types.Transaction)
+	if err := rlp.DecodeBytes(resp.Tx, signed); err != nil {
+		return nil, err
+	}
+	//Make sure the signer didn't change anything but the signature
+	hs := types.HomesteadSigner{}
+	if hs.Hash(signed) != hs.Hash(tx) {
+		return nil, fmt.Errorf("signer returned a different tx")
+	}
+	if from, err := types.Sender(hs, signed); err != nil || from != s.account.Address {
+		return nil, ErrUnauthorizedAccount
+	}
+	return signed, nil
+}
+
+func (s *RemoteSigner) SignSegmentHash(hash []byte) ([]byte, error) {
+	var resp signSegmentHashResponse
+	if err := s.call("POST", "/signSegmentHash", signSegmentHashRequest{Hash: hash}, &resp); err != nil {
+		glog.Errorf("Error signing segment hash: %v", err)
+		return nil, err
+	}
+	return resp.Sig, nil
+}
+
+func (s *RemoteSigner) call(method, path string, req interface{}, resp interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+
+	r, err := http.NewRequest(method, s.url+path, &body)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if s.authToken != "" {
+		r.Header.Set("Authorization", "Bearer "+s.authToken)
+	}
+	res, err := s.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusForbidden {
+		return policyError("%v", strings.TrimPrefix(strings.TrimSpace(string(data)), ErrPolicy.Error()+": "))
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", res.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, resp)
+}
@@ -0,0 +1,115 @@
+package signer
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/glog"
+)
+
+//Server serves signing requests from RemoteSigners.  Transactions are only signed if the policy allows them.
+type Server struct {
+	signer    Signer
+	policy    *Policy
+	authToken string
+	mux       *http.ServeMux
+}
+
+//NewServer creates a server that signs with signer.  If authToken isn't empty, requests need to send it in the Authorization header
+//(see RemoteSigner).
+func NewServer(signer Signer, policy *Policy, authToken string) *Server {
+	s := &Server{signer: signer, policy: policy, authToken: authToken, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/account", s.handleAccount)
+	s.mux.HandleFunc("/signTx", s.handleSignTx)
+	s.mux.HandleFunc("/signSegmentHash", s.handleSignSegmentHash)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.authToken != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.authToken)) != 1 {
+		glog.Errorf("Refusing unauthorized request for %v from %v", r.URL.Path, r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, accountResponse{Address: s.signer.Account().Address})
+}
+
+func (s *Server) handleSignTx(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req signTxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(req.Tx, tx); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.policy.Check(tx); err != nil {
+		glog.Errorf("Refusing to sign tx %v to %v: %v", tx.Hash().Hex(), tx.To(), err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	signed, err := s.signer.SignTx(tx)
+	if err != nil {
+		glog.Errorf("Error signing tx: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := rlp.EncodeToBytes(signed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Signed tx %v: %v to %v, nonce %v", signed.Hash().Hex(), s.policy.MethodName(tx), tx.To().Hex(), tx.Nonce())
+	writeJSON(w, signTxResponse{Tx: data})
+}
+
+func (s *Server) handleSignSegmentHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req signSegmentHashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//Only segment hashes can be signed here - anything else could be used to sign arbitrary messages
+	if len(req.Hash) != 32 {
+		http.Error(w, "Hash needs to be 32 bytes", http.StatusBadRequest)
+		return
+	}
+
+	sig, err := s.signer.SignSegmentHash(req.Hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, signSegmentHashResponse{Sig: sig})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
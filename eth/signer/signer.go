@@ -0,0 +1,86 @@
+/*
+Package signer holds the Ethereum keys of a node.  Transactions and segment hashes are signed either in process with a keystore, or by
+a separate signer process (see cmd/livepeer_signer) so that the keys never live in the media-facing node.
+*/
+package signer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/golang/glog"
+)
+
+var ErrUnauthorizedAccount = errors.New("ErrUnauthorizedAccount")
+var ErrAuthToken = errors.New("ErrAuthToken")
+
+//Signer signs transactions and segment hashes for a single account.
+type Signer interface {
+	Account() accounts.Account
+	//SignTx signs tx with the homestead signer, which is what the contract bindings use.
+	SignTx(tx *types.Transaction) (*types.Transaction, error)
+	//SignSegmentHash signs the hash of a segment with the Ethereum signed message prefix, so the contracts can verify it.
+	SignSegmentHash(hash []byte) ([]byte, error)
+}
+
+//TransactOpts returns contract binding options that sign transactions with s.
+func TransactOpts(s Signer) *bind.TransactOpts {
+	addr := s.Account().Address
+	return &bind.TransactOpts{
+		From: addr,
+		Signer: func(signer types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != addr {
+				return nil, ErrUnauthorizedAccount
+			}
+			if _, ok := signer.(types.HomesteadSigner); !ok {
+				return nil, fmt.Errorf("unsupported tx signer %T", signer)
+			}
+			return s.SignTx(tx)
+		},
+	}
+}
+
+//SegmentSignHash returns the hash that is actually signed for a segment hash.
+func SegmentSignHash(hash []byte) []byte {
+	msg := fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", 32, hash)
+	return crypto.Keccak256([]byte(msg))
+}
+
+//KeystoreSigner signs with a key from a local keystore.  The key is unlocked once when the signer is created, so the passphrase doesn't
+//need to be kept around.
+type KeystoreSigner struct {
+	account  accounts.Account
+	keyStore *keystore.KeyStore
+}
+
+//NewKeystoreSigner unlocks account in the keystore at keystoreDir.  Returns keystore.ErrDecrypt if the passphrase is wrong.
+func NewKeystoreSigner(account accounts.Account, passphrase string, keystoreDir string) (*KeystoreSigner, error) {
+	keyStore := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+	if err := keyStore.Unlock(account, passphrase); err != nil {
+		return nil, err
+	}
+	return &KeystoreSigner{account: account, keyStore: keyStore}, nil
+}
+
+func (s *KeystoreSigner) Account() accounts.Account {
+	return s.account
+}
+
+func (s *KeystoreSigner) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	return s.keyStore.SignTx(s.account, tx, nil)
+}
+
+func (s *KeystoreSigner) SignSegmentHash(hash []byte) ([]byte, error) {
+	sig, err := s.keyStore.SignHash(s.account, SegmentSignHash(hash))
+	if err != nil {
+		glog.Errorf("Error signing segment hash: %v", err)
+		return nil, err
+	}
+	return sig, nil
+}
@@ -0,0 +1,240 @@
+package signer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/eth/contracts"
+)
+
+type keySigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func (s *keySigner) Account() accounts.Account {
+	return accounts.Account{Address: crypto.PubkeyToAddress(s.key.PublicKey)}
+}
+
+func (s *keySigner) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	sig, err := crypto.Sign(types.HomesteadSigner{}.Hash(tx).Bytes(), s.key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithSignature(types.HomesteadSigner{}, sig)
+}
+
+func (s *keySigner) SignSegmentHash(hash []byte) ([]byte, error) {
+	return crypto.Sign(SegmentSignHash(hash), s.key)
+}
+
+func bondingManagerTx(t *testing.T, to common.Address, gas int64, method string, args ...interface{}) *types.Transaction {
+	return contractTx(t, contracts.BondingManagerABI, to, gas, method, args...)
+}
+
+func contractTx(t *testing.T, contractABI string, to common.Address, gas int64, method string, args ...interface{}) *types.Transaction {
+	a, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	data, err := a.Pack(method, args...)
+	if err != nil {
+		t.Fatalf("Error packing %v: %v", method, err)
+	}
+	return types.NewTransaction(0, to, big.NewInt(0), big.NewInt(gas), big.NewInt(1), data)
+}
+
+func TestPolicy(t *testing.T) {
+	if _, err := NewPolicy([]string{"BondingManager.nope"}); err == nil {
+		t.Errorf("Expecting error for unknown method")
+	}
+	if _, err := NewPolicy([]string{"BondingManager.transcoderStatus"}); err == nil {
+		t.Errorf("Expecting error for constant method")
+	}
+
+	p, err := NewPolicy([]string{"BondingManager.reward"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	bm := common.HexToAddress("0x1")
+	if err := p.Check(bondingManagerTx(t, bm, 100, "reward")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for a contract that isn't known, got %v", err)
+	}
+	p.Contracts[bm] = "BondingManager"
+	if err := p.Check(bondingManagerTx(t, bm, 100, "reward")); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+	if err := p.Check(bondingManagerTx(t, bm, 100, "unbond")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for unbond, got %v", err)
+	}
+	if err := p.Check(types.NewTransaction(0, bm, big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for plain transfer, got %v", err)
+	}
+
+	//Contracts
+	if err := p.Check(bondingManagerTx(t, common.HexToAddress("0x2"), 100, "reward")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for other contract, got %v", err)
+	}
+	//A method is only allowed on its own contract
+	p.Contracts[common.HexToAddress("0x3")] = "JobsManager"
+	if err := p.Check(bondingManagerTx(t, common.HexToAddress("0x3"), 100, "reward")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for the method on another contract, got %v", err)
+	}
+
+	//Spending limits
+	p.MaxTxCost = big.NewInt(200)
+	p.MaxWindowCost = big.NewInt(500)
+	if err := p.Check(bondingManagerTx(t, bm, 201, "reward")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for tx over the limit, got %v", err)
+	}
+	//100 was already spent above
+	for i := 0; i < 2; i++ {
+		if err := p.Check(bondingManagerTx(t, bm, 200, "reward")); err != nil {
+			t.Errorf("Error: %v", err)
+		}
+	}
+	if err := p.Check(bondingManagerTx(t, bm, 200, "reward")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for going over the window limit, got %v", err)
+	}
+}
+
+func TestPolicyApprove(t *testing.T) {
+	p, err := NewPolicy(DefaultMethods)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	token, bm, jm, other := common.HexToAddress("0x1"), common.HexToAddress("0x2"), common.HexToAddress("0x3"), common.HexToAddress("0x4")
+	p.Contracts = map[common.Address]string{token: "LivepeerToken", bm: "BondingManager", jm: "JobsManager"}
+	approve := func(spender common.Address, value int64) *types.Transaction {
+		return contractTx(t, contracts.LivepeerTokenABI, token, 100, "approve", spender, big.NewInt(value))
+	}
+
+	//No approvals without a cap
+	if err := p.Check(approve(bm, 1)); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error without MaxApprove, got %v", err)
+	}
+	p.MaxApprove = big.NewInt(1000)
+	for _, spender := range []common.Address{bm, jm} {
+		if err := p.Check(approve(spender, 1000)); err != nil {
+			t.Errorf("Error approving %v: %v", spender.Hex(), err)
+		}
+	}
+	if err := p.Check(approve(bm, 1001)); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for approving over the cap, got %v", err)
+	}
+	if err := p.Check(approve(other, 1)); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for approving another spender, got %v", err)
+	}
+	if err := p.Check(approve(token, 1)); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for approving the token itself, got %v", err)
+	}
+
+	//Transfers aren't allowed by default
+	if err := p.Check(contractTx(t, contracts.LivepeerTokenABI, token, 100, "transfer", other, big.NewInt(1))); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error for transfer, got %v", err)
+	}
+}
+
+//controllerCaller answers getContract calls of the Controller.
+type controllerCaller struct {
+	addrs map[common.Hash]common.Address
+}
+
+func (c *controllerCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (c *controllerCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return common.LeftPadBytes(c.addrs[common.BytesToHash(call.Data[4:36])].Bytes(), 32), nil
+}
+
+func TestResolveContracts(t *testing.T) {
+	p, err := NewPolicy(DefaultMethods)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	c := &controllerCaller{addrs: make(map[common.Hash]common.Address)}
+	for i, name := range []string{"BondingManager", "JobsManager", "RoundsManager", "LivepeerToken"} {
+		c.addrs[crypto.Keccak256Hash([]byte(name))] = common.BigToAddress(big.NewInt(int64(i + 1)))
+	}
+	if err := p.ResolveContracts(c, common.HexToAddress("0xc")); err == nil {
+		t.Errorf("Expecting error for a contract that isn't registered")
+	}
+
+	c.addrs[crypto.Keccak256Hash([]byte("LivepeerTokenFaucet"))] = common.HexToAddress("0x5")
+	if err := p.ResolveContracts(c, common.HexToAddress("0xc")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(p.Contracts) != 5 || p.Contracts[common.HexToAddress("0x1")] != "BondingManager" || p.Contracts[common.HexToAddress("0x5")] != "LivepeerTokenFaucet" {
+		t.Errorf("Wrong contracts: %v", p.Contracts)
+	}
+	if err := p.Check(bondingManagerTx(t, common.HexToAddress("0x1"), 100, "reward")); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ks := &keySigner{key: key}
+	p, err := NewPolicy([]string{"BondingManager.reward"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	p.Contracts[common.HexToAddress("0x1")] = "BondingManager"
+	ts := httptest.NewServer(NewServer(ks, p, "token"))
+	defer ts.Close()
+
+	//http needs the auth token
+	if _, err := NewRemoteSigner(ts.URL, ""); err != ErrAuthToken {
+		t.Errorf("Expecting ErrAuthToken, got %v", err)
+	}
+	if _, err := NewRemoteSigner(ts.URL, "wrong"); err == nil {
+		t.Errorf("Expecting error for the wrong token")
+	}
+	rs, err := NewRemoteSigner(ts.URL, "token")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if rs.Account().Address != ks.Account().Address {
+		t.Errorf("Expecting %v, got %v", ks.Account().Address.Hex(), rs.Account().Address.Hex())
+	}
+
+	//Transactions go through the bindings
+	opts := TransactOpts(rs)
+	tx := bondingManagerTx(t, common.HexToAddress("0x1"), 100, "reward")
+	signed, err := opts.Signer(types.HomesteadSigner{}, opts.From, tx)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if from, err := types.Sender(types.HomesteadSigner{}, signed); err != nil || from != ks.Account().Address {
+		t.Errorf("Expecting tx from %v, got %v %v", ks.Account().Address.Hex(), from.Hex(), err)
+	}
+	if _, err := rs.SignTx(bondingManagerTx(t, common.HexToAddress("0x1"), 100, "unbond")); !IsPolicyError(err) {
+		t.Errorf("Expecting policy error, got %v", err)
+	}
+
+	hash := crypto.Keccak256([]byte("segment"))
+	sig, err := rs.SignSegmentHash(hash)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	pub, err := crypto.SigToPub(SegmentSignHash(hash), sig)
+	if err != nil || crypto.PubkeyToAddress(*pub) != ks.Account().Address {
+		t.Errorf("Segment hash signature doesn't match the account")
+	}
+	if _, err := rs.SignSegmentHash([]byte("not a hash")); err == nil {
+		t.Errorf("Expecting error signing arbitrary data")
+	}
+}
@@ -39,6 +39,8 @@ type StubClient struct {
 	JobsMap           map[string]*Job
 	BlockNum          *big.Int
 	BlockHashToReturn common.Hash
+	Claims            []*Claim
+	TranscoderPool    map[common.Address]bool
 }
 
 func (e *StubClient) Backend() *ethclient.Client { return nil }
@@ -72,7 +74,7 @@ func (e *StubClient) Job(streamId string, transcodingOptions string, maxPricePer
 func (e *StubClient) JobDetails(id *big.Int) (*big.Int, [32]byte, *big.Int, common.Address, common.Address, *big.Int, error) {
 	return nil, [32]byte{}, nil, common.Address{}, common.Address{}, nil, nil
 }
-func (e *StubClient) SignSegmentHash(passphrase string, hash []byte) ([]byte, error) { return nil, nil }
+func (e *StubClient) SignSegmentHash(hash []byte) ([]byte, error) { return nil, nil }
 func (e *StubClient) ClaimWork(jobId *big.Int, segmentRange [2]*big.Int, transcodeClaimsRoot [32]byte) (<-chan types.Receipt, <-chan error) {
 	e.ClaimCounter++
 	e.ClaimJid = append(e.ClaimJid, jobId)
@@ -121,11 +123,17 @@ func (e *StubClient) GetJob(jobID *big.Int) (*Job, error) {
 	return e.JobsMap[jobID.String()], nil
 }
 func (c *StubClient) GetClaim(jobID *big.Int, claimID *big.Int) (*Claim, error) {
+	if claimID.Int64() < int64(len(c.Claims)) {
+		return c.Claims[claimID.Int64()], nil
+	}
 	return nil, nil
 }
 func (c *StubClient) IsRegisteredTranscoder() (bool, error) {
 	return false, nil
 }
+func (c *StubClient) IsTranscoderInPool(addr common.Address) (bool, error) {
+	return c.TranscoderPool[addr], nil
+}
 func (c *StubClient) RpcTimeout() time.Duration {
 	return time.Millisecond
 }
@@ -21,6 +21,7 @@ import (
 
 type IpfsApi interface {
 	Add(r io.Reader) (string, error)
+	Get(cid string) (io.ReadCloser, error)
 }
 
 type IpfsCoreApi core.IpfsNode
@@ -58,13 +59,9 @@ func StartIpfs(ctx context.Context, repoPath string) (*IpfsCoreApi, error) {
 	}
 
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				glog.Infof("Closing IPFS...")
-				closeIpfs(node, repoPath)
-			}
-		}
+		<-ctx.Done()
+		glog.Infof("Closing IPFS...")
+		closeIpfs(node, repoPath)
 	}()
 
 	return (*IpfsCoreApi)(node), nil
@@ -81,6 +78,12 @@ func (ipfs *IpfsCoreApi) Add(r io.Reader) (string, error) {
 	return addAndPin(node.Context(), node, r)
 }
 
+//Get returns the data for a content id, fetching it from the network if it's not local.
+func (ipfs *IpfsCoreApi) Get(cid string) (io.ReadCloser, error) {
+	node := ipfs.node()
+	return coreunix.Cat(node.Context(), node, "/ipfs/"+cid)
+}
+
 func addAndPin(ctx context.Context, n *core.IpfsNode, r io.Reader) (string, error) {
 	defer n.Blockstore.PinLock().Unlock()
 
@@ -126,3 +129,8 @@ func addAndPin(ctx context.Context, n *core.IpfsNode, r io.Reader) (string, erro
 func (ipfs *IpfsCoreApi) node() *core.IpfsNode {
 	return (*core.IpfsNode)(ipfs)
 }
+
+//Close shuts down the IPFS node and releases the repo.
+func (ipfs *IpfsCoreApi) Close() error {
+	return ipfs.node().Close()
+}
@@ -1,9 +1,16 @@
 package ipfs
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 )
 
 type StubIpfsApi struct{}
 
-func (s *StubIpfsApi) Add(r io.Reader) (string, error) { return "", nil }
+func (s *StubIpfsApi) Add(r io.Reader) (string, error) {
+	return "QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC", nil
+}
+func (s *StubIpfsApi) Get(cid string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(nil)), nil
+}
@@ -72,6 +72,11 @@ func (m *Monitor) RemoveRelay(strmID string) {
 	m.Node.RemoveRelay(strmID)
 }
 
+//LogUploadRate records the upload of the node in bytes per second.
+func (m *Monitor) LogUploadRate(rate int64) {
+	m.Node.AvgBandwidth = uint32(rate)
+}
+
 func (m *Monitor) LogSub(strmID string) {
 	m.Node.SetSub(strmID)
 }
@@ -1,13 +1,11 @@
 package net
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"math/big"
-	"strings"
 
 	"github.com/ericxtang/m3u8"
-	"github.com/golang/glog"
+	ethcommon "github.com/ethereum/go-ethereum/common"
 	lpmscore "github.com/livepeer/lpms/core"
 	"github.com/livepeer/lpms/stream"
 )
@@ -27,53 +25,81 @@ type VideoNetwork interface {
 	String() string
 }
 
-type TranscodeConfig struct {
-	StrmID              string
-	Profiles            []lpmscore.VideoProfile
-	PerformOnchainClaim bool
-	JobID               *big.Int
+//SegmentRouter is implemented by networks that can send the segments of a transcode job straight to the transcoder, and get the transcoded
+//segments back.
+type SegmentRouter interface {
+	//SetSegmentRoute sends the segments of strmID to the transcoder at url, empty to send them through the network only.  format is the
+	//segment format the transcoder gets them in, which can differ from the format they are broadcast in.
+	SetSegmentRoute(strmID string, url string, format string)
+	//SetTranscodedStreams tells the transcoder which streams the segments of strmID are transcoded into, nil once the job is done.
+	//broadcaster is the Eth address the segments have to be signed with, empty if the signatures aren't checked.
+	SetTranscodedStreams(strmID string, transcodedStrmIDs []string, broadcaster ethcommon.Address)
 }
 
-type Transcoder interface {
-	Transcode(strmID string, config TranscodeConfig, gotPlaylist func(masterPlaylist []byte)) error
+//CapabilityAnnouncer is implemented by networks that spread the capability announcements of nodes to the whole network.  The network
+//checks that announcements come from the node they say, but doesn't look into them - the nodes check what they say.
+type CapabilityAnnouncer interface {
+	//AnnounceCapabilities sends the announcement of the node to the network.
+	AnnounceCapabilities(data []byte) error
+	//ReceivedCapabilities sets the function called with the announcements of other nodes.  nodeID is the node that made and signed the
+	//announcement.  gotAnnouncement returns false for announcements that don't check out, which aren't passed on.
+	ReceivedCapabilities(gotAnnouncement func(nodeID string, data []byte) bool)
+}
+
+//StreamAnnouncer is implemented by networks that spread the stream announcements of broadcasters to the whole network, like
+//CapabilityAnnouncer.
+type StreamAnnouncer interface {
+	AnnounceStream(data []byte) error
+	ReceivedStreamAnnouncements(gotAnnouncement func(nodeID string, data []byte) bool)
+}
+
+//Announcer spreads both kinds of announcements, for networks that leave them to another protocol.
+type Announcer interface {
+	CapabilityAnnouncer
+	StreamAnnouncer
 }
 
-type NodeStatus struct {
-	NodeID    string
-	Manifests map[string]*m3u8.MasterPlaylist
+//AddrsReporter is implemented by networks that know the addresses other nodes reach the node on.  They change as the network finds out
+//whether the node can be reached from outside.
+type AddrsReporter interface {
+	//Addrs are the multiaddrs the node advertises.
+	Addrs() []string
 }
 
-func (n NodeStatus) String() string {
-	mstrs := make([]string, 0)
-	for mid, m := range n.Manifests {
-		mstrs = append(mstrs, fmt.Sprintf("%v[]%v", mid, m.String()))
-	}
-	str := fmt.Sprintf("%v|%v", n.NodeID, strings.Join(mstrs, "|"))
-	return str
+//ReachabilityNetwork is implemented by networks that find out which of their addresses can be reached from outside, by asking peers.
+type ReachabilityNetwork interface {
+	//CheckableAddrs are the public addresses peers should check, and the listen port to check on the address each peer sees the node at.
+	//port is 0 when the node advertises fixed addresses, and there is nothing to check.
+	CheckableAddrs() (addrs []string, port int)
+	//SetReachableAddrs sets the addresses peers could reach.  When there are none, the node advertises addresses through relays if it
+	//can.
+	SetReachableAddrs(addrs []string)
 }
 
-func (n *NodeStatus) FromString(str string) error {
-	arr := strings.Split(str, "|")
-	if len(arr[0]) != 68 {
-		return errors.New("Wrong format for NodeStatus")
-	} else {
-		n.NodeID = arr[0]
-	}
+//UpstreamReporter is implemented by networks that know which peer the segments of a stream come from, so the node can tell which peer
+//sent a bad segment.
+type UpstreamReporter interface {
+	//UpstreamPeer is the node ID of the peer strmID comes from, empty if the node doesn't know it.
+	UpstreamPeer(strmID string) string
+}
 
-	manifests := make(map[string]*m3u8.MasterPlaylist, 0)
-	for _, mstr := range arr[1:] {
-		//Decode the playlist from a string
-		mstrArr := strings.Split(mstr, "[]")
-		if len(mstrArr) == 2 {
-			m := m3u8.NewMasterPlaylist()
-			if err := m.DecodeFrom(strings.NewReader(mstrArr[1]), true); err != nil {
-				glog.Errorf("Error decoding playlist: %v", err)
-			} else {
-				manifests[mstrArr[0]] = m
-			}
-		}
-	}
-	n.Manifests = manifests
+//DrainingSubscriber is implemented by subscribers that queue the segments they get before passing them on, so the node can finish the
+//queued segments before it shuts down.
+type DrainingSubscriber interface {
+	//Drain stops taking segments from the network, and returns once the queued ones have been passed to gotData, or ctx is done.
+	//Unsubscribe still has to be called.
+	Drain(ctx context.Context) error
+}
 
-	return nil
+type TranscodeConfig struct {
+	StrmID              string
+	Profiles            []lpmscore.VideoProfile
+	PerformOnchainClaim bool
+	JobID               *big.Int
+	//BroadcasterAddress is the Eth address the segments are signed with, the signatures aren't checked if it's empty
+	BroadcasterAddress ethcommon.Address
+}
+
+type Transcoder interface {
+	Transcode(strmID string, config TranscodeConfig, gotPlaylist func(masterPlaylist []byte)) error
 }
@@ -0,0 +1,200 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/golang/glog"
+)
+
+var ErrNodeStatus = errors.New("ErrNodeStatus")
+
+//NodeStatusVersion is the version of the NodeStatus encoding.  Versions only ever add fields, so nodes decode the status of newer nodes
+//and leave out what they don't know.
+const NodeStatusVersion = 4
+
+//NodeStatus is what a node tells other nodes about itself.
+type NodeStatus struct {
+	NodeID    string
+	Manifests map[string]*m3u8.MasterPlaylist
+	//Broadcasts are the streams the node sends to the network
+	Broadcasts []string
+	//Subscriptions are the streams the node gets from the network
+	Subscriptions []string
+	//Relays are the streams the node passes on between other nodes
+	Relays []RelayStatus
+	//Jobs are the transcode jobs the node is working on
+	Jobs []JobStatus
+	//Version is the software version of the node
+	Version      string
+	Capabilities []string
+	EthAddress   string
+	//UploadCapacity and UploadRate are in bytes per second.  UploadCapacity is 0 if the node doesn't limit its upload
+	UploadCapacity int64
+	UploadRate     int64
+	//SegmentURL is where the node takes the segments of its transcode jobs over HTTP, empty if it only takes them from the network
+	SegmentURL string
+	//Addrs are the addresses other nodes reach the node on, as multiaddrs
+	Addrs []string
+	//StatusVersion is the encoding version the status was sent in, 0 for the format of nodes from before the versioned encoding
+	StatusVersion int
+}
+
+//NodeStatusReporter is implemented by networks that report the status of the node to other nodes.  The network fills in the streams it
+//knows about, f adds what only the node knows.
+type NodeStatusReporter interface {
+	SetNodeStatusFunc(f func(status *NodeStatus))
+}
+
+type RelayStatus struct {
+	StreamID     string
+	UpstreamPeer string
+	Listeners    int
+	//Bytes and Rate are what the node sent of the stream, in total and in bytes per second
+	Bytes uint64 `json:",omitempty"`
+	Rate  int64  `json:",omitempty"`
+}
+
+type JobStatus struct {
+	StreamID string
+	//JobID is the on-chain job ID, empty for offchain jobs
+	JobID    string
+	Profiles []string
+}
+
+//nodeStatusMsg is the encoding of NodeStatus.  Playlists are sent as text, since MasterPlaylist doesn't marshal to JSON.
+type nodeStatusMsg struct {
+	StatusVersion  int
+	NodeID         string
+	Manifests      map[string]string
+	Broadcasts     []string      `json:",omitempty"`
+	Subscriptions  []string      `json:",omitempty"`
+	Relays         []RelayStatus `json:",omitempty"`
+	Jobs           []JobStatus   `json:",omitempty"`
+	Version        string        `json:",omitempty"`
+	Capabilities   []string      `json:",omitempty"`
+	EthAddress     string        `json:",omitempty"`
+	UploadCapacity int64         `json:",omitempty"`
+	UploadRate     int64         `json:",omitempty"`
+	SegmentURL     string        `json:",omitempty"`
+	Addrs          []string      `json:",omitempty"`
+}
+
+//String encodes the status as a JSON object with the encoding version in StatusVersion.
+func (n NodeStatus) String() string {
+	msg := nodeStatusMsg{
+		StatusVersion:  NodeStatusVersion,
+		NodeID:         n.NodeID,
+		Manifests:      make(map[string]string),
+		Broadcasts:     n.Broadcasts,
+		Subscriptions:  n.Subscriptions,
+		Relays:         n.Relays,
+		Jobs:           n.Jobs,
+		Version:        n.Version,
+		Capabilities:   n.Capabilities,
+		EthAddress:     n.EthAddress,
+		UploadCapacity: n.UploadCapacity,
+		UploadRate:     n.UploadRate,
+		SegmentURL:     n.SegmentURL,
+		Addrs:          n.Addrs,
+	}
+	for mid, m := range n.Manifests {
+		msg.Manifests[mid] = m.String()
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		glog.Errorf("Error encoding node status: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+//Encode encodes the status for a node that decodes version and older.  Nodes that didn't say which versions they decode (version 0) get the
+//"|" separated format, which only has the node ID and the manifests, since they reject anything else.
+func (n NodeStatus) Encode(version int) string {
+	if version < 1 {
+		return n.LegacyString()
+	}
+	return n.String()
+}
+
+//LegacyString encodes the status in the format of nodes from before the versioned encoding: <node ID>|<manifest ID>[]<playlist>|...
+func (n NodeStatus) LegacyString() string {
+	mstrs := make([]string, 0)
+	for mid, m := range n.Manifests {
+		mstrs = append(mstrs, fmt.Sprintf("%v[]%v", mid, m.String()))
+	}
+	return fmt.Sprintf("%v|%v", n.NodeID, strings.Join(mstrs, "|"))
+}
+
+//FromString decodes a status from String, or from the "|" separated format of older nodes.
+func (n *NodeStatus) FromString(str string) error {
+	if !strings.HasPrefix(str, "{") {
+		return n.fromLegacyString(str)
+	}
+	var msg nodeStatusMsg
+	if err := json.Unmarshal([]byte(str), &msg); err != nil {
+		glog.Errorf("Error decoding node status: %v", err)
+		return ErrNodeStatus
+	}
+	if msg.StatusVersion < 1 || msg.NodeID == "" {
+		return ErrNodeStatus
+	}
+
+	manifests := make(map[string]*m3u8.MasterPlaylist)
+	for mid, mstr := range msg.Manifests {
+		m := m3u8.NewMasterPlaylist()
+		if err := m.DecodeFrom(strings.NewReader(mstr), true); err != nil {
+			glog.Errorf("Error decoding playlist: %v", err)
+			continue
+		}
+		manifests[mid] = m
+	}
+	*n = NodeStatus{
+		NodeID:         msg.NodeID,
+		Manifests:      manifests,
+		Broadcasts:     msg.Broadcasts,
+		Subscriptions:  msg.Subscriptions,
+		Relays:         msg.Relays,
+		Jobs:           msg.Jobs,
+		Version:        msg.Version,
+		Capabilities:   msg.Capabilities,
+		EthAddress:     msg.EthAddress,
+		UploadCapacity: msg.UploadCapacity,
+		UploadRate:     msg.UploadRate,
+		SegmentURL:     msg.SegmentURL,
+		Addrs:          msg.Addrs,
+		StatusVersion:  msg.StatusVersion,
+	}
+	return nil
+}
+
+//fromLegacyString decodes the status of nodes from before the versioned encoding: <node ID>|<manifest ID>[]<playlist>|...
+func (n *NodeStatus) fromLegacyString(str string) error {
+	arr := strings.Split(str, "|")
+	if arr[0] == "" {
+		return ErrNodeStatus
+	}
+	n.NodeID = arr[0]
+
+	manifests := make(map[string]*m3u8.MasterPlaylist, 0)
+	for _, mstr := range arr[1:] {
+		//Decode the playlist from a string
+		mstrArr := strings.Split(mstr, "[]")
+		if len(mstrArr) == 2 {
+			m := m3u8.NewMasterPlaylist()
+			if err := m.DecodeFrom(strings.NewReader(mstrArr[1]), true); err != nil {
+				glog.Errorf("Error decoding playlist: %v", err)
+			} else {
+				manifests[mstrArr[0]] = m
+			}
+		}
+	}
+	n.Manifests = manifests
+	n.StatusVersion = 0
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package net
+
+//RelayLimiter keeps the upload of a node within its capacity.  The network asks it before it relays a stream to one more peer, and tells it
+//the bytes it sends out.
+type RelayLimiter interface {
+	//AllowRelay tells if the node has the upload to relay strmID to one more peer, on top of the listeners it relays it to already.
+	AllowRelay(strmID string, listeners int) bool
+	//Sent counts the bytes of strmID sent to a peer.  relayed is false for the streams the node broadcasts itself.  It returns true when
+	//the node is over capacity, and should shed the peer of the relayed stream.
+	Sent(strmID string, bytes int, relayed bool) bool
+	//Load is the upload of the node.
+	Load() RelayLoad
+}
+
+//RelayLoad is the upload of a node, in bytes per second.
+type RelayLoad struct {
+	//Capacity is 0 if the upload isn't limited
+	Capacity int64
+	Rate     int64
+	Streams  map[string]StreamLoad
+}
+
+//StreamLoad is the upload for one stream.  Bytes is the total since the node started sending the stream.
+type StreamLoad struct {
+	Bytes uint64
+	Rate  int64
+}
+
+//RelayRefuser tells peers the node won't relay a stream to them, and moves the subscriptions of the node when its peers refuse it.
+type RelayRefuser interface {
+	//RefuseRelay tells nodeID the node won't relay strmID to it, and points it to the node IDs in redirect to get the stream from instead.
+	RefuseRelay(strmID string, nodeID string, redirect []string)
+}
+
+//RedirectNetwork is what a RelayRefuser needs from the network.
+type RedirectNetwork interface {
+	//Redirect moves the subscription and the relay of strmID that come from nodeID to the first node in redirect that takes the
+	//subscription request.  It returns false if strmID doesn't come from nodeID, or none of redirect took it.
+	Redirect(strmID string, nodeID string, redirect []string) bool
+}
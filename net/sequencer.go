@@ -0,0 +1,39 @@
+package net
+
+//SegmentSequencer passes on the segments of a subscription in order, and finds the ones that were lost on the way.  Segments that arrive
+//after a gap wait for the missing ones, which the network asks the broadcaster for again.  When they don't come, the sequencer gives up on
+//them and passes on the segments after the gap, so the subscriber sees a jump in seqNo only for segments that are lost for good.
+type SegmentSequencer interface {
+	//Insert takes a segment that arrived, and returns the segments that can be passed on now, in order.  Segments that were passed on or
+	//given up on already are dropped.
+	Insert(seqNo uint64, data []byte) []Segment
+	//Check is called every now and then.  It returns the segments that can be passed on after giving up on a gap, and the seqNos to ask
+	//the broadcaster for again.
+	Check() (ready []Segment, missing []uint64)
+	//Flush gives up on all the gaps, and returns the segments waiting behind them.  It is called when the subscription stops taking
+	//segments.
+	Flush() []Segment
+}
+
+//Segment is a segment of a stream as the network carries it.
+type Segment struct {
+	SeqNo uint64
+	Data  []byte
+}
+
+//SegmentRetransmitter asks peers for segments a subscription lost on the way.
+type SegmentRetransmitter interface {
+	//Retransmit asks for seqNos of strmID, and inserts the segments that come back into the subscription.  It blocks until the peers
+	//answered or timed out.
+	Retransmit(strmID string, seqNos []uint64)
+}
+
+//RetransmitNetwork is what a SegmentRetransmitter needs from the network.
+type RetransmitNetwork interface {
+	//RecentSegments returns the segments out of seqNos that the node still has of a stream it broadcasts.
+	RecentSegments(strmID string, seqNos []uint64) []Segment
+	//InsertSegment inserts a segment into the subscription of strmID.
+	InsertSegment(strmID string, seg Segment) error
+	//UpstreamReporter tells which peer to ask when the broadcaster doesn't answer
+	UpstreamReporter
+}
@@ -0,0 +1,297 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	gonet "net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	lpnet "github.com/livepeer/go-livepeer/net"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	manet "gx/ipfs/QmX3U3YXCQ6UYBxq2LVWF8dARS1hPUTEYLrSx654Qyxyw6/go-multiaddr-net"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+	circuit "gx/ipfs/QmfHWhmJSJD9RjogJdPsb7wzJbUkxpZkctHvAfvJCTAP6X/go-libp2p-circuit"
+)
+
+var ErrPublicAddr = errors.New("ErrPublicAddr")
+
+//ReachabilityProtocol carries a reachabilityReq, answered with a reachabilityResp on the same stream.  It is separate from the basicnet
+//protocol, since older nodes close the stream on messages they don't know.
+const ReachabilityProtocol = protocol.ID("/livepeer_reachability/0.0.1")
+
+var ReachabilityTimeout = 10 * time.Second
+
+//ReachabilityDialTimeout is how long a peer tries to connect to each address it checks.
+var ReachabilityDialTimeout = 5 * time.Second
+
+//AddrCheckDelay is how long the node waits for peers before it first checks its addresses.  After that, it checks them every
+//AddrCheckInterval.
+var AddrCheckDelay = 30 * time.Second
+var AddrCheckInterval = 5 * time.Minute
+
+//ReachabilityPeers is how many peers check the addresses of the node each time.
+const ReachabilityPeers = 3
+
+//MinReachabilityAgreement is how many peers have to reach an address before it's advertised, so one peer can't make the node advertise
+//addresses that don't work.  With fewer answers, the addresses stay as they are.
+const MinReachabilityAgreement = 2
+
+//MaxReachabilityAddrs is how many addresses a peer checks for one request.
+const MaxReachabilityAddrs = 4
+
+//reachabilityReq asks a peer to check whether it can reach the node.  The peer checks Port on the address it sees the node at, and the
+//Addrs on the same IP.
+type reachabilityReq struct {
+	Port  int
+	Addrs []string
+}
+
+//reachabilityResp has the address the peer sees the node at, and the addresses it could connect to.
+type reachabilityResp struct {
+	Observed  string
+	Reachable []string
+}
+
+//AddrsChecker asks peers which public addresses of the node they can reach, and checks the addresses of the peers that ask.
+type AddrsChecker struct {
+	host    host.Host
+	network lpnet.ReachabilityNetwork
+}
+
+//NewAddrsChecker starts answering reachability requests on h.  Start checks the addresses of nw.
+func NewAddrsChecker(h host.Host, nw lpnet.ReachabilityNetwork) *AddrsChecker {
+	c := &AddrsChecker{host: h, network: nw}
+	h.SetStreamHandler(ReachabilityProtocol, c.handleReachabilityReq)
+	return c
+}
+
+//Start checks the addresses of the node every AddrCheckInterval, after waiting AddrCheckDelay for peers.
+func (c *AddrsChecker) Start() {
+	go func() {
+		time.Sleep(AddrCheckDelay)
+		ticker := time.NewTicker(AddrCheckInterval)
+		defer ticker.Stop()
+		for {
+			c.Check()
+			<-ticker.C
+		}
+	}()
+}
+
+//Check asks ReachabilityPeers peers which addresses of the node they can reach.  Only the addresses the node asked about are taken, and
+//only when MinReachabilityAgreement peers reached them.
+func (c *AddrsChecker) Check() {
+	candidates, port := c.network.CheckableAddrs()
+	if port == 0 {
+		return
+	}
+	asked := make(map[string]bool)
+	for _, s := range candidates {
+		if a, err := ma.NewMultiaddr(s); err == nil {
+			asked[a.String()] = true
+		}
+	}
+
+	peers := c.host.Network().Peers()
+	for i := range peers {
+		j := rand.Intn(i + 1)
+		peers[i], peers[j] = peers[j], peers[i]
+	}
+	agreed := make(map[string]int)
+	answered := 0
+	for _, p := range peers {
+		if answered == ReachabilityPeers {
+			break
+		}
+		if directConn(c.host.Network(), p) == nil {
+			continue
+		}
+		resp, err := c.requestReachability(p, reachabilityReq{Port: port, Addrs: candidates})
+		if err != nil {
+			//Nodes from before the checks don't take the requests
+			glog.V(4).Infof("Cannot check addresses with %v: %v", peer.IDHexEncode(p), err)
+			continue
+		}
+		answered++
+		glog.V(4).Infof("%v sees the node at %v, and reached %v", peer.IDHexEncode(p), resp.Observed, resp.Reachable)
+		seen := make(map[string]bool)
+		for _, s := range resp.Reachable {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil || seen[a.String()] {
+				continue
+			}
+			//The peer was asked for the candidates, and the listen port on the address it sees the node at
+			tcp, ok := tcpAddr(a)
+			if !asked[a.String()] && (!ok || tcp.Port != port) {
+				glog.Errorf("%v reached %v, which the node didn't ask about", peer.IDHexEncode(p), s)
+				continue
+			}
+			seen[a.String()] = true
+			agreed[a.String()]++
+		}
+	}
+	if answered < MinReachabilityAgreement {
+		glog.V(4).Infof("Not enough peers to check the addresses of the node, %v answered", answered)
+		return
+	}
+
+	reachable := make([]string, 0)
+	for a, n := range agreed {
+		if n >= MinReachabilityAgreement {
+			reachable = append(reachable, a)
+		}
+	}
+	sort.Strings(reachable)
+	c.network.SetReachableAddrs(reachable)
+}
+
+func (c *AddrsChecker) requestReachability(pid peer.ID, req reachabilityReq) (*reachabilityResp, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ReachabilityTimeout)
+	defer cancel()
+	s, err := c.host.NewStream(ctx, pid, ReachabilityProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	//The peer dials the addresses one by one
+	s.SetDeadline(time.Now().Add(ReachabilityTimeout + MaxReachabilityAddrs*ReachabilityDialTimeout))
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp reachabilityResp
+	if err := json.NewDecoder(s).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+//handleReachabilityReq tells the node the address it's seen at, and which of its addresses the node can connect to.  Only addresses on
+//the IP the request came from are checked, so nodes can't be used to connect to others.
+func (c *AddrsChecker) handleReachabilityReq(s net.Stream) {
+	defer s.Close()
+	remotePID := s.Conn().RemotePeer()
+	remote := s.Conn().RemoteMultiaddr()
+	s.SetDeadline(time.Now().Add(ReachabilityTimeout + MaxReachabilityAddrs*ReachabilityDialTimeout))
+	var req reachabilityReq
+	if err := json.NewDecoder(s).Decode(&req); err != nil {
+		glog.Errorf("Error decoding reachability request from %v: %v", peer.IDHexEncode(remotePID), err)
+		return
+	}
+
+	resp := reachabilityResp{Observed: remote.String(), Reachable: make([]string, 0)}
+	if from, ok := tcpAddr(remote); ok {
+		candidates := make([]*gonet.TCPAddr, 0)
+		if req.Port > 0 && req.Port < 65536 {
+			candidates = append(candidates, &gonet.TCPAddr{IP: from.IP, Port: req.Port})
+		}
+		for _, a := range req.Addrs {
+			if addr, err := ma.NewMultiaddr(a); err == nil {
+				if t, ok := tcpAddr(addr); ok && t.IP.Equal(from.IP) {
+					candidates = append(candidates, t)
+				}
+			}
+		}
+		seen := make(map[string]bool)
+		for _, t := range candidates {
+			if len(seen) == MaxReachabilityAddrs {
+				break
+			}
+			if seen[t.String()] {
+				continue
+			}
+			seen[t.String()] = true
+			conn, err := gonet.DialTimeout("tcp", t.String(), ReachabilityDialTimeout)
+			if err != nil {
+				continue
+			}
+			conn.Close()
+			if a, err := manet.FromNetAddr(t); err == nil {
+				resp.Reachable = append(resp.Reachable, a.String())
+			}
+		}
+	}
+	if err := json.NewEncoder(s).Encode(resp); err != nil {
+		glog.Errorf("Error sending reachability to %v: %v", peer.IDHexEncode(remotePID), err)
+	}
+}
+
+//directConn is a connection to p that doesn't go through a relay, nil if there isn't one.
+func directConn(nw net.Network, p peer.ID) net.Conn {
+	for _, c := range nw.ConnsToPeer(p) {
+		if _, err := c.RemoteMultiaddr().ValueForProtocol(circuit.P_CIRCUIT); err != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+func tcpAddr(a ma.Multiaddr) (*gonet.TCPAddr, bool) {
+	na, err := manet.ToNetAddr(a)
+	if err != nil {
+		return nil, false
+	}
+	tcp, ok := na.(*gonet.TCPAddr)
+	return tcp, ok
+}
+
+//ParsePublicAddrs parses a comma separated list of the addresses the node is reached on.  Each one is an IP, with port as the port, an
+//IP and port (e.g. 1.2.3.4:15000 or [2001:db8::1]:15000), or a multiaddr without the peer ID (e.g. /ip4/1.2.3.4/tcp/15000).
+func ParsePublicAddrs(s string, port int) ([]ma.Multiaddr, error) {
+	addrs := make([]ma.Multiaddr, 0)
+	for _, addr := range strings.Split(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		a, err := parsePublicAddr(addr, port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public address %q: %v", addr, err)
+		}
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+func parsePublicAddr(addr string, port int) (ma.Multiaddr, error) {
+	if strings.HasPrefix(addr, "/") {
+		a, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		//The peer ID is added when the address is shared
+		if _, err := a.ValueForProtocol(ma.P_IPFS); err == nil {
+			return nil, ErrPublicAddr
+		}
+		return a, nil
+	}
+	host := addr
+	if gonet.ParseIP(addr) == nil {
+		h, p, err := gonet.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 65535 {
+			return nil, ErrPublicAddr
+		}
+		host = h
+	}
+	ip := gonet.ParseIP(host)
+	if ip == nil {
+		return nil, ErrPublicAddr
+	}
+	if ip.To4() != nil {
+		return ma.NewMultiaddr(fmt.Sprintf("/ip4/%v/tcp/%v", ip, port))
+	}
+	return ma.NewMultiaddr(fmt.Sprintf("/ip6/%v/tcp/%v", ip, port))
+}
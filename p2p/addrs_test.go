@@ -0,0 +1,176 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/p2p/basicnet"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+func TestParsePublicAddrs(t *testing.T) {
+	addrs, err := ParsePublicAddrs(" 1.2.3.4, 1.2.3.5:16000,[2001:db8::1]:15001,2001:db8::2, /ip4/1.2.3.6/tcp/15002,", 15000)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := []string{"/ip4/1.2.3.4/tcp/15000", "/ip4/1.2.3.5/tcp/16000", "/ip6/2001:db8::1/tcp/15001", "/ip6/2001:db8::2/tcp/15000", "/ip4/1.2.3.6/tcp/15002"}
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expecting %v, got %v", expected, addrs)
+	}
+	for i, a := range addrs {
+		if a.String() != expected[i] {
+			t.Errorf("Expecting %v, got %v", expected[i], a)
+		}
+	}
+
+	if addrs, err := ParsePublicAddrs("", 15000); err != nil || len(addrs) != 0 {
+		t.Errorf("Expecting no addresses, got %v %v", addrs, err)
+	}
+	for _, s := range []string{"example.com", "example.com:15000", "1.2.3.4:0", "1.2.3.4:port", "/ip4/1.2.3.4/tcp", "/ip4/1.2.3.4/tcp/15000/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC"} {
+		if _, err := ParsePublicAddrs(s, 15000); err == nil {
+			t.Errorf("Expecting error for %q", s)
+		}
+	}
+}
+
+type stubReachabilityNetwork struct {
+	candidates []string
+	port       int
+	reachable  [][]string
+}
+
+func (n *stubReachabilityNetwork) CheckableAddrs() ([]string, int) {
+	return n.candidates, n.port
+}
+
+func (n *stubReachabilityNetwork) SetReachableAddrs(addrs []string) {
+	n.reachable = append(n.reachable, addrs)
+}
+
+//connectIPv4 connects h1 to h2 over IPv4, so h2 sees h1 at 127.0.0.1.
+func connectIPv4(t *testing.T, h1, h2 host.Host, port int) {
+	addr, _ := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%v", port))
+	h1.Peerstore().AddAddr(h2.ID(), addr, pstore.PermanentAddrTTL)
+	if err := h1.Connect(context.Background(), pstore.PeerInfo{ID: h2.ID()}); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+}
+
+func TestCheckAddrs(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15160), newHost(t, 15161), newHost(t, 15162)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	NewAddrsChecker(h2, &stubReachabilityNetwork{})
+	NewAddrsChecker(h3, &stubReachabilityNetwork{})
+	//Peers check the candidates, and the listen port on the address they see the node at.  Port 1 is closed.
+	nw := &stubReachabilityNetwork{candidates: []string{"/ip4/127.0.0.1/tcp/15160", "/ip4/127.0.0.1/tcp/1"}, port: 15161}
+	c := NewAddrsChecker(h1, nw)
+
+	//One peer isn't enough
+	connectIPv4(t, h1, h2, 15161)
+	c.Check()
+	if len(nw.reachable) != 0 {
+		t.Errorf("Expecting no change with one peer, got %v", nw.reachable)
+	}
+
+	connectIPv4(t, h1, h3, 15162)
+	c.Check()
+	expected := []string{"/ip4/127.0.0.1/tcp/15160", "/ip4/127.0.0.1/tcp/15161"}
+	if len(nw.reachable) != 1 || fmt.Sprint(nw.reachable[0]) != fmt.Sprint(expected) {
+		t.Errorf("Expecting %v, got %v", expected, nw.reachable)
+	}
+
+	//Nodes with fixed addresses don't ask
+	fixed := &stubReachabilityNetwork{}
+	NewAddrsChecker(h1, fixed).Check()
+	if len(fixed.reachable) != 0 {
+		t.Errorf("Expecting no check, got %v", fixed.reachable)
+	}
+}
+
+func TestCheckAddrsAgreement(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15163), newHost(t, 15164), newHost(t, 15165)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	//Peers that make up addresses
+	lie := func(reachable ...string) func(s net.Stream) {
+		return func(s net.Stream) {
+			defer s.Close()
+			var req reachabilityReq
+			json.NewDecoder(s).Decode(&req)
+			json.NewEncoder(s).Encode(reachabilityResp{Reachable: reachable})
+		}
+	}
+	h2.SetStreamHandler(ReachabilityProtocol, lie("/ip4/8.8.8.8/tcp/80", "/ip4/1.2.3.4/tcp/15163", "/ip4/1.2.3.6/tcp/15000"))
+	h3.SetStreamHandler(ReachabilityProtocol, lie("/ip4/8.8.8.8/tcp/80", "/ip4/1.2.3.5/tcp/15163", "/ip4/1.2.3.6/tcp/15000"))
+	connect(t, h1, h2)
+	connect(t, h1, h3)
+	nw := &stubReachabilityNetwork{candidates: []string{"/ip4/1.2.3.6/tcp/15000"}, port: 15163}
+	NewAddrsChecker(h1, nw).Check()
+
+	//Only the addresses the node asked about, that both peers reached, are taken
+	expected := []string{"/ip4/1.2.3.6/tcp/15000"}
+	if len(nw.reachable) != 1 || fmt.Sprint(nw.reachable[0]) != fmt.Sprint(expected) {
+		t.Errorf("Expecting %v, got %v", expected, nw.reachable)
+	}
+}
+
+func TestNewNodeWithOptions(t *testing.T) {
+	//Public addresses are advertised as they are, and not checked
+	public, _ := ParsePublicAddrs("1.2.3.4", 15170)
+	priv, pub, _ := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	n1, err := basicnet.NewNodeWithOptions(15170, priv, pub, &basicnet.BasicNotifiee{}, basicnet.NodeOptions{PublicAddrs: public})
+	if err != nil {
+		t.Fatalf("Error creating node: %v", err)
+	}
+	defer n1.PeerHost.Close()
+	if addrs := n1.PeerHost.Addrs(); len(addrs) != 1 || addrs[0].String() != "/ip4/1.2.3.4/tcp/15170" {
+		t.Errorf("Expecting /ip4/1.2.3.4/tcp/15170, got %v", addrs)
+	}
+	if _, port := n1.CheckableAddrs(); port != 0 {
+		t.Errorf("Expecting nothing to check, got port %v", port)
+	}
+
+	priv, pub, _ = crypto.GenerateKeyPair(crypto.RSA, 2048)
+	n2, err := basicnet.NewNodeWithOptions(15171, priv, pub, &basicnet.BasicNotifiee{}, basicnet.NodeOptions{CircuitRelay: true, CircuitRelayHop: true})
+	if err != nil {
+		t.Fatalf("Error creating node: %v", err)
+	}
+	defer n2.PeerHost.Close()
+	candidates, port := n2.CheckableAddrs()
+	if port != 15171 {
+		t.Errorf("Expecting port 15171, got %v", port)
+	}
+	for _, s := range candidates {
+		if a, _ := ma.NewMultiaddr(s); a == nil || strings.HasPrefix(s, "/ip4/127.") {
+			t.Errorf("Expecting only public candidates, got %v", s)
+		}
+	}
+
+	//Reachable addresses replace the others, and private ones are dropped
+	n2.SetReachableAddrs([]string{"/ip4/127.0.0.1/tcp/15171", "/ip4/1.2.3.4/tcp/15171", "bad"})
+	if addrs := n2.PeerHost.Addrs(); len(addrs) != 1 || addrs[0].String() != "/ip4/1.2.3.4/tcp/15171" {
+		t.Errorf("Expecting /ip4/1.2.3.4/tcp/15171, got %v", addrs)
+	}
+	//Without reachable addresses or relays, the private addresses are left for the peers on the same network
+	n2.SetReachableAddrs(nil)
+	addrs := n2.PeerHost.Addrs()
+	if len(addrs) == 0 {
+		t.Errorf("Expecting the private addresses")
+	}
+	for _, a := range addrs {
+		if a.String() == "/ip4/1.2.3.4/tcp/15171" || strings.Contains(a.String(), "p2p-circuit") {
+			t.Errorf("Expecting no public or relay addresses, got %v", addrs)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+var ErrAnnouncement = errors.New("ErrAnnouncement")
+
+//CapabilityProtocol and StreamAnnouncementProtocol carry an announcement.  They are separate from the basicnet protocol, since older
+//nodes close the stream on messages they don't know, and from each other, so nodes only pass on the announcements they know.
+const CapabilityProtocol = protocol.ID("/livepeer_capabilities/0.0.1")
+const StreamAnnouncementProtocol = protocol.ID("/livepeer_stream_announcements/0.0.1")
+
+var AnnouncementTimeout = 10 * time.Second
+
+//MaxAnnouncementHops is how far an announcement travels from the node that made it.
+const MaxAnnouncementHops = 6
+
+//AnnouncementSeenTTL is how long the node remembers the announcements it passed on, so it doesn't pass them on again.
+var AnnouncementSeenTTL = 10 * time.Minute
+
+//AnnouncementRateInterval is the interval the announcements of each node are counted over.  Nodes that announce more than
+//MaxCapabilityAnnouncements or MaxStreamAnnouncements in an interval have the rest dropped until the next one, so a node can't flood the
+//network through its peers.
+var AnnouncementRateInterval = time.Minute
+var MaxCapabilityAnnouncements = 5
+var MaxStreamAnnouncements = 60
+
+//announcement is the message of the protocols.  Sig is the signature of the node over NodeID and Data, with the libp2p key NodeID is the
+//hash of, so peers can't pass on announcements in the name of other nodes.
+type announcement struct {
+	NodeID string
+	Data   []byte
+	PubKey []byte
+	Sig    []byte
+	Hops   int
+}
+
+func (a *announcement) signedBytes() []byte {
+	return append([]byte(a.NodeID), a.Data...)
+}
+
+//verify checks that the announcement is signed by the key of NodeID.
+func (a *announcement) verify() error {
+	pid, err := peer.IDHexDecode(a.NodeID)
+	if err != nil {
+		return err
+	}
+	pub, err := crypto.UnmarshalPublicKey(a.PubKey)
+	if err != nil {
+		return err
+	}
+	if !pid.MatchesPublicKey(pub) {
+		return ErrAnnouncement
+	}
+	ok, err := pub.Verify(a.signedBytes(), a.Sig)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAnnouncement
+	}
+	return nil
+}
+
+//announcer spreads one kind of announcement through the network.
+type announcer struct {
+	protocol protocol.ID
+	//name is the kind of announcement, for logs
+	name string
+	//maxPerInterval is how many announcements of a node are taken every AnnouncementRateInterval
+	maxPerInterval int
+
+	lock            sync.Mutex
+	gotAnnouncement func(nodeID string, data []byte) bool
+	seen            map[[sha256.Size]byte]time.Time
+	rates           map[string]*originRate
+}
+
+//originRate counts the announcements of a node since start.
+type originRate struct {
+	start time.Time
+	count int
+}
+
+//Announcer spreads the capability and stream announcements of the node to all its peers, which check them and pass them on.
+type Announcer struct {
+	host                host.Host
+	capabilities        *announcer
+	streamAnnouncements *announcer
+}
+
+//NewAnnouncer starts taking announcements on h.
+func NewAnnouncer(h host.Host) *Announcer {
+	a := &Announcer{
+		host:                h,
+		capabilities:        newAnnouncer(CapabilityProtocol, "capability", MaxCapabilityAnnouncements),
+		streamAnnouncements: newAnnouncer(StreamAnnouncementProtocol, "stream", MaxStreamAnnouncements)}
+	h.SetStreamHandler(CapabilityProtocol, a.handleAnnouncement(a.capabilities))
+	h.SetStreamHandler(StreamAnnouncementProtocol, a.handleAnnouncement(a.streamAnnouncements))
+	return a
+}
+
+func newAnnouncer(proto protocol.ID, name string, maxPerInterval int) *announcer {
+	return &announcer{
+		protocol:       proto,
+		name:           name,
+		maxPerInterval: maxPerInterval,
+		seen:           make(map[[sha256.Size]byte]time.Time),
+		rates:          make(map[string]*originRate)}
+}
+
+//AnnounceCapabilities sends the capability announcement of the node to all its peers.
+func (a *Announcer) AnnounceCapabilities(data []byte) error {
+	return a.announce(a.capabilities, data)
+}
+
+//ReceivedCapabilities sets the function that checks the capability announcements of other nodes.
+func (a *Announcer) ReceivedCapabilities(gotAnnouncement func(nodeID string, data []byte) bool) {
+	a.capabilities.setFunc(gotAnnouncement)
+}
+
+//AnnounceStream sends a stream announcement of the node to all its peers.
+func (a *Announcer) AnnounceStream(data []byte) error {
+	return a.announce(a.streamAnnouncements, data)
+}
+
+//ReceivedStreamAnnouncements sets the function that checks the stream announcements of other nodes.
+func (a *Announcer) ReceivedStreamAnnouncements(gotAnnouncement func(nodeID string, data []byte) bool) {
+	a.streamAnnouncements.setFunc(gotAnnouncement)
+}
+
+func (an *announcer) setFunc(f func(nodeID string, data []byte) bool) {
+	an.lock.Lock()
+	defer an.lock.Unlock()
+	an.gotAnnouncement = f
+}
+
+func (a *Announcer) announce(an *announcer, data []byte) error {
+	priv := a.host.Peerstore().PrivKey(a.host.ID())
+	if priv == nil {
+		glog.Errorf("Cannot sign %v announcement without the key of the node", an.name)
+		return ErrAnnouncement
+	}
+	pub, err := crypto.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return err
+	}
+	msg := announcement{NodeID: peer.IDHexEncode(a.host.ID()), Data: data, PubKey: pub}
+	if msg.Sig, err = priv.Sign(msg.signedBytes()); err != nil {
+		return err
+	}
+	an.markSeen(msg)
+	a.flood(an, msg, "")
+	return nil
+}
+
+//flood sends msg to all the peers but the one it came from.
+func (a *Announcer) flood(an *announcer, msg announcement, from peer.ID) {
+	for _, p := range a.host.Network().Peers() {
+		if p == from || p == a.host.ID() {
+			continue
+		}
+		go a.send(an, p, msg)
+	}
+}
+
+func (a *Announcer) send(an *announcer, pid peer.ID, msg announcement) {
+	ctx, cancel := context.WithTimeout(context.Background(), AnnouncementTimeout)
+	defer cancel()
+	s, err := a.host.NewStream(ctx, pid, an.protocol)
+	if err != nil {
+		//Nodes from before the announcements don't take them
+		glog.V(5).Infof("Cannot send %v announcement to %v: %v", an.name, peer.IDHexEncode(pid), err)
+		return
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(AnnouncementTimeout))
+	if err := json.NewEncoder(s).Encode(msg); err != nil {
+		glog.Errorf("Error sending %v announcement to %v: %v", an.name, peer.IDHexEncode(pid), err)
+	}
+}
+
+//handleAnnouncement returns the stream handler for the protocol of an.  Announcements are only passed on once they are signed by the node
+//that made them, the node isn't over its rate, and the function of an took them.
+func (a *Announcer) handleAnnouncement(an *announcer) func(s net.Stream) {
+	return func(s net.Stream) {
+		defer s.Close()
+		remotePID := s.Conn().RemotePeer()
+		s.SetDeadline(time.Now().Add(AnnouncementTimeout))
+		var msg announcement
+		if err := json.NewDecoder(s).Decode(&msg); err != nil {
+			glog.Errorf("Error decoding %v announcement from %v: %v", an.name, peer.IDHexEncode(remotePID), err)
+			return
+		}
+		if msg.NodeID == peer.IDHexEncode(a.host.ID()) || !an.markSeen(msg) {
+			return
+		}
+		if err := msg.verify(); err != nil {
+			glog.Errorf("Dropping %v announcement of %v from %v: %v", an.name, msg.NodeID, peer.IDHexEncode(remotePID), err)
+			return
+		}
+		if !an.allow(msg.NodeID) {
+			glog.V(4).Infof("Dropping %v announcement of %v from %v: over %v per %v", an.name, msg.NodeID, peer.IDHexEncode(remotePID), an.maxPerInterval, AnnouncementRateInterval)
+			return
+		}
+		glog.V(5).Infof("Got %v announcement of %v from %v (%v hops)", an.name, msg.NodeID, peer.IDHexEncode(remotePID), msg.Hops)
+
+		an.lock.Lock()
+		f := an.gotAnnouncement
+		an.lock.Unlock()
+		if f == nil || !f(msg.NodeID, msg.Data) {
+			return
+		}
+		if msg.Hops < MaxAnnouncementHops {
+			msg.Hops++
+			a.flood(an, msg, remotePID)
+		}
+	}
+}
+
+//markSeen returns false if the node saw msg already.  Hops isn't part of it, so the same announcement coming over a longer path is seen.
+//The key and signature are, so announcements that don't verify don't stop the one that does.
+func (an *announcer) markSeen(msg announcement) bool {
+	h := sha256.New()
+	h.Write([]byte(msg.NodeID))
+	h.Write(msg.Data)
+	h.Write(msg.PubKey)
+	h.Write(msg.Sig)
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+
+	an.lock.Lock()
+	defer an.lock.Unlock()
+	now := time.Now()
+	for k, t := range an.seen {
+		if now.Sub(t) > AnnouncementSeenTTL {
+			delete(an.seen, k)
+		}
+	}
+	if _, ok := an.seen[key]; ok {
+		return false
+	}
+	an.seen[key] = now
+	return true
+}
+
+//allow counts an announcement of nodeID, and returns false if nodeID is over its rate.
+func (an *announcer) allow(nodeID string) bool {
+	an.lock.Lock()
+	defer an.lock.Unlock()
+	now := time.Now()
+	for id, r := range an.rates {
+		if now.Sub(r.start) > AnnouncementRateInterval {
+			delete(an.rates, id)
+		}
+	}
+	r, ok := an.rates[nodeID]
+	if !ok {
+		r = &originRate{start: now}
+		an.rates[nodeID] = r
+	}
+	r.count++
+	return r.count <= an.maxPerInterval
+}
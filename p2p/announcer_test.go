@@ -0,0 +1,158 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+)
+
+type gotAnnouncement struct {
+	nodeID string
+	data   string
+}
+
+//announcements returns a function that sends the announcements it gets to a channel, and takes them if valid is true.
+func announcements(valid bool) (chan gotAnnouncement, func(nodeID string, data []byte) bool) {
+	got := make(chan gotAnnouncement, 10)
+	return got, func(nodeID string, data []byte) bool {
+		got <- gotAnnouncement{nodeID: nodeID, data: string(data)}
+		return valid
+	}
+}
+
+func expectAnnouncement(t *testing.T, got chan gotAnnouncement, nodeID string, data string) {
+	select {
+	case a := <-got:
+		if a.nodeID != nodeID || a.data != data {
+			t.Errorf("Expecting %v from %v, got %+v", data, nodeID, a)
+		}
+	case <-time.After(3 * time.Second):
+		t.Errorf("Expecting %v from %v, got nothing", data, nodeID)
+	}
+}
+
+func expectNoAnnouncement(t *testing.T, got chan gotAnnouncement) {
+	select {
+	case a := <-got:
+		t.Errorf("Expecting no announcement, got %+v", a)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestAnnouncer(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15180), newHost(t, 15181), newHost(t, 15182)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	//h1 and h3 only reach each other through h2
+	connect(t, h1, h2)
+	connect(t, h2, h3)
+	a1, a2, a3 := NewAnnouncer(h1), NewAnnouncer(h2), NewAnnouncer(h3)
+	got2, f2 := announcements(true)
+	a2.ReceivedCapabilities(f2)
+	got3, f3 := announcements(true)
+	a3.ReceivedCapabilities(f3)
+	streams3, s3 := announcements(true)
+	a3.ReceivedStreamAnnouncements(s3)
+
+	id1 := peer.IDHexEncode(h1.ID())
+	if err := a1.AnnounceCapabilities([]byte("caps")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expectAnnouncement(t, got2, id1, "caps")
+	expectAnnouncement(t, got3, id1, "caps")
+	//Announcements aren't taken twice, and don't come back to the node that made them
+	expectNoAnnouncement(t, got2)
+	expectNoAnnouncement(t, streams3)
+
+	//Announcements the node doesn't take aren't passed on
+	a2.ReceivedCapabilities(func(nodeID string, data []byte) bool { return false })
+	a1.AnnounceCapabilities([]byte("bad caps"))
+	expectNoAnnouncement(t, got3)
+
+	a2.ReceivedStreamAnnouncements(f2)
+	a1.AnnounceStream([]byte("stream"))
+	expectAnnouncement(t, got2, id1, "stream")
+	expectAnnouncement(t, streams3, id1, "stream")
+}
+
+func TestAnnouncerSignature(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15183), newHost(t, 15184), newHost(t, 15185)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	connect(t, h1, h2)
+	a2 := NewAnnouncer(h2)
+	capabilities, f := announcements(true)
+	a2.ReceivedCapabilities(f)
+	streams, f := announcements(true)
+	a2.ReceivedStreamAnnouncements(f)
+
+	sign := func(msg *announcement, priv crypto.PrivKey) {
+		msg.PubKey, _ = crypto.MarshalPublicKey(priv.GetPublic())
+		msg.Sig, _ = priv.Sign(msg.signedBytes())
+	}
+	priv1, priv3 := h1.Peerstore().PrivKey(h1.ID()), h3.Peerstore().PrivKey(h3.ID())
+
+	for proto, got := range map[protocol.ID]chan gotAnnouncement{CapabilityProtocol: capabilities, StreamAnnouncementProtocol: streams} {
+		send := func(msg announcement) {
+			s, err := h1.NewStream(context.Background(), h2.ID(), proto)
+			if err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+			defer s.Close()
+			if err := json.NewEncoder(s).Encode(msg); err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+		}
+		//h1 can't announce in the name of h3, with its own key or without a signature
+		msg := announcement{NodeID: peer.IDHexEncode(h3.ID()), Data: []byte("forged")}
+		send(msg)
+		sign(&msg, priv1)
+		send(msg)
+		expectNoAnnouncement(t, got)
+		//It can pass on the announcements h3 signed
+		sign(&msg, priv3)
+		send(msg)
+		expectAnnouncement(t, got, peer.IDHexEncode(h3.ID()), "forged")
+		//The data can't be changed on the way
+		msg.Data = []byte("changed")
+		send(msg)
+		expectNoAnnouncement(t, got)
+	}
+}
+
+func TestAnnouncerRate(t *testing.T) {
+	defer func(max int) { MaxCapabilityAnnouncements = max }(MaxCapabilityAnnouncements)
+	MaxCapabilityAnnouncements = 2
+	h1, h2 := newHost(t, 15186), newHost(t, 15187)
+	defer h1.Close()
+	defer h2.Close()
+	connect(t, h1, h2)
+	a1, a2 := NewAnnouncer(h1), NewAnnouncer(h2)
+	got, f := announcements(true)
+	a2.ReceivedCapabilities(f)
+
+	for i := 0; i < 4; i++ {
+		a1.AnnounceCapabilities([]byte(fmt.Sprintf("caps%v", i)))
+		time.Sleep(50 * time.Millisecond)
+	}
+	taken := 0
+	for done := false; !done; {
+		select {
+		case <-got:
+			taken++
+		case <-time.After(500 * time.Millisecond):
+			done = true
+		}
+	}
+	if taken != 2 {
+		t.Errorf("Expecting 2 announcements to be taken, got %v", taken)
+	}
+}
@@ -0,0 +1,233 @@
+package basicnet
+
+import (
+	"context"
+	gonet "net"
+	"sort"
+	"sync"
+	"time"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	manet "gx/ipfs/QmX3U3YXCQ6UYBxq2LVWF8dARS1hPUTEYLrSx654Qyxyw6/go-multiaddr-net"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	bhost "gx/ipfs/Qmbgce14YTWE2qhE49JVvTBPaHTyz3FaFmqQPyuZAz6C28/go-libp2p/p2p/host/basic"
+	circuit "gx/ipfs/QmfHWhmJSJD9RjogJdPsb7wzJbUkxpZkctHvAfvJCTAP6X/go-libp2p-circuit"
+
+	"github.com/golang/glog"
+)
+
+//CanHopTimeout is how long the node waits for a peer to tell if it relays connections.
+var CanHopTimeout = 10 * time.Second
+
+//MaxCircuitRelays is how many relays a node that can't be reached advertises addresses through.
+const MaxCircuitRelays = 3
+
+//privateNets are the networks that can't be reached from the internet.
+var privateNets = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+//NodeOptions are how the node can be reached from outside its network.
+type NodeOptions struct {
+	//PublicAddrs are advertised instead of the addresses the node finds out by itself
+	PublicAddrs []ma.Multiaddr
+	//NATPortMap maps the listen port on the router with UPnP or NAT-PMP
+	NATPortMap bool
+	//CircuitRelay lets the node connect to peers through circuit relays, and advertise addresses through relays when it can't be reached
+	CircuitRelay bool
+	//CircuitRelayHop lets other nodes connect to each other through the node.  It needs CircuitRelay.
+	CircuitRelayHop bool
+}
+
+//addrManager picks the addresses the node advertises.  Interface addresses are private behind NAT, and observed addresses have the port
+//of the connection instead of the listen port, so peers check which public addresses they can reach.
+type addrManager struct {
+	lock       sync.Mutex
+	host       *bhost.BasicHost
+	listenPort int
+	public     []ma.Multiaddr
+	//checked is true once peers checked the addresses, and reachable are the ones they could reach
+	checked    bool
+	reachable  []ma.Multiaddr
+	relay      *circuit.Relay
+	relayAddrs []ma.Multiaddr
+}
+
+//advertised is the AddrsFactory of the host.  It returns PublicAddrs when they are set, and the addresses peers could reach otherwise.
+//Nodes that can't be reached advertise addresses through relays, along with their private addresses for the peers on the same network.
+//Until the first check, all the addresses are advertised.
+func (am *addrManager) advertised(all []ma.Multiaddr) []ma.Multiaddr {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+	if len(am.public) > 0 {
+		return am.public
+	}
+	if len(am.reachable) > 0 {
+		return am.reachable
+	}
+	addrs := append([]ma.Multiaddr{}, am.relayAddrs...)
+	seen := make(map[string]bool)
+	for _, a := range all {
+		if isCircuitAddr(a) || (am.checked && isPublicAddr(a)) || seen[a.String()] {
+			continue
+		}
+		seen[a.String()] = true
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+//CheckableAddrs are the public addresses of the node, and the listen port.  Nodes with PublicAddrs have nothing to check.
+func (n *NetworkNode) CheckableAddrs() ([]string, int) {
+	am := n.addrMgr
+	if len(am.public) > 0 {
+		return nil, 0
+	}
+	candidates := make([]string, 0)
+	for _, a := range am.host.AllAddrs() {
+		if isPublicAddr(a) {
+			candidates = append(candidates, a.String())
+		}
+	}
+	return candidates, am.listenPort
+}
+
+//SetReachableAddrs sets the public addresses peers could reach.  When there are none, the node advertises addresses through the relays
+//among its peers.
+func (n *NetworkNode) SetReachableAddrs(reachableAddrs []string) {
+	am := n.addrMgr
+	reachable := make(map[string]ma.Multiaddr)
+	for _, s := range reachableAddrs {
+		if a, err := ma.NewMultiaddr(s); err == nil && isPublicAddr(a) {
+			reachable[a.String()] = a
+		}
+	}
+	keys := make([]string, 0, len(reachable))
+	for k := range reachable {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	addrs := make([]ma.Multiaddr, 0, len(keys))
+	for _, k := range keys {
+		addrs = append(addrs, reachable[k])
+	}
+	var relayAddrs []ma.Multiaddr
+	if len(addrs) == 0 && am.relay != nil {
+		relayAddrs = n.relayAddrs()
+	}
+
+	am.lock.Lock()
+	changed := !am.checked || !sameAddrs(am.reachable, addrs) || !sameAddrs(am.relayAddrs, relayAddrs)
+	am.checked = true
+	am.reachable = addrs
+	am.relayAddrs = relayAddrs
+	am.lock.Unlock()
+	if changed {
+		if len(addrs) > 0 {
+			glog.Infof("The node can be reached at %v", addrs)
+		} else if len(relayAddrs) > 0 {
+			glog.Infof("The node can't be reached from outside its network, advertising addresses through relays: %v", relayAddrs)
+		} else {
+			glog.Infof("The node can't be reached from outside its network.  Set -publicAddr, forward the port, or use -circuitRelay")
+		}
+	}
+}
+
+//relayAddrs are addresses of the node through the peers that relay connections.  Only peers at public addresses are used, since the
+//addresses are for nodes outside the network.
+func (n *NetworkNode) relayAddrs() []ma.Multiaddr {
+	am := n.addrMgr
+	addrs := make([]ma.Multiaddr, 0)
+	for _, p := range am.host.Network().Peers() {
+		if len(addrs) == MaxCircuitRelays {
+			break
+		}
+		c := directConn(am.host.Network(), p)
+		if c == nil || !isPublicAddr(c.RemoteMultiaddr()) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), CanHopTimeout)
+		canHop, err := am.relay.CanHop(ctx, p)
+		cancel()
+		if err != nil || !canHop {
+			continue
+		}
+		circuitAddr, err := ma.NewMultiaddr("/ipfs/" + peer.IDB58Encode(p) + "/p2p-circuit/ipfs/" + peer.IDB58Encode(n.Identity))
+		if err != nil {
+			glog.Errorf("Error creating relay address: %v", err)
+			continue
+		}
+		addrs = append(addrs, c.RemoteMultiaddr().Encapsulate(circuitAddr))
+	}
+	return addrs
+}
+
+//Addrs are the addresses the node advertises.
+func (n *BasicVideoNetwork) Addrs() []string {
+	addrs := make([]string, 0)
+	for _, a := range n.NetworkNode.PeerHost.Addrs() {
+		addrs = append(addrs, a.String())
+	}
+	return addrs
+}
+
+//directConn is a connection to p that doesn't go through a relay, nil if there isn't one.
+func directConn(nw net.Network, p peer.ID) net.Conn {
+	for _, c := range nw.ConnsToPeer(p) {
+		if !isCircuitAddr(c.RemoteMultiaddr()) {
+			return c
+		}
+	}
+	return nil
+}
+
+func tcpAddr(a ma.Multiaddr) (*gonet.TCPAddr, bool) {
+	na, err := manet.ToNetAddr(a)
+	if err != nil {
+		return nil, false
+	}
+	tcp, ok := na.(*gonet.TCPAddr)
+	return tcp, ok
+}
+
+func isCircuitAddr(a ma.Multiaddr) bool {
+	_, err := a.ValueForProtocol(circuit.P_CIRCUIT)
+	return err == nil
+}
+
+//isPublicAddr tells if a is a TCP address that can be reached from the internet, if nothing is in the way.
+func isPublicAddr(a ma.Multiaddr) bool {
+	tcp, ok := tcpAddr(a)
+	if !ok || tcp.IP.IsUnspecified() || tcp.IP.IsLoopback() || tcp.IP.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(tcp.IP) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCIDRs(cidrs ...string) []*gonet.IPNet {
+	nets := make([]*gonet.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := gonet.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,45 @@
+package basicnet
+
+import (
+	"errors"
+
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+var ErrNoAnnouncer = errors.New("ErrNoAnnouncer")
+
+//SetAnnouncer sets what spreads the announcements of the node.  It has to be set before the announcement functions are, since they are
+//passed on to it.
+func (n *BasicVideoNetwork) SetAnnouncer(a lpnet.Announcer) {
+	n.announcer = a
+}
+
+//AnnounceCapabilities sends the capability announcement of the node to the network.
+func (n *BasicVideoNetwork) AnnounceCapabilities(data []byte) error {
+	if n.announcer == nil {
+		return ErrNoAnnouncer
+	}
+	return n.announcer.AnnounceCapabilities(data)
+}
+
+//ReceivedCapabilities sets the function called with the capability announcements of other nodes.
+func (n *BasicVideoNetwork) ReceivedCapabilities(gotAnnouncement func(nodeID string, data []byte) bool) {
+	if n.announcer != nil {
+		n.announcer.ReceivedCapabilities(gotAnnouncement)
+	}
+}
+
+//AnnounceStream sends a stream announcement of the node to the network.
+func (n *BasicVideoNetwork) AnnounceStream(data []byte) error {
+	if n.announcer == nil {
+		return ErrNoAnnouncer
+	}
+	return n.announcer.AnnounceStream(data)
+}
+
+//ReceivedStreamAnnouncements sets the function called with the stream announcements of other nodes.
+func (n *BasicVideoNetwork) ReceivedStreamAnnouncements(gotAnnouncement func(nodeID string, data []byte) bool) {
+	if n.announcer != nil {
+		n.announcer.ReceivedStreamAnnouncements(gotAnnouncement)
+	}
+}
@@ -0,0 +1,137 @@
+package basicnet
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+
+	"github.com/golang/glog"
+)
+
+//BasicRelayer relays video segments to listners.  Unlike BasicBroadcaster, BasicRelayer is
+//does NOT have a worker - it sends out the chunks to its listeners as soon as it gets one from the network.
+type BasicRelayer struct {
+	UpstreamPeer peer.ID
+	listeners    map[string]*BasicOutStream
+	LastRelay    time.Time
+	//lock guards the fields above, since relay refusals come in on their own stream handler
+	lock sync.Mutex
+}
+
+//RelayStreamData sends a StreamDataMsg to its listeners.  When the node is over its upload capacity, listeners are shed.
+func (br *BasicRelayer) RelayStreamData(nw *BasicVideoNetwork, sd *StreamDataMsg) error {
+	shed := make([]string, 0)
+	br.lock.Lock()
+	for strmID, l := range br.listeners {
+		// glog.V(5).Infof("Relaying stream data to listener: %v", l)
+		// glog.Infof("Relaying stream data to listener: %v", peer.IDHexEncode(l.Stream.Conn().RemotePeer()))
+		if err := l.SendMessage(StreamDataID, *sd); err != nil {
+			glog.Errorf("Error writing data to relayer listener %v: %v", l, err)
+			delete(br.listeners, strmID)
+		} else if nw.relayLimiter != nil && nw.relayLimiter.Sent(sd.StrmID, len(sd.Data), true) {
+			shed = append(shed, strmID)
+		}
+		br.LastRelay = time.Now()
+	}
+	br.lock.Unlock()
+	for _, key := range shed {
+		nw.shed(sd.StrmID, br, key)
+	}
+	return nil
+}
+
+func (br *BasicRelayer) RelayFinishStream(nw *BasicVideoNetwork, fs FinishStreamMsg) error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	for strmID, l := range br.listeners {
+		if err := l.SendMessage(FinishStreamID, fs); err != nil {
+			glog.Errorf("Error relaying finish stream to %v: %v", peer.IDHexEncode(l.Stream.Conn().RemotePeer()), err)
+			delete(br.listeners, strmID)
+		}
+		br.LastRelay = time.Now()
+	}
+	return nil
+}
+
+func (br *BasicRelayer) RelayMasterPlaylistData(nw *BasicVideoNetwork, mpld MasterPlaylistDataMsg) error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	for strmID, l := range br.listeners {
+		if err := l.SendMessage(MasterPlaylistDataID, mpld); err != nil {
+			glog.Errorf("Error relaying master playlist data to %v: %v", peer.IDHexEncode(l.Stream.Conn().RemotePeer()), err)
+			delete(br.listeners, strmID)
+		}
+		br.LastRelay = time.Now()
+	}
+	return nil
+}
+
+func (br *BasicRelayer) RelayNodeStatusData(nw *BasicVideoNetwork, nsd NodeStatusDataMsg) error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	for id, l := range br.listeners {
+		if err := l.SendMessage(NodeStatusDataID, nsd); err != nil {
+			glog.Errorf("Error relaying node status data to %v: %v", peer.IDHexEncode(l.Stream.Conn().RemotePeer()), err)
+			delete(br.listeners, id)
+		}
+		br.LastRelay = time.Now()
+	}
+	return nil
+}
+
+func (br *BasicRelayer) AddListener(nw *BasicVideoNetwork, pid peer.ID) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	key := peer.IDHexEncode(pid)
+	if _, ok := br.listeners[key]; !ok {
+		br.listeners[key] = nw.NetworkNode.GetOutStream(pid)
+	}
+}
+
+//removeListener removes the listener with key, and returns how many are left.
+func (br *BasicRelayer) removeListener(key string) (*BasicOutStream, int) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	l := br.listeners[key]
+	delete(br.listeners, key)
+	return l, len(br.listeners)
+}
+
+func (br *BasicRelayer) listenerCount() int {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return len(br.listeners)
+}
+
+func (br *BasicRelayer) hasListener(pid peer.ID) bool {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	_, ok := br.listeners[peer.IDHexEncode(pid)]
+	return ok
+}
+
+func (br *BasicRelayer) upstream() peer.ID {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return br.UpstreamPeer
+}
+
+func (br *BasicRelayer) setUpstream(pid peer.ID) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	br.UpstreamPeer = pid
+}
+
+func (br *BasicRelayer) lastRelay() time.Time {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return br.LastRelay
+}
+
+func (br *BasicRelayer) String() string {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return fmt.Sprintf("UpstreamPeer: %v, len:%v", peer.IDHexEncode(br.UpstreamPeer), len(br.listeners))
+}
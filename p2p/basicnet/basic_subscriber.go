@@ -0,0 +1,262 @@
+package basicnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	kb "gx/ipfs/QmSAFA8v42u4gpJNy1tb7vW3JiiXiaYDC2b845c2RnNSJL/go-libp2p-kbucket"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+var SubscriberDataInsertTimeout = time.Second * 300
+var InsertDataWaitTime = time.Second * 10
+
+//SubscriberDeliverQueueSize is how many batches of segments can wait for gotData before the subscriber worker waits too.
+var SubscriberDeliverQueueSize = 10
+
+var ErrSubscriber = errors.New("ErrSubscriber")
+
+//BasicSubscriber keeps track of
+type BasicSubscriber struct {
+	Network *BasicVideoNetwork
+	host    host.Host
+	msgChan chan StreamDataMsg
+	// networkStream *BasicStream
+	StrmID       string
+	UpstreamPeer peer.ID
+	workingLock  sync.Mutex
+	working      bool
+	cancelWorker context.CancelFunc
+	//sequencer puts the segments back in order and finds the lost ones, nil to pass them on as they come
+	sequencer lpnet.SegmentSequencer
+	//drain is closed to stop the worker taking segments, and drained once the segments it has are passed on
+	drain     chan struct{}
+	drained   chan struct{}
+	drainOnce sync.Once
+}
+
+func (s *BasicSubscriber) InsertData(sd *StreamDataMsg) error {
+	go func(sd *StreamDataMsg) {
+		if s.isWorking() {
+			timer := time.NewTimer(InsertDataWaitTime)
+			select {
+			case s.msgChan <- *sd:
+				// glog.V(4).Infof("Data segment %v for %v inserted. (%v)", sd.SeqNo, sd.StrmID, time.Since(start))
+			case <-timer.C:
+				glog.Errorf("Subscriber data insert timed out: %v", sd.StrmID)
+			}
+		}
+	}(sd)
+	return nil
+}
+
+//Subscribe kicks off a go routine that calls the gotData func for every new video chunk
+func (s *BasicSubscriber) Subscribe(ctx context.Context, gotData func(seqNo uint64, data []byte, eof bool)) error {
+	//Do we already have the broadcaster locally? If we do, just subscribe to it and listen.
+	if b := s.Network.getBroadcaster(s.StrmID); b != nil {
+		localS := NewLocalOutStream(s)
+		b.AddListeningStream("localSub", localS)
+
+		ctxW, cancel := context.WithCancel(context.Background())
+		s.cancelWorker = cancel
+		s.setWorking(true)
+		s.startWorker(ctxW, nil, gotData)
+		return nil
+	}
+
+	//If we don't, send subscribe request, listen for response
+	localPeers := s.Network.NetworkNode.PeerHost.Peerstore().Peers()
+	if len(localPeers) == 1 {
+		glog.Errorf("No local peers")
+		return ErrSubscriber
+	}
+	targetPid, err := extractNodeID(s.StrmID)
+	if err != nil {
+		glog.Errorf("Error extracting node id from streamID: %v", s.StrmID)
+		return ErrSubscriber
+	}
+	peers := kb.SortClosestPeers(localPeers, kb.ConvertPeerID(targetPid))
+
+	for _, p := range peers {
+		if p == s.Network.NetworkNode.Identity {
+			continue
+		}
+		//Question: Where do we close the stream? If we only close on "Unsubscribe", we may leave some streams open...
+		glog.V(5).Infof("New peer from kademlia: %v", peer.IDHexEncode(p))
+		ns := s.Network.NetworkNode.GetOutStream(p)
+		if ns != nil {
+			//Send SubReq
+			glog.Infof("Sending Req %v", s.StrmID)
+			if err := ns.SendMessage(SubReqID, SubReqMsg{StrmID: s.StrmID}); err != nil {
+				glog.Errorf("Error sending SubReq to %v: %v", peer.IDHexEncode(p), err)
+			}
+			ctxW, cancel := context.WithCancel(context.Background())
+			s.cancelWorker = cancel
+			s.setWorking(true)
+			// s.networkStream = ns
+			s.Network.streamsLock.Lock()
+			s.UpstreamPeer = p
+			s.Network.streamsLock.Unlock()
+			s.startWorker(ctxW, ns, gotData)
+			return nil
+		}
+	}
+
+	glog.Errorf("Cannot subscribe from any of the peers: %v", peers)
+	return ErrNoClosePeers
+
+	//Call gotData for every new piece of data
+}
+
+func (s *BasicSubscriber) startWorker(ctxW context.Context, ws *BasicOutStream, gotData func(seqNo uint64, data []byte, eof bool)) {
+	//Segments are passed on from one goroutine, so gotData gets them in order, and a slow gotData holds up the worker instead of piling up
+	//goroutines.  EOF goes out after the segments before it.
+	deliver := make(chan []lpnet.Segment, SubscriberDeliverQueueSize)
+	drain, drained := make(chan struct{}), make(chan struct{})
+	s.Network.streamsLock.Lock()
+	s.drain, s.drained = drain, drained
+	s.Network.streamsLock.Unlock()
+	go func() {
+		for segs := range deliver {
+			passOn(segs, gotData)
+		}
+		close(drained)
+		gotData(0, nil, true)
+	}()
+
+	//We expect DataStreamMsg to come back
+	go func() {
+		//The sequencer is checked for gaps to ask for again or to give up on
+		var check <-chan time.Time
+		if s.sequencer != nil {
+			ticker := time.NewTicker(SequencerCheckInterval)
+			defer ticker.Stop()
+			check = ticker.C
+		}
+		msgs := s.msgChan
+		for {
+			//Get message from the msgChan (inserted from the network by StreamDataMsg)
+			//Call gotData(seqNo, data)
+			//Question: What happens if the handler gets stuck?
+			start := time.Now()
+			select {
+			case msg := <-msgs:
+				networkWaitTime := time.Since(start)
+				if s.sequencer == nil {
+					deliver <- []lpnet.Segment{{SeqNo: msg.SeqNo, Data: msg.Data}}
+				} else if segs := s.sequencer.Insert(msg.SeqNo, msg.Data); len(segs) > 0 {
+					deliver <- segs
+				}
+				glog.V(common.DEBUG).Infof("Subscriber worker inserted segment: %v - took %v in total, %v waiting for data", msg.SeqNo, time.Since(start), networkWaitTime)
+			case <-check:
+				ready, missing := s.sequencer.Check()
+				if len(ready) > 0 {
+					deliver <- ready
+				}
+				if len(missing) > 0 && s.Network.retransmitter != nil {
+					go s.Network.retransmitter.Retransmit(s.StrmID, missing)
+				}
+			case <-drain:
+				//Stop taking segments, and pass on the ones the sequencer holds back.  The worker only waits to be cancelled now.
+				s.setWorking(false)
+				if s.sequencer != nil {
+					if segs := s.sequencer.Flush(); len(segs) > 0 {
+						deliver <- segs
+					}
+				}
+				close(deliver)
+				msgs, check, drain = nil, nil, nil
+			case <-ctxW.Done():
+				// s.networkStream = nil
+				s.setWorking(false)
+				glog.Infof("Done with subscription, sending CancelSubMsg")
+				//Send EOF, unless the subscriber was drained and sent it already
+				if drain != nil {
+					close(deliver)
+				}
+				if ws != nil {
+					//The upstream peer changes when it redirects the subscription
+					s.Network.streamsLock.Lock()
+					upstream := s.UpstreamPeer
+					s.Network.streamsLock.Unlock()
+					if ns := s.Network.NetworkNode.GetOutStream(upstream); ns != nil {
+						ws = ns
+					}
+					if err := ws.SendMessage(CancelSubID, CancelSubMsg{StrmID: s.StrmID}); err != nil {
+						glog.Errorf("Error sending CancelSubMsg during worker cancellation: %v", err)
+					}
+				}
+				return
+			}
+		}
+	}()
+}
+
+//passOn passes segments on one after the other, so they stay in order.
+func passOn(segs []lpnet.Segment, gotData func(seqNo uint64, data []byte, eof bool)) {
+	for _, seg := range segs {
+		gotData(seg.SeqNo, seg.Data, false)
+	}
+}
+
+//Drain stops the subscriber taking segments from the network, and returns once the segments it has are passed on, or ctx is done.
+func (s *BasicSubscriber) Drain(ctx context.Context) error {
+	s.Network.streamsLock.Lock()
+	drain, drained := s.drain, s.drained
+	s.Network.streamsLock.Unlock()
+	if drain == nil {
+		return nil
+	}
+	s.drainOnce.Do(func() { close(drain) })
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Unsubscribe unsubscribes from the broadcast
+func (s *BasicSubscriber) Unsubscribe() error {
+	if s.cancelWorker != nil {
+		s.cancelWorker()
+	}
+
+	//Remove self from local broadcaster listener pool if it's in there
+	if b := s.Network.getBroadcaster(s.StrmID); b != nil {
+		delete(b.listeners, "localSub")
+	}
+
+	//Remove self from network
+	s.Network.deleteSubscriber(s.StrmID)
+
+	return nil
+}
+
+func (s *BasicSubscriber) String() string {
+	return fmt.Sprintf("StreamID: %v, working: %v", s.StrmID, s.isWorking())
+}
+
+func (s *BasicSubscriber) IsLive() bool {
+	return s.isWorking()
+}
+
+func (s *BasicSubscriber) isWorking() bool {
+	s.workingLock.Lock()
+	defer s.workingLock.Unlock()
+	return s.working
+}
+
+func (s *BasicSubscriber) setWorking(working bool) {
+	s.workingLock.Lock()
+	defer s.workingLock.Unlock()
+	s.working = working
+}
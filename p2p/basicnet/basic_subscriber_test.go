@@ -0,0 +1,63 @@
+package basicnet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	n1, _ := setupNodes(t, 15028, 15029)
+	strmID := fmt.Sprintf("%vStrmID", n1.GetNodeID())
+	n1.GetBroadcaster(strmID)
+	s, _ := n1.GetSubscriber(strmID)
+	sub := s.(*BasicSubscriber)
+
+	//gotData holds up the first segment, so the others are queued
+	release := make(chan struct{})
+	got := make(chan uint64, 10)
+	eof := make(chan struct{})
+	sub.Subscribe(context.Background(), func(seqNo uint64, data []byte, isEOF bool) {
+		if isEOF {
+			close(eof)
+			return
+		}
+		if seqNo == 1 {
+			<-release
+		}
+		got <- seqNo
+	})
+	defer sub.Unsubscribe()
+	for i := uint64(1); i <= 3; i++ {
+		sub.InsertData(&StreamDataMsg{SeqNo: i, StrmID: strmID, Data: []byte("data")})
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	drained := make(chan error)
+	go func() { drained <- sub.Drain(context.Background()) }()
+	select {
+	case <-drained:
+		t.Fatalf("Expecting Drain to wait for the queued segments")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Errorf("Error draining: %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("Expecting the 3 queued segments to be passed on, got %v", len(got))
+	}
+	select {
+	case <-eof:
+	case <-time.After(time.Second):
+		t.Errorf("Expecting EOF after draining")
+	}
+
+	//Segments that come after aren't taken
+	sub.InsertData(&StreamDataMsg{SeqNo: 4, StrmID: strmID, Data: []byte("data")})
+	time.Sleep(100 * time.Millisecond)
+	if len(got) != 3 {
+		t.Errorf("Expecting no segments after draining, got %v", len(got))
+	}
+}
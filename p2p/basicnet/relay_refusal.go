@@ -0,0 +1,120 @@
+package basicnet
+
+import (
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+
+	"github.com/golang/glog"
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+//SetRelayRefuser sets what tells peers the node won't relay a stream to them.  Without one, refused peers aren't told, and just don't get
+//the stream.
+func (n *BasicVideoNetwork) SetRelayRefuser(r lpnet.RelayRefuser) {
+	n.relayRefuser = r
+}
+
+//allowRelay asks the relay limiter if the node can relay strmID to pid.  Peers the node relays to already are always allowed.
+func (n *BasicVideoNetwork) allowRelay(strmID string, pid peer.ID) bool {
+	if n.relayLimiter == nil {
+		return true
+	}
+	listeners := 0
+	if r := n.getRelayer(strmID, SubReqID); r != nil {
+		if r.hasListener(pid) {
+			return true
+		}
+		listeners = r.listenerCount()
+	}
+	return n.relayLimiter.AllowRelay(strmID, listeners)
+}
+
+//refuseRelay tells pid the node won't relay strmID, and points it to the upstream peer and the peers closest to the broadcaster.
+func (n *BasicVideoNetwork) refuseRelay(strmID string, pid peer.ID, upstream peer.ID) {
+	glog.Infof("Over upload capacity, refusing to relay %v to %v", strmID, peer.IDHexEncode(pid))
+	if n.relayRefuser == nil {
+		return
+	}
+	candidates := []peer.ID{upstream}
+	if peers, err := closestLocalPeers(n.NetworkNode.PeerHost.Peerstore(), strmID); err == nil {
+		candidates = append(candidates, peers...)
+	}
+	redirect := make([]string, 0)
+	seen := make(map[peer.ID]bool)
+	for _, p := range candidates {
+		if p == "" || p == pid || p == n.NetworkNode.Identity || seen[p] {
+			continue
+		}
+		seen[p] = true
+		redirect = append(redirect, peer.IDHexEncode(p))
+	}
+	n.relayRefuser.RefuseRelay(strmID, peer.IDHexEncode(pid), redirect)
+}
+
+//Redirect moves the subscriber and the relayer of strmID that get the stream from nodeID to the first node in redirect that takes the
+//subscription request.
+func (n *BasicVideoNetwork) Redirect(strmID string, nodeID string, redirect []string) bool {
+	from, err := peer.IDHexDecode(nodeID)
+	if err != nil {
+		return false
+	}
+	//Only move the subscriber and the relayer that get the stream from the refusing peer
+	s := n.getSubscriber(strmID)
+	if s != nil && n.UpstreamPeer(strmID) != nodeID {
+		s = nil
+	}
+	r := n.getRelayer(strmID, SubReqID)
+	if r != nil && r.upstream() != from {
+		r = nil
+	}
+	if s == nil && r == nil {
+		return false
+	}
+
+	for _, id := range redirect {
+		pid, err := peer.IDHexDecode(id)
+		if err != nil || pid == n.NetworkNode.Identity || pid == from {
+			continue
+		}
+		ns := n.NetworkNode.GetOutStream(pid)
+		if ns == nil {
+			continue
+		}
+		if err := ns.SendMessage(SubReqID, SubReqMsg{StrmID: strmID}); err != nil {
+			glog.Errorf("Error sending SubReq to %v: %v", id, err)
+			continue
+		}
+		if s != nil {
+			n.streamsLock.Lock()
+			s.UpstreamPeer = pid
+			n.streamsLock.Unlock()
+		}
+		if r != nil {
+			r.setUpstream(pid)
+			lpmon.Instance().LogRelay(strmID, id)
+		}
+		return true
+	}
+	glog.Errorf("Cannot subscribe to %v from any of the redirected peers", strmID)
+	return false
+}
+
+//shed drops a listener of a relayed stream, once the node is over capacity.  The relayer is cancelled upstream when nobody is left.
+func (n *BasicVideoNetwork) shed(strmID string, r *BasicRelayer, key string) {
+	l, left := r.removeListener(key)
+	if l == nil {
+		return
+	}
+	upstream := r.upstream()
+	go n.refuseRelay(strmID, l.Stream.Conn().RemotePeer(), upstream)
+	if left > 0 || n.getSubscriber(strmID) != nil {
+		return
+	}
+	n.deleteRelayer(strmID, SubReqID)
+	lpmon.Instance().RemoveRelay(strmID)
+	if ns := n.NetworkNode.GetOutStream(upstream); ns != nil {
+		if err := ns.SendMessage(CancelSubID, CancelSubMsg{StrmID: strmID}); err != nil {
+			glog.Errorf("Error sending cancel message to %v: %v", peer.IDHexEncode(upstream), err)
+		}
+	}
+}
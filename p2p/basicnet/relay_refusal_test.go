@@ -0,0 +1,106 @@
+package basicnet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+)
+
+type stubRelayRefuser struct {
+	lock     sync.Mutex
+	refused  []string
+	redirect []string
+}
+
+func (r *stubRelayRefuser) RefuseRelay(strmID string, nodeID string, redirect []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.refused = append(r.refused, nodeID)
+	r.redirect = redirect
+}
+
+func TestRedirect(t *testing.T) {
+	n1, n2 := setupNodes(t, 15020, 15021)
+	n3, _ := setupNodes(t, 15022, 15023)
+	connectHosts(n1.NetworkNode.PeerHost, n2.NetworkNode.PeerHost)
+	connectHosts(n1.NetworkNode.PeerHost, n3.NetworkNode.PeerHost)
+	id1, id2, id3 := n1.GetNodeID(), n2.GetNodeID(), n3.GetNodeID()
+	strmID := fmt.Sprintf("%vStrmID", id2)
+
+	//Nothing to move without a relayer or subscriber
+	if n1.Redirect(strmID, id2, []string{id3}) {
+		t.Errorf("Expecting no redirect")
+	}
+
+	//Only the peer the stream comes from can redirect it
+	r := n1.NewRelayer(strmID, SubReqID)
+	r.setUpstream(n2.NetworkNode.Identity)
+	if n1.Redirect(strmID, id3, []string{id3}) {
+		t.Errorf("Expecting no redirect from %v", id3)
+	}
+	if n1.Redirect(strmID, id2, []string{id1, id2, "bad", id3}) != true {
+		t.Errorf("Expecting a redirect to %v", id3)
+	}
+	if r.upstream() != n3.NetworkNode.Identity {
+		t.Errorf("Expecting the relayer to get %v from %v, got %v", strmID, id3, peer.IDHexEncode(r.upstream()))
+	}
+}
+
+func TestShed(t *testing.T) {
+	n1, n2 := setupNodes(t, 15024, 15025)
+	connectHosts(n1.NetworkNode.PeerHost, n2.NetworkNode.PeerHost)
+	refuser := &stubRelayRefuser{}
+	n1.SetRelayRefuser(refuser)
+	strmID := fmt.Sprintf("%vStrmID", n2.GetNodeID())
+
+	//The shed peer is refused, and the relayer goes once nobody is left
+	r := n1.NewRelayer(strmID, SubReqID)
+	r.AddListener(n1, n2.NetworkNode.Identity)
+	n1.shed(strmID, r, n2.GetNodeID())
+	if r.listenerCount() != 0 || n1.getRelayer(strmID, SubReqID) != nil {
+		t.Errorf("Expecting the relayer to be gone, got %v", r)
+	}
+	//Shedding twice doesn't refuse twice
+	n1.shed(strmID, r, n2.GetNodeID())
+	n1.refuseRelay(strmID, n2.NetworkNode.Identity, n1.NetworkNode.Identity)
+	refuser.lock.Lock()
+	defer refuser.lock.Unlock()
+	if len(refuser.refused) > 2 {
+		t.Errorf("Expecting %v to be refused once by shed, got %v", n2.GetNodeID(), refuser.refused)
+	}
+	for _, id := range refuser.redirect {
+		if id == n1.GetNodeID() || id == n2.GetNodeID() {
+			t.Errorf("Expecting no redirect to the node or the refused peer, got %v", refuser.redirect)
+		}
+	}
+}
+
+func TestRelayersConcurrent(t *testing.T) {
+	//Run with -race: relay refusals come in on their own stream handler, next to the basicnet protocol
+	n1, n2 := setupNodes(t, 15026, 15027)
+	connectHosts(n1.NetworkNode.PeerHost, n2.NetworkNode.PeerHost)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		strmID := fmt.Sprintf("%vStrmID%v", n2.GetNodeID(), i)
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			r := n1.NewRelayer(strmID, SubReqID)
+			r.setUpstream(n2.NetworkNode.Identity)
+			r.AddListener(n1, n2.NetworkNode.Identity)
+		}()
+		go func() {
+			defer wg.Done()
+			n1.Redirect(strmID, n2.GetNodeID(), []string{n2.GetNodeID()})
+			n1.allowRelay(strmID, n2.NetworkNode.Identity)
+		}()
+		go func() {
+			defer wg.Done()
+			n1.nodeStatus()
+			handleCancelSubReq(n1, CancelSubMsg{StrmID: strmID}, n2.NetworkNode.Identity)
+		}()
+	}
+	wg.Wait()
+}
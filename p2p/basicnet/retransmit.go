@@ -0,0 +1,46 @@
+package basicnet
+
+import (
+	"time"
+
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+//RetransmitBufferSize is how many of the latest segments a broadcaster keeps to send again.
+var RetransmitBufferSize = 10
+
+//SequencerCheckInterval is how often subscribers check their sequencer for gaps to ask for, or to give up on.
+var SequencerCheckInterval = 500 * time.Millisecond
+
+//SetSequencerFunc sets the function that creates the sequencer of each subscription.  Without one, subscribers pass segments on as they
+//come, and don't ask for lost ones.
+func (n *BasicVideoNetwork) SetSequencerFunc(f func(strmID string) lpnet.SegmentSequencer) {
+	n.sequencerFunc = f
+}
+
+//SetRetransmitter sets what subscribers use to ask for the segments their sequencer finds missing.
+func (n *BasicVideoNetwork) SetRetransmitter(r lpnet.SegmentRetransmitter) {
+	n.retransmitter = r
+}
+
+//RecentSegments returns the segments out of seqNos that the node still has of a stream it broadcasts.
+func (n *BasicVideoNetwork) RecentSegments(strmID string, seqNos []uint64) []lpnet.Segment {
+	segs := make([]lpnet.Segment, 0)
+	b := n.getBroadcaster(strmID)
+	if b == nil {
+		return segs
+	}
+	for _, sd := range b.recentSegments(seqNos) {
+		segs = append(segs, lpnet.Segment{SeqNo: sd.SeqNo, Data: sd.Data})
+	}
+	return segs
+}
+
+//InsertSegment inserts a segment into the subscription of strmID, as if it came from the upstream peer.
+func (n *BasicVideoNetwork) InsertSegment(strmID string, seg lpnet.Segment) error {
+	s := n.getSubscriber(strmID)
+	if s == nil {
+		return ErrSubscriber
+	}
+	return s.InsertData(&StreamDataMsg{SeqNo: seg.SeqNo, StrmID: strmID, Data: seg.Data})
+}
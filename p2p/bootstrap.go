@@ -0,0 +1,85 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	gonet "net"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+)
+
+var ErrBootstrapAddr = errors.New("ErrBootstrapAddr")
+
+//dnsaddrPrefix starts the TXT records with bootstrap nodes, like the dnsaddr records of libp2p.
+const dnsaddrPrefix = "dnsaddr="
+
+var lookupTXT = gonet.LookupTXT
+
+//ParseBootstrapAddr parses the multiaddr of a bootstrap node, which ends with its peer ID, e.g.
+///ip4/52.15.174.204/tcp/15000/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC
+func ParseBootstrapAddr(s string) (core.PeerConn, error) {
+	addr, err := ma.NewMultiaddr(strings.TrimSpace(s))
+	if err != nil {
+		return core.PeerConn{}, err
+	}
+	id, err := addr.ValueForProtocol(ma.P_IPFS)
+	if err != nil {
+		return core.PeerConn{}, ErrBootstrapAddr
+	}
+	pid, err := peer.IDB58Decode(id)
+	if err != nil {
+		return core.PeerConn{}, err
+	}
+	ipfsAddr, err := ma.NewMultiaddr("/ipfs/" + id)
+	if err != nil {
+		return core.PeerConn{}, err
+	}
+	transport := addr.Decapsulate(ipfsAddr)
+	if transport == nil || len(transport.Bytes()) == 0 {
+		return core.PeerConn{}, ErrBootstrapAddr
+	}
+	return core.PeerConn{NodeID: peer.IDHexEncode(pid), NodeAddr: transport.String()}, nil
+}
+
+//ParseBootstrapAddrs parses a comma separated list of bootstrap multiaddrs.
+func ParseBootstrapAddrs(s string) ([]core.PeerConn, error) {
+	conns := make([]core.PeerConn, 0)
+	for _, addr := range strings.Split(s, ",") {
+		if strings.TrimSpace(addr) == "" {
+			continue
+		}
+		pc, err := ParseBootstrapAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap node %q: %v", strings.TrimSpace(addr), err)
+		}
+		conns = append(conns, pc)
+	}
+	return conns, nil
+}
+
+//LookupBootstrapAddrs gets the bootstrap nodes in the TXT records of a domain.  Each record has one node, as dnsaddr=<multiaddr>.  Other
+//records, and records that don't parse, are left out.
+func LookupBootstrapAddrs(domain string) ([]core.PeerConn, error) {
+	records, err := lookupTXT(domain)
+	if err != nil {
+		return nil, err
+	}
+	conns := make([]core.PeerConn, 0)
+	for _, r := range records {
+		if !strings.HasPrefix(r, dnsaddrPrefix) {
+			continue
+		}
+		pc, err := ParseBootstrapAddr(strings.TrimPrefix(r, dnsaddrPrefix))
+		if err != nil {
+			glog.Errorf("Invalid bootstrap node %q in the TXT records of %v: %v", r, domain, err)
+			continue
+		}
+		conns = append(conns, pc)
+	}
+	return conns, nil
+}
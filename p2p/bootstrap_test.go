@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testnetBootAddr = "/ip4/52.15.174.204/tcp/15000/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC"
+const testnetBootID = "12208a4eb428aa57a74ef0593612adb88077c75c71ad07c3c26e4e7a8d4860083b01"
+
+func TestParseBootstrapAddr(t *testing.T) {
+	pc, err := ParseBootstrapAddr(testnetBootAddr)
+	if err != nil || pc.NodeID != testnetBootID || pc.NodeAddr != "/ip4/52.15.174.204/tcp/15000" {
+		t.Errorf("Wrong bootstrap node: %+v %v", pc, err)
+	}
+
+	for _, addr := range []string{"", "/ip4/52.15.174.204/tcp/15000", "/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC", "52.15.174.204:15000"} {
+		if _, err := ParseBootstrapAddr(addr); err == nil {
+			t.Errorf("Expecting an error for %q", addr)
+		}
+	}
+
+	conns, err := ParseBootstrapAddrs(" " + testnetBootAddr + ",,/ip6/::1/tcp/15001/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC ")
+	if err != nil || len(conns) != 2 || conns[1].NodeAddr != "/ip6/::1/tcp/15001" {
+		t.Errorf("Wrong bootstrap nodes: %v %v", conns, err)
+	}
+	if _, err := ParseBootstrapAddrs(testnetBootAddr + ",foo"); err == nil {
+		t.Errorf("Expecting an error for a bad entry")
+	}
+	if conns, err := ParseBootstrapAddrs(""); err != nil || len(conns) != 0 {
+		t.Errorf("Expecting no bootstrap nodes, got %v %v", conns, err)
+	}
+}
+
+func TestLookupBootstrapAddrs(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupTXT = f }(lookupTXT)
+	lookupTXT = func(domain string) ([]string, error) {
+		if domain != "bootstrap.example.org" {
+			return nil, fmt.Errorf("no such host")
+		}
+		return []string{"v=spf1 -all", "dnsaddr=" + testnetBootAddr, "dnsaddr=/ip4/1.2.3.4", "dnsaddr=/ip4/1.2.3.4/tcp/15000/ipfs/QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC"}, nil
+	}
+
+	conns, err := LookupBootstrapAddrs("bootstrap.example.org")
+	if err != nil || len(conns) != 2 || conns[0].NodeID != testnetBootID || conns[1].NodeAddr != "/ip4/1.2.3.4/tcp/15000" {
+		t.Errorf("Wrong bootstrap nodes: %v %v", conns, err)
+	}
+	if _, err := LookupBootstrapAddrs("example.com"); err == nil {
+		t.Errorf("Expecting the lookup error")
+	}
+}
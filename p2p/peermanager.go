@@ -0,0 +1,360 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/p2p/basicnet"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	ping "gx/ipfs/Qmbgce14YTWE2qhE49JVvTBPaHTyz3FaFmqQPyuZAz6C28/go-libp2p/p2p/protocol/ping"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+var ErrPeerManager = errors.New("ErrPeerManager")
+
+//PingInterval is how often the peers are pinged.
+var PingInterval = 30 * time.Second
+var PingTimeout = 10 * time.Second
+var DialTimeout = 10 * time.Second
+
+//MaxPingFailures is how many pings in a row a peer can miss before it's evicted.
+var MaxPingFailures = 3
+
+//MaxMisbehavior is how many bad messages a peer can send before it's evicted.
+var MaxMisbehavior = 3
+
+//EvictionTime is how long the node stays away from a peer it evicted.
+var EvictionTime = 10 * time.Minute
+
+//rttWeight is the weight of the latest ping in the smoothed round trip time.
+const rttWeight = 0.2
+
+//PeerManager pings the peers of the node, evicts the ones that stop answering or misbehave, and connects to the peers it knows about when
+//the node has fewer than TargetPeers.  Known peers are the bootstrap peers, the peers in the peer cache of the network and the peers in
+//the peerstore.
+type PeerManager struct {
+	//TargetPeers is how many peers the node stays connected to
+	TargetPeers int
+	//MaxPeers is how many peers the node can be connected to before the slowest are dropped, 0 for no limit
+	MaxPeers int
+
+	host      host.Host
+	cacheFile string
+	lock      sync.Mutex
+	peers     map[peer.ID]*core.PeerStats
+	bootstrap []pstore.PeerInfo
+}
+
+//NewPeerManager starts answering pings on h.  cacheFile is the peer cache written by the network, empty if there is none.
+func NewPeerManager(h host.Host, cacheFile string, targetPeers, maxPeers int) *PeerManager {
+	ping.NewPingService(h)
+	return &PeerManager{TargetPeers: targetPeers, MaxPeers: maxPeers, host: h, cacheFile: cacheFile, peers: make(map[peer.ID]*core.PeerStats)}
+}
+
+func (m *PeerManager) Start(ctx context.Context, bootstrap []core.PeerConn) {
+	m.lock.Lock()
+	m.bootstrap = make([]pstore.PeerInfo, 0)
+	for _, pc := range bootstrap {
+		info, err := peerInfo(pc.NodeID, []string{pc.NodeAddr})
+		if err != nil {
+			glog.Errorf("Invalid bootstrap peer %v at %v: %v", pc.NodeID, pc.NodeAddr, err)
+			continue
+		}
+		m.bootstrap = append(m.bootstrap, info)
+	}
+	m.lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.check(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+//check pings the connected peers, then drops peers over MaxPeers and connects to new ones under TargetPeers.
+func (m *PeerManager) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pid := range m.host.Network().Peers() {
+		if until, evicted := m.evictedUntil(pid); evicted {
+			//Evicted peers can still connect to the node
+			glog.V(common.DEBUG).Infof("Disconnecting from evicted peer %v until %v", peer.IDHexEncode(pid), until)
+			m.host.Network().ClosePeer(pid)
+			continue
+		}
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, PingTimeout)
+			defer cancel()
+			rtt, err := Ping(pctx, m.host, pid)
+			m.gotPing(pid, rtt, err)
+		}(pid)
+	}
+	wg.Wait()
+
+	m.trim()
+	m.reconnect(ctx)
+	m.forget()
+}
+
+func (m *PeerManager) gotPing(pid peer.ID, rtt time.Duration, err error) {
+	m.lock.Lock()
+	st := m.stats(pid)
+	switch {
+	case err != nil:
+		st.PingFailures++
+		glog.V(common.DEBUG).Infof("Peer %v missed a ping: %v", st.NodeID, err)
+	default:
+		st.PingFailures = 0
+		st.LastSeen = time.Now()
+		if st.RTT == 0 {
+			st.RTT = rtt
+		} else {
+			st.RTT = time.Duration((1-rttWeight)*float64(st.RTT) + rttWeight*float64(rtt))
+		}
+	}
+	failures, misbehavior := st.PingFailures, st.Misbehavior
+	m.lock.Unlock()
+
+	if failures >= MaxPingFailures {
+		m.evict(pid, fmt.Sprintf("missed %v pings", failures))
+	} else if misbehavior >= MaxMisbehavior {
+		m.evict(pid, fmt.Sprintf("sent %v bad messages", misbehavior))
+	}
+}
+
+//ReportMisbehavior counts a bad message from a peer.  Peers are evicted after MaxMisbehavior bad messages.
+func (m *PeerManager) ReportMisbehavior(nodeID core.NodeID, reason string) {
+	pid, err := peer.IDHexDecode(string(nodeID))
+	if err != nil {
+		return
+	}
+	m.lock.Lock()
+	st := m.stats(pid)
+	st.Misbehavior++
+	misbehavior := st.Misbehavior
+	m.lock.Unlock()
+	glog.Infof("Peer %v misbehaved: %v", nodeID, reason)
+
+	if misbehavior >= MaxMisbehavior {
+		m.evict(pid, fmt.Sprintf("sent %v bad messages, last: %v", misbehavior, reason))
+	}
+}
+
+func (m *PeerManager) EvictPeer(nodeID core.NodeID, reason string) error {
+	pid, err := peer.IDHexDecode(string(nodeID))
+	if err != nil {
+		return ErrPeerManager
+	}
+	m.evict(pid, reason)
+	return nil
+}
+
+func (m *PeerManager) evict(pid peer.ID, reason string) {
+	m.lock.Lock()
+	st := m.stats(pid)
+	st.EvictedUntil = time.Now().Add(EvictionTime)
+	st.EvictReason = reason
+	st.PingFailures = 0
+	st.Misbehavior = 0
+	m.lock.Unlock()
+
+	glog.Infof("Evicting peer %v for %v: %v", st.NodeID, EvictionTime, reason)
+	if err := m.host.Network().ClosePeer(pid); err != nil {
+		glog.Errorf("Error disconnecting from peer %v: %v", st.NodeID, err)
+	}
+}
+
+func (m *PeerManager) evictedUntil(pid peer.ID) (time.Time, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	st, ok := m.peers[pid]
+	if !ok || time.Now().After(st.EvictedUntil) {
+		return time.Time{}, false
+	}
+	return st.EvictedUntil, true
+}
+
+//trim drops the slowest peers over MaxPeers.  Bootstrap peers, and peers that haven't answered a ping yet, are kept.
+func (m *PeerManager) trim() {
+	connected := m.host.Network().Peers()
+	if m.MaxPeers <= 0 || len(connected) <= m.MaxPeers {
+		return
+	}
+
+	m.lock.Lock()
+	bootstrap := make(map[peer.ID]bool)
+	for _, info := range m.bootstrap {
+		bootstrap[info.ID] = true
+	}
+	candidates := make(peersByRTT, 0)
+	for _, pid := range connected {
+		if st, ok := m.peers[pid]; ok && st.RTT > 0 && !bootstrap[pid] {
+			candidates = append(candidates, peerRTT{pid: pid, rtt: st.RTT})
+		}
+	}
+	m.lock.Unlock()
+
+	sort.Sort(sort.Reverse(candidates))
+	for i := 0; i < len(connected)-m.MaxPeers && i < len(candidates); i++ {
+		glog.V(common.SHORT).Infof("Dropping peer %v with round trip time %v, the node has more than %v peers", peer.IDHexEncode(candidates[i].pid), candidates[i].rtt, m.MaxPeers)
+		m.host.Network().ClosePeer(candidates[i].pid)
+	}
+}
+
+//reconnect connects to known peers until the node has TargetPeers.  Bootstrap peers are tried first.
+func (m *PeerManager) reconnect(ctx context.Context) {
+	connected := len(m.host.Network().Peers())
+	if connected >= m.TargetPeers {
+		return
+	}
+
+	for _, info := range m.candidates() {
+		if connected >= m.TargetPeers {
+			return
+		}
+		dctx, cancel := context.WithTimeout(ctx, DialTimeout)
+		err := m.host.Connect(dctx, info)
+		cancel()
+		if err != nil {
+			glog.V(common.DEBUG).Infof("Cannot reconnect to peer %v: %v", peer.IDHexEncode(info.ID), err)
+			continue
+		}
+		m.host.Peerstore().AddAddrs(info.ID, info.Addrs, pstore.PermanentAddrTTL)
+		glog.V(common.SHORT).Infof("Reconnected to peer %v", peer.IDHexEncode(info.ID))
+		connected++
+	}
+}
+
+//candidates are the known peers the node isn't connected to and didn't evict.
+func (m *PeerManager) candidates() []pstore.PeerInfo {
+	m.lock.Lock()
+	infos := append([]pstore.PeerInfo{}, m.bootstrap...)
+	m.lock.Unlock()
+	if m.cacheFile != "" {
+		infos = append(infos, basicnet.NewPeerCache(m.host.Peerstore(), m.cacheFile).LoadPeers()...)
+	}
+	for _, pid := range m.host.Peerstore().Peers() {
+		infos = append(infos, m.host.Peerstore().PeerInfo(pid))
+	}
+
+	seen := make(map[peer.ID]bool)
+	result := make([]pstore.PeerInfo, 0)
+	for _, info := range infos {
+		if seen[info.ID] || info.ID == m.host.ID() || len(info.Addrs) == 0 {
+			continue
+		}
+		seen[info.ID] = true
+		if m.host.Network().Connectedness(info.ID) == net.Connected {
+			continue
+		}
+		if _, evicted := m.evictedUntil(info.ID); evicted {
+			continue
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+//forget drops the stats of peers the node isn't connected to, unless they are evicted.
+func (m *PeerManager) forget() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	for pid, st := range m.peers {
+		if m.host.Network().Connectedness(pid) != net.Connected && now.After(st.EvictedUntil) {
+			delete(m.peers, pid)
+		}
+	}
+}
+
+//stats returns the stats of a peer, adding them if they aren't there.  Needs the lock.
+func (m *PeerManager) stats(pid peer.ID) *core.PeerStats {
+	st, ok := m.peers[pid]
+	if !ok {
+		st = &core.PeerStats{NodeID: peer.IDHexEncode(pid)}
+		m.peers[pid] = st
+	}
+	return st
+}
+
+//Peers returns the stats of the connected peers and the evicted ones.
+func (m *PeerManager) Peers() []core.PeerStats {
+	m.lock.Lock()
+	stats := make(map[peer.ID]core.PeerStats)
+	for pid, st := range m.peers {
+		stats[pid] = *st
+	}
+	m.lock.Unlock()
+	for _, pid := range m.host.Network().Peers() {
+		if _, ok := stats[pid]; !ok {
+			stats[pid] = core.PeerStats{NodeID: peer.IDHexEncode(pid)}
+		}
+	}
+
+	result := make(peerStatsByID, 0, len(stats))
+	for pid, st := range stats {
+		if time.Now().After(st.EvictedUntil) {
+			st.EvictedUntil, st.EvictReason = time.Time{}, ""
+		}
+		st.Connected = m.host.Network().Connectedness(pid) == net.Connected
+		st.Addrs = make([]string, 0)
+		for _, addr := range m.host.Peerstore().Addrs(pid) {
+			st.Addrs = append(st.Addrs, addr.String())
+		}
+		result = append(result, st)
+	}
+	sort.Sort(result)
+	return result
+}
+
+func peerInfo(nodeID string, addrs []string) (pstore.PeerInfo, error) {
+	pid, err := peer.IDHexDecode(nodeID)
+	if err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	info := pstore.PeerInfo{ID: pid, Addrs: make([]ma.Multiaddr, 0)}
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return pstore.PeerInfo{}, err
+		}
+		info.Addrs = append(info.Addrs, maddr)
+	}
+	return info, nil
+}
+
+type peerRTT struct {
+	pid peer.ID
+	rtt time.Duration
+}
+
+type peersByRTT []peerRTT
+
+func (p peersByRTT) Len() int           { return len(p) }
+func (p peersByRTT) Swap(a, b int)      { p[a], p[b] = p[b], p[a] }
+func (p peersByRTT) Less(a, b int) bool { return p[a].rtt < p[b].rtt }
+
+type peerStatsByID []core.PeerStats
+
+func (p peerStatsByID) Len() int           { return len(p) }
+func (p peerStatsByID) Swap(a, b int)      { p[a], p[b] = p[b], p[a] }
+func (p peerStatsByID) Less(a, b int) bool { return p[a].NodeID < p[b].NodeID }
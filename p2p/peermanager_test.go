@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/p2p/basicnet"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	pstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	crypto "gx/ipfs/QmaPbCnUMBohSGo3KnxEa2bHqyJVVeEEcwtqJAYxerieBo/go-libp2p-crypto"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+func newHost(t *testing.T, port int) host.Host {
+	priv, pub, _ := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	n, err := basicnet.NewNode(port, priv, pub, &basicnet.BasicNotifiee{})
+	if err != nil {
+		t.Fatalf("Error creating node: %v", err)
+	}
+	return n.PeerHost
+}
+
+func connect(t *testing.T, h1, h2 host.Host) {
+	h1.Peerstore().AddAddrs(h2.ID(), h2.Addrs(), pstore.PermanentAddrTTL)
+	if err := h1.Connect(context.Background(), pstore.PeerInfo{ID: h2.ID()}); err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15100), newHost(t, 15101), newHost(t, 15102)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	NewPeerManager(h2, "", 0, 0)
+	connect(t, h1, h2)
+	connect(t, h1, h3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if rtt, err := Ping(ctx, h1, h2.ID()); err != nil || rtt <= 0 {
+		t.Errorf("Expecting a round trip time, got %v %v", rtt, err)
+	}
+	//h3 doesn't know the ping protocol, like older nodes
+	if rtt, err := Ping(ctx, h1, h3.ID()); err != nil || rtt <= 0 {
+		t.Errorf("Expecting a round trip time from a node without the protocol, got %v %v", rtt, err)
+	}
+}
+
+func TestPeerManager(t *testing.T) {
+	oldFailures := MaxPingFailures
+	defer func() { MaxPingFailures = oldFailures }()
+	MaxPingFailures = 2
+
+	h1, h2, h3 := newHost(t, 15110), newHost(t, 15111), newHost(t, 15112)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	m := NewPeerManager(h1, "", 2, 0)
+	NewPeerManager(h2, "", 0, 0)
+	NewPeerManager(h3, "", 0, 0)
+	connect(t, h1, h2)
+	m.Start(context.Background(), []core.PeerConn{{NodeID: peer.IDHexEncode(h3.ID()), NodeAddr: h3.Addrs()[0].String()}})
+
+	//Connects to the bootstrap peer to get to 2 peers
+	m.check(context.Background())
+	if h1.Network().Connectedness(h3.ID()) != net.Connected {
+		t.Errorf("Expecting to reconnect to the bootstrap peer")
+	}
+	peers := m.Peers()
+	if len(peers) != 2 || !peers[0].Connected || !peers[1].Connected {
+		t.Fatalf("Expecting 2 connected peers, got %+v", peers)
+	}
+	for _, p := range peers {
+		if p.NodeID == peer.IDHexEncode(h2.ID()) && (p.RTT <= 0 || p.LastSeen.IsZero()) {
+			t.Errorf("Expecting the round trip time of %v, got %+v", p.NodeID, p)
+		}
+	}
+
+	//Evicted peers are disconnected and not reconnected
+	if err := m.EvictPeer(core.NodeID(peer.IDHexEncode(h2.ID())), "test"); err != nil {
+		t.Fatalf("Error evicting peer: %v", err)
+	}
+	m.check(context.Background())
+	if h1.Network().Connectedness(h2.ID()) == net.Connected {
+		t.Errorf("Expecting the evicted peer to stay disconnected")
+	}
+	for _, p := range m.Peers() {
+		if p.NodeID == peer.IDHexEncode(h2.ID()) && (p.Connected || p.EvictedUntil.IsZero() || p.EvictReason != "test") {
+			t.Errorf("Expecting %v to be evicted, got %+v", p.NodeID, p)
+		}
+	}
+	if err := m.EvictPeer("bad", "test"); err != ErrPeerManager {
+		t.Errorf("Expecting ErrPeerManager, got %v", err)
+	}
+
+	//Peers that stop answering are evicted after MaxPingFailures
+	m.gotPing(h3.ID(), 0, context.DeadlineExceeded)
+	if h1.Network().Connectedness(h3.ID()) != net.Connected {
+		t.Errorf("Expecting the peer to stay after one missed ping")
+	}
+	m.gotPing(h3.ID(), 0, context.DeadlineExceeded)
+	if _, evicted := m.evictedUntil(h3.ID()); !evicted || h1.Network().Connectedness(h3.ID()) == net.Connected {
+		t.Errorf("Expecting the peer to be evicted after missing %v pings", MaxPingFailures)
+	}
+}
+
+func TestPeerManagerTrim(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15120), newHost(t, 15121), newHost(t, 15122)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	m := NewPeerManager(h1, "", 1, 1)
+	connect(t, h1, h2)
+	connect(t, h1, h3)
+
+	//The slowest peer is dropped
+	m.gotPing(h2.ID(), 10*time.Millisecond, nil)
+	m.gotPing(h3.ID(), 100*time.Millisecond, nil)
+	m.trim()
+	if h1.Network().Connectedness(h3.ID()) == net.Connected || h1.Network().Connectedness(h2.ID()) != net.Connected {
+		t.Errorf("Expecting only the faster peer to stay")
+	}
+	if _, evicted := m.evictedUntil(h3.ID()); evicted {
+		t.Errorf("Expecting dropped peers not to be evicted")
+	}
+}
+
+func TestReportMisbehavior(t *testing.T) {
+	h1, h2 := newHost(t, 15130), newHost(t, 15131)
+	defer h1.Close()
+	defer h2.Close()
+	m := NewPeerManager(h1, "", 1, 0)
+	connect(t, h1, h2)
+
+	//Listing the peers doesn't add stats
+	if peers := m.Peers(); len(peers) != 1 || !peers[0].Connected || len(m.peers) != 0 {
+		t.Errorf("Expecting 1 connected peer without stats, got %+v %v", peers, m.peers)
+	}
+
+	nid := core.NodeID(peer.IDHexEncode(h2.ID()))
+	for i := 1; i < MaxMisbehavior; i++ {
+		m.ReportMisbehavior(nid, "test")
+	}
+	if _, evicted := m.evictedUntil(h2.ID()); evicted {
+		t.Errorf("Expecting the peer to stay under %v bad messages", MaxMisbehavior)
+	}
+	if peers := m.Peers(); len(peers) != 1 || peers[0].Misbehavior != MaxMisbehavior-1 {
+		t.Errorf("Expecting %v bad messages, got %+v", MaxMisbehavior-1, peers)
+	}
+	m.ReportMisbehavior(nid, "test")
+	if _, evicted := m.evictedUntil(h2.ID()); !evicted || h1.Network().Connectedness(h2.ID()) == net.Connected {
+		t.Errorf("Expecting the peer to be evicted after %v bad messages", MaxMisbehavior)
+	}
+}
@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	msmux "gx/ipfs/QmTnsezaB1wWNRHeHnYrm8K4d5i9wtyj3GsqjC3Rt5b5v5/go-multistream"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	ping "gx/ipfs/Qmbgce14YTWE2qhE49JVvTBPaHTyz3FaFmqQPyuZAz6C28/go-libp2p/p2p/protocol/ping"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+var ErrPing = errors.New("ErrPing")
+
+//Ping measures the round trip time to a peer with the libp2p ping protocol.  Nodes from before the ping protocol refuse the stream, which
+//takes a round trip as well, so they are timed by how long that takes.
+func Ping(ctx context.Context, h host.Host, pid peer.ID) (time.Duration, error) {
+	//The ping service keeps pinging until ctx is done
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	rtts, err := (&ping.PingService{Host: h}).Ping(ctx, pid)
+	if err != nil {
+		if notSupported(err) {
+			return time.Since(start), nil
+		}
+		return 0, err
+	}
+	select {
+	case rtt, ok := <-rtts:
+		if !ok {
+			return 0, ErrPing
+		}
+		return rtt, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+//notSupported tells if a stream failed because the peer doesn't have the protocol.
+func notSupported(err error) bool {
+	return err == msmux.ErrNotSupported
+}
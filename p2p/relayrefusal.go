@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+	lpnet "github.com/livepeer/go-livepeer/net"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	peerstore "gx/ipfs/QmPgDWmTmuzvP7QE5zwo1TmjbJme9pmZHNujB2453jkCTr/go-libp2p-peerstore"
+	ma "gx/ipfs/QmXY77cVe7rVRQXZZQRioukUM7aRW3BTcAgJe12MCtb3Ji/go-multiaddr"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+//RelayRefusalProtocol carries a relayRefusal.  It is separate from the basicnet protocol, since older nodes close the stream on messages
+//they don't know.
+const RelayRefusalProtocol = protocol.ID("/livepeer_relay_refusal/0.0.1")
+
+var RelayRefusalTimeout = 10 * time.Second
+
+//MaxRelayRedirects is how many peers a refusal points to.
+const MaxRelayRedirects = 3
+
+//relayRefusal tells a peer the node won't relay a stream to it, and which peers it can ask instead.
+type relayRefusal struct {
+	StrmID   string
+	Redirect []redirectPeer
+}
+
+type redirectPeer struct {
+	NodeID string
+	Addrs  []string
+}
+
+//RelayRefusals tells peers the node won't relay streams to them, and moves the subscriptions of the node when its peers refuse it.
+type RelayRefusals struct {
+	host    host.Host
+	network lpnet.RedirectNetwork
+}
+
+//NewRelayRefusals starts taking relay refusals on h, and moves the subscriptions of nw that get refused.
+func NewRelayRefusals(h host.Host, nw lpnet.RedirectNetwork) *RelayRefusals {
+	r := &RelayRefusals{host: h, network: nw}
+	h.SetStreamHandler(RelayRefusalProtocol, r.handleRelayRefusal)
+	return r
+}
+
+//RefuseRelay tells nodeID the node won't relay strmID to it, and points it to the first MaxRelayRedirects peers of redirect, with the
+//addresses the node has for them.
+func (r *RelayRefusals) RefuseRelay(strmID string, nodeID string, redirect []string) {
+	pid, err := peer.IDHexDecode(nodeID)
+	if err != nil {
+		glog.Errorf("Cannot refuse relay to %v: %v", nodeID, err)
+		return
+	}
+	msg := relayRefusal{StrmID: strmID, Redirect: make([]redirectPeer, 0)}
+	for _, id := range redirect {
+		if len(msg.Redirect) == MaxRelayRedirects {
+			break
+		}
+		p, err := peer.IDHexDecode(id)
+		if err != nil || p == pid || p == r.host.ID() {
+			continue
+		}
+		rp := redirectPeer{NodeID: id, Addrs: make([]string, 0)}
+		for _, addr := range r.host.Peerstore().Addrs(p) {
+			rp.Addrs = append(rp.Addrs, addr.String())
+		}
+		msg.Redirect = append(msg.Redirect, rp)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RelayRefusalTimeout)
+	defer cancel()
+	s, err := r.host.NewStream(ctx, pid, RelayRefusalProtocol)
+	if err != nil {
+		//Nodes from before the refusals don't take them
+		glog.V(4).Infof("Cannot send relay refusal to %v: %v", nodeID, err)
+		return
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(RelayRefusalTimeout))
+	if err := json.NewEncoder(s).Encode(msg); err != nil {
+		glog.Errorf("Error sending relay refusal to %v: %v", nodeID, err)
+	}
+}
+
+//handleRelayRefusal moves the subscription the refusing peer relays to the peers it points to.  Their addresses are only kept for long
+//enough to connect, so peers can't fill the peerstore with refusals for streams they don't relay.
+func (r *RelayRefusals) handleRelayRefusal(s net.Stream) {
+	defer s.Close()
+	remotePID := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(RelayRefusalTimeout))
+	var msg relayRefusal
+	if err := json.NewDecoder(s).Decode(&msg); err != nil {
+		glog.Errorf("Error decoding relay refusal from %v: %v", peer.IDHexEncode(remotePID), err)
+		return
+	}
+	glog.Infof("%v refused to relay %v, redirected to %v peers", peer.IDHexEncode(remotePID), msg.StrmID, len(msg.Redirect))
+
+	redirect := make([]string, 0)
+	for _, rp := range msg.Redirect {
+		if len(redirect) == MaxRelayRedirects {
+			break
+		}
+		pid, err := peer.IDHexDecode(rp.NodeID)
+		if err != nil || pid == r.host.ID() || pid == remotePID {
+			continue
+		}
+		for _, a := range rp.Addrs {
+			if addr, err := ma.NewMultiaddr(a); err == nil {
+				r.host.Peerstore().AddAddr(pid, addr, peerstore.TempAddrTTL)
+			}
+		}
+		redirect = append(redirect, rp.NodeID)
+	}
+	r.network.Redirect(msg.StrmID, peer.IDHexEncode(remotePID), redirect)
+}
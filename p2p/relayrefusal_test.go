@@ -0,0 +1,72 @@
+package p2p
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+)
+
+type redirect struct {
+	strmID   string
+	nodeID   string
+	redirect []string
+}
+
+type stubRedirectNetwork struct {
+	redirects chan redirect
+}
+
+func (n *stubRedirectNetwork) Redirect(strmID string, nodeID string, to []string) bool {
+	n.redirects <- redirect{strmID: strmID, nodeID: nodeID, redirect: to}
+	return true
+}
+
+func TestRelayRefusal(t *testing.T) {
+	h1, h2, h3 := newHost(t, 15150), newHost(t, 15151), newHost(t, 15152)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+	connect(t, h1, h2)
+	connect(t, h1, h3)
+	r1 := NewRelayRefusals(h1, &stubRedirectNetwork{redirects: make(chan redirect, 1)})
+	nw2 := &stubRedirectNetwork{redirects: make(chan redirect, 1)}
+	NewRelayRefusals(h2, nw2)
+
+	//The refused peer, the node itself and bad node IDs aren't redirected to
+	id1, id2, id3 := peer.IDHexEncode(h1.ID()), peer.IDHexEncode(h2.ID()), peer.IDHexEncode(h3.ID())
+	r1.RefuseRelay("strm", id2, []string{id2, id1, "bad", id3})
+	select {
+	case rd := <-nw2.redirects:
+		if rd.strmID != "strm" || rd.nodeID != id1 || fmt.Sprint(rd.redirect) != fmt.Sprint([]string{id3}) {
+			t.Errorf("Expecting strm from %v to go to %v, got %v", id1, id3, rd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting a redirect")
+	}
+	//The refused peer can connect to the peers it's redirected to
+	if len(h2.Peerstore().Addrs(h3.ID())) == 0 {
+		t.Errorf("Expecting the addresses of h3")
+	}
+
+	//Refusals point to MaxRelayRedirects peers at most
+	redirect := make([]string, 0)
+	for i := 0; i < MaxRelayRedirects+2; i++ {
+		h := newHost(t, 15153+i)
+		defer h.Close()
+		redirect = append(redirect, peer.IDHexEncode(h.ID()))
+	}
+	r1.RefuseRelay("strm", id2, redirect)
+	select {
+	case rd := <-nw2.redirects:
+		if len(rd.redirect) != MaxRelayRedirects {
+			t.Errorf("Expecting %v peers, got %v", MaxRelayRedirects, rd.redirect)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expecting a redirect")
+	}
+
+	//Nodes from before the refusals don't take them
+	r1.RefuseRelay("strm", id3, []string{id2})
+}
@@ -0,0 +1,176 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+	lpnet "github.com/livepeer/go-livepeer/net"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+//RetransmitProtocol carries a retransmitReq, answered with a retransmitData on the same stream.  It is separate from the basicnet
+//protocol, since older nodes close the stream on messages they don't know.
+const RetransmitProtocol = protocol.ID("/livepeer_retransmit/0.0.1")
+
+var RetransmitReqTimeout = 10 * time.Second
+
+type retransmitReq struct {
+	StrmID string
+	SeqNos []uint64
+}
+
+type retransmitSegment struct {
+	SeqNo  uint64
+	StrmID string
+	Data   []byte
+}
+
+type retransmitData struct {
+	StrmID   string
+	Segments []retransmitSegment
+}
+
+//Retransmitter asks the broadcaster of a stream for segments a subscription lost, and sends the segments of the streams the node
+//broadcasts again to the peers that ask.
+type Retransmitter struct {
+	host    host.Host
+	network lpnet.RetransmitNetwork
+	//Limiter counts the segments that are sent again, nil to not count them
+	Limiter lpnet.RelayLimiter
+
+	lock sync.Mutex
+	//inFlight has the streams with a request out, so a stream doesn't ask again before the peers answered
+	inFlight map[string]bool
+}
+
+//NewRetransmitter starts answering retransmit requests on h with the segments of nw.
+func NewRetransmitter(h host.Host, nw lpnet.RetransmitNetwork) *Retransmitter {
+	r := &Retransmitter{host: h, network: nw, inFlight: make(map[string]bool)}
+	h.SetStreamHandler(RetransmitProtocol, r.handleRetransmitReq)
+	return r
+}
+
+//Retransmit asks the broadcaster of strmID for seqNos, and then the upstream peer of the subscription in case it's the broadcaster or
+//knows how to reach it.  The segments that come back are inserted into the subscription.
+func (r *Retransmitter) Retransmit(strmID string, seqNos []uint64) {
+	r.lock.Lock()
+	if r.inFlight[strmID] {
+		r.lock.Unlock()
+		return
+	}
+	r.inFlight[strmID] = true
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.inFlight, strmID)
+		r.lock.Unlock()
+	}()
+
+	candidates := []peer.ID{}
+	sid := core.StreamID(strmID)
+	if len(strmID) >= core.NodeIDLength {
+		if pid, err := peer.IDHexDecode(string(sid.GetNodeID())); err == nil {
+			candidates = append(candidates, pid)
+		}
+	}
+	if up := r.network.UpstreamPeer(strmID); up != "" {
+		if pid, err := peer.IDHexDecode(up); err == nil {
+			candidates = append(candidates, pid)
+		}
+	}
+
+	missing := make(map[uint64]bool)
+	for _, seqNo := range seqNos {
+		missing[seqNo] = true
+	}
+	tried := make(map[peer.ID]bool)
+	for _, p := range candidates {
+		if p == r.host.ID() || tried[p] {
+			continue
+		}
+		tried[p] = true
+		req := retransmitReq{StrmID: strmID, SeqNos: make([]uint64, 0, len(missing))}
+		for _, seqNo := range seqNos {
+			if missing[seqNo] {
+				req.SeqNos = append(req.SeqNos, seqNo)
+			}
+		}
+		data, err := r.request(p, req)
+		if err != nil {
+			//Nodes from before the retransmissions don't take the requests
+			glog.V(4).Infof("Cannot get segments of %v again from %v: %v", strmID, peer.IDHexEncode(p), err)
+			continue
+		}
+		got := 0
+		for _, seg := range data.Segments {
+			if seg.StrmID != strmID || !missing[seg.SeqNo] {
+				continue
+			}
+			delete(missing, seg.SeqNo)
+			if err := r.network.InsertSegment(strmID, lpnet.Segment{SeqNo: seg.SeqNo, Data: seg.Data}); err != nil {
+				glog.Errorf("Error inserting segment %v of %v: %v", seg.SeqNo, strmID, err)
+				continue
+			}
+			got++
+		}
+		glog.Infof("Got %v of %v missing segments of %v from %v", got, len(req.SeqNos), strmID, peer.IDHexEncode(p))
+		if len(missing) == 0 {
+			return
+		}
+	}
+}
+
+func (r *Retransmitter) request(pid peer.ID, req retransmitReq) (*retransmitData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RetransmitReqTimeout)
+	defer cancel()
+	s, err := r.host.NewStream(ctx, pid, RetransmitProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(RetransmitReqTimeout))
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		return nil, err
+	}
+	var data retransmitData
+	if err := json.NewDecoder(s).Decode(&data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+//handleRetransmitReq answers with the segments the node still has of a stream it broadcasts.  Nodes that don't broadcast the stream
+//answer with no segments.
+func (r *Retransmitter) handleRetransmitReq(s net.Stream) {
+	defer s.Close()
+	remotePID := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(RetransmitReqTimeout))
+	var req retransmitReq
+	if err := json.NewDecoder(s).Decode(&req); err != nil {
+		glog.Errorf("Error decoding retransmit request from %v: %v", peer.IDHexEncode(remotePID), err)
+		return
+	}
+
+	data := retransmitData{StrmID: req.StrmID, Segments: make([]retransmitSegment, 0)}
+	for _, seg := range r.network.RecentSegments(req.StrmID, req.SeqNos) {
+		data.Segments = append(data.Segments, retransmitSegment{SeqNo: seg.SeqNo, StrmID: req.StrmID, Data: seg.Data})
+	}
+	glog.V(4).Infof("Sending %v of %v segments of %v again to %v", len(data.Segments), len(req.SeqNos), req.StrmID, peer.IDHexEncode(remotePID))
+	if err := json.NewEncoder(s).Encode(data); err != nil {
+		glog.Errorf("Error sending segments of %v again to %v: %v", req.StrmID, peer.IDHexEncode(remotePID), err)
+		return
+	}
+	if r.Limiter != nil {
+		for _, seg := range data.Segments {
+			r.Limiter.Sent(req.StrmID, len(seg.Data), false)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lpnet "github.com/livepeer/go-livepeer/net"
+
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+)
+
+type stubRetransmitNetwork struct {
+	lock     sync.Mutex
+	recent   map[uint64][]byte
+	inserted map[uint64][]byte
+	upstream string
+}
+
+func newStubRetransmitNetwork() *stubRetransmitNetwork {
+	return &stubRetransmitNetwork{recent: make(map[uint64][]byte), inserted: make(map[uint64][]byte)}
+}
+
+func (n *stubRetransmitNetwork) RecentSegments(strmID string, seqNos []uint64) []lpnet.Segment {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	segs := make([]lpnet.Segment, 0)
+	for _, seqNo := range seqNos {
+		if data, ok := n.recent[seqNo]; ok {
+			segs = append(segs, lpnet.Segment{SeqNo: seqNo, Data: data})
+		}
+	}
+	return segs
+}
+
+func (n *stubRetransmitNetwork) InsertSegment(strmID string, seg lpnet.Segment) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.inserted[seg.SeqNo] = seg.Data
+	return nil
+}
+
+func (n *stubRetransmitNetwork) UpstreamPeer(strmID string) string {
+	return n.upstream
+}
+
+func (n *stubRetransmitNetwork) insertedSeqNos() map[uint64][]byte {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	inserted := make(map[uint64][]byte)
+	for seqNo, data := range n.inserted {
+		inserted[seqNo] = data
+	}
+	return inserted
+}
+
+func TestRetransmit(t *testing.T) {
+	h1, h2 := newHost(t, 15140), newHost(t, 15141)
+	defer h1.Close()
+	defer h2.Close()
+	connect(t, h1, h2)
+
+	broadcaster := newStubRetransmitNetwork()
+	broadcaster.recent[3] = []byte("seg3")
+	broadcaster.recent[5] = []byte("seg5")
+	limiter := NewUploadLimiter(0)
+	NewRetransmitter(h1, broadcaster).Limiter = limiter
+	subscriber := newStubRetransmitNetwork()
+	r := NewRetransmitter(h2, subscriber)
+
+	//The broadcaster is asked first, its node ID starts the stream ID
+	strmID := peer.IDHexEncode(h1.ID()) + strings.Repeat("a", 64) + "P720p30fps16x9"
+	r.Retransmit(strmID, []uint64{3, 4, 5})
+	inserted := subscriber.insertedSeqNos()
+	if len(inserted) != 2 || string(inserted[3]) != "seg3" || string(inserted[5]) != "seg5" {
+		t.Errorf("Expecting segments 3 and 5, got %v", inserted)
+	}
+	//The broadcaster counts the segments after sending them
+	counted := false
+	for i := 0; i < 100 && !counted; i++ {
+		_, counted = limiter.Load().Streams[strmID]
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !counted {
+		t.Errorf("Expecting the segments sent again to be counted")
+	}
+
+	//Streams of other broadcasters get segments from the upstream peer
+	subscriber = newStubRetransmitNetwork()
+	subscriber.upstream = peer.IDHexEncode(h1.ID())
+	r = NewRetransmitter(h2, subscriber)
+	other := newHost(t, 15142)
+	defer other.Close()
+	r.Retransmit(peer.IDHexEncode(other.ID())+strings.Repeat("a", 64)+"P720p30fps16x9", []uint64{5})
+	if inserted := subscriber.insertedSeqNos(); len(inserted) != 1 || string(inserted[5]) != "seg5" {
+		t.Errorf("Expecting segment 5 from the upstream peer, got %v", inserted)
+	}
+
+	//Segments that weren't asked for aren't inserted
+	subscriber = newStubRetransmitNetwork()
+	r = NewRetransmitter(h2, subscriber)
+	r.Retransmit(strmID, []uint64{4})
+	if inserted := subscriber.insertedSeqNos(); len(inserted) != 0 {
+		t.Errorf("Expecting no segments, got %v", inserted)
+	}
+}
+
+func TestRetransmitConcurrent(t *testing.T) {
+	h1, h2 := newHost(t, 15143), newHost(t, 15144)
+	defer h1.Close()
+	defer h2.Close()
+	connect(t, h1, h2)
+
+	broadcaster := newStubRetransmitNetwork()
+	for i := uint64(0); i < 10; i++ {
+		broadcaster.recent[i] = []byte(fmt.Sprintf("seg%v", i))
+	}
+	NewRetransmitter(h1, broadcaster)
+	subscriber := newStubRetransmitNetwork()
+	r := NewRetransmitter(h2, subscriber)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		strmID := peer.IDHexEncode(h1.ID()) + strings.Repeat("a", 64) + fmt.Sprintf("P%vp", i)
+		for j := 0; j < 3; j++ {
+			wg.Add(1)
+			go func(strmID string) {
+				defer wg.Done()
+				r.Retransmit(strmID, []uint64{1, 2, 3})
+			}(strmID)
+		}
+	}
+	wg.Wait()
+	if inserted := subscriber.insertedSeqNos(); len(inserted) != 3 {
+		t.Errorf("Expecting segments 1 to 3, got %v", inserted)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.inFlight) != 0 {
+		t.Errorf("Expecting no requests in flight, got %v", r.inFlight)
+	}
+}
+
+func TestRetransmitUnsupported(t *testing.T) {
+	h1, h2 := newHost(t, 15145), newHost(t, 15146)
+	defer h1.Close()
+	defer h2.Close()
+	connect(t, h1, h2)
+
+	//h1 is a node from before the retransmissions
+	subscriber := newStubRetransmitNetwork()
+	r := NewRetransmitter(h2, subscriber)
+	r.Retransmit(peer.IDHexEncode(h1.ID())+strings.Repeat("a", 64)+"P720p30fps16x9", []uint64{1})
+	if inserted := subscriber.insertedSeqNos(); len(inserted) != 0 {
+		t.Errorf("Expecting no segments, got %v", inserted)
+	}
+}
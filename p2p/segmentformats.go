@@ -0,0 +1,78 @@
+/*
+Package p2p has the Livepeer protocols that run on the libp2p host of the node next to the basicnet protocol.  Nodes that don't speak
+one of them refuse the stream when the protocol is negotiated, so they can be told apart from nodes that are down.
+*/
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+
+	net "gx/ipfs/QmNa31VPzC561NWwRsJLE7nGYZYuuD2QfpK2b1q9BK54J1/go-libp2p-net"
+	peer "gx/ipfs/QmXYjuNuxVzXKJCfWasQk1RqkhVLDM9jtUKhqc2WPQmFSB/go-libp2p-peer"
+	protocol "gx/ipfs/QmZNkThpqfVXs9GNbexPrfBbXSLNYeKrE7jwFM2oqHbyqN/go-libp2p-protocol"
+	host "gx/ipfs/Qmc1XhrFEiSeBNn3mpfg6gEuYCt5im2gYmNVmncsvmpeAk/go-libp2p-host"
+)
+
+var ErrSegmentFormats = errors.New("ErrSegmentFormats")
+
+//SegmentFormatsProtocol answers with the segment formats the node decodes, as a comma separated line.
+const SegmentFormatsProtocol = protocol.ID("/livepeer/segmentformats/1.0.0")
+
+var SegmentFormatsTimeout = 10 * time.Second
+
+//SegmentFormats asks peers which segment formats they decode, and answers their requests.
+type SegmentFormats struct {
+	host host.Host
+}
+
+//NewSegmentFormats starts answering segment format requests on h.
+func NewSegmentFormats(h host.Host) *SegmentFormats {
+	h.SetStreamHandler(SegmentFormatsProtocol, func(s net.Stream) {
+		defer s.Close()
+		names := make([]string, 0, len(core.SegmentFormats))
+		for _, f := range core.SegmentFormats {
+			names = append(names, string(f))
+		}
+		if _, err := s.Write([]byte(strings.Join(names, ",") + "\n")); err != nil {
+			glog.Errorf("Error sending segment formats: %v", err)
+		}
+	})
+	return &SegmentFormats{host: h}
+}
+
+//PeerSegmentFormats asks a node which segment formats it decodes.  Nodes from before the versioned formats don't have the protocol,
+//they only decode gob.
+func (f *SegmentFormats) PeerSegmentFormats(nodeID core.NodeID) ([]core.SegmentFormat, error) {
+	pid, err := peer.IDHexDecode(string(nodeID))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), SegmentFormatsTimeout)
+	defer cancel()
+	s, err := f.host.NewStream(ctx, pid, SegmentFormatsProtocol)
+	if err != nil {
+		if notSupported(err) {
+			return []core.SegmentFormat{core.SegmentFormatGob}, nil
+		}
+		return nil, err
+	}
+	defer s.Close()
+	s.SetReadDeadline(time.Now().Add(SegmentFormatsTimeout))
+
+	line, err := bufio.NewReader(s).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	formats := core.ParseSegmentFormats(line)
+	if len(formats) == 0 {
+		return nil, ErrSegmentFormats
+	}
+	return formats, nil
+}
@@ -0,0 +1,132 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+//RetransmitDelay is how long a gap can be open before the missing segments are asked for again.  Segments come a little out of order
+//sometimes, so the sequencer waits for them first.
+var RetransmitDelay = 2 * time.Second
+
+//RetransmitTimeout is how long a gap can be open before the sequencer gives up on the missing segments.
+var RetransmitTimeout = 10 * time.Second
+
+//MaxPendingSegments is how many segments can wait behind a gap.  With more, the sequencer gives up on the gap right away.
+var MaxPendingSegments = 10
+
+//SegmentSequencer puts the segments of a subscription back in order.  It isn't safe for concurrent use - the subscriber calls it from its
+//worker.
+type SegmentSequencer struct {
+	strmID  string
+	started bool
+	next    uint64
+	pending map[uint64][]byte
+	//gapStart is when the current gap opened, and requested is true once its segments were asked for
+	gapStart  time.Time
+	requested bool
+	now       func() time.Time
+}
+
+//NewSegmentSequencer creates a sequencer for strmID.  The first segment that comes in starts the sequence.
+func NewSegmentSequencer(strmID string) *SegmentSequencer {
+	return &SegmentSequencer{strmID: strmID, pending: make(map[uint64][]byte), now: time.Now}
+}
+
+func (s *SegmentSequencer) Insert(seqNo uint64, data []byte) []lpnet.Segment {
+	if !s.started {
+		s.started = true
+		s.next = seqNo
+	}
+	if seqNo < s.next {
+		return nil
+	}
+	if _, ok := s.pending[seqNo]; ok {
+		return nil
+	}
+	s.pending[seqNo] = data
+	ready := s.flush()
+	if len(s.pending) >= MaxPendingSegments {
+		ready = append(ready, s.skip()...)
+	}
+	return ready
+}
+
+func (s *SegmentSequencer) Check() ([]lpnet.Segment, []uint64) {
+	if len(s.pending) == 0 {
+		return nil, nil
+	}
+	elapsed := s.now().Sub(s.gapStart)
+	if elapsed >= RetransmitTimeout {
+		return s.skip(), nil
+	}
+	if s.requested || elapsed < RetransmitDelay {
+		return nil, nil
+	}
+	s.requested = true
+	//Broadcasters only keep the latest segments, so big gaps are only asked for in part
+	first := s.first()
+	start := s.next
+	if first-start > uint64(MaxPendingSegments) {
+		start = first - uint64(MaxPendingSegments)
+	}
+	missing := make([]uint64, 0, first-start)
+	for seqNo := start; seqNo < first; seqNo++ {
+		missing = append(missing, seqNo)
+	}
+	glog.Infof("Segments %v-%v of %v are missing, asking for them again", s.next, first-1, s.strmID)
+	return nil, missing
+}
+
+func (s *SegmentSequencer) Flush() []lpnet.Segment {
+	ready := make([]lpnet.Segment, 0)
+	for len(s.pending) > 0 {
+		ready = append(ready, s.skip()...)
+	}
+	return ready
+}
+
+//flush returns the pending segments that follow on without a gap.  A new gap opens if segments are left.
+func (s *SegmentSequencer) flush() []lpnet.Segment {
+	ready := make([]lpnet.Segment, 0)
+	for {
+		data, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		ready = append(ready, lpnet.Segment{SeqNo: s.next, Data: data})
+		delete(s.pending, s.next)
+		s.next++
+	}
+	if len(s.pending) == 0 {
+		s.gapStart = time.Time{}
+	} else if len(ready) > 0 || s.gapStart.IsZero() {
+		s.gapStart = s.now()
+		s.requested = false
+	}
+	return ready
+}
+
+//skip gives up on the current gap.
+func (s *SegmentSequencer) skip() []lpnet.Segment {
+	first := s.first()
+	glog.Errorf("Segments %v-%v of %v are lost", s.next, first-1, s.strmID)
+	s.next = first
+	s.gapStart = time.Time{}
+	return s.flush()
+}
+
+//first is the lowest pending seqNo.
+func (s *SegmentSequencer) first() uint64 {
+	first := true
+	var min uint64
+	for seqNo := range s.pending {
+		if first || seqNo < min {
+			min = seqNo
+			first = false
+		}
+	}
+	return min
+}
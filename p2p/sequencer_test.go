@@ -0,0 +1,83 @@
+package p2p
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+func seqNos(segs []lpnet.Segment) []uint64 {
+	nos := make([]uint64, 0)
+	for _, seg := range segs {
+		nos = append(nos, seg.SeqNo)
+	}
+	return nos
+}
+
+func TestSegmentSequencer(t *testing.T) {
+	now := time.Now()
+	s := NewSegmentSequencer("strm")
+	s.now = func() time.Time { return now }
+
+	//In order, segments are passed on right away, and duplicates are dropped
+	for i := uint64(5); i < 7; i++ {
+		if got := seqNos(s.Insert(i, []byte("data"))); !reflect.DeepEqual(got, []uint64{i}) {
+			t.Errorf("Expecting %v, got %v", i, got)
+		}
+	}
+	if got := s.Insert(6, nil); len(got) != 0 {
+		t.Errorf("Expecting the duplicate to be dropped, got %v", seqNos(got))
+	}
+
+	//8 and 9 wait for 7, which is asked for after RetransmitDelay, once
+	s.Insert(8, nil)
+	s.Insert(9, nil)
+	if ready, missing := s.Check(); len(ready) != 0 || len(missing) != 0 {
+		t.Errorf("Expecting to wait before asking, got %v %v", seqNos(ready), missing)
+	}
+	now = now.Add(RetransmitDelay)
+	if _, missing := s.Check(); !reflect.DeepEqual(missing, []uint64{7}) {
+		t.Errorf("Expecting to ask for 7, got %v", missing)
+	}
+	if _, missing := s.Check(); len(missing) != 0 {
+		t.Errorf("Expecting to ask only once, got %v", missing)
+	}
+	if got := seqNos(s.Insert(7, nil)); !reflect.DeepEqual(got, []uint64{7, 8, 9}) {
+		t.Errorf("Expecting 7-9 once the gap is filled, got %v", got)
+	}
+
+	//Gaps that don't get filled are skipped after RetransmitTimeout
+	s.Insert(12, nil)
+	now = now.Add(RetransmitDelay)
+	if _, missing := s.Check(); !reflect.DeepEqual(missing, []uint64{10, 11}) {
+		t.Errorf("Expecting to ask for 10 and 11, got %v", missing)
+	}
+	now = now.Add(RetransmitTimeout)
+	if ready, _ := s.Check(); !reflect.DeepEqual(seqNos(ready), []uint64{12}) {
+		t.Errorf("Expecting 12 after giving up, got %v", seqNos(ready))
+	}
+	if got := s.Insert(10, nil); len(got) != 0 {
+		t.Errorf("Expecting segments that were given up on to be dropped, got %v", seqNos(got))
+	}
+
+	//Too many segments behind a gap skip it right away
+	var ready []lpnet.Segment
+	for i := 0; i < MaxPendingSegments; i++ {
+		ready = s.Insert(uint64(14+i), nil)
+	}
+	if len(ready) != MaxPendingSegments || ready[0].SeqNo != 14 {
+		t.Errorf("Expecting the gap to be skipped, got %v", seqNos(ready))
+	}
+
+	//Flush gives up on every gap
+	s.Insert(30, nil)
+	s.Insert(32, nil)
+	if got := seqNos(s.Flush()); !reflect.DeepEqual(got, []uint64{30, 32}) {
+		t.Errorf("Expecting 30 and 32 after flushing, got %v", got)
+	}
+	if got := s.Flush(); len(got) != 0 {
+		t.Errorf("Expecting nothing left after flushing, got %v", seqNos(got))
+	}
+}
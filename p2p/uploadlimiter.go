@@ -0,0 +1,127 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	lpnet "github.com/livepeer/go-livepeer/net"
+)
+
+//RateWindow is the window the upload rate is averaged over.  It spans a few segments, since segments are sent in bursts.
+var RateWindow = 10 * time.Second
+
+//ShedInterval is how long the limiter waits after shedding a peer before it sheds another, so the rate can come down first.
+var ShedInterval = 10 * time.Second
+
+//UploadLimiter keeps the upload of the node within its capacity.  It counts the bytes the node sends for every stream, refuses new relay
+//listeners when the node doesn't have the upload left for them, and sheds relay listeners when it goes over capacity.  The streams the node
+//broadcasts count towards the upload, but are never refused or shed.
+type UploadLimiter struct {
+	//Capacity is the upload capacity in bytes per second, 0 for no limit
+	Capacity int64
+
+	lock     sync.Mutex
+	total    rateCounter
+	streams  map[string]*rateCounter
+	lastShed time.Time
+	now      func() time.Time
+}
+
+//NewUploadLimiter creates a limiter for capacity bytes per second.  With 0, it only counts the upload.
+func NewUploadLimiter(capacity int64) *UploadLimiter {
+	return &UploadLimiter{Capacity: capacity, streams: make(map[string]*rateCounter), now: time.Now}
+}
+
+func (l *UploadLimiter) AllowRelay(strmID string, listeners int) bool {
+	if l.Capacity == 0 {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	rate := l.total.rate(now)
+	//One more listener takes what each of the current ones takes.  For a new stream we don't know yet, so it only needs some room left.
+	if s, ok := l.streams[strmID]; ok && listeners > 0 {
+		return rate+s.rate(now)/int64(listeners) <= l.Capacity
+	}
+	return rate < l.Capacity
+}
+
+func (l *UploadLimiter) Sent(strmID string, bytes int, relayed bool) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	l.total.add(now, bytes)
+	s, ok := l.streams[strmID]
+	if !ok {
+		s = &rateCounter{}
+		l.streams[strmID] = s
+	}
+	s.add(now, bytes)
+	rate := l.total.rate(now)
+	lpmon.Instance().LogUploadRate(rate)
+
+	if l.Capacity == 0 || !relayed || rate <= l.Capacity || now.Sub(l.lastShed) < ShedInterval {
+		return false
+	}
+	l.lastShed = now
+	return true
+}
+
+//Load is the upload of the node.  Streams the node stopped sending are left out after two windows.
+func (l *UploadLimiter) Load() lpnet.RelayLoad {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	load := lpnet.RelayLoad{Capacity: l.Capacity, Rate: l.total.rate(now), Streams: make(map[string]lpnet.StreamLoad)}
+	for strmID, s := range l.streams {
+		if now.Sub(s.last) > 2*RateWindow {
+			delete(l.streams, strmID)
+			continue
+		}
+		load.Streams[strmID] = lpnet.StreamLoad{Bytes: s.bytes, Rate: s.rate(now)}
+	}
+	return load
+}
+
+//rateCounter averages a rate over a sliding window, estimated from the bytes of the current window and of the one before.
+type rateCounter struct {
+	bytes uint64
+	start time.Time
+	last  time.Time
+	cur   int64
+	prev  int64
+}
+
+func (c *rateCounter) add(now time.Time, bytes int) {
+	c.roll(now)
+	c.bytes += uint64(bytes)
+	c.cur += int64(bytes)
+	c.last = now
+}
+
+func (c *rateCounter) rate(now time.Time) int64 {
+	c.roll(now)
+	elapsed := now.Sub(c.start)
+	prevWeight := 1 - float64(elapsed)/float64(RateWindow)
+	return int64((float64(c.prev)*prevWeight + float64(c.cur)) / RateWindow.Seconds())
+}
+
+func (c *rateCounter) roll(now time.Time) {
+	if c.start.IsZero() {
+		c.start = now
+		return
+	}
+	elapsed := now.Sub(c.start)
+	if elapsed < RateWindow {
+		return
+	}
+	if elapsed < 2*RateWindow {
+		c.prev = c.cur
+	} else {
+		c.prev = 0
+	}
+	c.cur = 0
+	c.start = c.start.Add(elapsed / RateWindow * RateWindow)
+}
@@ -0,0 +1,60 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadLimiter(t *testing.T) {
+	start := time.Now()
+	now := start
+	l := NewUploadLimiter(1000)
+	l.now = func() time.Time { return now }
+
+	//10s at 600 bytes/s for a relayed stream with 2 listeners
+	for i := 0; i < 10; i++ {
+		if l.Sent("strm1", 600, true) {
+			t.Errorf("Expecting no shedding under capacity")
+		}
+		now = now.Add(time.Second)
+	}
+	load := l.Load()
+	if load.Capacity != 1000 || load.Rate < 500 || load.Rate > 600 || load.Streams["strm1"].Bytes != 6000 {
+		t.Errorf("Wrong load: %+v", load)
+	}
+	//A third listener takes another ~300 bytes/s, but a second one on top of a single listener doesn't fit
+	if !l.AllowRelay("strm1", 2) {
+		t.Errorf("Expecting room for one more listener")
+	}
+	if l.AllowRelay("strm1", 1) {
+		t.Errorf("Expecting no room for a listener that doubles the stream")
+	}
+
+	//Broadcasts go over capacity without being shed, but relays don't get new listeners
+	if l.Sent("strm2", 10000, false) {
+		t.Errorf("Expecting broadcasts not to be shed")
+	}
+	if l.AllowRelay("strm3", 0) {
+		t.Errorf("Expecting new relays to be refused over capacity")
+	}
+	//Relays are shed once per ShedInterval
+	if !l.Sent("strm1", 500, true) {
+		t.Errorf("Expecting a relay listener to be shed over capacity")
+	}
+	if l.Sent("strm1", 500, true) {
+		t.Errorf("Expecting to wait before shedding again")
+	}
+
+	//The rate comes down after the window, and idle streams are left out
+	now = now.Add(3 * RateWindow)
+	load = l.Load()
+	if load.Rate != 0 || len(load.Streams) != 0 || !l.AllowRelay("strm3", 0) {
+		t.Errorf("Expecting an idle node, got %+v", load)
+	}
+
+	//Without a capacity everything is allowed, and still counted
+	l = NewUploadLimiter(0)
+	if l.Sent("strm1", 1000000, true) || !l.AllowRelay("strm1", 1) || l.Load().Streams["strm1"].Bytes != 1000000 {
+		t.Errorf("Expecting no limit")
+	}
+}
@@ -0,0 +1,466 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+//TranscodeResponseTimeout is how long the broadcaster waits for the transcoder of a job to send its transcoded streams.
+var TranscodeResponseTimeout = 2 * time.Minute
+
+//RenditionStallTimeout is how long a transcoded stream can go without a new segment before the broadcaster gives up on the transcoder.
+var RenditionStallTimeout = time.Minute
+
+//MaxTranscoderFailovers is how many times a broadcast session creates a new job before it goes on with the source stream only.
+var MaxTranscoderFailovers = 3
+
+//ExcludeFailedTranscoders makes a broadcast session ignore the transcoders that already failed it.  The protocol assigns the
+//transcoder, so if a failed transcoder gets a new job, the session fails over again right away.
+var ExcludeFailedTranscoders = true
+
+//AdaptiveLadder makes a broadcast session build the profiles of its job from the probed source stream with LadderRules (see
+//core.BuildLadder), instead of using the transcoding options as they are.
+var AdaptiveLadder = false
+
+var LadderRules = core.DefaultLadderRules
+
+//LadderProbeTimeout is how long a broadcast session with the adaptive ladder waits for the source to be probed.  After that the job is
+//created with the transcoding options.
+var LadderProbeTimeout = 30 * time.Second
+
+var sessionCheckInterval = 5 * time.Second
+
+//AnnounceStreams makes broadcasts announce their streams to the network, so they are listed in the live stream directory.  Each broadcast
+//can opt in or out with ?announce=
+var AnnounceStreams = false
+
+//broadcastSession watches the transcode job of a broadcast.  If the transcoder doesn't answer, or one of its streams stops getting
+//segments, the job is abandoned and a new one is created.  The transcoded streams in the master playlist are swapped for the new ones,
+//the source stream stays in the playlist the whole time.
+type broadcastSession struct {
+	strmID     core.StreamID
+	manifestID core.ManifestID
+	source     *m3u8.Variant
+
+	//profiles are the transcoding options, or the ladder once it's built
+	profiles []lpmscore.VideoProfile
+	price    uint64
+
+	//createJob creates a new transcode job for the stream (on-chain)
+	createJob func(profiles []lpmscore.VideoProfile) error
+	//latestSeqNo returns the newest segment we have of a transcoded stream
+	latestSeqNo func(strmID core.StreamID) (uint64, bool)
+	//updateManifest publishes the master playlist
+	updateManifest func(mpl *m3u8.MasterPlaylist) error
+	//watch starts pulling a transcoded stream, so latestSeqNo can see its segments, and unwatch stops
+	watch   func(strmID core.StreamID)
+	unwatch func(strmID core.StreamID)
+	//peerFormats returns the segment formats a transcoder decodes, nil if it can't be asked
+	peerFormats func(nid core.NodeID) ([]core.SegmentFormat, error)
+	//segFormat is the format the source segments are broadcast in.  Any node can subscribe to the source, or relay it to nodes the
+	//broadcaster doesn't know, so it's never negotiated.
+	segFormat core.SegmentFormat
+	//transport is how the segments get to the transcoder
+	transport core.SegmentTransport
+	//segmentURL returns the URL a transcoder takes segments on over HTTP, nil if it can't be asked
+	segmentURL func(nid core.NodeID) (string, error)
+	//route sends the segments to the transcoder at url over HTTP as well, in format f, "" to only broadcast them
+	route func(url string, f core.SegmentFormat)
+
+	lock       sync.Mutex
+	jobStart   time.Time
+	transcoder core.NodeID
+	renditions map[core.StreamID]*renditionProgress
+	failed     map[core.NodeID]bool
+	failovers  int
+	done       bool
+	stopped    bool
+	cancel     context.CancelFunc
+	//waitingForSource is true until the source is probed, if the session builds an adaptive ladder
+	waitingForSource bool
+	jobCreated       bool
+	//routed is true while the segments also go to the transcoder over HTTP
+	routed bool
+	//stopAnnouncing ends the announcements of the stream, nil if it isn't announced
+	stopAnnouncing context.CancelFunc
+	//stopSegmenter stops segmenting the RTMP stream, so nothing is broadcast after the stream is finished
+	stopSegmenter context.CancelFunc
+}
+
+type renditionProgress struct {
+	profile string
+	seqNo   uint64
+	seen    bool
+	updated time.Time
+}
+
+func newBroadcastSession(s *LivepeerServer, strmID core.StreamID, mid core.ManifestID, source *m3u8.Variant, profiles []lpmscore.VideoProfile, price uint64, transport core.SegmentTransport) *broadcastSession {
+	n := s.LivepeerNode
+	bs := &broadcastSession{
+		strmID:     strmID,
+		manifestID: mid,
+		source:     source,
+		profiles:   profiles,
+		price:      price,
+		createJob: func(profiles []lpmscore.VideoProfile) error {
+			return n.CreateTranscodeJob(strmID, append([]lpmscore.VideoProfile{}, profiles...), price)
+		},
+		latestSeqNo: n.VideoCache.LatestSegmentSeqNo,
+		updateManifest: func(mpl *m3u8.MasterPlaylist) error {
+			s.setRenditions(mid, mpl)
+			return n.VideoNetwork.UpdateMasterPlaylist(string(mid), mpl)
+		},
+		watch: func(strmID core.StreamID) {
+			//Subscribes to the stream and caches its segments, which also gets them ready for viewers
+			n.VideoCache.GetHLSMediaPlaylist(strmID)
+		},
+		unwatch: func(strmID core.StreamID) {
+			n.VideoCache.EvictHLSSubscriber(strmID)
+			s.removeHLSKeys(strmID)
+		},
+		renditions:       make(map[core.StreamID]*renditionProgress),
+		failed:           make(map[core.NodeID]bool),
+		waitingForSource: AdaptiveLadder && n.SourceProber != nil && n.Eth != nil,
+		segFormat:        n.SegmentFormat,
+		transport:        transport,
+		segmentURL:       n.PeerSegmentURL,
+	}
+	if n.SegmentFormats != nil {
+		bs.peerFormats = n.SegmentFormats.PeerSegmentFormats
+	}
+	if r, ok := n.VideoNetwork.(net.SegmentRouter); ok {
+		bs.route = func(url string, f core.SegmentFormat) { r.SetSegmentRoute(string(strmID), url, string(f)) }
+	}
+	return bs
+}
+
+//start creates the first transcode job (or waits for the source to be probed first) and watches it until stop is called.
+func (bs *broadcastSession) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	bs.lock.Lock()
+	bs.cancel = cancel
+	bs.jobStart = time.Now()
+	if !bs.waitingForSource && !bs.jobCreated {
+		bs.startJobLocked(bs.profiles)
+	}
+	bs.lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sessionCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				bs.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (bs *broadcastSession) stop() {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	bs.done = true
+	bs.stopped = true
+	bs.unrouteLocked()
+	if bs.cancel != nil {
+		bs.cancel()
+	}
+	if bs.stopAnnouncing != nil {
+		bs.stopAnnouncing()
+	}
+	if bs.stopSegmenter != nil {
+		bs.stopSegmenter()
+	}
+}
+
+func (bs *broadcastSession) isStopped() bool {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	return bs.stopped
+}
+
+//announce announces the stream to the network with title and tags until the session is stopped.
+func (bs *broadcastSession) announce(n *core.LivepeerNode, title string, tags []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	bs.lock.Lock()
+	bs.stopAnnouncing = cancel
+	bs.lock.Unlock()
+	go n.AnnounceStream(ctx, bs.manifestID, title, tags, bs.renditionNames)
+}
+
+//renditionNames are the names of the profiles the stream is transcoded into.
+func (bs *broadcastSession) renditionNames() []string {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	return core.ProfileNamesOf(bs.profiles)
+}
+
+//startJobLocked creates the first job with profiles.  The session only broadcasts the source if there are no profiles.
+func (bs *broadcastSession) startJobLocked(profiles []lpmscore.VideoProfile) {
+	bs.waitingForSource = false
+	bs.jobCreated = true
+	bs.profiles = profiles
+	bs.jobStart = time.Now()
+	if len(profiles) == 0 {
+		glog.Errorf("No profiles to transcode %v into, broadcasting the source stream only", bs.strmID)
+		bs.done = true
+		if bs.cancel != nil {
+			bs.cancel()
+		}
+		return
+	}
+	go bs.runJob(profiles)
+}
+
+func (bs *broadcastSession) runJob(profiles []lpmscore.VideoProfile) {
+	glog.Infof("Creating transcode job for %v with profiles %v", bs.strmID, core.ProfileNamesOf(profiles))
+	if err := bs.createJob(profiles); err != nil {
+		glog.Errorf("Error creating transcode job for %v: %v", bs.strmID, err)
+	}
+}
+
+//gotTranscodeResponse is called with the transcoded streams (stream ID -> profile name) when a transcoder picks up the job.
+func (bs *broadcastSession) gotTranscodeResponse(result map[string]string) {
+	if len(result) == 0 {
+		return
+	}
+	var nid core.NodeID
+	for strmID := range result {
+		sid := core.StreamID(strmID)
+		nid = sid.GetNodeID()
+		break
+	}
+
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	if bs.done {
+		return
+	}
+	if bs.transcoder != "" {
+		//Duplicates of the current response, or a late response for a job we abandoned
+		if nid != bs.transcoder {
+			glog.Infof("Ignoring transcode response from %v for %v, already using %v", nid, bs.strmID, bs.transcoder)
+		}
+		return
+	}
+	if ExcludeFailedTranscoders && bs.failed[nid] {
+		glog.Infof("Transcoder %v already failed %v", nid, bs.strmID)
+		bs.failoverLocked(fmt.Sprintf("got excluded transcoder %v", nid))
+		return
+	}
+
+	bs.transcoder = nid
+	now := time.Now()
+	for strmID, profile := range result {
+		bs.renditions[core.StreamID(strmID)] = &renditionProgress{profile: profile, updated: now}
+		go bs.watch(core.StreamID(strmID))
+	}
+	bs.publishLocked()
+	glog.V(common.SHORT).Infof("Transcoder %v is transcoding %v into %v", nid, bs.strmID, result)
+	if bs.transport == core.SegmentTransportHTTP && bs.segmentURL != nil && bs.route != nil {
+		go bs.routeSegments(nid)
+	}
+}
+
+//routeFormat picks the format of the segments sent over HTTP, which only the transcoder gets.  It's the best format the transcoder
+//decodes, and the broadcast format if the transcoder doesn't answer.
+func (bs *broadcastSession) routeFormat(nid core.NodeID) core.SegmentFormat {
+	if bs.peerFormats == nil {
+		return bs.segFormat
+	}
+	formats, err := bs.peerFormats(nid)
+	if err != nil {
+		glog.V(common.DEBUG).Infof("Cannot get the segment formats of %v, sending %v: %v", nid, bs.segFormat, err)
+		return bs.segFormat
+	}
+	return core.NegotiateSegmentFormat(formats)
+}
+
+//routeSegments sends the segments to the transcoder over HTTP as well, if it takes them.  They are broadcast to the network either way.
+func (bs *broadcastSession) routeSegments(nid core.NodeID) {
+	url, err := bs.segmentURL(nid)
+	if err != nil || url == "" {
+		glog.V(common.SHORT).Infof("Transcoder %v doesn't take segments over HTTP, sending %v through the network: %v", nid, bs.strmID, err)
+		return
+	}
+	f := bs.routeFormat(nid)
+
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	if bs.done || bs.transcoder != nid {
+		return
+	}
+	glog.V(common.SHORT).Infof("Sending %v to transcoder %v at %v in segment format %v", bs.strmID, nid, url, f)
+	bs.route(url, f)
+	bs.routed = true
+}
+
+func (bs *broadcastSession) unrouteLocked() {
+	if bs.routed {
+		bs.route("", "")
+		bs.routed = false
+	}
+}
+
+//encodeSegment encodes a source segment for the network.
+func (bs *broadcastSession) encodeSegment(seg *stream.HLSSegment, sig []byte) ([]byte, error) {
+	bs.lock.Lock()
+	codecs := bs.source.Codecs
+	bs.lock.Unlock()
+	return core.EncodeSignedSegment(core.SignedSegment{Seg: *seg, Sig: sig, StrmID: string(bs.strmID), Timestamp: time.Now(), Codecs: codecs}, bs.segFormat)
+}
+
+//check fails over if the transcoder didn't answer in time, or if one of the transcoded streams stalled.
+func (bs *broadcastSession) check() {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	if bs.done {
+		return
+	}
+
+	if bs.waitingForSource {
+		if time.Since(bs.jobStart) > LadderProbeTimeout {
+			glog.Errorf("Source %v wasn't probed after %v, using the transcoding options for the job", bs.strmID, LadderProbeTimeout)
+			bs.startJobLocked(bs.profiles)
+		}
+		return
+	}
+
+	if bs.transcoder == "" {
+		if time.Since(bs.jobStart) > TranscodeResponseTimeout {
+			bs.failoverLocked(fmt.Sprintf("no transcode response after %v", TranscodeResponseTimeout))
+		}
+		return
+	}
+
+	for strmID, r := range bs.renditions {
+		if seqNo, ok := bs.latestSeqNo(strmID); ok && (!r.seen || seqNo != r.seqNo) {
+			r.seqNo = seqNo
+			r.seen = true
+			r.updated = time.Now()
+			continue
+		}
+		if time.Since(r.updated) > RenditionStallTimeout {
+			bs.failoverLocked(fmt.Sprintf("%v stream %v stalled", r.profile, strmID))
+			return
+		}
+	}
+}
+
+//failoverLocked abandons the current job and creates a new one.  There is no way to end a job on-chain, so the old job just runs out.
+func (bs *broadcastSession) failoverLocked(reason string) {
+	if bs.transcoder != "" {
+		bs.failed[bs.transcoder] = true
+	}
+	bs.transcoder = ""
+	bs.unrouteLocked()
+	//The streams of the abandoned job aren't in the playlist anymore, so nobody needs their segments
+	for strmID := range bs.renditions {
+		go bs.unwatch(strmID)
+	}
+	bs.renditions = make(map[core.StreamID]*renditionProgress)
+	//Only the source stream until the new transcoder answers
+	bs.publishLocked()
+
+	if bs.failovers >= MaxTranscoderFailovers {
+		glog.Errorf("Transcode job for %v failed (%v), giving up after %v failovers. Broadcasting the source stream only", bs.strmID, reason, bs.failovers)
+		bs.done = true
+		if bs.cancel != nil {
+			bs.cancel()
+		}
+		return
+	}
+	bs.failovers++
+	glog.Errorf("Transcode job for %v failed (%v), creating a new job (failover %v/%v)", bs.strmID, reason, bs.failovers, MaxTranscoderFailovers)
+	bs.jobStart = time.Now()
+	go bs.runJob(bs.profiles)
+}
+
+//publish updates the master playlist.
+func (bs *broadcastSession) publish() {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	bs.publishLocked()
+}
+
+//probeSource probes a segment of the source stream, and updates the master playlist with what the source really is instead of the
+//guessed profile.  With the adaptive ladder, this is when the job is created.
+func (bs *broadcastSession) probeSource(prober core.SourceProber, seg *stream.HLSSegment) {
+	info, err := prober.ProbeSource(seg)
+
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	if err != nil {
+		glog.Errorf("Error probing source stream %v, keeping the guessed variant params: %v", bs.strmID, err)
+		if bs.waitingForSource && !bs.stopped {
+			bs.startJobLocked(bs.profiles)
+		}
+		return
+	}
+	glog.V(common.SHORT).Infof("Source stream %v is %v", bs.strmID, info)
+
+	//Still publish if the session gave up on transcoding, the source is in the playlist until the broadcast ends
+	if bs.stopped {
+		return
+	}
+	bs.source.VariantParams = info.VariantParams()
+	bs.publishLocked()
+
+	if bs.waitingForSource {
+		extra := make([]lpmscore.VideoProfile, 0)
+		for _, p := range bs.profiles {
+			if core.IsAudioProfile(p.Name) || core.IsPassthroughProfile(p.Name) {
+				extra = append(extra, p)
+			}
+		}
+		ladder := core.BuildLadder(LadderRules, info, extra, bs.price)
+		glog.Infof("Built ladder %v for source %v (%v)", core.ProfileNamesOf(ladder), bs.strmID, info)
+		bs.startJobLocked(ladder)
+	}
+}
+
+//publishLocked updates the master playlist with the source stream and the current transcoded streams.  The transcoded video streams
+//share the audio rendition if there is one.
+func (bs *broadcastSession) publishLocked() {
+	mpl := m3u8.NewMasterPlaylist()
+	mpl.Append(bs.source.URI, bs.source.Chunklist, bs.source.VariantParams)
+	strmIDs := make([]string, 0, len(bs.renditions))
+	uris := make(map[string]string)
+	for strmID, r := range bs.renditions {
+		strmIDs = append(strmIDs, string(strmID))
+		uris[r.profile] = fmt.Sprintf("%v.m3u8", strmID)
+	}
+	sort.Strings(strmIDs)
+	sharedAudio := core.SharedAudioRendition(uris)
+	for _, strmID := range strmIDs {
+		pl, _ := m3u8.NewMediaPlaylist(stream.DefaultHLSStreamWin, stream.DefaultHLSStreamCap)
+		profile := bs.renditions[core.StreamID(strmID)].profile
+		p, _ := core.ProfileLookup(profile)
+		vParams := core.ProfileVariantParams(p)
+		if core.IsPassthroughProfile(profile) {
+			vParams = bs.source.VariantParams
+		}
+		if sharedAudio != nil && !core.IsAudioProfile(profile) {
+			vParams.Audio = sharedAudio.GroupId
+			vParams.Alternatives = []*m3u8.Alternative{sharedAudio}
+		}
+		mpl.Append(fmt.Sprintf("%v.m3u8", strmID), pl, vParams)
+	}
+	if err := bs.updateManifest(mpl); err != nil {
+		glog.Errorf("Error updating master playlist for %v: %v", bs.manifestID, err)
+	}
+}
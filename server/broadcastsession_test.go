@@ -0,0 +1,343 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/livepeer/go-livepeer/core"
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+type stubSessionDeps struct {
+	lock      sync.Mutex
+	jobs      int
+	profiles  []lpmscore.VideoProfile
+	seqNos    map[core.StreamID]uint64
+	manifests []*m3u8.MasterPlaylist
+	unwatched []core.StreamID
+}
+
+func (d *stubSessionDeps) unwatchedStreams() []core.StreamID {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]core.StreamID{}, d.unwatched...)
+}
+
+func (d *stubSessionDeps) lastManifest() *m3u8.MasterPlaylist {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.manifests[len(d.manifests)-1]
+}
+
+func (d *stubSessionDeps) jobCount() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.jobs
+}
+
+func newStubSession(d *stubSessionDeps) *broadcastSession {
+	pl, _ := m3u8.NewMediaPlaylist(stream.DefaultHLSStreamWin, stream.DefaultHLSStreamCap)
+	source := &m3u8.Variant{URI: "source.m3u8", Chunklist: pl, VariantParams: lpmscore.VideoProfileToVariantParams(lpmscore.P720p30fps16x9)}
+	return &broadcastSession{
+		strmID:   core.StreamID("source"),
+		source:   source,
+		profiles: []lpmscore.VideoProfile{lpmscore.P720p30fps16x9, lpmscore.P240p30fps16x9},
+		price:    10,
+		createJob: func(profiles []lpmscore.VideoProfile) error {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			d.jobs++
+			d.profiles = profiles
+			return nil
+		},
+		latestSeqNo: func(strmID core.StreamID) (uint64, bool) {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			seqNo, ok := d.seqNos[strmID]
+			return seqNo, ok
+		},
+		updateManifest: func(mpl *m3u8.MasterPlaylist) error {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			d.manifests = append(d.manifests, mpl)
+			return nil
+		},
+		watch: func(strmID core.StreamID) {},
+		unwatch: func(strmID core.StreamID) {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			d.unwatched = append(d.unwatched, strmID)
+		},
+		renditions: make(map[core.StreamID]*renditionProgress),
+		failed:     make(map[core.NodeID]bool),
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	start := time.Now()
+	for !cond() {
+		if time.Since(start) > time.Second {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//transcodeResult makes the stream IDs a transcoder with node ID nid would send back
+func transcodeResult(nid string) map[string]string {
+	return map[string]string{
+		fmt.Sprintf("%v%v", nid, "aa"): lpmscore.P240p30fps16x9.Name,
+		fmt.Sprintf("%v%v", nid, "bb"): lpmscore.P360p30fps16x9.Name,
+	}
+}
+
+func TestBroadcastSessionFailover(t *testing.T) {
+	oldResponse, oldStall, oldInterval := TranscodeResponseTimeout, RenditionStallTimeout, sessionCheckInterval
+	defer func() {
+		TranscodeResponseTimeout, RenditionStallTimeout, sessionCheckInterval = oldResponse, oldStall, oldInterval
+	}()
+	TranscodeResponseTimeout = 100 * time.Millisecond
+	RenditionStallTimeout = 100 * time.Millisecond
+	sessionCheckInterval = 10 * time.Millisecond
+
+	nid1 := "12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d"
+	nid2 := "122011e494a06b20bf7a80f40e80d538675cc0b168c21912d33e0179617d5d4fe4e0"
+	d := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs := newStubSession(d)
+	bs.start()
+	defer bs.stop()
+	waitFor(t, "first job", func() bool { return d.jobCount() == 1 })
+
+	//No response - should create a new job
+	waitFor(t, "failover after missing response", func() bool { return d.jobCount() == 2 })
+	if len(d.lastManifest().Variants) != 1 {
+		t.Errorf("Expecting only the source stream, got %v", d.lastManifest())
+	}
+
+	//Transcoder answers, renditions are added after the source
+	bs.gotTranscodeResponse(transcodeResult(nid1))
+	mpl := d.lastManifest()
+	if len(mpl.Variants) != 3 || mpl.Variants[0].URI != "source.m3u8" || mpl.Variants[1].URI != nid1+"aa.m3u8" {
+		t.Errorf("Expecting source and 2 renditions, got %v", mpl)
+	}
+
+	//Keep the renditions going for a while
+	for i := uint64(1); i < 20; i++ {
+		d.lock.Lock()
+		d.seqNos[core.StreamID(nid1+"aa")] = i
+		d.seqNos[core.StreamID(nid1+"bb")] = i
+		d.lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d.jobCount() != 2 {
+		t.Errorf("Expecting no failover while the renditions are going, got %v jobs", d.jobCount())
+	}
+
+	//One rendition stalls
+	d.lock.Lock()
+	d.seqNos[core.StreamID(nid1+"aa")] = 20
+	d.lock.Unlock()
+	waitFor(t, "failover after stalled rendition", func() bool { return d.jobCount() == 3 })
+	if len(d.lastManifest().Variants) != 1 {
+		t.Errorf("Expecting the stalled renditions to be removed, got %v", d.lastManifest())
+	}
+	//The streams of the abandoned job aren't pulled anymore
+	waitFor(t, "abandoned renditions unwatched", func() bool { return len(d.unwatchedStreams()) == 2 })
+
+	//The failed transcoder gets the job again - should fail over right away
+	bs.gotTranscodeResponse(transcodeResult(nid1))
+	if d.jobCount() != 3 {
+		waitFor(t, "failover after excluded transcoder", func() bool { return d.jobCount() == 4 })
+	}
+
+	//A new transcoder takes over
+	bs.gotTranscodeResponse(transcodeResult(nid2))
+	mpl = d.lastManifest()
+	if len(mpl.Variants) != 3 || mpl.Variants[1].URI != nid2+"aa.m3u8" {
+		t.Errorf("Expecting renditions from the new transcoder, got %v", mpl)
+	}
+	//Late response from the old one is ignored
+	bs.gotTranscodeResponse(transcodeResult(nid1))
+	if d.lastManifest() != mpl {
+		t.Errorf("Expecting the manifest not to change")
+	}
+}
+
+func TestBroadcastSessionGivesUp(t *testing.T) {
+	oldResponse, oldInterval, oldMax := TranscodeResponseTimeout, sessionCheckInterval, MaxTranscoderFailovers
+	defer func() {
+		TranscodeResponseTimeout, sessionCheckInterval, MaxTranscoderFailovers = oldResponse, oldInterval, oldMax
+	}()
+	TranscodeResponseTimeout = 20 * time.Millisecond
+	sessionCheckInterval = 5 * time.Millisecond
+	MaxTranscoderFailovers = 2
+
+	d := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs := newStubSession(d)
+	bs.start()
+	defer bs.stop()
+
+	waitFor(t, "session to give up", func() bool {
+		bs.lock.Lock()
+		defer bs.lock.Unlock()
+		return bs.done
+	})
+	if d.jobCount() != 1+MaxTranscoderFailovers {
+		t.Errorf("Expecting %v jobs, got %v", 1+MaxTranscoderFailovers, d.jobCount())
+	}
+	if len(d.lastManifest().Variants) != 1 {
+		t.Errorf("Expecting only the source stream, got %v", d.lastManifest())
+	}
+}
+
+type stubProber struct {
+	info *core.SourceInfo
+	err  error
+}
+
+func (p *stubProber) ProbeSource(seg *stream.HLSSegment) (*core.SourceInfo, error) {
+	return p.info, p.err
+}
+
+func TestBroadcastSessionProbeSource(t *testing.T) {
+	nid := "12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d"
+	d := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs := newStubSession(d)
+	bs.publish()
+
+	//Probe failed, keep the guess
+	bs.probeSource(&stubProber{err: fmt.Errorf("no ffprobe")}, &stream.HLSSegment{})
+	if len(d.manifests) != 1 {
+		t.Errorf("Not expecting the manifest to change, got %v", d.manifests)
+	}
+
+	info := &core.SourceInfo{Bandwidth: 2500000, Resolution: "1920x1080", Framerate: 29.97, Codecs: "avc1.64001f,mp4a.40.2"}
+	bs.probeSource(&stubProber{info: info}, &stream.HLSSegment{})
+	if v := d.lastManifest().Variants[0]; v.Bandwidth != 2500000 || v.Resolution != "1920x1080" || v.Codecs != "avc1.64001f,mp4a.40.2" {
+		t.Errorf("Expecting probed source params, got %v", v.VariantParams)
+	}
+
+	//Passthrough rendition has the params of the source
+	bs.gotTranscodeResponse(map[string]string{nid + "aa": core.PassthroughProfile.Name, nid + "bb": lpmscore.P240p30fps16x9.Name})
+	mpl := d.lastManifest()
+	if len(mpl.Variants) != 3 || mpl.Variants[1].URI != nid+"aa.m3u8" || mpl.Variants[1].Bandwidth != 2500000 || mpl.Variants[1].Codecs != info.Codecs {
+		t.Errorf("Expecting passthrough rendition with the source params, got %v", mpl)
+	}
+
+	//Not after the broadcast ended
+	bs.stop()
+	bs.probeSource(&stubProber{info: &core.SourceInfo{Bandwidth: 1}}, &stream.HLSSegment{})
+	if d.lastManifest() != mpl {
+		t.Errorf("Not expecting the manifest to change after stop")
+	}
+}
+
+func TestBroadcastSessionAdaptiveLadder(t *testing.T) {
+	oldTimeout, oldInterval := LadderProbeTimeout, sessionCheckInterval
+	defer func() {
+		LadderProbeTimeout, sessionCheckInterval = oldTimeout, oldInterval
+	}()
+	LadderProbeTimeout = 50 * time.Millisecond
+	sessionCheckInterval = 5 * time.Millisecond
+
+	d := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs := newStubSession(d)
+	bs.waitingForSource = true
+	bs.profiles = append(bs.profiles, core.A64kStereo.VideoProfile())
+	bs.start()
+	defer bs.stop()
+	time.Sleep(20 * time.Millisecond)
+	if d.jobCount() != 0 {
+		t.Errorf("Expecting the job to wait for the source")
+	}
+
+	//480p source: no 720p, and the audio profile is kept
+	bs.probeSource(&stubProber{info: &core.SourceInfo{Bandwidth: 1800000, Resolution: "854x480", Framerate: 30}}, &stream.HLSSegment{})
+	waitFor(t, "job", func() bool { return d.jobCount() == 1 })
+	d.lock.Lock()
+	names := core.ProfileNamesOf(d.profiles)
+	d.lock.Unlock()
+	if fmt.Sprint(names) != "[P360p30fps16x9 P240p30fps16x9 P144p30fps16x9 A64kStereo]" {
+		t.Errorf("Unexpected ladder %v", names)
+	}
+
+	//Not probed in time, use the transcoding options
+	d2 := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs2 := newStubSession(d2)
+	bs2.waitingForSource = true
+	bs2.start()
+	defer bs2.stop()
+	waitFor(t, "job after probe timeout", func() bool { return d2.jobCount() == 1 })
+	d2.lock.Lock()
+	defer d2.lock.Unlock()
+	if len(d2.profiles) != 2 {
+		t.Errorf("Expecting the transcoding options, got %v", d2.profiles)
+	}
+}
+
+func TestBroadcastSessionSegmentFormat(t *testing.T) {
+	nid1 := "12201c23641663bf06187a8c154a6c97266d138cb8379c1bc0828122dcc51c83698d"
+	nid2 := "122011e494a06b20bf7a80f40e80d538675cc0b168c21912d33e0179617d5d4fe4e0"
+	d := &stubSessionDeps{seqNos: make(map[core.StreamID]uint64)}
+	bs := newStubSession(d)
+	bs.segFormat = core.SegmentFormatGob
+	bs.peerFormats = func(nid core.NodeID) ([]core.SegmentFormat, error) {
+		if string(nid) == nid1 {
+			return []core.SegmentFormat{core.SegmentFormatGob, core.SegmentFormatV1}, nil
+		}
+		return nil, fmt.Errorf("no answer")
+	}
+	bs.transport = core.SegmentTransportHTTP
+	bs.segmentURL = func(nid core.NodeID) (string, error) { return "https://" + string(nid)[:6], nil }
+	var lock sync.Mutex
+	routes := make(map[string]core.SegmentFormat)
+	bs.route = func(url string, f core.SegmentFormat) {
+		lock.Lock()
+		defer lock.Unlock()
+		routes[url] = f
+	}
+	routeFormat := func(url string) (core.SegmentFormat, bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		f, ok := routes[url]
+		return f, ok
+	}
+	bs.start()
+	defer bs.stop()
+	waitFor(t, "first job", func() bool { return d.jobCount() == 1 })
+
+	//The transcoder gets its best format over HTTP
+	bs.gotTranscodeResponse(transcodeResult(nid1))
+	waitFor(t, "route", func() bool { _, ok := routeFormat("https://" + nid1[:6]); return ok })
+	if f, _ := routeFormat("https://" + nid1[:6]); f != core.SegmentFormatV1 {
+		t.Errorf("Expecting the transcoder to get lpss1, got %v", f)
+	}
+
+	//The network, where any node can subscribe, still gets gob
+	seg := &stream.HLSSegment{SeqNo: 1, Data: []byte("data")}
+	data, err := bs.encodeSegment(seg, []byte("sig"))
+	if err != nil {
+		t.Fatalf("Error encoding segment: %v", err)
+	}
+	if f := core.SegmentFormatOf(data); f != core.SegmentFormatGob {
+		t.Errorf("Expecting the source to be broadcast in gob, got %v", f)
+	}
+	ss, err := core.BytesToSignedSegment(data)
+	if err != nil || ss.StrmID != "source" || ss.Timestamp.IsZero() {
+		t.Errorf("Expecting a segment of the source, got %+v %v", ss, err)
+	}
+
+	//The next transcoder doesn't answer, so it gets the broadcast format
+	bs.lock.Lock()
+	bs.failoverLocked("test")
+	bs.lock.Unlock()
+	bs.gotTranscodeResponse(transcodeResult(nid2))
+	waitFor(t, "second route", func() bool { _, ok := routeFormat("https://" + nid2[:6]); return ok })
+	if f, _ := routeFormat("https://" + nid2[:6]); f != core.SegmentFormatGob {
+		t.Errorf("Expecting gob for a transcoder that doesn't answer, got %v", f)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+//HLSKeyPath is where players get the keys of encrypted streams: /keys/<streamID>/<key index>.key
+const HLSKeyPath = "/keys/"
+
+var keyPathRegex = regexp.MustCompile("^/keys/([[:alnum:]]+)/(\\d+)\\.key$")
+
+func hlsKeyURI(strmID core.StreamID, index uint64) string {
+	return fmt.Sprintf("%v%v/%v.key", HLSKeyPath, strmID, index)
+}
+
+//encryptMediaPlaylist adds the EXT-X-KEY of each segment to the playlist.  Every segment gets its own tag, since the IV is different
+//for each segment even when the key isn't.
+func (s *LivepeerServer) encryptMediaPlaylist(strmID core.StreamID, pl *m3u8.MediaPlaylist) {
+	for _, seg := range pl.Segments {
+		if seg == nil {
+			continue
+		}
+		cached := s.LivepeerNode.VideoCache.GetHLSSegment(strmID, seg.URI)
+		if cached == nil {
+			continue
+		}
+		seg.Key = &m3u8.Key{Method: core.HLSEncryptionMethod, URI: hlsKeyURI(strmID, s.HLSEncryption.KeyIndex(cached.SeqNo)), IV: core.SegmentIV(cached.SeqNo)}
+	}
+}
+
+//handleHLSKey serves the keys of encrypted streams.  Keys are only handed out to players allowed to watch the stream, and only for
+//streams this node is serving, up to the key of the latest segment, so requests can't make the node store keys that aren't needed.
+func (s *LivepeerServer) handleHLSKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if s.HLSEncryption == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	match := keyPathRegex.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	strmID := core.StreamID(match[1])
+	index, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil || !strmID.IsValid() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.authorizeStream(r, strmID); err != nil {
+		glog.Infof("Refusing key %v of stream %v to %v: %v", index, strmID, r.RemoteAddr, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	seqNo, ok := s.LivepeerNode.VideoCache.LatestSegmentSeqNo(strmID)
+	if !ok || index > s.HLSEncryption.KeyIndex(seqNo) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	key, err := s.HLSEncryption.Keys.Key(strmID, index)
+	if err != nil {
+		glog.Errorf("Error getting key %v of stream %v: %v", index, strmID, err)
+		http.Error(w, "Error getting key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write(key)
+}
+
+//removeHLSKeys drops the keys of a stream the node stopped serving.
+func (s *LivepeerServer) removeHLSKeys(strmID core.StreamID) {
+	if s.HLSEncryption == nil {
+		return
+	}
+	if err := s.HLSEncryption.Keys.RemoveKeys(strmID); err != nil {
+		glog.Errorf("Error removing the keys of stream %v: %v", strmID, err)
+	}
+}
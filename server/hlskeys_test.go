@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+type stubVideoCache struct {
+	core.VideoCache
+	segs   []*stream.HLSSegment
+	master *m3u8.MasterPlaylist
+	//masterID is the manifest of master, empty if master is returned for any manifest
+	masterID core.ManifestID
+}
+
+func (c *stubVideoCache) GetHLSMasterPlaylist(mid core.ManifestID) *m3u8.MasterPlaylist {
+	if c.masterID != "" && mid != c.masterID {
+		return nil
+	}
+	return c.master
+}
+
+func (c *stubVideoCache) GetHLSMediaPlaylist(strmID core.StreamID) *m3u8.MediaPlaylist {
+	pl, _ := m3u8.NewMediaPlaylist(uint(len(c.segs)), uint(len(c.segs)))
+	for _, seg := range c.segs {
+		pl.Append(seg.Name, seg.Duration, "")
+	}
+	pl.SeqNo = c.segs[0].SeqNo
+	return pl
+}
+
+func (c *stubVideoCache) GetHLSSegment(strmID core.StreamID, segName string) *stream.HLSSegment {
+	for _, seg := range c.segs {
+		if seg.Name == segName {
+			return seg
+		}
+	}
+	return nil
+}
+
+func (c *stubVideoCache) LatestSegmentSeqNo(strmID core.StreamID) (uint64, bool) {
+	return c.segs[len(c.segs)-1].SeqNo, true
+}
+
+type stubKeys struct {
+	removed []core.StreamID
+}
+
+func (k *stubKeys) Key(strmID core.StreamID, index uint64) ([]byte, error) {
+	return bytes.Repeat([]byte{byte(index)}, core.HLSKeySize), nil
+}
+
+func (k *stubKeys) RemoveKeys(strmID core.StreamID) error {
+	k.removed = append(k.removed, strmID)
+	return nil
+}
+
+func TestHLSEncryption(t *testing.T) {
+	strmID := "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd210f6afa01868f11f5722434aa4a0769842e04fac75dfaccece208c5710fd52e0P240p30fps16x9"
+	cache := &stubVideoCache{}
+	for i := uint64(9); i < 12; i++ {
+		cache.segs = append(cache.segs, &stream.HLSSegment{SeqNo: i, Name: fmt.Sprintf("%v_%v.ts", strmID, i), Data: []byte("segment data"), Duration: 8})
+	}
+	s := &LivepeerServer{LivepeerNode: &core.LivepeerNode{VideoCache: cache}, hlsSubTimer: make(map[core.StreamID]time.Time), playbackPolicies: make(map[core.ManifestID]*PlaybackPolicy)}
+	s.HLSEncryption = &core.HLSEncryption{Keys: &stubKeys{}, RotationSegments: 10}
+
+	//Each segment gets its key and IV in the playlist
+	u, _ := url.Parse("http://localhost/stream/" + strmID + ".m3u8")
+	pl, err := getHLSMediaPlaylistHandler(s)(u)
+	if err != nil {
+		t.Fatalf("Error getting playlist: %v", err)
+	}
+	if k := pl.Segments[0].Key; k == nil || k.Method != "AES-128" || k.URI != "/keys/"+strmID+"/0.key" || k.IV != core.SegmentIV(9) {
+		t.Errorf("Wrong key for the first segment: %+v", k)
+	}
+	if k := pl.Segments[1].Key; k == nil || k.URI != "/keys/"+strmID+"/1.key" {
+		t.Errorf("Expected the key to rotate at segment 10: %+v", k)
+	}
+	if !strings.Contains(pl.Encode().String(), "#EXT-X-KEY:METHOD=AES-128,URI=\"/keys/"+strmID+"/1.key\",IV="+core.SegmentIV(11)) {
+		t.Errorf("Expected EXT-X-KEY in the playlist: %v", pl.Encode().String())
+	}
+
+	//Segments decrypt with the key in the playlist
+	u, _ = url.Parse("http://localhost/stream/" + cache.segs[2].Name)
+	data, err := getHLSSegmentHandler(s)(u)
+	if err != nil {
+		t.Fatalf("Error getting segment: %v", err)
+	}
+	key, _ := (&stubKeys{}).Key(core.StreamID(strmID), 1)
+	if dec, err := core.DecryptHLSSegment(key, 11, data); err != nil || string(dec) != "segment data" {
+		t.Errorf("Segment didn't decrypt: %v", err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleHLSKey(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	if w := get("/keys/" + strmID + "/1.key"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), key) {
+		t.Errorf("Expected the key, got %v %x", w.Code, w.Body.Bytes())
+	}
+	//No keys past the latest segment, or for bad paths
+	for _, path := range []string{"/keys/" + strmID + "/2.key", "/keys/strm/0.key", "/keys/" + strmID + "/x.key"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %v, got %v", path, w.Code)
+		}
+	}
+
+	//Keys follow the playback policy of the manifest
+	s.PlaybackSigner = NewPlaybackSigner([]byte("secret"))
+	sid := core.StreamID(strmID)
+	s.SetPlaybackPolicy(sid.ManifestID(), &PlaybackPolicy{Signed: true})
+	if w := get("/keys/" + strmID + "/1.key"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a token, got %v", w.Code)
+	}
+	token := s.PlaybackSigner.Token(sid.ManifestID(), time.Now().Add(time.Minute))
+	if w := get("/keys/" + strmID + "/1.key?token=" + token); w.Code != http.StatusOK {
+		t.Errorf("Expected the key with a token, got %v", w.Code)
+	}
+}
+
+func TestEndBroadcastRemovesHLSKeys(t *testing.T) {
+	b := &StubBroadcaster{Data: make(map[uint64][]byte)}
+	nw := &StubNetwork{B: map[string]*StubBroadcaster{"hlsID": b}, MPL: make(map[string]*m3u8.MasterPlaylist)}
+	n, _ := core.NewLivepeerNode(nil, nw, "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2", []string{"test"}, "")
+	s := NewLivepeerServer("1935", "8080", "", n)
+	keys := &stubKeys{}
+	s.HLSEncryption = &core.HLSEncryption{Keys: keys}
+	s.rtmpStreams["rtmpID"] = stream.NewBasicRTMPVideoStream("rtmpID")
+	s.broadcastRtmpToHLSMap["rtmpID"] = "hlsID"
+	s.broadcastRtmpToManifestMap["rtmpID"] = "manifestID"
+	s.renditions = map[core.ManifestID][]core.StreamID{"manifestID": {"hlsID", "renditionID"}, "otherID": {"otherRenditionID"}}
+
+	//The keys of the streams of the broadcast go with it, the ones of other broadcasts stay
+	s.endBroadcast("rtmpID")
+	removed := make(map[core.StreamID]bool)
+	for _, id := range keys.removed {
+		removed[id] = true
+	}
+	if !removed["hlsID"] || !removed["renditionID"] || removed["otherRenditionID"] {
+		t.Errorf("Expecting the keys of hlsID and renditionID to be removed, got %v", keys.removed)
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ericxtang/m3u8"
@@ -18,7 +20,6 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
-	"github.com/livepeer/go-livepeer/eth"
 	ethTypes "github.com/livepeer/go-livepeer/eth/types"
 	lpmscore "github.com/livepeer/lpms/core"
 	"github.com/livepeer/lpms/segmenter"
@@ -32,6 +33,7 @@ var ErrRTMPPublish = errors.New("ErrRTMPPublish")
 var ErrBroadcast = errors.New("ErrBroadcast")
 var ErrHLSPlay = errors.New("ErrHLSPlay")
 var ErrRTMPPlay = errors.New("ErrRTMPPlay")
+var ErrShuttingDown = errors.New("ErrShuttingDown")
 
 const HLSWaitInterval = time.Second
 const HLSBufferCap = uint(43200) //12 hrs assuming 1s segment
@@ -46,11 +48,6 @@ const EthEventTimeout = 5 * time.Second
 const EthMinedTxTimeout = 60 * time.Second
 
 var HLSWaitTime = time.Second * 45
-var BroadcastPrice = uint64(1)
-var BroadcastJobVideoProfiles = []lpmscore.VideoProfile{lpmscore.P240p30fps4x3, lpmscore.P360p30fps16x9}
-var TranscoderFeeCut = uint8(10)
-var TranscoderRewardCut = uint8(10)
-var TranscoderSegmentPrice = big.NewInt(150)
 var LastHLSStreamID core.StreamID
 var LastManifestID core.ManifestID
 
@@ -61,32 +58,43 @@ type LivepeerServer struct {
 	RtmpPort      string
 	FfmpegPath    string
 	LivepeerNode  *core.LivepeerNode
-
+	//EffectiveConfig is the node config at startup (with secrets redacted), by section.  The /config endpoint shows it with the current
+	//settings.
+	EffectiveConfig map[string]map[string]string
+	//HLSEncryption encrypts the HLS streams served to players, nil to serve them in the clear.
+	HLSEncryption *core.HLSEncryption
+	//PlaybackSigner checks the tokens of signed playback URLs, nil if the node has no playback key.
+	PlaybackSigner *PlaybackSigner
+	//DefaultPlaybackPolicy is the policy of manifests without one of their own, nil for public.
+	DefaultPlaybackPolicy *PlaybackPolicy
+
+	//broadcastLock guards rtmpStreams, broadcastRtmpToHLSMap, broadcastRtmpToManifestMap and broadcastSessions
+	broadcastLock              sync.Mutex
 	rtmpStreams                map[core.StreamID]stream.RTMPVideoStream
 	hlsSubTimer                map[core.StreamID]time.Time
 	hlsWorkerRunning           bool
 	broadcastRtmpToHLSMap      map[string]string
 	broadcastRtmpToManifestMap map[string]string
+	broadcastSessions          map[string]*broadcastSession
+	playbackPolicies           map[core.ManifestID]*PlaybackPolicy
+	//renditions are the streams in the master playlist of each manifest, so renditions are played under the policy of their manifest
+	renditions   map[core.ManifestID][]core.StreamID
+	playbackLock sync.Mutex
+
+	drainLock sync.Mutex
+	draining  bool
 }
 
 func NewLivepeerServer(rtmpPort string, httpPort string, ffmpegPath string, lpNode *core.LivepeerNode) *LivepeerServer {
 	server := lpmscore.New(rtmpPort, httpPort, ffmpegPath, "", fmt.Sprintf("%v/.tmp", lpNode.WorkDir))
-	return &LivepeerServer{RTMPSegmenter: server, LPMS: server, HttpPort: httpPort, RtmpPort: rtmpPort, FfmpegPath: ffmpegPath, LivepeerNode: lpNode, rtmpStreams: make(map[core.StreamID]stream.RTMPVideoStream), broadcastRtmpToHLSMap: make(map[string]string), broadcastRtmpToManifestMap: make(map[string]string)}
+	return &LivepeerServer{RTMPSegmenter: server, LPMS: server, HttpPort: httpPort, RtmpPort: rtmpPort, FfmpegPath: ffmpegPath, LivepeerNode: lpNode, rtmpStreams: make(map[core.StreamID]stream.RTMPVideoStream), broadcastRtmpToHLSMap: make(map[string]string), broadcastRtmpToManifestMap: make(map[string]string), broadcastSessions: make(map[string]*broadcastSession), playbackPolicies: make(map[core.ManifestID]*PlaybackPolicy), renditions: make(map[core.ManifestID][]core.StreamID)}
 }
 
 //StartServer starts the LPMS server
-func (s *LivepeerServer) StartMediaServer(ctx context.Context, maxPricePerSegment int, transcodingOptions string) error {
+func (s *LivepeerServer) StartMediaServer(ctx context.Context) error {
 	if s.LivepeerNode.Eth != nil {
-		BroadcastPrice = uint64(maxPricePerSegment)
-		bProfiles := make([]lpmscore.VideoProfile, 0)
-		for _, opt := range strings.Split(transcodingOptions, ",") {
-			p, ok := lpmscore.VideoProfileLookup[strings.TrimSpace(opt)]
-			if ok {
-				bProfiles = append(bProfiles, p)
-			}
-		}
-		BroadcastJobVideoProfiles = bProfiles
-		glog.Infof("Transcode Job Price: %v, Transcode Job Type: %v", BroadcastPrice, BroadcastJobVideoProfiles)
+		settings := s.LivepeerNode.Settings.Get()
+		glog.Infof("Transcode Job Price: %v, Transcode Job Type: %v", settings.BroadcastPrice, settings.BroadcastJobVideoProfiles)
 	}
 
 	//Start HLS unsubscribe worker
@@ -97,8 +105,9 @@ func (s *LivepeerServer) StartMediaServer(ctx context.Context, maxPricePerSegmen
 	s.LPMS.HandleRTMPPublish(createRTMPStreamIDHandler(s), gotRTMPStreamHandler(s), endRTMPStreamHandler(s))
 	s.LPMS.HandleRTMPPlay(getRTMPStreamHandler(s))
 
-	//LPMS hanlder for handling HLS video play
-	s.LPMS.HandleHLSPlay(getHLSMasterPlaylistHandler(s), getHLSMediaPlaylistHandler(s), getHLSSegmentHandler(s))
+	//HLS video play is handled here instead of by LPMS, so the playback policies can check the whole request
+	http.HandleFunc("/stream/", s.handleHLSPlay)
+	http.HandleFunc(HLSKeyPath, s.handleHLSKey)
 
 	//Start the LPMS server
 	lpmsCtx, cancel := context.WithCancel(context.Background())
@@ -132,6 +141,13 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 
 func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
 	return func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
+		if s.isDraining() {
+			glog.Errorf("Server is shutting down - cannot start broadcast session")
+			return ErrShuttingDown
+		}
+
+		//Use the same settings for the whole broadcast session, even if they are updated in the meantime
+		settings := s.LivepeerNode.Settings.Get()
 		if s.LivepeerNode.Eth != nil {
 			//Check Token Balance
 			b, err := s.LivepeerNode.Eth.TokenBalance()
@@ -141,21 +157,35 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 			}
 			glog.Infof("Current token balance for is: %v", b)
 
-			if b.Cmp(big.NewInt(int64(BroadcastPrice))) < 0 {
+			if b.Cmp(new(big.Int).SetUint64(settings.BroadcastPrice)) < 0 {
 				glog.Errorf("Low balance (%v) - cannot start broadcast session", b)
 				return ErrBroadcast
 			}
 		}
 
+		//The segment transport of the job can be picked for each broadcast with ?segmentTransport=
+		transport := settings.BroadcastSegmentTransport
+		if t := url.Query().Get("segmentTransport"); t != "" {
+			transport = core.SegmentTransport(t)
+		}
+		if !core.ValidSegmentTransport(transport) {
+			glog.Errorf("Unknown segment transport: %v", transport)
+			return ErrRTMPPublish
+		}
+
 		//Check if stream ID already exists
+		s.broadcastLock.Lock()
 		if _, ok := s.rtmpStreams[core.StreamID(rtmpStrm.GetStreamID())]; ok {
+			s.broadcastLock.Unlock()
 			return ErrAlreadyExists
 		}
 
 		//Add stream to stream store
 		s.rtmpStreams[core.StreamID(rtmpStrm.GetStreamID())] = rtmpStrm
+		s.broadcastLock.Unlock()
 
-		//We try to automatically determine the video profile from the RTMP stream.
+		//We try to automatically determine the video profile from the RTMP stream.  It's only used until the first segment is probed (if
+		//there is a SourceProber), then the master playlist gets the real params of the source.
 		var vProfile lpmscore.VideoProfile
 		resolution := fmt.Sprintf("%vx%v", rtmpStrm.Width(), rtmpStrm.Height())
 		for _, vp := range lpmscore.VideoProfileLookup {
@@ -197,19 +227,44 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 		}
 		LastHLSStreamID = hlsStrmID
 
-		//Segment the stream, insert the segments into the broadcaster
+		mid, err := core.MakeManifestID(hlsStrmID.GetNodeID(), hlsStrmID.GetVideoID())
+		if err != nil {
+			glog.Errorf("Error creating manifest id: %v", err)
+			return ErrRTMPPublish
+		}
+		vParams := core.ProfileVariantParams(vProfile)
+		if rtmpStrm.Width() > 0 && rtmpStrm.Height() > 0 {
+			vParams.Resolution = resolution
+		}
+		source := &m3u8.Variant{URI: fmt.Sprintf("%v.m3u8", hlsStrmID), Chunklist: pl, VariantParams: vParams}
+		//The session keeps the master playlist up to date, and fails over to a new job if the transcoder doesn't deliver
+		bs := newBroadcastSession(s, hlsStrmID, mid, source, settings.BroadcastJobVideoProfiles, settings.BroadcastPrice, transport)
+		prober := s.LivepeerNode.SourceProber
+
+		//Segment the stream, insert the segments into the broadcaster.  Ending the broadcast stops the segmenter, and the segments it
+		//still has aren't broadcast.
+		segCtx, stopSegmenter := context.WithCancel(context.Background())
+		bs.stopSegmenter = stopSegmenter
 		go func(broadcaster stream.Broadcaster, rtmpStrm stream.RTMPVideoStream) {
 			hlsStrm := stream.NewBasicHLSVideoStream(string(hlsStrmID), stream.DefaultHLSStreamWin)
+			probed := false
 			hlsStrm.SetSubscriber(func(seg *stream.HLSSegment, eof bool) {
+				if bs.isStopped() {
+					return
+				}
 				if eof {
 					broadcaster.Finish()
 					return
 				}
+				if !probed && prober != nil {
+					probed = true
+					go bs.probeSource(prober, seg)
+				}
 
 				segHash := (&ethTypes.Segment{StreamID: hlsStrm.GetStreamID(), SegmentSequenceNumber: big.NewInt(int64(seg.SeqNo)), DataHash: crypto.Keccak256Hash(seg.Data)}).Hash()
 				var sig []byte
-				if c, ok := s.LivepeerNode.Eth.(*eth.Client); ok {
-					sig, err = c.SignSegmentHash(s.LivepeerNode.EthPassword, segHash.Bytes())
+				if s.LivepeerNode.Eth != nil {
+					sig, err = s.LivepeerNode.Eth.SignSegmentHash(segHash.Bytes())
 					if err != nil {
 						glog.Errorf("Error signing segment %v-%v: %v", hlsStrm.GetStreamID(), seg.SeqNo, err)
 						return
@@ -217,7 +272,7 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 				}
 
 				//Encode segment into []byte, broadcast it
-				if ssb, err := core.SignedSegmentToBytes(core.SignedSegment{Seg: *seg, Sig: sig}); err != nil {
+				if ssb, err := bs.encodeSegment(seg, sig); err != nil {
 					glog.Errorf("Error signing segment: %v", seg.SeqNo)
 				} else {
 					if err := broadcaster.Broadcast(seg.SeqNo, ssb); err != nil {
@@ -226,8 +281,8 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 				}
 			})
 
-			err := s.RTMPSegmenter.SegmentRTMPToHLS(context.Background(), rtmpStrm, hlsStrm, SegOptions)
-			if err != nil {
+			err := s.RTMPSegmenter.SegmentRTMPToHLS(segCtx, rtmpStrm, hlsStrm, SegOptions)
+			if err != nil && segCtx.Err() == nil {
 				// glog.Infof("Error in segmenter: %v, broadcasting finish message", err)
 				if err := s.LivepeerNode.BroadcastFinishMsg(hlsStrmID.String()); err != nil {
 					glog.Errorf("Error broadcaseting finish message: %v", err)
@@ -235,56 +290,118 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 			}
 		}(broadcaster, rtmpStrm)
 
-		//Create the manifest and broadcast it (so the video can be consumed by itself without transcoding)
-		mid, err := core.MakeManifestID(hlsStrmID.GetNodeID(), hlsStrmID.GetVideoID())
-		if err != nil {
-			glog.Errorf("Error creating manifest id: %v", err)
-			return ErrRTMPPublish
-		}
+		//Broadcast the manifest (so the video can be consumed by itself without transcoding)
 		LastManifestID = mid
-		manifest := m3u8.NewMasterPlaylist()
-		vParams := lpmscore.VideoProfileToVariantParams(lpmscore.VideoProfileLookup[vProfile.Name])
-		manifest.Append(fmt.Sprintf("%v.m3u8", hlsStrmID), pl, vParams)
-		if err := s.LivepeerNode.VideoNetwork.UpdateMasterPlaylist(string(mid), manifest); err != nil {
-			glog.Errorf("Error broadasting manifest to network: %v", err)
-		}
+		bs.publish()
 		glog.Infof("\n\nManifestID: %v\n\n", mid)
 		glog.V(common.SHORT).Infof("\n\nhlsStrmID: %v\n\n", hlsStrmID)
 
 		//Remember HLS stream so we can remove later
+		s.broadcastLock.Lock()
 		s.broadcastRtmpToHLSMap[rtmpStrm.GetStreamID()] = string(hlsStrmID)
 		s.broadcastRtmpToManifestMap[rtmpStrm.GetStreamID()] = string(mid)
-
+		s.broadcastSessions[rtmpStrm.GetStreamID()] = bs
+		s.broadcastLock.Unlock()
+		//Announced streams are listed in the live stream directory of every node, with ?title= and ?tags=a,b
+		announce := AnnounceStreams
+		if a := url.Query().Get("announce"); a != "" {
+			announce = a == "true"
+		}
+		if announce {
+			bs.announce(s.LivepeerNode, url.Query().Get("title"), splitTags(url.Query().Get("tags")))
+		}
 		if s.LivepeerNode.Eth != nil {
 			//Create Transcode Job Onchain
-			go s.LivepeerNode.CreateTranscodeJob(hlsStrmID, BroadcastJobVideoProfiles, BroadcastPrice)
+			s.LivepeerNode.VideoNetwork.ReceivedTranscodeResponse(string(hlsStrmID), bs.gotTranscodeResponse)
+			bs.start()
 		}
 		return nil
 	}
 }
 
+//splitTags splits a comma separated list of tags, dropping the empty ones.
+func splitTags(s string) []string {
+	tags := make([]string, 0)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
 func endRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error {
 	return func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error {
-		rtmpID := rtmpStrm.GetStreamID()
-		hlsID := s.broadcastRtmpToHLSMap[rtmpID]
-		manifestID := s.broadcastRtmpToManifestMap[rtmpID]
-		//Remove RTMP stream
-		delete(s.rtmpStreams, core.StreamID(rtmpID))
-		//Remove HLS stream from the network - only need to remove the original HLS stream because the other streams in the manifest are not on the current node (they are on the transcoding node)
-		s.LivepeerNode.VideoCache.EvictHLSSubscriber(core.StreamID(hlsID))
-		if b, err := s.LivepeerNode.VideoNetwork.GetBroadcaster(hlsID); err != nil {
-			glog.Errorf("Error getting broadcaster from network: %v", err)
-		} else {
-			b.Finish()
-		}
-		//Remove Manifest
-		s.LivepeerNode.VideoCache.EvictHLSMasterPlaylist(core.ManifestID(manifestID))
-		//Remove the master playlist from the network
-		s.LivepeerNode.VideoNetwork.UpdateMasterPlaylist(manifestID, nil)
+		s.endBroadcast(rtmpStrm.GetStreamID())
 		return nil
 	}
 }
 
+//endBroadcast sends EOF for the HLS stream of an RTMP broadcast and removes it from the node and the network.  It's called when the RTMP
+//stream ends and when the server drains, so broadcasts that already ended are skipped.
+func (s *LivepeerServer) endBroadcast(rtmpID string) {
+	s.broadcastLock.Lock()
+	if _, ok := s.rtmpStreams[core.StreamID(rtmpID)]; !ok {
+		s.broadcastLock.Unlock()
+		return
+	}
+	hlsID := s.broadcastRtmpToHLSMap[rtmpID]
+	manifestID := s.broadcastRtmpToManifestMap[rtmpID]
+	bs := s.broadcastSessions[rtmpID]
+	//Remove RTMP stream
+	delete(s.broadcastSessions, rtmpID)
+	delete(s.rtmpStreams, core.StreamID(rtmpID))
+	delete(s.broadcastRtmpToHLSMap, rtmpID)
+	delete(s.broadcastRtmpToManifestMap, rtmpID)
+	s.broadcastLock.Unlock()
+
+	if bs != nil {
+		bs.stop()
+	}
+	//Remove HLS stream from the network - only need to remove the original HLS stream because the other streams in the manifest are not on the current node (they are on the transcoding node)
+	s.LivepeerNode.VideoCache.EvictHLSSubscriber(core.StreamID(hlsID))
+	if b, err := s.LivepeerNode.VideoNetwork.GetBroadcaster(hlsID); err != nil {
+		glog.Errorf("Error getting broadcaster from network: %v", err)
+	} else {
+		b.Finish()
+	}
+	//Remove Manifest, and the keys of its streams
+	s.LivepeerNode.VideoCache.EvictHLSMasterPlaylist(core.ManifestID(manifestID))
+	s.playbackLock.Lock()
+	strmIDs := append([]core.StreamID{core.StreamID(hlsID)}, s.renditions[core.ManifestID(manifestID)]...)
+	s.playbackLock.Unlock()
+	for _, strmID := range strmIDs {
+		s.removeHLSKeys(strmID)
+	}
+	s.setRenditions(core.ManifestID(manifestID), nil)
+	//Remove the master playlist from the network
+	s.LivepeerNode.VideoNetwork.UpdateMasterPlaylist(manifestID, nil)
+}
+
+//Drain stops the server from accepting new RTMP broadcasts and sends EOF to all active broadcasts.
+func (s *LivepeerServer) Drain() {
+	s.drainLock.Lock()
+	s.draining = true
+	s.drainLock.Unlock()
+
+	s.broadcastLock.Lock()
+	hlsIDs := make(map[string]string, len(s.broadcastRtmpToHLSMap))
+	for rtmpID, hlsID := range s.broadcastRtmpToHLSMap {
+		hlsIDs[rtmpID] = hlsID
+	}
+	s.broadcastLock.Unlock()
+	for rtmpID, hlsID := range hlsIDs {
+		glog.Infof("Ending broadcast %v", hlsID)
+		s.endBroadcast(rtmpID)
+	}
+}
+
+func (s *LivepeerServer) isDraining() bool {
+	s.drainLock.Lock()
+	defer s.drainLock.Unlock()
+	return s.draining
+}
+
 //End RTMP Publish Handlers
 
 //HLS Play Handlers
@@ -318,6 +435,9 @@ func getHLSMediaPlaylistHandler(s *LivepeerServer) func(url *url.URL) (*m3u8.Med
 			return nil, ErrNotFound
 		}
 		s.hlsSubTimer[strmID] = time.Now()
+		if s.HLSEncryption != nil {
+			s.encryptMediaPlaylist(strmID, pl)
+		}
 		return pl, nil
 	}
 }
@@ -337,6 +457,13 @@ func getHLSSegmentHandler(s *LivepeerServer) func(url *url.URL) ([]byte, error)
 		seg := s.LivepeerNode.VideoCache.GetHLSSegment(strmID, segName)
 		if seg == nil {
 			return nil, ErrNotFound
+		} else if s.HLSEncryption != nil {
+			data, err := s.HLSEncryption.EncryptSegment(strmID, seg.SeqNo, seg.Data)
+			if err != nil {
+				glog.Errorf("Error encrypting segment %v: %v", segName, err)
+				return nil, ErrHLSPlay
+			}
+			return data, nil
 		} else {
 			return seg.Data, nil
 		}
@@ -354,7 +481,9 @@ func getRTMPStreamHandler(s *LivepeerServer) func(url *url.URL) (stream.RTMPVide
 			glog.Errorf("Error parsing streamID with url %v - %v", url.Path, err)
 			return nil, ErrRTMPPlay
 		}
+		s.broadcastLock.Lock()
 		strm, ok := s.rtmpStreams[core.StreamID(strmID)]
+		s.broadcastLock.Unlock()
 		if !ok {
 			glog.Errorf("Cannot find RTMP stream")
 			return nil, ErrNotFound
@@ -380,6 +509,7 @@ func (s *LivepeerServer) startHlsUnsubscribeWorker(limit time.Duration, freq tim
 				glog.Infof("Inactive HLS Stream %v - unsubscribing", sid)
 				s.LivepeerNode.VideoCache.EvictHLSSubscriber(sid)
 				s.LivepeerNode.UnsubscribeFromNetwork(sid)
+				s.removeHLSKeys(sid)
 				delete(s.hlsSubTimer, sid)
 			}
 		}
@@ -14,9 +14,9 @@ import (
 
 	"github.com/ericxtang/m3u8"
 	"github.com/golang/glog"
-	bnet "github.com/livepeer/go-livepeer-basicnet"
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/net"
+	bnet "github.com/livepeer/go-livepeer/p2p/basicnet"
 	"github.com/livepeer/lpms/segmenter"
 	"github.com/livepeer/lpms/stream"
 )
@@ -40,7 +40,7 @@ func setupServer(nw net.VideoNetwork) *LivepeerServer {
 		}
 		n, _ := core.NewLivepeerNode(nil, nw, "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2", []string{"test"}, "./tmp")
 		S = NewLivepeerServer("1935", "8080", "", n)
-		go S.StartMediaServer(context.Background(), 0, "")
+		go S.StartMediaServer(context.Background())
 		go S.StartWebserver()
 	}
 	return S
@@ -97,7 +97,8 @@ func (n *StubNetwork) GetNodeStatus(nodeID string) (chan *net.NodeStatus, error)
 }
 
 type StubBroadcaster struct {
-	Data map[uint64][]byte
+	Data     map[uint64][]byte
+	Finished int
 }
 
 func (b *StubBroadcaster) IsLive() bool   { return false }
@@ -106,7 +107,10 @@ func (b *StubBroadcaster) Broadcast(seqNo uint64, data []byte) error {
 	b.Data[seqNo] = data
 	return nil
 }
-func (b *StubBroadcaster) Finish() error { return nil }
+func (b *StubBroadcaster) Finish() error {
+	b.Finished++
+	return nil
+}
 
 type StubSubscriber struct {
 	working         bool
@@ -210,6 +214,69 @@ func TestGotRTMPStreamHandler(t *testing.T) {
 	}
 }
 
+func TestEndBroadcastOnce(t *testing.T) {
+	b := &StubBroadcaster{Data: make(map[uint64][]byte)}
+	nw := &StubNetwork{B: map[string]*StubBroadcaster{"hlsID": b}, MPL: make(map[string]*m3u8.MasterPlaylist)}
+	n, _ := core.NewLivepeerNode(nil, nw, "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2", []string{"test"}, "")
+	s := NewLivepeerServer("1935", "8080", "", n)
+	s.rtmpStreams["rtmpID"] = stream.NewBasicRTMPVideoStream("rtmpID")
+	s.broadcastRtmpToHLSMap["rtmpID"] = "hlsID"
+	s.broadcastRtmpToManifestMap["rtmpID"] = "manifestID"
+
+	//The RTMP stream can end while the server drains
+	s.Drain()
+	s.endBroadcast("rtmpID")
+	if b.Finished != 1 {
+		t.Errorf("Expecting the broadcast to be finished once, got %v", b.Finished)
+	}
+	if len(s.rtmpStreams) != 0 || len(s.broadcastRtmpToHLSMap) != 0 || len(s.broadcastRtmpToManifestMap) != 0 {
+		t.Errorf("Expecting the broadcast to be removed")
+	}
+}
+
+//ctxSegmenter adds a segment, and another one once the segmenter is stopped.
+type ctxSegmenter struct {
+	StubSegmenter
+	added   chan struct{}
+	stopped chan struct{}
+}
+
+func (s *ctxSegmenter) SegmentRTMPToHLS(ctx context.Context, rs stream.RTMPVideoStream, hs stream.HLSVideoStream, segOptions segmenter.SegmenterOptions) error {
+	hs.AddHLSSegment(&stream.HLSSegment{SeqNo: 0, Name: "seg0.ts"})
+	close(s.added)
+	<-ctx.Done()
+	hs.AddHLSSegment(&stream.HLSSegment{SeqNo: 1, Name: "seg1.ts"})
+	close(s.stopped)
+	return ctx.Err()
+}
+
+func TestDrainStopsSegmenter(t *testing.T) {
+	nid := "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2"
+	hlsStrmID := nid + "10f6afa01868f11f5722434aa4a0769842e04fac75dfaccece208c5710fd52e0"
+	b := &StubBroadcaster{Data: make(map[uint64][]byte)}
+	nw := &StubNetwork{B: map[string]*StubBroadcaster{hlsStrmID: b}, MPL: make(map[string]*m3u8.MasterPlaylist)}
+	n, _ := core.NewLivepeerNode(nil, nw, core.NodeID(nid), []string{"test"}, "")
+	s := NewLivepeerServer("1935", "8080", "", n)
+	seg := &ctxSegmenter{added: make(chan struct{}), stopped: make(chan struct{})}
+	s.RTMPSegmenter = seg
+
+	url, _ := url.Parse(fmt.Sprintf("rtmp://localhost:1935/movie?hlsStrmID=%v", hlsStrmID))
+	if err := gotRTMPStreamHandler(s)(url, stream.NewBasicRTMPVideoStream("strmID")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	<-seg.added
+	s.Drain()
+	select {
+	case <-seg.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Expecting draining to stop the segmenter")
+	}
+	//Only the segment from before is broadcast, and the broadcast is finished once
+	if len(b.Data) != 1 || b.Finished != 1 {
+		t.Errorf("Expecting 1 segment and the broadcast to be finished once, got %v segments and %v finishes", len(b.Data), b.Finished)
+	}
+}
+
 func TestGetHLSMasterPlaylistHandler(t *testing.T) {
 	glog.Infof("\n\nTestGetHLSMasterPlaylistHandler...\n")
 	stubnet := &StubNetwork{MPL: make(map[string]*m3u8.MasterPlaylist)}
@@ -320,3 +387,35 @@ func TestParseSegname(t *testing.T) {
 		t.Errorf("Expecting %v, but %v", "1220c50f8bc4d2a807aace1e1376496a9d7f7c1408dec2512763c3ca16fe828f6631_01.ts", segName)
 	}
 }
+
+func TestSplitTags(t *testing.T) {
+	tags := splitTags(" chess, sports,,")
+	if len(tags) != 2 || tags[0] != "chess" || tags[1] != "sports" {
+		t.Errorf("Expecting [chess sports], got %v", tags)
+	}
+	if tags := splitTags(""); len(tags) != 0 {
+		t.Errorf("Expecting no tags, got %v", tags)
+	}
+}
+
+func TestCurrentConfig(t *testing.T) {
+	n, _ := core.NewLivepeerNode(nil, &StubNetwork{}, "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd2", []string{"test"}, "")
+	s := NewLivepeerServer("1935", "8080", "", n)
+	s.EffectiveConfig = map[string]map[string]string{"broadcaster": {"maxPricePerSegment": "1", "probeSource": "true"}}
+
+	if err := n.Settings.Update(func(settings *core.Settings) error {
+		settings.BroadcastPrice = 5
+		settings.TranscoderFeeCut = 20
+		return nil
+	}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := s.currentConfig()
+	if config["broadcaster"]["maxPricePerSegment"] != "5" || config["broadcaster"]["probeSource"] != "true" || config["settings"]["transcoderFeeCut"] != "20" {
+		t.Errorf("Expecting the updated settings, got %v", config)
+	}
+	//The startup config isn't changed
+	if s.EffectiveConfig["broadcaster"]["maxPricePerSegment"] != "1" {
+		t.Errorf("Expecting the startup config to stay, got %v", s.EffectiveConfig)
+	}
+}
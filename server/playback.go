@@ -0,0 +1,424 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/vidplayer"
+)
+
+var ErrPlaybackDenied = errors.New("ErrPlaybackDenied")
+var ErrPlaybackToken = errors.New("ErrPlaybackToken")
+
+//PlaybackTokenParam is the query parameter of the token in signed playback URLs.
+const PlaybackTokenParam = "token"
+
+//PlaybackPolicy is who can watch a manifest.  The zero value is public.
+type PlaybackPolicy struct {
+	//Signed requires a playback URL signed with the node's playback key (see PlaybackSigner).
+	Signed bool
+	//AllowedIPs are the IPs or CIDR networks viewers can watch from, empty for anywhere.
+	AllowedIPs []string
+	//AllowedReferrers are the hosts of the pages the stream can be played from, empty for any.  Requests without a Referer are refused
+	//if this is set.
+	AllowedReferrers []string
+
+	nets []*net.IPNet
+}
+
+//NewPlaybackPolicy makes a policy from comma separated lists of IPs and referrer hosts.
+func NewPlaybackPolicy(signed bool, allowedIPs, allowedReferrers string) (*PlaybackPolicy, error) {
+	p := &PlaybackPolicy{Signed: signed}
+	for _, ip := range splitList(allowedIPs) {
+		if !strings.Contains(ip, "/") {
+			if strings.Contains(ip, ":") {
+				ip = ip + "/128"
+			} else {
+				ip = ip + "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %q", ip)
+		}
+		p.AllowedIPs = append(p.AllowedIPs, n.String())
+		p.nets = append(p.nets, n)
+	}
+	for _, host := range splitList(allowedReferrers) {
+		p.AllowedReferrers = append(p.AllowedReferrers, strings.ToLower(host))
+	}
+	return p, nil
+}
+
+func (p *PlaybackPolicy) IsPublic() bool {
+	return !p.Signed && len(p.AllowedIPs) == 0 && len(p.AllowedReferrers) == 0
+}
+
+//check checks the IP and referrer restrictions of the policy.  The token is checked by the caller, since it needs the signer.
+func (p *PlaybackPolicy) check(r *http.Request) error {
+	if len(p.nets) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		allowed := false
+		for _, n := range p.nets {
+			allowed = allowed || (ip != nil && n.Contains(ip))
+		}
+		if !allowed {
+			return ErrPlaybackDenied
+		}
+	}
+	if len(p.AllowedReferrers) > 0 {
+		ref, err := url.Parse(r.Referer())
+		if err != nil || ref.Host == "" {
+			return ErrPlaybackDenied
+		}
+		host := strings.ToLower(ref.Hostname())
+		allowed := false
+		for _, h := range p.AllowedReferrers {
+			allowed = allowed || host == h
+		}
+		if !allowed {
+			return ErrPlaybackDenied
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+//PlaybackSigner makes and checks the tokens of signed playback URLs.  A token is <expiry>.<HMAC-SHA256 of manifest ID and expiry>, and is
+//good for every playlist and segment of the manifest until it expires.
+type PlaybackSigner struct {
+	key []byte
+}
+
+func NewPlaybackSigner(key []byte) *PlaybackSigner {
+	return &PlaybackSigner{key: key}
+}
+
+func (s *PlaybackSigner) Token(mid core.ManifestID, expiry time.Time) string {
+	return fmt.Sprintf("%v.%x", expiry.Unix(), s.mac(mid, expiry.Unix()))
+}
+
+//SignURL returns the signed playback URL (path and query) of the master playlist of the manifest.
+func (s *PlaybackSigner) SignURL(mid core.ManifestID, expiry time.Time) string {
+	return fmt.Sprintf("/stream/%v.m3u8?%v=%v", mid, PlaybackTokenParam, s.Token(mid, expiry))
+}
+
+func (s *PlaybackSigner) Verify(mid core.ManifestID, token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return ErrPlaybackToken
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrPlaybackToken
+	}
+	mac, err := hex.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, s.mac(mid, expiry)) {
+		return ErrPlaybackToken
+	}
+	if now.Unix() > expiry {
+		return ErrPlaybackToken
+	}
+	return nil
+}
+
+func (s *PlaybackSigner) mac(mid core.ManifestID, expiry int64) []byte {
+	h := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(h, "%v.%v", mid, expiry)
+	return h.Sum(nil)
+}
+
+//SetPlaybackPolicy sets the policy of a manifest.  nil goes back to the default policy.
+func (s *LivepeerServer) SetPlaybackPolicy(mid core.ManifestID, p *PlaybackPolicy) {
+	s.playbackLock.Lock()
+	defer s.playbackLock.Unlock()
+	if p == nil {
+		delete(s.playbackPolicies, mid)
+		return
+	}
+	s.playbackPolicies[mid] = p
+}
+
+//PlaybackPolicy returns the policy of a manifest, which is the default policy unless one was set for it.
+func (s *LivepeerServer) PlaybackPolicy(mid core.ManifestID) *PlaybackPolicy {
+	s.playbackLock.Lock()
+	defer s.playbackLock.Unlock()
+	if p, ok := s.playbackPolicies[mid]; ok {
+		return p
+	}
+	if s.DefaultPlaybackPolicy != nil {
+		return s.DefaultPlaybackPolicy
+	}
+	return &PlaybackPolicy{}
+}
+
+//authorizePlayback checks a request for a playlist, segment or key of the manifest against its policy.
+func (s *LivepeerServer) authorizePlayback(r *http.Request, mid core.ManifestID) error {
+	p := s.PlaybackPolicy(mid)
+	if err := p.check(r); err != nil {
+		return err
+	}
+	if !p.Signed {
+		return nil
+	}
+	if s.PlaybackSigner == nil {
+		glog.Errorf("Manifest %v needs signed playback URLs, but there is no playback key", mid)
+		return ErrPlaybackDenied
+	}
+	return s.PlaybackSigner.Verify(mid, r.URL.Query().Get(PlaybackTokenParam), time.Now())
+}
+
+//setRenditions records the streams in the master playlist of mid, nil forgets them.
+func (s *LivepeerServer) setRenditions(mid core.ManifestID, mpl *m3u8.MasterPlaylist) {
+	s.playbackLock.Lock()
+	defer s.playbackLock.Unlock()
+	if s.renditions == nil {
+		s.renditions = make(map[core.ManifestID][]core.StreamID)
+	}
+	if mpl == nil {
+		delete(s.renditions, mid)
+		return
+	}
+	strmIDs := make([]core.StreamID, 0, len(mpl.Variants))
+	//The URIs are relative, <streamID>.m3u8
+	add := func(uri string) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return
+		}
+		strmID := core.StreamID(strings.TrimSuffix(path.Base(u.Path), ".m3u8"))
+		if strmID.IsValid() {
+			strmIDs = append(strmIDs, strmID)
+		}
+	}
+	for _, v := range mpl.Variants {
+		add(v.URI)
+		for _, alt := range v.Alternatives {
+			if alt.URI != "" {
+				add(alt.URI)
+			}
+		}
+	}
+	s.renditions[mid] = strmIDs
+}
+
+//streamManifests returns the manifests a stream is played in.  Transcoded streams have the stream IDs the transcoder made, so they are
+//looked up in the master playlists, or in the transcode jobs if this node transcodes them.  A stream can be in more than one manifest if
+//another broadcaster lists it, and then it's played under the policies of all of them.
+func (s *LivepeerServer) streamManifests(strmID core.StreamID) []core.ManifestID {
+	mids := make([]core.ManifestID, 0)
+	add := func(mid core.ManifestID) {
+		for _, m := range mids {
+			if m == mid {
+				return
+			}
+		}
+		mids = append(mids, mid)
+	}
+
+	s.playbackLock.Lock()
+	for mid, strmIDs := range s.renditions {
+		for _, id := range strmIDs {
+			if id == strmID {
+				add(mid)
+			}
+		}
+	}
+	//The manifest the stream ID is made from is the one of a source stream
+	own := strmID.ManifestID()
+	_, hasRenditions := s.renditions[own]
+	_, hasPolicy := s.playbackPolicies[own]
+	s.playbackLock.Unlock()
+	if hasRenditions || hasPolicy {
+		add(own)
+	}
+	if src, ok := s.LivepeerNode.TranscodeSource(strmID); ok {
+		add(src.ManifestID())
+	}
+	if len(mids) > 0 {
+		return mids
+	}
+
+	//Not seen yet, the stream can be the source stream of a manifest that wasn't played on this node
+	if mpl := s.LivepeerNode.VideoCache.GetHLSMasterPlaylist(own); mpl != nil {
+		s.setRenditions(own, mpl)
+		s.playbackLock.Lock()
+		defer s.playbackLock.Unlock()
+		for _, id := range s.renditions[own] {
+			if id == strmID {
+				return []core.ManifestID{own}
+			}
+		}
+	}
+	return mids
+}
+
+//authorizeStream checks a request for a media playlist, segment or key of a stream against the policies of its manifests.  Streams that
+//aren't in a manifest the node knows of are only played if playback is public.
+func (s *LivepeerServer) authorizeStream(r *http.Request, strmID core.StreamID) error {
+	mids := s.streamManifests(strmID)
+	if len(mids) == 0 {
+		if !s.publicPlayback() {
+			return ErrPlaybackDenied
+		}
+		return nil
+	}
+	for _, mid := range mids {
+		if err := s.authorizePlayback(r, mid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//publicPlayback tells if every manifest is public.
+func (s *LivepeerServer) publicPlayback() bool {
+	s.playbackLock.Lock()
+	defer s.playbackLock.Unlock()
+	if s.DefaultPlaybackPolicy != nil && !s.DefaultPlaybackPolicy.IsPublic() {
+		return false
+	}
+	for _, p := range s.playbackPolicies {
+		if !p.IsPublic() {
+			return false
+		}
+	}
+	return true
+}
+
+//handleHLSPlay serves the HLS streams like the lpms player, after checking the playback policy of the manifest.  The lpms player can't do
+//this, its handlers only get the URL.  If the request has a token, it's added to the URIs in the playlists, so the player sends it with
+//the requests of the whole session.
+func (s *LivepeerServer) handleHLSPlay(w http.ResponseWriter, r *http.Request) {
+	glog.V(common.SHORT).Infof("Got HTTP request @ %v", r.URL.Path)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=5")
+
+	mid, err := parseManifestID(r.URL.Path)
+	if err == nil {
+		err = s.authorizePlayback(r, mid)
+	} else if strmID, serr := parseStreamID(r.URL.Path); serr == nil && strmID.IsValid() {
+		err = s.authorizeStream(r, strmID)
+	} else {
+		http.Error(w, "Cannot find HTTP video resource: "+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		glog.Infof("Refusing %v to %v: %v", r.URL.Path, r.RemoteAddr, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	token := r.URL.Query().Get(PlaybackTokenParam)
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".m3u8"):
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.Header().Set("Connection", "keep-alive")
+		masterPl, err := getHLSMasterPlaylistHandler(s)(r.URL)
+		if err != nil && err != vidplayer.ErrNotMasterPlaylistID {
+			glog.Errorf("Error getting HLS master playlist: %v", err)
+			http.Error(w, "Error getting HLS master playlist", http.StatusInternalServerError)
+			return
+		}
+		if masterPl != nil && len(masterPl.Variants) > 0 {
+			s.setRenditions(mid, masterPl)
+			if token != "" {
+				masterPl = tokenMasterPlaylist(masterPl, token)
+			}
+			w.Write(masterPl.Encode().Bytes())
+			return
+		}
+
+		mediaPl, err := getHLSMediaPlaylistHandler(s)(r.URL)
+		if err != nil {
+			http.Error(w, "Error getting HLS media playlist", http.StatusInternalServerError)
+			return
+		}
+		if token != "" {
+			tokenMediaPlaylist(mediaPl, token)
+		}
+		w.Write(mediaPl.Encode().Bytes())
+	case strings.HasSuffix(r.URL.Path, ".ts"):
+		seg, err := getHLSSegmentHandler(s)(r.URL)
+		if err != nil {
+			glog.Errorf("Error getting segment %v: %v", r.URL, err)
+			http.Error(w, "Error getting segment", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(r.URL.Path)))
+		w.Header().Set("Connection", "keep-alive")
+		if _, err := w.Write(seg); err != nil {
+			glog.Errorf("Error writing HLS segment %v: %v", r.URL, err)
+		}
+	default:
+		http.Error(w, "Only HLS requests (m3u8, ts) are supported", http.StatusNotFound)
+	}
+}
+
+func tokenQuery(token string) string {
+	return fmt.Sprintf("%v=%v", PlaybackTokenParam, url.QueryEscape(token))
+}
+
+func withToken(uri, token string) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + tokenQuery(token)
+	}
+	return uri + "?" + tokenQuery(token)
+}
+
+//tokenMasterPlaylist returns a copy of the master playlist with the token on the URIs of the variants and the EXT-X-MEDIA entries.  The
+//playlist is shared, so it's not changed.
+func tokenMasterPlaylist(mpl *m3u8.MasterPlaylist, token string) *m3u8.MasterPlaylist {
+	out := m3u8.NewMasterPlaylist()
+	for _, v := range mpl.Variants {
+		vParams := v.VariantParams
+		vParams.Alternatives = nil
+		for _, alt := range v.Alternatives {
+			a := *alt
+			if a.URI != "" {
+				a.URI = withToken(a.URI, token)
+			}
+			vParams.Alternatives = append(vParams.Alternatives, &a)
+		}
+		out.Append(withToken(v.URI, token), v.Chunklist, vParams)
+	}
+	return out
+}
+
+//tokenMediaPlaylist puts the token on the segment and key URIs of the media playlist, which is made for each request.
+func tokenMediaPlaylist(pl *m3u8.MediaPlaylist, token string) {
+	pl.Args = tokenQuery(token)
+	for _, seg := range pl.Segments {
+		if seg != nil && seg.Key != nil {
+			seg.Key.URI = withToken(seg.Key.URI, token)
+		}
+	}
+}
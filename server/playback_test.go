@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+func TestPlaybackSigner(t *testing.T) {
+	mid := core.ManifestID("12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd210f6afa01868f11f5722434aa4a0769842e04fac75dfaccece208c5710fd52e0")
+	signer := NewPlaybackSigner([]byte("secret"))
+	now := time.Now()
+	token := signer.Token(mid, now.Add(time.Minute))
+
+	if err := signer.Verify(mid, token, now); err != nil {
+		t.Errorf("Expected the token to be good: %v", err)
+	}
+	if err := signer.Verify(mid, token, now.Add(2*time.Minute)); err != ErrPlaybackToken {
+		t.Errorf("Expected the token to expire, got %v", err)
+	}
+	if err := signer.Verify(core.ManifestID(strings.Replace(string(mid), "e0", "e1", 1)), token, now); err != ErrPlaybackToken {
+		t.Errorf("Expected the token to be for one manifest only, got %v", err)
+	}
+	if err := NewPlaybackSigner([]byte("other")).Verify(mid, token, now); err != ErrPlaybackToken {
+		t.Errorf("Expected the token to need the key, got %v", err)
+	}
+	later := fmt.Sprintf("%v%v", now.Add(time.Hour).Unix(), token[strings.Index(token, "."):])
+	if err := signer.Verify(mid, later, now); err != ErrPlaybackToken {
+		t.Errorf("Expected a changed expiry to be refused, got %v", err)
+	}
+	for _, bad := range []string{"", "abc", "1.zz"} {
+		if err := signer.Verify(mid, bad, now); err != ErrPlaybackToken {
+			t.Errorf("Expected %q to be refused, got %v", bad, err)
+		}
+	}
+	if u := signer.SignURL(mid, now); u != fmt.Sprintf("/stream/%v.m3u8?token=%v", mid, signer.Token(mid, now)) {
+		t.Errorf("Wrong signed URL: %v", u)
+	}
+}
+
+func TestPlaybackPolicy(t *testing.T) {
+	if _, err := NewPlaybackPolicy(false, "10.0.0.0/8,nope", ""); err == nil {
+		t.Errorf("Expected an error for a bad IP")
+	}
+	p, err := NewPlaybackPolicy(false, "10.0.0.0/8, 192.168.1.5", "Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsPublic() {
+		t.Errorf("Expected a restricted policy")
+	}
+	tests := []struct {
+		remote  string
+		referer string
+		ok      bool
+	}{
+		{"10.1.2.3:5000", "https://example.com/watch", true},
+		{"192.168.1.5:5000", "http://EXAMPLE.com:8080/", true},
+		{"192.168.1.6:5000", "https://example.com/watch", false},
+		{"10.1.2.3:5000", "https://example.org/watch", false},
+		{"10.1.2.3:5000", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/stream/x.m3u8", nil)
+		r.RemoteAddr = test.remote
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		if err := p.check(r); (err == nil) != test.ok {
+			t.Errorf("%v from %q: expected ok=%v, got %v", test.remote, test.referer, test.ok, err)
+		}
+	}
+}
+
+func TestHandleHLSPlay(t *testing.T) {
+	mid := "12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd210f6afa01868f11f5722434aa4a0769842e04fac75dfaccece208c5710fd52e0"
+	strmID := mid + "P240p30fps16x9"
+	cache := &stubVideoCache{segs: []*stream.HLSSegment{{SeqNo: 1, Name: strmID + "_1.ts", Data: []byte("segment data"), Duration: 8}}}
+	cache.master = m3u8.NewMasterPlaylist()
+	cache.master.Append(strmID+".m3u8", nil, m3u8.VariantParams{Bandwidth: 400000, Audio: "audio", Alternatives: []*m3u8.Alternative{{Type: "AUDIO", GroupId: "audio", Name: "English", URI: mid + "A128kStereo.m3u8"}}})
+	s := &LivepeerServer{LivepeerNode: &core.LivepeerNode{VideoCache: cache}, hlsSubTimer: make(map[core.StreamID]time.Time), playbackPolicies: make(map[core.ManifestID]*PlaybackPolicy)}
+	s.PlaybackSigner = NewPlaybackSigner([]byte("secret"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleHLSPlay(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	//Public by default
+	if w := get("/stream/" + strmID + "_1.ts"); w.Code != http.StatusOK || w.Body.String() != "segment data" {
+		t.Errorf("Expected the segment, got %v %q", w.Code, w.Body.String())
+	}
+
+	s.DefaultPlaybackPolicy = &PlaybackPolicy{Signed: true}
+	for _, path := range []string{"/stream/" + mid + ".m3u8", "/stream/" + strmID + ".m3u8", "/stream/" + strmID + "_1.ts"} {
+		if w := get(path); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %v without a token, got %v", path, w.Code)
+		}
+	}
+
+	token := s.PlaybackSigner.Token(core.ManifestID(mid), time.Now().Add(time.Minute))
+	w := get("/stream/" + mid + ".m3u8?token=" + token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the master playlist, got %v", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, strmID+".m3u8?token="+token+"\n") || !strings.Contains(body, "URI=\""+mid+"A128kStereo.m3u8?token="+token+"\"") {
+		t.Errorf("Expected the token on the playlist URIs: %v", body)
+	}
+	if strings.Contains(cache.master.Encode().String(), token) {
+		t.Errorf("Expected the shared master playlist to stay the same")
+	}
+
+	w = get("/stream/" + strmID + ".m3u8?token=" + token)
+	if !strings.Contains(w.Body.String(), strmID+"_1.ts?token="+token+"\n") {
+		t.Errorf("Expected the token on the segment URIs: %v", w.Body.String())
+	}
+	if w := get("/stream/" + strmID + "_1.ts?token=" + token); w.Code != http.StatusOK {
+		t.Errorf("Expected the segment with a token, got %v", w.Code)
+	}
+
+	//Per manifest policies take precedence
+	s.SetPlaybackPolicy(core.ManifestID(mid), &PlaybackPolicy{})
+	if w := get("/stream/" + strmID + "_1.ts"); w.Code != http.StatusOK {
+		t.Errorf("Expected the manifest to be public, got %v", w.Code)
+	}
+	s.SetPlaybackPolicy(core.ManifestID(mid), nil)
+	if w := get("/stream/" + strmID + "_1.ts"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the default policy again, got %v", w.Code)
+	}
+}
+
+func TestRenditionPlayback(t *testing.T) {
+	mid := core.ManifestID("12209433a695c8bf34ef6a40863cfe7ed64266d876176aee13732293b63ba1637fd210f6afa01868f11f5722434aa4a0769842e04fac75dfaccece208c5710fd52e0")
+	source := core.StreamID(string(mid) + "RTMP")
+	//Renditions get stream IDs of the transcoder
+	rendition, err := core.MakeStreamID(core.NodeID("1220c1fbd5ba4bd91ebbf0ee9dfd0d8d4e95fbd14e1a3b33e2b63e5ae3f4123ff59c"), core.RandomVideoID(), "P240p30fps16x9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendition.ManifestID() == mid {
+		t.Fatalf("Expecting the rendition to have the manifest ID of the transcoder")
+	}
+	cache := &stubVideoCache{segs: []*stream.HLSSegment{{SeqNo: 1, Name: string(rendition) + "_1.ts", Data: []byte("segment data"), Duration: 8}}, masterID: mid}
+	cache.master = m3u8.NewMasterPlaylist()
+	cache.master.Append(string(source)+".m3u8", nil, m3u8.VariantParams{Bandwidth: 4000000})
+	cache.master.Append(string(rendition)+".m3u8", nil, m3u8.VariantParams{Bandwidth: 400000})
+	s := &LivepeerServer{LivepeerNode: &core.LivepeerNode{VideoCache: cache}, hlsSubTimer: make(map[core.StreamID]time.Time), playbackPolicies: make(map[core.ManifestID]*PlaybackPolicy)}
+	s.PlaybackSigner = NewPlaybackSigner([]byte("secret"))
+	s.SetPlaybackPolicy(mid, &PlaybackPolicy{Signed: true})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleHLSPlay(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	//The rendition isn't in a manifest the node knows of yet, and the node isn't public
+	if w := get("/stream/" + string(rendition) + "_1.ts"); w.Code != http.StatusForbidden {
+		t.Errorf("Expecting 403 for an unknown rendition, got %v", w.Code)
+	}
+
+	token := s.PlaybackSigner.Token(mid, time.Now().Add(time.Minute))
+	if w := get("/stream/" + string(mid) + ".m3u8?token=" + token); w.Code != http.StatusOK {
+		t.Fatalf("Expected the master playlist, got %v", w.Code)
+	}
+	//The rendition is played under the policy of the manifest of the master playlist
+	for _, path := range []string{"/stream/" + string(rendition) + ".m3u8", "/stream/" + string(rendition) + "_1.ts"} {
+		if w := get(path); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %v without a token, got %v", path, w.Code)
+		}
+		if w := get(path + "?token=" + token); w.Code != http.StatusOK {
+			t.Errorf("Expected %v with a token, got %v", path, w.Code)
+		}
+	}
+	otherToken := s.PlaybackSigner.Token(rendition.ManifestID(), time.Now().Add(time.Minute))
+	if w := get("/stream/" + string(rendition) + "_1.ts?token=" + otherToken); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a token for the manifest ID of the rendition to be refused, got %v", w.Code)
+	}
+
+	//Another manifest that lists the rendition can't make it public
+	other := m3u8.NewMasterPlaylist()
+	other.Append(string(rendition)+".m3u8", nil, m3u8.VariantParams{Bandwidth: 400000})
+	s.setRenditions(core.ManifestID(strings.Replace(string(mid), "e0", "e1", 1)), other)
+	if w := get("/stream/" + string(rendition) + "_1.ts"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a rendition listed in a public manifest, got %v", w.Code)
+	}
+
+	//The source stream is resolved through the master playlist of its manifest
+	s.setRenditions(mid, nil)
+	if w := get("/stream/" + string(source) + ".m3u8"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the source without a token, got %v", w.Code)
+	}
+}
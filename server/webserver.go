@@ -8,8 +8,8 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
-	basicnet "github.com/livepeer/go-livepeer-basicnet"
 	lpmscore "github.com/livepeer/lpms/core"
 	"github.com/livepeer/lpms/transcoder"
 
@@ -21,6 +21,49 @@ import (
 	"github.com/livepeer/go-livepeer/net"
 )
 
+//currentConfig is EffectiveConfig with the settings that can change while the node runs taken from the settings store, so updates made
+//through the web API show up.  The settings are also in their own section.
+func (s *LivepeerServer) currentConfig() map[string]map[string]string {
+	config := make(map[string]map[string]string)
+	for section, values := range s.EffectiveConfig {
+		config[section] = make(map[string]string)
+		for k, v := range values {
+			config[section][k] = v
+		}
+	}
+	settings := s.LivepeerNode.Settings.Get()
+	if config["broadcaster"] == nil {
+		config["broadcaster"] = make(map[string]string)
+	}
+	config["broadcaster"]["maxPricePerSegment"] = strconv.FormatUint(settings.BroadcastPrice, 10)
+	config["broadcaster"]["transcodingOptions"] = strings.Join(core.ProfileNamesOf(settings.BroadcastJobVideoProfiles), ",")
+	config["broadcaster"]["segmentTransport"] = string(settings.BroadcastSegmentTransport)
+	config["settings"] = map[string]string{
+		"broadcastPrice":                  strconv.FormatUint(settings.BroadcastPrice, 10),
+		"broadcastJobVideoProfiles":       strings.Join(core.ProfileNamesOf(settings.BroadcastJobVideoProfiles), ","),
+		"broadcastSegmentTransport":       string(settings.BroadcastSegmentTransport),
+		"transcoderFeeCut":                strconv.Itoa(int(settings.TranscoderFeeCut)),
+		"transcoderRewardCut":             strconv.Itoa(int(settings.TranscoderRewardCut)),
+		"transcoderSegmentPrice":          fmt.Sprintf("%v", settings.TranscoderSegmentPrice),
+		"transcoderAcceptJobs":            strconv.FormatBool(settings.TranscoderAcceptJobs),
+		"transcoderMinBroadcasterDeposit": fmt.Sprintf("%v", settings.TranscoderMinBroadcasterDeposit),
+		"autoReward":                      strconv.FormatBool(settings.AutoReward),
+	}
+	return config
+}
+
+//updateTranscoderSettings records the transcoder config after it has been set on-chain.
+func (s *LivepeerServer) updateTranscoderSettings(blockRewardCut, feeShare, price int) {
+	if err := s.LivepeerNode.Settings.Update(func(settings *core.Settings) error {
+		settings.TranscoderRewardCut = uint8(blockRewardCut)
+		settings.TranscoderFeeCut = uint8(100 - feeShare)
+		settings.TranscoderSegmentPrice = big.NewInt(int64(price))
+		return nil
+	}); err != nil {
+		glog.Errorf("Error updating transcoder settings: %v", err)
+	}
+}
+
 func (s *LivepeerServer) StartWebserver() {
 	//Temporary endpoint just so we can invoke a transcode job.  IRL this should be invoked by transcoders monitoring the smart contract.
 	http.HandleFunc("/transcode", func(w http.ResponseWriter, r *http.Request) {
@@ -52,75 +95,157 @@ func (s *LivepeerServer) StartWebserver() {
 	http.HandleFunc("/setBroadcastConfig", func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
 			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		priceStr := r.FormValue("maxPricePerSegment")
 		if priceStr == "" {
 			glog.Errorf("Need to provide max price per segment")
+			http.Error(w, "Need to provide max price per segment", http.StatusBadRequest)
 			return
 		}
 		price, err := strconv.Atoi(priceStr)
 		if err != nil {
 			glog.Errorf("Cannot convert max price per segment: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert max price per segment: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		transcodingOptions := r.FormValue("transcodingOptions")
 		if transcodingOptions == "" {
 			glog.Errorf("Need to provide transcoding options")
+			http.Error(w, "Need to provide transcoding options", http.StatusBadRequest)
 			return
 		}
 
 		profiles := []lpmscore.VideoProfile{}
 		for _, pName := range strings.Split(transcodingOptions, ",") {
-			p, ok := lpmscore.VideoProfileLookup[pName]
+			p, ok := core.ProfileLookup(pName)
 			if ok {
 				profiles = append(profiles, p)
 			}
 		}
 		if len(profiles) == 0 {
 			glog.Errorf("Invalid transcoding options: %v", transcodingOptions)
+			http.Error(w, fmt.Sprintf("Invalid transcoding options: %v", transcodingOptions), http.StatusBadRequest)
+			return
+		}
+
+		//The segment transport is optional, it stays the same if it's left out
+		transport := core.SegmentTransport(r.FormValue("segmentTransport"))
+		if transport != "" && !core.ValidSegmentTransport(transport) {
+			glog.Errorf("Invalid segment transport: %v", transport)
+			http.Error(w, fmt.Sprintf("Invalid segment transport: %v", transport), http.StatusBadRequest)
 			return
 		}
 
-		BroadcastPrice = uint64(price)
-		BroadcastJobVideoProfiles = profiles
+		if err := s.LivepeerNode.Settings.Update(func(settings *core.Settings) error {
+			settings.BroadcastPrice = uint64(price)
+			settings.BroadcastJobVideoProfiles = profiles
+			if transport != "" {
+				settings.BroadcastSegmentTransport = transport
+			}
+			return nil
+		}); err != nil {
+			glog.Errorf("Error updating broadcast config: %v", err)
+			http.Error(w, fmt.Sprintf("Error updating broadcast config: %v", err), http.StatusInternalServerError)
+			return
+		}
 
-		glog.Infof("Transcode Job Price: %v, Transcode Job Type: %v", BroadcastPrice, BroadcastJobVideoProfiles)
+		glog.Infof("Transcode Job Price: %v, Transcode Job Type: %v", price, profiles)
 	})
 
 	http.HandleFunc("/getBroadcastConfig", func(w http.ResponseWriter, r *http.Request) {
+		settings := s.LivepeerNode.Settings.Get()
 		pNames := []string{}
-		for _, p := range BroadcastJobVideoProfiles {
+		for _, p := range settings.BroadcastJobVideoProfiles {
 			pNames = append(pNames, p.Name)
 		}
 		config := struct {
 			MaxPricePerSegment uint64
 			TranscodingOptions string
+			SegmentTransport   core.SegmentTransport
 		}{
-			BroadcastPrice,
+			settings.BroadcastPrice,
 			strings.Join(pNames, ","),
+			settings.BroadcastSegmentTransport,
 		}
 
 		data, err := json.Marshal(config)
 		if err != nil {
 			glog.Errorf("Error marshalling broadcaster config: %v", err)
+			http.Error(w, fmt.Sprintf("Error marshalling broadcaster config: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		w.Write(data)
 	})
 
-	http.HandleFunc("/getAvailableTranscodingOptions", func(w http.ResponseWriter, r *http.Request) {
-		transcodingOptions := make([]string, 0, len(lpmscore.VideoProfileLookup))
-		for opt := range lpmscore.VideoProfileLookup {
-			transcodingOptions = append(transcodingOptions, opt)
+	//Set the playback policy of a manifest: signed, allowedIPs and allowedReferrers, or reset=true to go back to the default policy.
+	http.HandleFunc("/setPlaybackPolicy", func(w http.ResponseWriter, r *http.Request) {
+		mid := core.ManifestID(r.FormValue("manifestID"))
+		if !mid.IsValid() {
+			http.Error(w, "Need to provide a valid manifestID", http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("reset") == "true" {
+			s.SetPlaybackPolicy(mid, nil)
+			glog.Infof("Playback policy of %v reset to the default", mid)
+			return
+		}
+		p, err := NewPlaybackPolicy(r.FormValue("signed") == "true", r.FormValue("allowedIPs"), r.FormValue("allowedReferrers"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid playback policy: %v", err), http.StatusBadRequest)
+			return
+		}
+		if p.Signed && s.PlaybackSigner == nil {
+			http.Error(w, "Node has no playback key for signed playback URLs", http.StatusBadRequest)
+			return
 		}
+		s.SetPlaybackPolicy(mid, p)
+		glog.Infof("Playback policy of %v: %+v", mid, p)
+	})
+
+	http.HandleFunc("/getPlaybackPolicy", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.PlaybackPolicy(core.ManifestID(r.FormValue("manifestID"))))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	//Sign a playback URL for the manifest, good for ttl (1h by default).
+	http.HandleFunc("/signPlaybackURL", func(w http.ResponseWriter, r *http.Request) {
+		if s.PlaybackSigner == nil {
+			http.Error(w, "Node has no playback key", http.StatusServiceUnavailable)
+			return
+		}
+		mid := core.ManifestID(r.FormValue("manifestID"))
+		if !mid.IsValid() {
+			http.Error(w, "Need to provide a valid manifestID", http.StatusBadRequest)
+			return
+		}
+		ttl := time.Hour
+		if ttlStr := r.FormValue("ttl"); ttlStr != "" {
+			var err error
+			if ttl, err = time.ParseDuration(ttlStr); err != nil || ttl <= 0 {
+				http.Error(w, fmt.Sprintf("Invalid ttl %q", ttlStr), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Write([]byte(s.PlaybackSigner.SignURL(mid, time.Now().Add(ttl))))
+	})
+
+	http.HandleFunc("/getAvailableTranscodingOptions", func(w http.ResponseWriter, r *http.Request) {
+		transcodingOptions := core.ProfileNames()
 
 		data, err := json.Marshal(transcodingOptions)
 		if err != nil {
 			glog.Errorf("Error marshalling all transcoding options: %v", err)
+			http.Error(w, fmt.Sprintf("Error marshalling all transcoding options: %v", err), http.StatusInternalServerError)
 			return
 		}
 
@@ -129,68 +254,85 @@ func (s *LivepeerServer) StartWebserver() {
 
 	//Activate the transcoder on-chain.
 	http.HandleFunc("/activateTranscoder", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
+
 		registered, err := s.LivepeerNode.Eth.IsRegisteredTranscoder()
 		if err != nil {
 			glog.Errorf("Error checking for registered transcoder: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking for registered transcoder: %v", err), http.StatusInternalServerError)
 			return
 		}
 
 		if registered {
 			glog.Error("Transcoder is already registered")
+			http.Error(w, "Transcoder is already registered", http.StatusBadRequest)
 			return
 		}
 
 		if err := r.ParseForm(); err != nil {
 			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		blockRewardCutStr := r.FormValue("blockRewardCut")
 		if blockRewardCutStr == "" {
 			glog.Errorf("Need to provide block reward cut")
+			http.Error(w, "Need to provide block reward cut", http.StatusBadRequest)
 			return
 		}
 		blockRewardCut, err := strconv.Atoi(blockRewardCutStr)
 		if err != nil {
 			glog.Errorf("Cannot convert block reward cut: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert block reward cut: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		feeShareStr := r.FormValue("feeShare")
 		if feeShareStr == "" {
 			glog.Errorf("Need to provide fee share")
+			http.Error(w, "Need to provide fee share", http.StatusBadRequest)
 			return
 		}
 		feeShare, err := strconv.Atoi(feeShareStr)
 		if err != nil {
 			glog.Errorf("Cannot convert fee share: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert fee share: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		priceStr := r.FormValue("pricePerSegment")
 		if priceStr == "" {
 			glog.Errorf("Need to provide price per segment")
+			http.Error(w, "Need to provide price per segment", http.StatusBadRequest)
 			return
 		}
 		price, err := strconv.Atoi(priceStr)
 		if err != nil {
 			glog.Errorf("Cannot convert price per segment: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert price per segment: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		amountStr := r.FormValue("amount")
 		if amountStr == "" {
 			glog.Errorf("Need to provide amount")
+			http.Error(w, "Need to provide amount", http.StatusBadRequest)
 			return
 		}
 		amount, err := strconv.Atoi(amountStr)
 		if err != nil {
 			glog.Errorf("Cannot convert amount: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert amount: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
 			glog.Errorf("Error checking and initializing round: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking and initializing round: %v", err), http.StatusInternalServerError)
 			return
 		}
 
@@ -204,11 +346,14 @@ func (s *LivepeerServer) StartWebserver() {
 				select {
 				case rec := <-rc:
 					glog.Infof("%v", rec)
+					s.updateTranscoderSettings(blockRewardCut, feeShare, price)
 				case err := <-ec:
 					glog.Errorf("Error creating transcoder: %v", err)
+					http.Error(w, fmt.Sprintf("Error creating transcoder: %v", err), http.StatusInternalServerError)
 				}
 			case err := <-bondEc:
 				glog.Errorf("Error bonding: %v", err)
+				http.Error(w, fmt.Sprintf("Error bonding: %v", err), http.StatusInternalServerError)
 			}
 		} else {
 			glog.Infof("Activating Transcoder %v", s.LivepeerNode.Eth.Account().Address)
@@ -216,54 +361,69 @@ func (s *LivepeerServer) StartWebserver() {
 			select {
 			case rec := <-rc:
 				glog.Infof("%v", rec)
+				s.updateTranscoderSettings(blockRewardCut, feeShare, price)
 			case err := <-ec:
 				glog.Errorf("Error creating transcoder: %v", err)
+				http.Error(w, fmt.Sprintf("Error creating transcoder: %v", err), http.StatusInternalServerError)
 			}
 		}
 	})
 
 	//Set transcoder config on-chain.
 	http.HandleFunc("/setTranscoderConfig", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
+
 		if err := r.ParseForm(); err != nil {
 			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		blockRewardCutStr := r.FormValue("blockRewardCut")
 		if blockRewardCutStr == "" {
 			glog.Errorf("Need to provide block reward cut")
+			http.Error(w, "Need to provide block reward cut", http.StatusBadRequest)
 			return
 		}
 		blockRewardCut, err := strconv.Atoi(blockRewardCutStr)
 		if err != nil {
 			glog.Errorf("Cannot convert block reward cut: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert block reward cut: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		feeShareStr := r.FormValue("feeShare")
 		if feeShareStr == "" {
 			glog.Errorf("Need to provide fee share")
+			http.Error(w, "Need to provide fee share", http.StatusBadRequest)
 			return
 		}
 		feeShare, err := strconv.Atoi(feeShareStr)
 		if err != nil {
 			glog.Errorf("Cannot convert fee share: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert fee share: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		priceStr := r.FormValue("pricePerSegment")
 		if priceStr == "" {
 			glog.Errorf("Need to provide price per segment")
+			http.Error(w, "Need to provide price per segment", http.StatusBadRequest)
 			return
 		}
 		price, err := strconv.Atoi(priceStr)
 		if err != nil {
 			glog.Errorf("Cannot convert price per segment: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert price per segment: %v", err), http.StatusBadRequest)
 			return
 		}
 
 		if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
 			glog.Errorf("Error checking and initializing round: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking and initializing round: %v", err), http.StatusInternalServerError)
 			return
 		}
 
@@ -271,82 +431,166 @@ func (s *LivepeerServer) StartWebserver() {
 		select {
 		case rec := <-rc:
 			glog.Infof("%v", rec)
+			s.updateTranscoderSettings(blockRewardCut, feeShare, price)
 		case err := <-ec:
 			glog.Errorf("Error setting transcoder config: %v", err)
+			http.Error(w, fmt.Sprintf("Error setting transcoder config: %v", err), http.StatusInternalServerError)
 		}
 	})
 
-	//Bond some amount of tokens to a transcoder.
-	http.HandleFunc("/bond", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := r.ParseForm(); err != nil {
-				glog.Errorf("Parse Form Error: %v", err)
-				return
-			}
+	//Set the transcoder job policy and auto reward.  All the fields are optional.
+	http.HandleFunc("/setTranscoderSettings", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
+			return
+		}
 
-			amountStr := r.FormValue("amount")
-			if amountStr == "" {
-				glog.Errorf("Need to provide amount")
-				return
+		if err := s.LivepeerNode.Settings.Update(func(settings *core.Settings) error {
+			if v := r.FormValue("acceptJobs"); v != "" {
+				acceptJobs, err := strconv.ParseBool(v)
+				if err != nil {
+					glog.Errorf("Cannot convert accept jobs: %v", err)
+					return err
+				}
+				settings.TranscoderAcceptJobs = acceptJobs
 			}
-			amount, err := strconv.Atoi(amountStr)
-			if err != nil {
-				glog.Errorf("Cannot convert amount: %v", err)
-				return
+			if v := r.FormValue("minBroadcasterDeposit"); v != "" {
+				deposit, ok := new(big.Int).SetString(v, 10)
+				if !ok {
+					glog.Errorf("Cannot convert min broadcaster deposit: %v", v)
+					return core.ErrSettings
+				}
+				settings.TranscoderMinBroadcasterDeposit = deposit
 			}
-
-			toAddr := r.FormValue("toAddr")
-			if toAddr == "" {
-				glog.Errorf("Need to provide to addr")
-				return
+			if v := r.FormValue("autoReward"); v != "" {
+				autoReward, err := strconv.ParseBool(v)
+				if err != nil {
+					glog.Errorf("Cannot convert auto reward: %v", err)
+					return err
+				}
+				settings.AutoReward = autoReward
 			}
+			return nil
+		}); err != nil {
+			glog.Errorf("Error updating transcoder settings: %v", err)
+			http.Error(w, fmt.Sprintf("Error updating transcoder settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
 
-			if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
-				glog.Errorf("Error checking and initializing round: %v", err)
-				return
-			}
+	http.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.LivepeerNode.Settings.Get())
+		if err != nil {
+			glog.Errorf("Error marshalling settings: %v", err)
+			http.Error(w, fmt.Sprintf("Error marshalling settings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
 
-			rc, ec := s.LivepeerNode.Eth.Bond(big.NewInt(int64(amount)), common.HexToAddress(toAddr))
-			select {
-			case rec := <-rc:
-				glog.Infof("%v", rec)
-			case err := <-ec:
-				glog.Errorf("Error bonding: %v", err)
-			}
+	//Bond some amount of tokens to a transcoder.
+	http.HandleFunc("/outputFailures", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.LivepeerNode.OutputFailures())
+		if err != nil {
+			glog.Errorf("Error marshalling output failures: %v", err)
+			http.Error(w, fmt.Sprintf("Error marshalling output failures: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	http.HandleFunc("/bond", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		amountStr := r.FormValue("amount")
+		if amountStr == "" {
+			glog.Errorf("Need to provide amount")
+			http.Error(w, "Need to provide amount", http.StatusBadRequest)
+			return
+		}
+		amount, err := strconv.Atoi(amountStr)
+		if err != nil {
+			glog.Errorf("Cannot convert amount: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert amount: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		toAddr := r.FormValue("toAddr")
+		if toAddr == "" {
+			glog.Errorf("Need to provide to addr")
+			http.Error(w, "Need to provide to addr", http.StatusBadRequest)
+			return
+		}
+
+		if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
+			glog.Errorf("Error checking and initializing round: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking and initializing round: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rc, ec := s.LivepeerNode.Eth.Bond(big.NewInt(int64(amount)), common.HexToAddress(toAddr))
+		select {
+		case rec := <-rc:
+			glog.Infof("%v", rec)
+		case err := <-ec:
+			glog.Errorf("Error bonding: %v", err)
+			http.Error(w, fmt.Sprintf("Error bonding: %v", err), http.StatusInternalServerError)
 		}
 	})
 
 	http.HandleFunc("/unbond", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
-				glog.Errorf("Error checking and initializing round: %v", err)
-				return
-			}
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
 
-			rc, ec := s.LivepeerNode.Eth.Unbond()
-			select {
-			case rec := <-rc:
-				glog.Infof("%v", rec)
-			case err := <-ec:
-				glog.Errorf("Error unbonding: %v", err)
-			}
+		if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
+			glog.Errorf("Error checking and initializing round: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking and initializing round: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rc, ec := s.LivepeerNode.Eth.Unbond()
+		select {
+		case rec := <-rc:
+			glog.Infof("%v", rec)
+		case err := <-ec:
+			glog.Errorf("Error unbonding: %v", err)
+			http.Error(w, fmt.Sprintf("Error unbonding: %v", err), http.StatusInternalServerError)
 		}
 	})
 
 	http.HandleFunc("/withdrawBond", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
-				glog.Errorf("Error checking and initializing round: %v", err)
-				return
-			}
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
 
-			rc, ec := s.LivepeerNode.Eth.WithdrawBond()
-			select {
-			case rec := <-rc:
-				glog.Infof("%v", rec)
-			case err := <-ec:
-				glog.Errorf("Error withdrawing bond: %v", err)
-			}
+		if err := eth.CheckRoundAndInit(s.LivepeerNode.Eth); err != nil {
+			glog.Errorf("Error checking and initializing round: %v", err)
+			http.Error(w, fmt.Sprintf("Error checking and initializing round: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rc, ec := s.LivepeerNode.Eth.WithdrawBond()
+		select {
+		case rec := <-rc:
+			glog.Infof("%v", rec)
+		case err := <-ec:
+			glog.Errorf("Error withdrawing bond: %v", err)
+			http.Error(w, fmt.Sprintf("Error withdrawing bond: %v", err), http.StatusInternalServerError)
 		}
 	})
 
@@ -354,7 +598,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			status, err := s.LivepeerNode.Eth.TranscoderStatus()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(status))
 		}
@@ -365,7 +610,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			b, err := s.LivepeerNode.Eth.TranscoderStake()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(b.String()))
 		}
@@ -375,7 +621,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			status, err := s.LivepeerNode.Eth.DelegatorStatus()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(status))
 		}
@@ -385,38 +632,46 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			s, err := s.LivepeerNode.Eth.DelegatorStake()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(s.String()))
 		}
 	})
 
 	http.HandleFunc("/deposit", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := r.ParseForm(); err != nil {
-				glog.Errorf("Parse Form Error: %v", err)
-				return
-			}
-			//Parse amount
-			amountStr := r.FormValue("amount")
-			if amountStr == "" {
-				glog.Errorf("Need to provide amount")
-				return
-			}
-			amount, err := strconv.Atoi(amountStr)
-			if err != nil {
-				glog.Errorf("Cannot convert amount: %v", err)
-				return
-			}
-			glog.Infof("Depositing: %v", amount)
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
 
-			rc, ec := s.LivepeerNode.Eth.Deposit(big.NewInt(int64(amount)))
-			select {
-			case <-rc:
-				glog.Infof("Deposit successful")
-			case err := <-ec:
-				glog.Errorf("Error depositing: %v", err)
-			}
+		if err := r.ParseForm(); err != nil {
+			glog.Errorf("Parse Form Error: %v", err)
+			http.Error(w, fmt.Sprintf("Parse Form Error: %v", err), http.StatusBadRequest)
+			return
+		}
+		//Parse amount
+		amountStr := r.FormValue("amount")
+		if amountStr == "" {
+			glog.Errorf("Need to provide amount")
+			http.Error(w, "Need to provide amount", http.StatusBadRequest)
+			return
+		}
+		amount, err := strconv.Atoi(amountStr)
+		if err != nil {
+			glog.Errorf("Cannot convert amount: %v", err)
+			http.Error(w, fmt.Sprintf("Cannot convert amount: %v", err), http.StatusBadRequest)
+			return
+		}
+		glog.Infof("Depositing: %v", amount)
+
+		rc, ec := s.LivepeerNode.Eth.Deposit(big.NewInt(int64(amount)))
+		select {
+		case <-rc:
+			glog.Infof("Deposit successful")
+		case err := <-ec:
+			glog.Errorf("Error depositing: %v", err)
+			http.Error(w, fmt.Sprintf("Error depositing: %v", err), http.StatusInternalServerError)
 		}
 	})
 
@@ -430,10 +685,11 @@ func (s *LivepeerServer) StartWebserver() {
 	})
 
 	http.HandleFunc("/localStreams", func(w http.ResponseWriter, r *http.Request) {
-		net := s.LivepeerNode.VideoNetwork.(*basicnet.BasicVideoNetwork)
 		ret := make([]map[string]string, 0)
-		for _, strmID := range net.GetLocalStreams() {
-			ret = append(ret, map[string]string{"format": "hls", "streamID": strmID})
+		if net, ok := s.LivepeerNode.VideoNetwork.(localStreamsLister); ok {
+			for _, strmID := range net.GetLocalStreams() {
+				ret = append(ret, map[string]string{"format": "hls", "streamID": strmID})
+			}
 		}
 		js, err := json.Marshal(ret)
 		if err != nil {
@@ -460,6 +716,85 @@ func (s *LivepeerServer) StartWebserver() {
 		w.Write(js)
 	})
 
+	http.HandleFunc("/peers", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Peers == nil {
+			http.Error(w, "Node doesn't manage its peers", http.StatusServiceUnavailable)
+			return
+		}
+		data, err := json.Marshal(s.LivepeerNode.Peers.Peers())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	//The live streams announced by broadcasters, optionally only the ones with a tag, from a node, or with q in their title or tags
+	http.HandleFunc("/liveStreams", func(w http.ResponseWriter, r *http.Request) {
+		tag, q, nodeID := r.FormValue("tag"), r.FormValue("q"), r.FormValue("nodeID")
+		recs := make([]*core.StreamRecord, 0)
+		for _, rec := range s.LivepeerNode.Streams.Records() {
+			if (tag != "" && !rec.HasTag(tag)) || (q != "" && !rec.Matches(q)) || (nodeID != "" && rec.NodeID != nodeID) {
+				continue
+			}
+			recs = append(recs, rec)
+		}
+		data, err := json.Marshal(recs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	//The transcoders that announced their capabilities, optionally only the ones that transcode a profile or ask at most a price
+	http.HandleFunc("/transcoders", func(w http.ResponseWriter, r *http.Request) {
+		profile := r.FormValue("profile")
+		var maxPrice *big.Int
+		if p := r.FormValue("maxPricePerSegment"); p != "" {
+			var ok bool
+			if maxPrice, ok = new(big.Int).SetString(p, 10); !ok {
+				http.Error(w, fmt.Sprintf("Invalid price %q", p), http.StatusBadRequest)
+				return
+			}
+		}
+		recs := make([]*core.CapabilityRecord, 0)
+		for _, rec := range s.LivepeerNode.Transcoders.Records() {
+			if profile != "" && !rec.HasProfile(profile) {
+				continue
+			}
+			if maxPrice != nil && (rec.PricePerSegment == nil || rec.PricePerSegment.Cmp(maxPrice) > 0) {
+				continue
+			}
+			recs = append(recs, rec)
+		}
+		data, err := json.Marshal(recs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	http.HandleFunc("/evictPeer", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Peers == nil {
+			http.Error(w, "Node doesn't manage its peers", http.StatusServiceUnavailable)
+			return
+		}
+		nid := r.FormValue("nodeID")
+		reason := r.FormValue("reason")
+		if reason == "" {
+			reason = "evicted by the operator"
+		}
+		if err := s.LivepeerNode.Peers.EvictPeer(core.NodeID(nid), reason); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid node ID %q", nid), http.StatusBadRequest)
+			return
+		}
+	})
+
 	http.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(fmt.Sprintf("\n\nVideoNetwork: %v", s.LivepeerNode.VideoNetwork)))
 		w.Write([]byte(fmt.Sprintf("\n\nmediaserver sub timer: %v", s.hlsSubTimer)))
@@ -473,25 +808,34 @@ func (s *LivepeerServer) StartWebserver() {
 		}
 
 		statusc, err := s.LivepeerNode.VideoNetwork.GetNodeStatus(nid)
-		if err == nil {
-			status := <-statusc
-			mstrs := make(map[string]string, 0)
-			for mid, m := range status.Manifests {
-				mstrs[mid] = m.String()
-			}
-			d := struct {
-				NodeID    string
-				Manifests map[string]string
-			}{
-				NodeID:    status.NodeID,
-				Manifests: mstrs,
-			}
-			if data, err := json.Marshal(d); err == nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(data)
-				return
-			}
+		if err != nil {
+			glog.Errorf("Error getting status for %v: %v", nid, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := <-statusc
+		if status == nil {
+			http.Error(w, "Cannot get the status of node "+nid, http.StatusNotFound)
+			return
+		}
+		data, err := json.Marshal(nodeStatusResponse(status))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.currentConfig())
+		if err != nil {
+			glog.Errorf("Error marshalling config: %v", err)
+			http.Error(w, "Error marshalling config", 500)
+			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
 	})
 
 	http.HandleFunc("/nodeID", func(w http.ResponseWriter, r *http.Request) {
@@ -499,7 +843,7 @@ func (s *LivepeerServer) StartWebserver() {
 	})
 
 	http.HandleFunc("/nodeAddrs", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(strings.Join(s.LivepeerNode.Addrs, ", ")))
+		w.Write([]byte(strings.Join(s.LivepeerNode.NodeAddrs(), ", ")))
 	})
 
 	http.HandleFunc("/controllerContractAddr", func(w http.ResponseWriter, r *http.Request) {
@@ -530,7 +874,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			b, err := s.LivepeerNode.Eth.TokenBalance()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(b.String()))
 		}
@@ -540,7 +885,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			b, err := s.LivepeerNode.Eth.Backend().BalanceAt(context.Background(), s.LivepeerNode.Eth.Account().Address, nil)
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(b.String()))
 		}
@@ -550,7 +896,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			b, err := s.LivepeerNode.Eth.GetBroadcasterDeposit(s.LivepeerNode.Eth.Account().Address)
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(b.String()))
 		}
@@ -560,7 +907,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			b, err := s.LivepeerNode.Eth.TranscoderBond()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(b.String()))
 		}
@@ -591,14 +939,21 @@ func (s *LivepeerServer) StartWebserver() {
 	})
 
 	http.HandleFunc("/candidateTranscodersStats", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
+
 		candidateTranscodersStats, err := s.LivepeerNode.Eth.GetCandidateTranscodersStats()
 		if err != nil {
-			w.Write([]byte(""))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
 		data, err := json.Marshal(candidateTranscodersStats)
 		if err != nil {
 			glog.Errorf("Error marshalling all transcoder stats: %v", err)
+			http.Error(w, fmt.Sprintf("Error marshalling all transcoder stats: %v", err), http.StatusInternalServerError)
 			return
 		}
 
@@ -610,7 +965,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			blockRewardCut, _, _, err := s.LivepeerNode.Eth.TranscoderPendingPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(strconv.Itoa(int(blockRewardCut.Int64()))))
 		}
@@ -620,7 +976,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			_, feeShare, _, err := s.LivepeerNode.Eth.TranscoderPendingPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(strconv.Itoa(int(feeShare.Int64()))))
 		}
@@ -630,7 +987,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			_, _, price, err := s.LivepeerNode.Eth.TranscoderPendingPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(price.String()))
 		}
@@ -640,7 +998,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			blockRewardCut, _, _, err := s.LivepeerNode.Eth.TranscoderPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(strconv.Itoa(int(blockRewardCut.Int64()))))
 		}
@@ -650,7 +1009,8 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			_, feeShare, _, err := s.LivepeerNode.Eth.TranscoderPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(strconv.Itoa(int(feeShare.Int64()))))
 		}
@@ -660,23 +1020,89 @@ func (s *LivepeerServer) StartWebserver() {
 		if s.LivepeerNode.Eth != nil {
 			_, _, price, err := s.LivepeerNode.Eth.TranscoderPricingInfo()
 			if err != nil {
-				w.Write([]byte(""))
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
 			}
 			w.Write([]byte(price.String()))
 		}
 	})
 
 	http.HandleFunc("/requestTokens", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			glog.Infof("Requesting tokens from faucet")
+		if s.LivepeerNode.Eth == nil {
+			http.Error(w, "Node is not connected to Ethereum", http.StatusServiceUnavailable)
+			return
+		}
 
-			rc, ec := s.LivepeerNode.Eth.RequestTokens()
-			select {
-			case rec := <-rc:
-				glog.Infof("%v", rec)
-			case err := <-ec:
-				glog.Errorf("Error request tokens from faucet: %v", err)
-			}
+		glog.Infof("Requesting tokens from faucet")
+
+		rc, ec := s.LivepeerNode.Eth.RequestTokens()
+		select {
+		case rec := <-rc:
+			glog.Infof("%v", rec)
+		case err := <-ec:
+			glog.Errorf("Error request tokens from faucet: %v", err)
+			http.Error(w, fmt.Sprintf("Error request tokens from faucet: %v", err), http.StatusInternalServerError)
 		}
 	})
 }
+
+//localStreamsLister is implemented by networks that list the streams of the node.
+type localStreamsLister interface {
+	GetLocalStreams() []string
+}
+
+//statusResponse is the /status of a node.  Playlists are shown as text.
+type statusResponse struct {
+	NodeID        string
+	Version       string `json:",omitempty"`
+	StatusVersion int
+	EthAddress    string `json:",omitempty"`
+	Capabilities  []string
+	Manifests     map[string]string
+	Broadcasts    []string
+	Subscriptions []string
+	Relays        []net.RelayStatus
+	Jobs          []net.JobStatus
+	//UploadCapacity and UploadRate are in bytes per second
+	UploadCapacity int64
+	UploadRate     int64
+	SegmentURL     string `json:",omitempty"`
+}
+
+func nodeStatusResponse(status *net.NodeStatus) statusResponse {
+	resp := statusResponse{
+		NodeID:         status.NodeID,
+		Version:        status.Version,
+		StatusVersion:  status.StatusVersion,
+		EthAddress:     status.EthAddress,
+		Capabilities:   status.Capabilities,
+		Manifests:      make(map[string]string),
+		Broadcasts:     status.Broadcasts,
+		Subscriptions:  status.Subscriptions,
+		Relays:         status.Relays,
+		Jobs:           status.Jobs,
+		UploadCapacity: status.UploadCapacity,
+		UploadRate:     status.UploadRate,
+		SegmentURL:     status.SegmentURL,
+	}
+	for mid, m := range status.Manifests {
+		resp.Manifests[mid] = m.String()
+	}
+	//Older nodes don't send these, show them as empty rather than null
+	if resp.Capabilities == nil {
+		resp.Capabilities = []string{}
+	}
+	if resp.Broadcasts == nil {
+		resp.Broadcasts = []string{}
+	}
+	if resp.Subscriptions == nil {
+		resp.Subscriptions = []string{}
+	}
+	if resp.Relays == nil {
+		resp.Relays = []net.RelayStatus{}
+	}
+	if resp.Jobs == nil {
+		resp.Jobs = []net.JobStatus{}
+	}
+	return resp
+}
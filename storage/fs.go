@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+//FSPrefix is the prefix of the identifiers returned by FSStorage.  The rest of the identifier is the hex sha256 of the data.
+const FSPrefix = "sha256:"
+
+//FSStorage stores data in a local directory, named by its sha256.  It's meant for testing, since the data can only be resolved on the
+//same machine, so its identifiers can't be submitted for verification (see Verifiable).
+type FSStorage struct {
+	dir string
+}
+
+func NewFSStorage(dir string) (*FSStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		glog.Errorf("Error creating storage dir %v: %v", dir, err)
+		return nil, err
+	}
+	return &FSStorage{dir: dir}, nil
+}
+
+func (s *FSStorage) Add(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	d := digest(data)
+	path := s.path(d)
+	if _, err := os.Stat(path); err == nil {
+		//Content addressed, so it's already there
+		return FSPrefix + d, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	//Write to a temp file first so a crash doesn't leave partial data under the digest
+	tmp, err := ioutil.TempFile(filepath.Dir(path), d+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return FSPrefix + d, nil
+}
+
+func (s *FSStorage) Get(id string) (io.ReadCloser, error) {
+	d := strings.TrimPrefix(id, FSPrefix)
+	if !strings.HasPrefix(id, FSPrefix) || !digestRegexp.MatchString(d) {
+		return nil, ErrInvalidID
+	}
+	f, err := os.Open(s.path(d))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *FSStorage) path(d string) string {
+	//Spread the files over subdirectories so no single dir gets too big
+	return filepath.Join(s.dir, d[:2], d)
+}
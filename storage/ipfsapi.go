@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+//IpfsHTTPTimeout is the timeout for requests to an external IPFS node.
+var IpfsHTTPTimeout = 60 * time.Second
+
+//IpfsHTTPStorage stores data on an external IPFS node through its HTTP API, so the transcoder doesn't need to run an embedded node.
+//The identifiers are IPFS content ids, the same as with the embedded node.
+type IpfsHTTPStorage struct {
+	url    string
+	client *http.Client
+}
+
+//NewIpfsHTTPStorage uses the IPFS API at apiURL, e.g. http://127.0.0.1:5001
+func NewIpfsHTTPStorage(apiURL string) *IpfsHTTPStorage {
+	return &IpfsHTTPStorage{url: strings.TrimSuffix(apiURL, "/"), client: &http.Client{Timeout: IpfsHTTPTimeout}}
+}
+
+func (s *IpfsHTTPStorage) Add(r io.Reader) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "segment")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := s.client.Post(s.url+"/api/v0/add?pin=true", w.FormDataContentType(), &body)
+	if err != nil {
+		glog.Errorf("Error adding to IPFS at %v: %v", s.url, err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkIpfsResponse(resp); err != nil {
+		return "", err
+	}
+
+	var added struct {
+		Name string
+		Hash string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	if added.Hash == "" {
+		return "", fmt.Errorf("IPFS didn't return a hash")
+	}
+	return added.Hash, nil
+}
+
+func (s *IpfsHTTPStorage) Get(id string) (io.ReadCloser, error) {
+	if id == "" || strings.ContainsAny(id, "/?&") {
+		return nil, ErrInvalidID
+	}
+	resp, err := s.client.Post(s.url+"/api/v0/cat?arg="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIpfsResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func checkIpfsResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	var ipfsErr struct {
+		Message string
+	}
+	if json.Unmarshal(data, &ipfsErr) == nil && ipfsErr.Message != "" {
+		return fmt.Errorf("IPFS error: %v", ipfsErr.Message)
+	}
+	return fmt.Errorf("IPFS error: %v %v", resp.Status, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+//S3Timeout is the timeout for requests to the object store.
+var S3Timeout = 60 * time.Second
+
+//S3Storage stores data in a bucket of an S3-compatible object store (AWS S3, Minio, etc), keyed by its sha256.  Requests are signed
+//with AWS signature version 4, or sent anonymously if there is no access key.  The identifiers are the object URLs, so the verifier
+//can fetch the data directly if the bucket is readable.
+type S3Storage struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+//NewS3Storage uses the bucket at endpoint (e.g. https://s3.amazonaws.com or http://127.0.0.1:9000), with path-style URLs.
+func NewS3Storage(endpoint, bucket, region, accessKey, secretKey string) *S3Storage {
+	return &S3Storage{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: S3Timeout},
+	}
+}
+
+func (s *S3Storage) Add(r io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	u := s.objectURL(digest(data))
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = int64(len(data))
+	s.sign(req, data, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		glog.Errorf("Error uploading to %v: %v", u, err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("error uploading to %v: %v %v", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return u, nil
+}
+
+func (s *S3Storage) Get(id string) (io.ReadCloser, error) {
+	prefix := s.objectURL("")
+	if !strings.HasPrefix(id, prefix) || !digestRegexp.MatchString(strings.TrimPrefix(id, prefix)) {
+		return nil, ErrInvalidID
+	}
+
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("error getting %v: %v %v", id, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	return fmt.Sprintf("%v/%v/%v", s.endpoint, s.bucket, key)
+}
+
+//sign adds the AWS signature version 4 headers to req.
+func (s *S3Storage) sign(req *http.Request, payload []byte, t time.Time) {
+	if s.accessKey == "" {
+		return
+	}
+
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payloadHash := digest(payload)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%v/%v/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, digest([]byte(canonicalRequest))}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(signingKey(s.secretKey, date, s.region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", s.accessKey, scope, signedHeaders, signature))
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secret), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,45 @@
+/*
+Package storage stores the segment data transcoders submit for verification.  Add returns a content identifier that the verifier can
+resolve the data with, and Get resolves it.
+
+The backends are the embedded IPFS node (ipfs.IpfsCoreApi), an external IPFS node over its HTTP API, an S3-compatible object store,
+and a content-addressed directory on the local filesystem.
+*/
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var ErrNotFound = errors.New("ErrNotFound")
+var ErrInvalidID = errors.New("ErrInvalidID")
+
+//Storage stores segment data for verification.
+type Storage interface {
+	//Add stores the data and returns its content identifier.
+	Add(r io.Reader) (string, error)
+	//Get returns the data for an identifier returned by Add.
+	Get(id string) (io.ReadCloser, error)
+}
+
+var digestRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+//ipfsHashRegexp matches IPFS content ids: base58 CIDv0 and base32 CIDv1.
+var ipfsHashRegexp = regexp.MustCompile("^(Qm[1-9A-HJ-NP-Za-km-z]{44}|b[a-z2-7]{58})$")
+
+//Verifiable tells if an identifier can be submitted on-chain.  The verifier resolves IPFS content ids through IPFS, and fetches HTTP
+//URLs (the identifiers of S3Storage).  The identifiers of FSStorage can only be resolved on the machine of the node.
+func Verifiable(id string) bool {
+	return ipfsHashRegexp.MatchString(id) || strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://")
+}
+
+//digest returns the hex sha256 of data, which the object store and the filesystem store use as the key.
+func digest(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
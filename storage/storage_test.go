@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testStorage(t *testing.T, s Storage) string {
+	id, err := s.Add(bytes.NewReader([]byte("segment data")))
+	if err != nil {
+		t.Fatalf("Error adding: %v", err)
+	}
+	//Same data, same id
+	if id2, err := s.Add(bytes.NewReader([]byte("segment data"))); err != nil || id2 != id {
+		t.Errorf("Expecting %v, got %v %v", id, id2, err)
+	}
+
+	r, err := s.Get(id)
+	if err != nil {
+		t.Fatalf("Error getting %v: %v", id, err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != "segment data" {
+		t.Errorf("Expecting segment data, got %q %v", data, err)
+	}
+	return id
+}
+
+func TestFSStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFSStorage(dir)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	id := testStorage(t, s)
+	if id != FSPrefix+digest([]byte("segment data")) {
+		t.Errorf("Unexpected id %v", id)
+	}
+
+	if _, err := s.Get(FSPrefix + "../../etc/passwd"); err != ErrInvalidID {
+		t.Errorf("Expecting ErrInvalidID, got %v", err)
+	}
+	if _, err := s.Get(FSPrefix + digest([]byte("other data"))); err != ErrNotFound {
+		t.Errorf("Expecting ErrNotFound, got %v", err)
+	}
+}
+
+func TestIpfsHTTPStorage(t *testing.T) {
+	//Stand-in for the add and cat endpoints of the IPFS API
+	var lock sync.Mutex
+	files := make(map[string][]byte)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch r.URL.Path {
+		case "/api/v0/add":
+			f, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, `{"Message":"no file"}`, http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(f)
+			hash := "Qm" + digest(data)[:44]
+			files[hash] = data
+			fmt.Fprintf(w, `{"Name":"segment","Hash":"%v","Size":"%v"}`, hash, len(data))
+		case "/api/v0/cat":
+			data, ok := files[r.URL.Query().Get("arg")]
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"Message":"not found","Code":0}`))
+				return
+			}
+			w.Write(data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	s := NewIpfsHTTPStorage(ts.URL)
+	testStorage(t, s)
+	if _, err := s.Get("QmNope"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expecting not found error, got %v", err)
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	//From the AWS signature version 4 docs
+	k := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	if hex.EncodeToString(k) != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Errorf("Unexpected signing key %x", k)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	//Stand-in for an object store, only checks that requests are signed with the right credential
+	var lock sync.Mutex
+	objects := make(map[string][]byte)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") || r.Header.Get("x-amz-date") == "" {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/bucket/") {
+			http.Error(w, "NoSuchBucket", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			data, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get("x-amz-content-sha256") != digest(data) {
+				http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = data
+		case "GET":
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer ts.Close()
+
+	s := NewS3Storage(ts.URL, "bucket", "us-east-1", "access", "secret")
+	id := testStorage(t, s)
+	if id != ts.URL+"/bucket/"+digest([]byte("segment data")) {
+		t.Errorf("Unexpected id %v", id)
+	}
+	if _, err := s.Get(ts.URL + "/bucket/" + digest([]byte("other data"))); err != ErrNotFound {
+		t.Errorf("Expecting ErrNotFound, got %v", err)
+	}
+	if _, err := s.Get("http://example.com/bucket/" + digest([]byte("segment data"))); err != ErrInvalidID {
+		t.Errorf("Expecting ErrInvalidID, got %v", err)
+	}
+
+	//No credentials
+	s = NewS3Storage(ts.URL, "bucket", "us-east-1", "", "")
+	if _, err := s.Add(bytes.NewReader([]byte("segment data"))); err == nil {
+		t.Errorf("Expecting error for anonymous upload")
+	}
+}
+
+func TestVerifiable(t *testing.T) {
+	for id, ok := range map[string]bool{
+		"QmXeYaU3Laqy3DJTTfBBq96YpqD5Eh5247BpytxSSk4uUC":              true,
+		"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi": true,
+		"https://s3.amazonaws.com/bucket/" + digest([]byte("data")):   true,
+		FSPrefix + digest([]byte("data")):                             false,
+		"/tmp/storage/ab/" + digest([]byte("data")):                   false,
+		"": false,
+	} {
+		if Verifiable(id) != ok {
+			t.Errorf("Expecting Verifiable(%q) to be %v", id, ok)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Package verifier simulates the on-chain verification of claimed segments, so a transcoder can find out whether its output is
+deterministic enough to pass before a verifier slashes it.  It works offline on the claim data the node saves (core.PersistedClaims):
+the segment is transcoded again, and the receipt hash and Merkle proof are recomputed against the claim root.
+*/
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/eth/signer"
+	ethTypes "github.com/livepeer/go-livepeer/eth/types"
+	lpmscore "github.com/livepeer/lpms/core"
+	"github.com/livepeer/lpms/transcoder"
+)
+
+var ErrSegmentNotFound = errors.New("ErrSegmentNotFound")
+var ErrNotClaimable = errors.New("ErrNotClaimable")
+var ErrUnknownProfile = errors.New("ErrUnknownProfile")
+
+//Result is the outcome of a simulated verification.  Pass is true if the on-chain verification would pass.
+type Result struct {
+	SeqNo      int64
+	ClaimStart int64
+	ClaimEnd   int64
+	ClaimRoot  common.Hash
+
+	DataHashMatch  bool
+	BroadcasterSig bool
+	//ProfileMatches is keyed by profile name, and is true if the transcoded data hashes to the claimed hash
+	ProfileMatches      map[string]bool
+	ClaimedReceiptHash  common.Hash
+	VerifiedReceiptHash common.Hash
+	ProofValid          bool
+
+	Pass    bool
+	Reasons []string
+}
+
+//LoadClaims reads claim data saved by core.BasicClaimManager.PersistClaims.
+func LoadClaims(path string) (*core.PersistedClaims, error) {
+	return core.LoadPersistedClaims(path)
+}
+
+//Profiles looks up the profiles of the claims, in the order the claim manager concatenates the transcoded data hashes.  The transcoder
+//used for verification has to output the profiles in this order.
+func Profiles(claims *core.PersistedClaims) ([]lpmscore.VideoProfile, error) {
+	ps := make([]lpmscore.VideoProfile, 0, len(claims.Profiles))
+	for _, name := range claims.Profiles {
+		p, ok := core.ProfileLookup(name)
+		if !ok {
+			return nil, ErrUnknownProfile
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+//Ranges returns the claim ranges for the segments.  These are the ranges of the claims submitted on-chain, and for the segments that
+//aren't claimed yet, the ranges the claim manager would make: runs of consecutive segments that have hashes for all profiles.
+func Ranges(claims *core.PersistedClaims) [][2]int64 {
+	ranges := make([][2]int64, 0)
+	for _, c := range claims.Claims {
+		ranges = append(ranges, [2]int64{c.Start, c.End})
+	}
+	segs := sortedSegments(claims)
+	claimable := func(seg core.PersistedSegmentClaim) bool {
+		return complete(claims, seg) && findClaim(claims, seg.SeqNo) == nil
+	}
+	for i := 0; i < len(segs); {
+		if !claimable(segs[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < len(segs) && segs[j+1].SeqNo == segs[j].SeqNo+1 && claimable(segs[j+1]) {
+			j++
+		}
+		ranges = append(ranges, [2]int64{segs[i].SeqNo, segs[j].SeqNo})
+		i = j + 1
+	}
+	sort.Sort(byStart(ranges))
+	return ranges
+}
+
+//Verify simulates the verification of segment seqNo.  The segment data in the claims is transcoded again with t, and the result is
+//checked against the claimed hashes.  If root is nil, the root and proof of the claim submitted for the segment are used, or they are
+//recomputed from the claims if the segment isn't claimed yet.  Otherwise root should be the root submitted on-chain.  segData replaces
+//the segment data in the claims if it's not nil.
+func Verify(claims *core.PersistedClaims, seqNo int64, segData []byte, t transcoder.Transcoder, root *common.Hash) (*Result, error) {
+	var segRange [2]int64
+	found := false
+	for _, r := range Ranges(claims) {
+		if seqNo >= r[0] && seqNo <= r[1] {
+			segRange = r
+			found = true
+			break
+		}
+	}
+	segs := make(map[int64]core.PersistedSegmentClaim)
+	for _, s := range claims.Segments {
+		segs[s.SeqNo] = s
+	}
+	seg, ok := segs[seqNo]
+	if !ok {
+		return nil, ErrSegmentNotFound
+	}
+	if !found {
+		return nil, ErrNotClaimable
+	}
+
+	//Rebuild the Merkle tree of the claim from the claimed receipts
+	receiptHashes := make([]common.Hash, 0, segRange[1]-segRange[0]+1)
+	for i := segRange[0]; i <= segRange[1]; i++ {
+		receiptHashes = append(receiptHashes, receiptHash(claims, segs[i], claimedHashes(claims, segs[i])))
+	}
+	tree, proofs, err := ethTypes.NewMerkleTree(receiptHashes)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{
+		SeqNo:              seqNo,
+		ClaimStart:         segRange[0],
+		ClaimEnd:           segRange[1],
+		ClaimRoot:          tree.Hash,
+		ClaimedReceiptHash: receiptHashes[seqNo-segRange[0]],
+		ProfileMatches:     make(map[string]bool),
+		Reasons:            make([]string, 0),
+	}
+	proof := proofs[seqNo-segRange[0]]
+	if c := findClaim(claims, seqNo); c != nil {
+		res.ClaimRoot = common.HexToHash(c.Root)
+		if len(seg.ClaimProof) > 0 {
+			proof = proofFromBytes(seg.ClaimProof)
+		}
+	}
+	if root != nil {
+		res.ClaimRoot = *root
+	}
+
+	if segData == nil {
+		segData = seg.SegData
+	}
+	res.DataHashMatch = common.BytesToHash(crypto.Keccak256(segData)) == common.BytesToHash(seg.DataHash)
+	if !res.DataHashMatch {
+		res.Reasons = append(res.Reasons, "segment data doesn't match the claimed data hash")
+	}
+
+	res.BroadcasterSig = checkBroadcasterSig(claims, seg)
+	if !res.BroadcasterSig {
+		res.Reasons = append(res.Reasons, fmt.Sprintf("broadcaster signature isn't from %v", claims.BroadcasterAddr))
+	}
+
+	//Transcode again, like the verifier would
+	tData, err := t.Transcode(segData)
+	if err != nil {
+		return nil, err
+	}
+	if len(tData) != len(claims.Profiles) {
+		return nil, fmt.Errorf("transcoder returned %v outputs for %v profiles", len(tData), len(claims.Profiles))
+	}
+	tHashes := make([][]byte, len(claims.Profiles))
+	for i, name := range claims.Profiles {
+		tHashes[i] = crypto.Keccak256(tData[i])
+		res.ProfileMatches[name] = common.BytesToHash(tHashes[i]) == common.BytesToHash(seg.TranscodedDataHashes[name])
+		if !res.ProfileMatches[name] {
+			res.Reasons = append(res.Reasons, fmt.Sprintf("%v output doesn't match the claimed hash", name))
+		}
+	}
+
+	res.VerifiedReceiptHash = receiptHash(claims, seg, tHashes)
+	res.ProofValid = ethTypes.VerifyProof(res.ClaimRoot, res.VerifiedReceiptHash, proof)
+	if !res.ProofValid {
+		res.Reasons = append(res.Reasons, "receipt isn't in the claim root")
+	}
+
+	res.Pass = len(res.Reasons) == 0
+	return res, nil
+}
+
+//findClaim returns the claim submitted for segment seqNo, or nil if it isn't claimed.
+func findClaim(claims *core.PersistedClaims, seqNo int64) *core.PersistedClaim {
+	for i, c := range claims.Claims {
+		if seqNo >= c.Start && seqNo <= c.End {
+			return &claims.Claims[i]
+		}
+	}
+	return nil
+}
+
+//proofFromBytes splits a proof the claim manager persisted into its hashes.
+func proofFromBytes(b []byte) *ethTypes.MerkleProof {
+	proof := &ethTypes.MerkleProof{}
+	for i := 0; i+common.HashLength <= len(b); i += common.HashLength {
+		proof.Hashes = append(proof.Hashes, common.BytesToHash(b[i:i+common.HashLength]))
+	}
+	return proof
+}
+
+func sortedSegments(claims *core.PersistedClaims) []core.PersistedSegmentClaim {
+	segs := append([]core.PersistedSegmentClaim{}, claims.Segments...)
+	sort.Sort(bySeqNo(segs))
+	return segs
+}
+
+type bySeqNo []core.PersistedSegmentClaim
+
+func (a bySeqNo) Len() int           { return len(a) }
+func (a bySeqNo) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySeqNo) Less(i, j int) bool { return a[i].SeqNo < a[j].SeqNo }
+
+type byStart [][2]int64
+
+func (a byStart) Len() int           { return len(a) }
+func (a byStart) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byStart) Less(i, j int) bool { return a[i][0] < a[j][0] }
+
+func complete(claims *core.PersistedClaims, seg core.PersistedSegmentClaim) bool {
+	for _, p := range claims.Profiles {
+		if _, ok := seg.TranscodedDataHashes[p]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func claimedHashes(claims *core.PersistedClaims, seg core.PersistedSegmentClaim) [][]byte {
+	hashes := make([][]byte, len(claims.Profiles))
+	for i, p := range claims.Profiles {
+		hashes[i] = seg.TranscodedDataHashes[p]
+	}
+	return hashes
+}
+
+func receiptHash(claims *core.PersistedClaims, seg core.PersistedSegmentClaim, tHashes [][]byte) common.Hash {
+	receipt := &ethTypes.TranscodeReceipt{
+		StreamID:                 claims.StreamID,
+		SegmentSequenceNumber:    big.NewInt(seg.SeqNo),
+		DataHash:                 seg.DataHash,
+		ConcatTranscodedDataHash: crypto.Keccak256(tHashes...),
+		BroadcasterSig:           seg.BroadcasterSig,
+	}
+	return receipt.Hash()
+}
+
+func checkBroadcasterSig(claims *core.PersistedClaims, seg core.PersistedSegmentClaim) bool {
+	segHash := (&ethTypes.Segment{StreamID: claims.StreamID, SegmentSequenceNumber: big.NewInt(seg.SeqNo), DataHash: common.BytesToHash(seg.DataHash)}).Hash()
+	pub, err := crypto.SigToPub(signer.SegmentSignHash(segHash.Bytes()), seg.BroadcasterSig)
+	if err != nil {
+		return false
+	}
+	return crypto.PubkeyToAddress(*pub) == common.HexToAddress(claims.BroadcasterAddr)
+}
@@ -0,0 +1,157 @@
+package verifier
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/eth/signer"
+	ethTypes "github.com/livepeer/go-livepeer/eth/types"
+)
+
+//StubTranscoder "transcodes" deterministically unless nondeterministic is set
+type StubTranscoder struct {
+	profiles         []string
+	nondeterministic bool
+	count            int
+}
+
+func (t *StubTranscoder) Transcode(d []byte) ([][]byte, error) {
+	t.count++
+	out := make([][]byte, len(t.profiles))
+	for i, p := range t.profiles {
+		out[i] = append([]byte(p), d...)
+		if t.nondeterministic && i == 0 {
+			out[i] = append(out[i], byte(t.count))
+		}
+	}
+	return out, nil
+}
+
+func makeClaims(t *testing.T, tr *StubTranscoder) *core.PersistedClaims {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	claims := &core.PersistedClaims{
+		StreamID:        "strmID",
+		JobID:           "5",
+		BroadcasterAddr: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		PricePerSegment: "1",
+		Profiles:        tr.profiles,
+	}
+	//Segments 0-3 and 5-6, 7 is missing a profile
+	for _, seqNo := range []int64{0, 1, 2, 3, 5, 6, 7} {
+		data := []byte{byte(seqNo)}
+		segHash := (&ethTypes.Segment{StreamID: "strmID", SegmentSequenceNumber: big.NewInt(seqNo), DataHash: crypto.Keccak256Hash(data)}).Hash()
+		sig, err := crypto.Sign(signer.SegmentSignHash(segHash.Bytes()), key)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		tData, _ := tr.Transcode(data)
+		hashes := make(map[string][]byte)
+		for i, p := range tr.profiles {
+			if seqNo == 7 && i == 1 {
+				continue
+			}
+			hashes[p] = crypto.Keccak256(tData[i])
+		}
+		claims.Segments = append(claims.Segments, core.PersistedSegmentClaim{SeqNo: seqNo, SegData: data, DataHash: crypto.Keccak256(data), TranscodedDataHashes: hashes, BroadcasterSig: sig})
+	}
+	return claims
+}
+
+func TestRanges(t *testing.T) {
+	claims := makeClaims(t, &StubTranscoder{profiles: []string{"P240p30fps16x9", "P360p30fps16x9"}})
+	ranges := Ranges(claims)
+	if len(ranges) != 2 || ranges[0] != [2]int64{0, 3} || ranges[1] != [2]int64{5, 6} {
+		t.Errorf("Expecting [[0 3] [5 6]], got %v", ranges)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tr := &StubTranscoder{profiles: []string{"P240p30fps16x9", "P360p30fps16x9"}}
+	claims := makeClaims(t, tr)
+
+	res, err := Verify(claims, 2, nil, tr, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !res.Pass || res.ClaimStart != 0 || res.ClaimEnd != 3 || res.ClaimedReceiptHash != res.VerifiedReceiptHash {
+		t.Errorf("Expecting verification to pass, got %+v", res)
+	}
+
+	//Same result against the root that was claimed
+	root := res.ClaimRoot
+	if res, err := Verify(claims, 1, nil, tr, &root); err != nil || !res.Pass {
+		t.Errorf("Expecting verification to pass, got %+v %v", res, err)
+	}
+	//But not against the root of another claim
+	if res, err := Verify(claims, 5, nil, tr, &root); err != nil || res.Pass || res.ProofValid {
+		t.Errorf("Expecting proof to fail, got %+v %v", res, err)
+	}
+
+	//Different segment data
+	if res, err := Verify(claims, 2, []byte("other"), tr, nil); err != nil || res.Pass || res.DataHashMatch {
+		t.Errorf("Expecting data hash to fail, got %+v %v", res, err)
+	}
+
+	//Output that changes every time it's transcoded can't pass
+	ndtr := &StubTranscoder{profiles: tr.profiles, nondeterministic: true}
+	claims = makeClaims(t, ndtr)
+	res, err = Verify(claims, 2, nil, ndtr, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if res.Pass || res.ProfileMatches["P240p30fps16x9"] || !res.ProfileMatches["P360p30fps16x9"] || res.ProofValid {
+		t.Errorf("Expecting first profile to fail, got %+v", res)
+	}
+
+	//Wrong broadcaster
+	claims.BroadcasterAddr = common.Address{}.Hex()
+	if res, err := Verify(claims, 2, nil, tr, nil); err != nil || res.BroadcasterSig {
+		t.Errorf("Expecting broadcaster signature to fail, got %+v %v", res, err)
+	}
+
+	if _, err := Verify(claims, 7, nil, tr, nil); err != ErrNotClaimable {
+		t.Errorf("Expecting ErrNotClaimable, got %v", err)
+	}
+	if _, err := Verify(claims, 4, nil, tr, nil); err != ErrSegmentNotFound {
+		t.Errorf("Expecting ErrSegmentNotFound, got %v", err)
+	}
+}
+
+func TestVerifySubmittedClaim(t *testing.T) {
+	tr := &StubTranscoder{profiles: []string{"P240p30fps16x9", "P360p30fps16x9"}}
+	claims := makeClaims(t, tr)
+	//Segments 1-2 were claimed on-chain
+	receiptHashes := []common.Hash{receiptHash(claims, claims.Segments[1], claimedHashes(claims, claims.Segments[1])), receiptHash(claims, claims.Segments[2], claimedHashes(claims, claims.Segments[2]))}
+	root, proofs, err := ethTypes.NewMerkleTree(receiptHashes)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	claims.Claims = []core.PersistedClaim{{ID: 0, Start: 1, End: 2, Root: root.Hash.Hex(), BlockNum: "10"}}
+	claims.Segments[1].ClaimProof = proofs[0].Bytes()
+	claims.Segments[2].ClaimProof = proofs[1].Bytes()
+
+	ranges := Ranges(claims)
+	if len(ranges) != 4 || ranges[0] != [2]int64{0, 0} || ranges[1] != [2]int64{1, 2} || ranges[2] != [2]int64{3, 3} || ranges[3] != [2]int64{5, 6} {
+		t.Errorf("Expecting [[0 0] [1 2] [3 3] [5 6]], got %v", ranges)
+	}
+
+	res, err := Verify(claims, 2, nil, tr, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !res.Pass || res.ClaimStart != 1 || res.ClaimEnd != 2 || res.ClaimRoot != root.Hash {
+		t.Errorf("Expecting verification against the submitted claim to pass, got %+v", res)
+	}
+
+	//The proof that was submitted is checked
+	claims.Segments[2].ClaimProof = proofs[0].Bytes()
+	if res, err := Verify(claims, 2, nil, tr, nil); err != nil || res.Pass || res.ProofValid {
+		t.Errorf("Expecting proof to fail, got %+v %v", res, err)
+	}
+}